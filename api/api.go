@@ -2,14 +2,13 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
-	"os"
-	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	db "aiolimas/db"
 	"aiolimas/logging"
@@ -48,11 +47,13 @@ func ListRelationsAsJSONL(ctx RequestContext) {
 			Children []int64
 			Requires []int64
 			Copies []int64
+			Related map[string][]int64
 		} {
 			ItemId: id,
 			Children: rs.Children,
 			Copies: rs.Copies,
 			Requires: rs.Requires,
+			Related: rs.Related,
 		}
 
 		if out.Children == nil {
@@ -64,6 +65,9 @@ func ListRelationsAsJSONL(ctx RequestContext) {
 		if out.Copies == nil {
 			out.Copies = []int64{}
 		}
+		if out.Related == nil {
+			out.Related = map[string][]int64{}
+		}
 
 		res, err := json.Marshal(out)
 		if err != nil {
@@ -352,7 +356,8 @@ func ModEntry(ctx RequestContext) {
 	if exists {
 		if err := db.SetParent(ctx.Uid, info.ItemId, parent.ItemId); err != nil {
 			logging.ELog(err)
-			util.WError(ctx.W, 500, "Failed to set parent\n%s", err.Error())
+			util.WError(ctx.W, relationErrorStatus(err), "Failed to set parent\n%s", err.Error())
+			return
 		}
 	}
 
@@ -444,7 +449,7 @@ func AddChild(ctx RequestContext) {
 
 	err := db.AddRelation(uid, child.ItemId, db_types.R_Child, parent.ItemId)
 	if err != nil {
-		util.WError(ctx.W, 500, "Failed to add child\n%s", err.Error())
+		util.WError(ctx.W, relationErrorStatus(err), "Failed to add child\n%s", err.Error())
 		return
 	}
 
@@ -458,7 +463,7 @@ func AddCopy(ctx RequestContext) {
 
 	err := db.AddRelation(uid, cpy.ItemId, db_types.R_Copy, cpyOf.ItemId)
 	if err != nil {
-		util.WError(ctx.W, 500, "Failed to add copy\n%s", err.Error())
+		util.WError(ctx.W, relationErrorStatus(err), "Failed to add copy\n%s", err.Error())
 		return
 	}
 
@@ -472,143 +477,13 @@ func AddRequires(ctx RequestContext) {
 
 	err := db.AddRelation(uid, item.ItemId, db_types.R_Requires, requires.ItemId)
 	if err != nil {
-		util.WError(ctx.W, 500, "Failed to add requirement\n%s", err.Error())
+		util.WError(ctx.W, relationErrorStatus(err), "Failed to add requirement\n%s", err.Error())
 		return
 	}
 
 	success(ctx.W)
 }
 
-type RadarrPostWebhook struct {
-	Movie struct {
-		Id          int
-		Title       string
-		Year        int
-		ReleaseDate string
-		FolderPath  string
-		TmdbId      int
-		Tags        []string
-	}
-	RemoteMovie struct {
-		TmdbId int
-		ImdbId string
-		Title  string
-		year   int
-	}
-	Release struct {
-		Quality           string
-		QualityVersion    int
-		ReleaseGroup      string
-		ReleaseTitle      string
-		Indexer           string
-		Size              int
-		CustomFormatScore int
-	}
-	EventType      string
-	InstanceName   string
-	ApplicationUrl string
-}
-
-func _radarrAdd(ctx RequestContext, data RadarrPostWebhook) {
-	var entryInfo db_types.InfoEntry
-	var userEntry db_types.UserViewingEntry
-
-	if slices.Contains(data.Movie.Tags, "no-add") {
-		ctx.W.WriteHeader(200)
-		return
-	}
-
-	us, err := settings.GetUserSettings(ctx.Uid)
-	if err != nil {
-		util.WError(ctx.W, 500, "Could not update entry\n%s", err.Error())
-		return
-	}
-
-	if slices.Contains(data.Movie.Tags, "planned") {
-		userEntry.Status = db_types.S_PLANNED
-	}
-
-	entryInfo.En_Title = data.Movie.Title
-	entryInfo.Type = db_types.TY_MOVIE
-	entryInfo.Format = db_types.F_DIGITAL
-	entryInfo.ItemId = 0
-	entryInfo.Location = settings.CondensePathWithLocationAliases(us.LocationAliases, data.Movie.FolderPath)
-	if slices.Contains(data.Movie.Tags, "anime") {
-		entryInfo.ArtStyle |= db_types.AS_ANIME
-	}
-
-	if slices.Contains(data.Movie.Tags, "live-action") {
-		entryInfo.ArtStyle |= db_types.AS_LIVE_ACTION
-	}
-
-	timezone := us.DefaultTimeZone
-
-	metadata := db_types.MetadataEntry{}
-	if !slices.Contains(data.Movie.Tags, "anime") && data.RemoteMovie.ImdbId != "" {
-		metadata, err = meta.GetMetadataById(data.RemoteMovie.ImdbId, ctx.Uid, "omdb")
-		if err != nil {
-			metadata = db_types.MetadataEntry{}
-		}
-	} else {
-		metadata, err = meta.GetMetadata(&meta.GetMetadataInfo{
-			Entry:         &entryInfo,
-			MetadataEntry: &metadata,
-			Uid:           ctx.Uid,
-		})
-		if err != nil {
-			metadata = db_types.MetadataEntry{}
-		}
-	}
-
-	datapoints := map[string]string{}
-	if metadata.Datapoints != "" {
-		json.Unmarshal([]byte(metadata.Datapoints), &datapoints)
-	}
-	datapoints["radarr-id"] = fmt.Sprintf("%d", data.Movie.Id)
-	mar_datapoints, _ := json.Marshal(datapoints)
-	metadata.Datapoints = string(mar_datapoints)
-
-	if err := db.AddEntry(ctx.Uid, timezone, &entryInfo, &metadata, &userEntry); err != nil {
-		util.WError(ctx.W, 500, "Error adding entry\n%s", err.Error())
-		return
-	}
-
-	j, err := entryInfo.ToJson()
-	if err != nil {
-		util.WError(ctx.W, 500, "Could not convert new entry to json\n%s", err.Error())
-		return
-	}
-
-	ctx.W.WriteHeader(201)
-	ctx.W.Write(j)
-}
-
-func HookRadarr(ctx RequestContext) {
-	body, err := io.ReadAll(ctx.Req.Body)
-
-	defer ctx.Req.Body.Close()
-	if err != nil {
-		util.WError(ctx.W, 500, "Failed to read body\n%s", err.Error())
-		return
-	}
-
-	data := RadarrPostWebhook{}
-	if err := json.Unmarshal(body, &data); err != nil {
-		util.WError(ctx.W, 400, "Failed to parse body\n%s", err.Error())
-		return
-	}
-
-	if data.EventType == "Test" {
-		ctx.W.WriteHeader(200)
-		ctx.W.Write([]byte("OK"))
-		return
-	} else if data.EventType == "MovieAdded" {
-		_radarrAdd(ctx, data)
-	} else {
-		util.WError(ctx.W, 422, "Unknown event: %s\n", data.EventType)
-	}
-}
-
 // lets the user add an item in their library
 func AddEntry(ctx RequestContext) {
 	parsedParams := ctx.PP
@@ -720,7 +595,7 @@ func AddEntry(ctx RequestContext) {
 	} else if parsedParams.Get("get-metadata", false).(bool) {
 		providerOverride := parsedParams.Get("metadata-provider", "").(string)
 		var err error
-		newMeta, err := meta.GetMetadata(&meta.GetMetadataInfo{
+		newMeta, _, err := meta.GetMergedMetadata(&meta.GetMetadataInfo{
 			Entry:         &entryInfo,
 			MetadataEntry: &metadata,
 			Override:      providerOverride,
@@ -771,7 +646,147 @@ func AddEntry(ctx RequestContext) {
 
 	if priceNum > 0 {
 		currency := parsedParams.Get("currency", "USD").(string)
-		db.CreateTransaction("Purchased", ctx.Uid, entryInfo.ItemId, 0, timezone, priceNum, currency)
+		db.CreateTransaction(ctx.Uid, timezone, &db_types.TransactionEntry{
+			ItemId:   entryInfo.ItemId,
+			Currency: currency,
+			Amount:   db_types.MajorToMinor(priceNum, currency),
+			Kind:     db_types.TRANSACTION_BUY,
+		})
+	}
+
+	j, err := entryInfo.ToJson()
+	if err != nil {
+		util.WError(w, 500, "Could not convert new entry to json\n%s", err.Error())
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(j)
+}
+
+// adds an entry from a barcode, ISBN, or GameTDB id
+//
+// if an entry with the same barcode already exists, the new entry is a copy of it
+// otherwise metadata is looked up from the providers for that type of code
+// codes that cannot be looked up (UPC/EAN) need ?title, ?type and ?format
+func AddEntryByCode(ctx RequestContext) {
+	pp := ctx.PP
+	w := ctx.W
+
+	code, err := meta.ParseCode(pp["code"].(string), meta.CodeType(pp.Get("code-type", "").(string)))
+	if err != nil {
+		util.WError(w, 400, "%s\n", err.Error())
+		return
+	}
+
+	us, err := settings.GetUserSettings(ctx.Uid)
+	if err != nil {
+		util.WError(w, 500, "Could not get user settings\n%s", err.Error())
+		return
+	}
+
+	var entryInfo db_types.InfoEntry
+	var metadata db_types.MetadataEntry
+	var copyOfId int64 = 0
+
+	dctx := actx2dctx(ctx)
+
+	cached, err := db.FindInventoryByBarcode(dctx, code.Value)
+	if err != nil {
+		util.WError(w, 500, "Could not search inventory\n%s", err.Error())
+		return
+	}
+
+	if len(cached) > 0 {
+		copyOfId = cached[0].ItemId
+		entryInfo, err = db.GetInfoEntryById(dctx, copyOfId)
+		if err != nil {
+			util.WError(w, 500, "Could not get cached entry\n%s", err.Error())
+			return
+		}
+		metadata, err = db.GetMetadataEntryById(dctx, copyOfId)
+		if err != nil {
+			util.WError(w, 500, "Could not get cached metadata\n%s", err.Error())
+			return
+		}
+	} else if len(code.Providers) > 0 {
+		var provider string
+		metadata, provider, err = meta.LookupCode(code, us)
+		if err != nil {
+			util.WError(w, 404, "%s\n", err.Error())
+			return
+		}
+		entryInfo.En_Title = metadata.Title
+		entryInfo.Native_Title = metadata.Native_Title
+		entryInfo.Format = code.FormatFrom(provider)
+		entryInfo.Type = code.MediaType
+	} else {
+		if _, ok := pp["title"]; !ok {
+			util.WError(w, 404, "%s is not in the inventory and cannot be looked up, give ?title, ?type and ?format to add it\n", code.Value)
+			return
+		}
+		if _, ok := pp["type"]; !ok {
+			util.WError(w, 400, "?type is required for %s codes\n", code.Type)
+			return
+		}
+		if _, ok := pp["format"]; !ok {
+			util.WError(w, 400, "?format is required for %s codes\n", code.Type)
+			return
+		}
+	}
+
+	entryInfo.ItemId = 0
+	entryInfo.Uid = ctx.Uid
+	metadata.ItemId = 0
+	metadata.Uid = ctx.Uid
+
+	if title, ok := pp["title"]; ok {
+		entryInfo.En_Title = title.(string)
+	}
+	if ty, ok := pp["type"]; ok {
+		entryInfo.Type = ty.(db_types.MediaTypes)
+	}
+	if format, ok := pp["format"]; ok {
+		entryInfo.Format = format.(db_types.Format)
+	}
+	if entryInfo.RecommendedBy == "" {
+		entryInfo.RecommendedBy = "[]"
+	}
+
+	var userEntry db_types.UserViewingEntry
+	userEntry.Status = pp.Get("user-status", db_types.Status("")).(db_types.Status)
+
+	timezone := pp.Get("timezone", us.DefaultTimeZone).(string)
+
+	if err := db.AddEntry(ctx.Uid, timezone, &entryInfo, &metadata, &userEntry); err != nil {
+		util.WError(w, 500, "Error adding into table\n%s", err.Error())
+		return
+	}
+
+	if copyOfId != 0 {
+		db.AddRelation(ctx.Uid, entryInfo.ItemId, db_types.R_Copy, copyOfId)
+	}
+
+	err = db.SetInventoryEntry(ctx.Uid, &db_types.InventoryEntry{
+		ItemId:       entryInfo.ItemId,
+		Barcode:      code.Value,
+		Condition:    pp.Get("condition", db_types.C_UNKNOWN).(db_types.Condition),
+		Storage:      pp.Get("storage", "").(string),
+		PurchaseDate: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		util.WError(w, 500, "Could not add inventory entry\n%s", err.Error())
+		return
+	}
+
+	if price := pp.Get("price", 0.0).(float64); price > 0 {
+		currency := pp.Get("currency", "USD").(string)
+		db.CreateTransaction(ctx.Uid, timezone, &db_types.TransactionEntry{
+			ItemId:   entryInfo.ItemId,
+			Currency: currency,
+			Amount:   db_types.MajorToMinor(price, currency),
+			Kind:     db_types.TRANSACTION_BUY,
+		})
 	}
 
 	j, err := entryInfo.ToJson()
@@ -859,62 +874,22 @@ func GetCopies(ctx RequestContext) {
 
 func GetEntry(ctx RequestContext) {
 	entry := ctx.PP["id"].(db_types.InfoEntry)
-	res, err := json.Marshal(&entry)
+
+	rollups, err := db.GetRollups(actx2dctx(ctx), []int64{entry.ItemId})
 	if err != nil {
-		util.WError(ctx.W, 500, "Could not marshal info item: %s\n", err.Error())
+		util.WError(ctx.W, 500, "Could not get rollup\n%s", err.Error())
 		return
 	}
-	ctx.W.Write(res)
-}
-
-func Stream(ctx RequestContext) {
-	parsedParams := ctx.PP
-	w := ctx.W
-	req := ctx.Req
-	entry := parsedParams["id"].(db_types.InfoEntry)
-	subFile := parsedParams.Get("subfile", "").(string)
-
-	subFile, err := url.QueryUnescape(subFile)
-	if err != nil {
-		subFile = ""
+	if rollup, ok := rollups[entry.ItemId]; ok {
+		entry.Rollup = &rollup
 	}
 
-	us, err := settings.GetUserSettings(ctx.Uid)
+	res, err := json.Marshal(&entry)
 	if err != nil {
-		logging.ELog(err)
+		util.WError(ctx.W, 500, "Could not marshal info item: %s\n", err.Error())
 		return
 	}
-
-	fullPath := settings.ExpandPathWithLocationAliases(us.LocationAliases, entry.Location)
-
-	if subFile != "" {
-		fullPath += "/" + subFile
-	}
-
-	stat, err := os.Stat(fullPath)
-	if err == nil && stat.IsDir() {
-		files, err := os.ReadDir(fullPath)
-		if err != nil {
-			return
-		}
-		w.Write([]byte("#EXTM3U\n"))
-		for _, file := range files {
-			path := url.QueryEscape(file.Name())
-			var data string
-			if subFile != "" {
-				data = fmt.Sprintf("stream-entry?id=%d&subfile=%s\n", entry.ItemId, subFile+"/"+path)
-			} else {
-				data = fmt.Sprintf("stream-entry?id=%d&subfile=%s\n", entry.ItemId, path)
-			}
-			w.Write([]byte(data))
-		}
-	} else if err != nil {
-		logging.ELog(err)
-		w.WriteHeader(500)
-		w.Write([]byte("ERROR"))
-	} else {
-		http.ServeFile(w, req, fullPath)
-	}
+	ctx.W.Write(res)
 }
 
 func DeleteEntry(ctx RequestContext) {
@@ -946,24 +921,101 @@ func GetDescendants(ctx RequestContext) {
 	w.Write([]byte("\n"))
 }
 
+func GetAncestors(ctx RequestContext) {
+	entry := ctx.PP["id"].(db_types.InfoEntry)
+
+	items, err := db.GetAncestors(actx2dctx(ctx), entry.ItemId)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not get items\n%s", err.Error())
+		return
+	}
+	ctx.W.WriteHeader(200)
+
+	writeSQLRowResults(ctx.W, items)
+	ctx.W.Write([]byte("\n"))
+}
+
+func ValidateRelations(ctx RequestContext) {
+	report, err := db.ValidateRelations(ctx.Uid, ctx.Req.Method == "POST")
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not validate relations\n%s", err.Error())
+		return
+	}
+
+	j, err := json.Marshal(report)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not marshal report\n%s", err.Error())
+		return
+	}
+
+	ctx.W.Header().Set("Content-Type", "application/json")
+	ctx.W.WriteHeader(200)
+	ctx.W.Write(j)
+}
+
 func GetTree(ctx RequestContext) {
 	w := ctx.W
-	tree, err := db.BuildEntryTree(db.RequestContext{
+
+	roots := ctx.PP.Get("roots", []int64{}).([]int64)
+	if entry, ok := ctx.PP["id"].(db_types.InfoEntry); ok {
+		roots = append(roots, entry.ItemId)
+	}
+	depth := ctx.PP.Get("depth", int64(0)).(int64)
+
+	version, err := db.GetLibraryVersion()
+	if err != nil {
+		util.WError(w, 500, "Could not get library version\n%s", err.Error())
+		return
+	}
+
+	// the tree depends on who is asking, and what part of it they asked for
+	etag := fmt.Sprintf(`"tree-%d-%d-%d-%v-%d"`, version, ctx.Uid, ctx.Authorized, roots, depth)
+	w.Header().Set("ETag", etag)
+	if ctx.Req.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(304)
+		return
+	}
+
+	// written an entry at a time as the tree is read, so that the whole tree is never in memory
+	written := 0
+	err = db.BuildEntryTree(db.RequestContext{
 		UID:  ctx.Uid,
 		Auth: ctx.Authorized,
-	}, 0)
-	if err != nil {
+	}, roots, depth, func(entry db_types.EntryTree) error {
+		j, err := json.Marshal(entry)
+		if err != nil {
+			logging.ELog(err)
+			return nil
+		}
+
+		if written == 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(200)
+			w.Write([]byte("{"))
+		} else {
+			w.Write([]byte(","))
+		}
+		fmt.Fprintf(w, `"%d":`, entry.EntryInfo.ItemId)
+		_, err = w.Write(j)
+		written++
+		return err
+	})
+
+	if err != nil && written == 0 {
 		util.WError(w, 500, "Could not build tree\n%s", err.Error())
 		return
-	}
-	jStr, err := json.Marshal(tree)
-	if err != nil {
-		util.WError(w, 500, "Could not marshal tree\n%s", err.Error())
+	} else if err != nil {
+		// the response has already started, so all that can be done is to stop
+		logging.ELog(err)
 		return
 	}
 
-	w.WriteHeader(200)
-	w.Write(jStr)
+	if written == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		w.Write([]byte("{"))
+	}
+	w.Write([]byte("}"))
 }
 
 func EntrySettings(ctx RequestContext) {
@@ -1083,6 +1135,8 @@ func SpecificEntry(ctx RequestContext) {
 		actionMedia(ctx, FinishMedia)
 	case "TRANSACT":
 		Transact(ctx)
+	case "EXPAND":
+		ExpandEntry(ctx)
 	case "POST":
 		AddEntry(ctx)
 	case "PATCH":
@@ -1099,6 +1153,8 @@ func SpecificEntry(ctx RequestContext) {
 			ListTransactions(ctx)
 		case "children":
 			GetDescendants(ctx)
+		case "ancestors":
+			GetAncestors(ctx)
 		case "info":
 			GetEntry(ctx)
 		case "meta":
@@ -1138,6 +1194,46 @@ func EntryCopyResource(ctx RequestContext) {
 	}
 }
 
+// {id} is {kind} {other}, eg: /entry/2/relation/sequel-of/1
+func EntryRelationResource(ctx RequestContext) {
+	kind, reversed, _ := db_types.LookupRelationKind(ctx.PP["kind"].(string))
+
+	left := ctx.PP["id"].(db_types.InfoEntry).ItemId
+	right := ctx.PP["other"].(db_types.InfoEntry).ItemId
+	if reversed {
+		left, right = right, left
+	}
+
+	if left == right {
+		util.WError(ctx.W, 400, "An entry cannot be related to itself\n")
+		return
+	}
+
+	switch ctx.Req.Method {
+	case "POST":
+		if err := db.AddRelation(ctx.Uid, left, kind.Id, right); err != nil {
+			util.WError(ctx.W, relationErrorStatus(err), "Failed to add relation\n%s", err.Error())
+			return
+		}
+	case "DELETE":
+		if err := db.DelRelation(ctx.Uid, left, kind.Id, right, kind.Symmetric); err != nil {
+			util.WError(ctx.W, 500, "Failed to delete relation\n%s", err.Error())
+			return
+		}
+	}
+
+	success(ctx.W)
+}
+
+// 409 if err is a db.CycleError, otherwise 500
+func relationErrorStatus(err error) int {
+	var cycle db.CycleError
+	if errors.As(err, &cycle) {
+		return 409
+	}
+	return 500
+}
+
 func success(w http.ResponseWriter) {
 	w.WriteHeader(200)
 	w.Write([]byte("Success\n"))
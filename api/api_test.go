@@ -0,0 +1,59 @@
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	meta "aiolimas/metadata"
+	"aiolimas/settings"
+	db_types "aiolimas/types"
+)
+
+// replaces the provider with one that finds every id with ById, or none of them if title is empty
+func fakeCodeProvider(t *testing.T, name string, title string) {
+	t.Helper()
+	real, ok := meta.GetProvider(name)
+	if !ok {
+		t.Fatalf("there is no %s provider", name)
+	}
+	t.Cleanup(func() { meta.RegisterProvider(real) })
+
+	meta.RegisterProvider(&meta.BasicProvider{
+		ProviderName: name,
+		ByIdFn: func(id string, us settings.SettingsData) (db_types.MetadataEntry, error) {
+			if title == "" {
+				return db_types.MetadataEntry{}, errors.New("id " + id + " not found")
+			}
+			return db_types.MetadataEntry{Title: title}, nil
+		},
+	})
+}
+
+func TestAddEntryByCodeFormat(t *testing.T) {
+	const uid = 30
+
+	// ids that start with G are tried as gamecube games first, then wii games
+	fakeCodeProvider(t, "gtdbgamecube", "")
+	fakeCodeProvider(t, "gtdbwii", "A Wii Game")
+
+	w := httptest.NewRecorder()
+	AddEntryByCode(RequestContext{
+		Uid:        uid,
+		Authorized: uid,
+		Req:        httptest.NewRequest("GET", "/", nil),
+		W:          w,
+		PP:         ParsedParams{"code": "GWIE01"},
+	})
+	if w.Code != 200 {
+		t.Fatalf("got status %d\n%s", w.Code, w.Body.String())
+	}
+
+	entries := testEntriesTitled(t, uid, "A Wii Game")
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	if entry := entries[0]; entry.Format != db_types.F_WII {
+		t.Errorf("got format %d, want the format of the provider that found it, %d", entry.Format, db_types.F_WII)
+	}
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"aiolimas/db"
+	"aiolimas/settings"
+	db_types "aiolimas/types"
+)
+
+/*
+	This file is for common functions between the *arr webhooks
+	for now that is sonarr, and radarr
+*/
+
+// the events that are sent by both radarr and sonarr that do not change the library
+var arrIgnoredEvents = []string{"Grab", "Health", "HealthRestored", "ApplicationUpdate", "ManualInteractionRequired"}
+
+var defaultArrTagRules = map[string]settings.ArrTagRule{
+	"no-add":      {Skip: true},
+	"planned":     {Status: string(db_types.S_PLANNED)},
+	"anime":       {ArtStyle: uint64(db_types.AS_ANIME)},
+	"live-action": {ArtStyle: uint64(db_types.AS_LIVE_ACTION)},
+}
+
+func arrTagRule(us settings.SettingsData, tag string) (settings.ArrTagRule, bool) {
+	if rule, ok := us.ArrTagRules[tag]; ok {
+		return rule, true
+	}
+	rule, ok := defaultArrTagRules[tag]
+	return rule, ok
+}
+
+// applies the ArrTagRules of tags to a new entry
+// returns the aio tags that the entry should get, and false if one of the tags skips the entry
+func applyArrTags(us settings.SettingsData, tags []string, info *db_types.InfoEntry, user *db_types.UserViewingEntry) ([]string, bool) {
+	aioTags := []string{}
+	for _, tag := range tags {
+		rule, ok := arrTagRule(us, tag)
+		if !ok {
+			continue
+		}
+		if rule.Skip {
+			return nil, false
+		}
+		if rule.Status != "" {
+			user.Status = db_types.Status(rule.Status)
+		}
+		info.ArtStyle |= db_types.ArtStyle(rule.ArtStyle)
+		if rule.Format != "" {
+			for format, name := range db_types.ListFormats() {
+				if strings.EqualFold(name, rule.Format) {
+					info.Format = format
+				}
+			}
+		}
+		if rule.Library != 0 {
+			info.Library = rule.Library
+		}
+		aioTags = append(aioTags, rule.Tags...)
+	}
+	return aioTags, true
+}
+
+// finds the entry whose metadata has the datapoint key set to value
+func entryByDatapoint(ctx RequestContext, key string, value string) (db_types.InfoEntry, bool, error) {
+	metas, err := db.ListMetadata(actx2dctx(ctx))
+	if err != nil {
+		return db_types.InfoEntry{}, false, err
+	}
+	for _, m := range metas {
+		datapoints := map[string]string{}
+		json.Unmarshal([]byte(m.Datapoints), &datapoints)
+		if datapoints[key] != value {
+			continue
+		}
+		entry, err := db.GetInfoEntryById(actx2dctx(ctx), m.ItemId)
+		return entry, err == nil, err
+	}
+	return db_types.InfoEntry{}, false, nil
+}
+
+// finds the entry of an *arr item by its id datapoint, eg: radarr-id,
+// or by its folder for entries that were added before they were linked
+func findArrEntry(ctx RequestContext, us settings.SettingsData, key string, value string, folder string) (db_types.InfoEntry, bool, error) {
+	entry, found, err := entryByDatapoint(ctx, key, value)
+	if err != nil || found || folder == "" {
+		return entry, found, err
+	}
+
+	entries, err := db.ListEntries(actx2dctx(ctx), "entryInfo.itemId")
+	if err != nil {
+		return db_types.InfoEntry{}, false, err
+	}
+	folder = filepath.Clean(folder)
+	for _, e := range entries {
+		if e.Location != "" && filepath.Clean(settings.ExpandPathWithLocationAliases(us.LocationAliases, e.Location)) == folder {
+			return e, true, nil
+		}
+	}
+	return db_types.InfoEntry{}, false, nil
+}
+
+// sets the datapoints in values, a value of "" removes the datapoint
+func setDatapoints(metadata *db_types.MetadataEntry, values map[string]string) {
+	datapoints := map[string]string{}
+	if metadata.Datapoints != "" {
+		json.Unmarshal([]byte(metadata.Datapoints), &datapoints)
+	}
+	for k, v := range values {
+		if v == "" {
+			delete(datapoints, k)
+		} else {
+			datapoints[k] = v
+		}
+	}
+	mar_datapoints, _ := json.Marshal(datapoints)
+	metadata.Datapoints = string(mar_datapoints)
+}
+
+func updateDatapoints(ctx RequestContext, itemId int64, values map[string]string) error {
+	metadata, err := db.GetMetadataEntryById(actx2dctx(ctx), itemId)
+	if err != nil {
+		return err
+	}
+	setDatapoints(&metadata, values)
+	return db.UpdateMetadataEntry(ctx.Uid, &metadata)
+}
+
+func setLocation(ctx RequestContext, entry *db_types.InfoEntry, location string) error {
+	if entry.Location == location {
+		return nil
+	}
+	entry.Location = location
+	return db.UpdateInfoEntry(ctx.Uid, entry)
+}
+
+// the item was deleted from radarr/sonarr
+// if ArrDeleteEntries is set, the entry and its descendants are deleted,
+// otherwise they are no longer linked by the key datapoint, and if deletedFiles, their Location is cleared
+func arrDelete(ctx RequestContext, us settings.SettingsData, entry db_types.InfoEntry, key string, deletedFiles bool) error {
+	descendants, err := db.GetDescendants(actx2dctx(ctx), entry.ItemId)
+	if err != nil {
+		return err
+	}
+
+	if us.ArrDeleteEntries {
+		for _, item := range append(descendants, entry) {
+			if err := db.Delete(ctx.Uid, item.ItemId); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := updateDatapoints(ctx, entry.ItemId, map[string]string{key: ""}); err != nil {
+		return err
+	}
+
+	if deletedFiles {
+		for _, item := range append(descendants, entry) {
+			if err := setLocation(ctx, &item, ""); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"aiolimas/db"
+	"aiolimas/logging"
+	"aiolimas/util"
+)
+
+// how often a stream checks for new changes
+const changePollInterval = time.Second
+
+// a comment is sent this often, so that proxies do not close streams that have nothing to say
+const streamKeepAlive = 15 * time.Second
+
+// every change is kept for this long, after that only the latest change to each row is, see db.CompactChanges
+const changeHistory = 7 * 24 * time.Hour
+
+// compacts the changes table every interval
+func CompactChangesEvery(interval time.Duration) {
+	for {
+		n, err := db.CompactChanges(time.Now().Add(-changeHistory).UnixMilli())
+		if err != nil {
+			logging.ELog(err)
+		} else if n > 0 {
+			logging.Info("compacted %d changes", n)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// the change to start the stream after
+// Last-Event-ID is set by EventSource when it reconnects, so it takes precedence over ?since
+func streamStart(ctx RequestContext) (int64, error) {
+	if lastId := ctx.Req.Header.Get("Last-Event-ID"); lastId != "" {
+		return strconv.ParseInt(lastId, 10, 64)
+	}
+	if since, ok := ctx.PP["since"]; ok {
+		return since.(int64), nil
+	}
+	return db.LastChangeSeq(ctx.Uid)
+}
+
+// writes every change of the user after the start as a Server-Sent Event
+// the id of each event is the change's Seq, and its type is the change's Kind
+func StreamChanges(ctx RequestContext) {
+	flusher, ok := ctx.W.(http.Flusher)
+	if !ok {
+		util.WError(ctx.W, 500, "Streaming is not supported\n")
+		return
+	}
+
+	seq, err := streamStart(ctx)
+	if err != nil {
+		util.WError(ctx.W, 400, "Invalid Last-Event-ID\n%s", err.Error())
+		return
+	}
+
+	kinds := ctx.PP.Get("kinds", []string{}).([]string)
+
+	ctx.W.Header().Set("Content-Type", "text/event-stream")
+	ctx.W.Header().Set("Cache-Control", "no-cache")
+	ctx.W.Header().Set("X-Accel-Buffering", "no")
+	ctx.W.WriteHeader(200)
+	fmt.Fprintf(ctx.W, "retry: %d\n\n", (3 * time.Second).Milliseconds())
+	flusher.Flush()
+
+	poll := time.NewTicker(changePollInterval)
+	defer poll.Stop()
+	lastWrite := time.Now()
+
+	for {
+		changes, err := db.ListChanges(ctx.Uid, seq, 500)
+		if err != nil {
+			logging.ELog(err)
+			return
+		}
+
+		for _, change := range changes {
+			seq = change.Seq
+			if len(kinds) > 0 && !slices.Contains(kinds, change.Kind) {
+				continue
+			}
+			j, err := change.ToJson()
+			if err != nil {
+				logging.ELog(err)
+				continue
+			}
+			fmt.Fprintf(ctx.W, "id: %d\nevent: %s\ndata: %s\n\n", change.Seq, change.Kind, j)
+			lastWrite = time.Now()
+		}
+
+		if time.Since(lastWrite) >= streamKeepAlive {
+			fmt.Fprintf(ctx.W, ": keep-alive\n\n")
+			lastWrite = time.Now()
+		}
+		flusher.Flush()
+
+		// there may be more
+		if len(changes) == 500 {
+			continue
+		}
+
+		select {
+		case <-ctx.Req.Context().Done():
+			return
+		case <-poll.C:
+		}
+	}
+}
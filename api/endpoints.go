@@ -34,14 +34,69 @@ var mainEndpointList = []ApiEndPoint{
 
 	{
 		Methods: map[string]MethodSpec{
-			"POST": {},
+			"POST": {
+				WebhookAuth: true,
+			},
 		},
 		Handler: HookRadarr,
-		Description: "Handles radar webhook requests",
+		Description: `Handles radarr webhook requests<br>
+		MovieAdded creates the movie, the movie's tags are applied with ArrTagRules<br>
+		Download creates the movie if it does not exist, and updates its Location<br>
+		Rename updates the Location<br>
+		MovieFileDelete sets the "file-deleted" datapoint to the reason the file was deleted<br>
+		MovieDelete deletes the entry if ArrDeleteEntries is set, otherwise it removes its radarr-id, and if the files were deleted, clears its Location<br>
+		Grab, Health, and other events that do not change the library are ignored<br>
+		The movie is found by its "radarr-id" datapoint, or its folder`,
 		Returns: "InfoEntry",
 		EndPoint: "hook-radarr",
 	},
 
+	{
+		Methods: map[string]MethodSpec{
+			"POST": {
+				WebhookAuth: true,
+			},
+		},
+		Handler: HookSonarr,
+		Description: `Handles sonarr webhook requests, example payloads are in docs/hooks/sonarr<br>
+		SeriesAdd creates the show, the series' tags are applied with ArrTagRules<br>
+		Download creates (or moves) the season, and episode children of the imported episode, the show is created if it does not exist<br>
+		SeriesDelete deletes the entries if ArrDeleteEntries is set, otherwise it removes the show's sonarr-id, and if the files were deleted, clears their Location<br>
+		The show is found by its "sonarr-id" datapoint`,
+		Returns: "InfoEntry | InfoEntry[]",
+		EndPoint: "hook-sonarr",
+	},
+
+	{
+		EndPoint: "hook-player",
+		Handler:  HookPlayer,
+		Methods: map[string]MethodSpec{
+			"POST": {
+				Params: QueryParams{
+					"format": MkQueryInfo(P_PlayerFormat, false),
+				},
+				WebhookAuth: true,
+			},
+		},
+		Description: `Records a play event from a local media player, the entry is begun, resumed, or finished, and its CurrentPosition, and Minutes are updated<br>
+		The event is matched with the entry whose Location is, or contains the file, or else by the "{provider}-id" datapoint, or "{Type}-{provider}id" metadata of the entry<br>
+		?format is the shape of the body:
+		<dl>
+			<dt>generic (default)
+			<dd>{event: "started" | "position" | "stopped", path?, title?, ids?: {provider: id}, position?: seconds, duration?: seconds, completed?: bool}
+			<dt>mpv
+			<dd>{event: "start-file" | "playback-progress" | "pause" | "unpause" | "seek" | "end-file", path, media-title?, time-pos?, duration?, reason?}<br>
+			the names match mpv's events, and properties, an end-file with a reason of "eof" finishes the entry
+			<dt>jellyfin
+			<dd>the default template of the jellyfin webhook plugin, PlaybackStart, PlaybackProgress, or PlaybackStop
+			<dt>plex
+			<dd>a plex webhook, media.play, media.resume, media.pause, media.stop, or media.scrobble
+		</dl>
+		A stop finishes the entry when the player says the file was played to the end, or PlaybackFinishPercent of it was played<br>
+		For an entry that is a folder, only its last file can finish it`,
+		Returns: "{ItemId, Title, Actions: string[], Status, CurrentPosition, Minutes}",
+	},
+
 
 	{
 		EndPoint: "query-v4",
@@ -101,7 +156,13 @@ var mainEndpointList = []ApiEndPoint{
 		Handler: EntryResource,
 		Methods: map[string]MethodSpec {
 			"TREE": {
-				Description: "Generate a tree representation of all entries",
+				Description: `Generate a tree representation of all entries<br>
+				if ?roots is given, only those entries and their descendants are included, going at most ?depth levels down (0 means no limit)<br>
+				the response has an ETag, and is 304 if the library has not changed since`,
+				Params: QueryParams{
+					"roots": MkQueryInfo(P_Int64List, false),
+					"depth": MkQueryInfo(P_Int64, false),
+				},
 				Returns: "Record<string, {EntryInfo: InfoEntry, MetaInfo: Metadata, UserInfo: UserEntry, Children: string[], Copies: string[]}>",
 				GuestAllowed: true,
 				UserIndependant: true,
@@ -152,7 +213,7 @@ var mainEndpointList = []ApiEndPoint{
 					"format":            MkQueryInfo(P_EntryFormat, true),
 					"timezone":          MkQueryInfo(P_NotEmpty, false),
 					"price":             MkQueryInfo(P_Float64, false),
-					"currency":          MkQueryInfo(P_NotEmpty, false),
+					"currency":          MkQueryInfo(P_Currency, false),
 					"is-digital":        MkQueryInfo(P_Bool, false),
 					"is-anime":          MkQueryInfo(P_Bool, false),
 					"format-modifiers":  MkQueryInfo(P_Int64, false),
@@ -190,13 +251,39 @@ var mainEndpointList = []ApiEndPoint{
 				GuestAllowed: true,
 				UserIndependant: true,
 			},
+			"EXPAND": {
+				Description: `Creates children of {id} from its metadata, and returns them<br>
+				?kind is seasons, volumes, or episodes, by default it is volumes for Manga and Books, and seasons for everything else<br>
+				sonarr and omdb are asked for each season, otherwise the counts in MediaDependant (eg: Manga-volumes) are used<br>
+				children created by a previous EXPAND are skipped, so it can be used again to add new seasons`,
+				Params: QueryParams{
+					"kind": MkQueryInfo(P_ExpandKind, false),
+					"timezone": MkQueryInfo(P_NotEmpty, false),
+				},
+				Returns: "JSONL<InfoEntry>",
+			},
+			"TREE": {
+				Description: `Same as TREE /entry with {id} as the root`,
+				Params: QueryParams{
+					"depth": MkQueryInfo(P_Int64, false),
+				},
+				GuestAllowed: true,
+				UserIndependant: true,
+			},
 			"TRANSACT": {
-				Description: "registers a transaction",
+				Description: `registers a transaction<br>
+				if ?kind is not given, it is Purchased, or Sold if ?price is negative<br>
+				the sign of ?price is ignored for kinds other than Traded`,
 				Params: QueryParams{
 					"price": MkQueryInfo(P_Float64, true),
-					"currency": MkQueryInfo(P_NotEmpty, true),
+					"currency": MkQueryInfo(P_Currency, true),
 					"timezone": MkQueryInfo(P_NotEmpty, false),
-					"eventId": MkQueryInfo(P_NotEmpty, false),
+					"eventId": MkQueryInfo(P_Int64, false),
+					"kind": MkQueryInfo(P_TransactionKind, false),
+					"vendor": MkQueryInfo(P_True, false),
+					"order-id": MkQueryInfo(P_True, false),
+					"receipt": MkQueryInfo(P_True, false),
+					"notes": MkQueryInfo(P_True, false),
 				},
 			},
 			"PATCH": {
@@ -271,6 +358,8 @@ var mainEndpointList = []ApiEndPoint{
 					<dd> get user viewing info
 					<dt> children
 					<dd> lists children of entry
+					<dt> ancestors
+					<dd> lists every entry that {id} is a descendant of
 					<dt> relations
 					<dd> lists relations of the entry
 					<dt> events
@@ -323,6 +412,26 @@ var mainEndpointList = []ApiEndPoint{
 		},
 	},
 
+	{
+		EndPoint: "entry/{id}/relation/{kind}/{other}",
+		Handler: EntryRelationResource,
+		Description: `{id} is {kind} {other}, eg: <code>entry/2/relation/sequel-of/1</code> means 2 is a sequel of 1<br>
+		{kind} can be the name, or inverse name of any relation kind (see GET /type/relation)`,
+		PathParams: QueryParams {
+			"id": MkQueryInfo(P_VerifyIdAndGetInfoEntry, true),
+			"kind": MkQueryInfo(P_RelationKind, true),
+			"other": MkQueryInfo(P_VerifyIdAndGetInfoEntry, true),
+		},
+		Methods: map[string]MethodSpec {
+			"POST": {
+				Description: "Adds the relation",
+			},
+			"DELETE": {
+				Description: "Removes the relation",
+			},
+		},
+	},
+
 	{
 		EndPoint: "entry/allfor",
 		Handler: GetAllForEntry2,
@@ -382,7 +491,7 @@ var mainEndpointList = []ApiEndPoint{
 					"format":            MkQueryInfo(P_EntryFormat, true),
 					"timezone":          MkQueryInfo(P_NotEmpty, false),
 					"price":             MkQueryInfo(P_Float64, false),
-					"currency":          MkQueryInfo(P_NotEmpty, false),
+					"currency":          MkQueryInfo(P_Currency, false),
 					"is-digital":        MkQueryInfo(P_Bool, false),
 					"is-anime":          MkQueryInfo(P_Bool, false),
 					"format-modifiers":  MkQueryInfo(P_Int64, false),
@@ -412,6 +521,36 @@ var mainEndpointList = []ApiEndPoint{
 		EndPoint: "entry/add",
 	},
 
+	{
+		EndPoint: "entry/add-by-code",
+		Handler: AddEntryByCode,
+		Methods: map[string]MethodSpec {
+			"POST": {
+				Params: QueryParams{
+					"code":        MkQueryInfo(P_NotEmpty, true),
+					"code-type":   MkQueryInfo(P_CodeType, false),
+					"title":       MkQueryInfo(P_NotEmpty, false),
+					"type":        MkQueryInfo(P_EntryType, false),
+					"format":      MkQueryInfo(P_EntryFormat, false),
+					"timezone":    MkQueryInfo(P_NotEmpty, false),
+					"price":       MkQueryInfo(P_Float64, false),
+					"currency":    MkQueryInfo(P_Currency, false),
+					"condition":   MkQueryInfo(P_Condition, false),
+					"storage":     MkQueryInfo(P_True, false),
+					"user-status": MkQueryInfo(P_UserStatus, false),
+				},
+			},
+		},
+		Description: `Adds a new entry from an EAN/UPC/ISBN/GameTDB ?code, the code is stored as the entry's barcode<br>
+		?code-type can be isbn, ean, upc, or gametdb, by default it is detected from the code<br>
+		ISBNs are 10 digits, or 13 starting with 978 or 979, UPCs are 12 digits, and EANs are 8 or 13, the check digit of each must be correct<br>
+		If an entry with the same barcode exists, the new entry is a copy of it.
+		Otherwise, ISBNs and GameTDB ids are looked up, and the metadata, title, type and format are filled in.
+		UPC and EAN codes cannot be looked up, so ?title, ?type, and ?format are required the first time one is added<br>
+		?title, ?type and ?format override what was found`,
+		Returns:     "InfoEntry",
+	},
+
 	{
 		Aliases: []string{"delete-entry"},
 		EndPoint: "entry/delete",
@@ -442,6 +581,27 @@ var mainEndpointList = []ApiEndPoint{
 		Returns:      "InfoEntry",
 	},
 
+	{
+		EndPoint: "entry/graph",
+		Handler: ExportGraph,
+		Methods: map[string]MethodSpec {
+			"GET": {
+				Params: QueryParams{
+					"search": MkQueryInfo(P_NotEmpty, false),
+					"format": MkQueryInfo(P_GraphFormat, false),
+					"thumbnails": MkQueryInfo(P_Bool, false),
+				},
+				GuestAllowed: true,
+				UserIndependant: true,
+			},
+		},
+		Description: `Exports the relations between entries as a graph<br>
+		?search is a query-v3 search, only matching entries, and the relations between them are included<br>
+		?format can be json (default), dot (graphviz), or graphml<br>
+		if ?thumbnails is true, each node includes its thumbnail`,
+		Returns: "{Nodes: {ItemId, Title, Type, Status, Rating, Thumbnail}[], Edges: {Source, Target, Relation, Symmetric}[]}",
+	},
+
 	{
 		EndPoint: "entry/mod",
 		Aliases: []string{"mod-entry"},
@@ -643,6 +803,44 @@ var mainEndpointList = []ApiEndPoint{
 		},
 	},
 
+	{
+		EndPoint: "entry/relation/validate",
+		Handler: ValidateRelations,
+		Description: `Checks relations for orphans (relations to entries that do not exist), self references, duplicates, and cycles`,
+		Returns: "RelationReport",
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Description: "Reports problems without fixing them",
+			},
+			"POST": {
+				Description: "Deletes orphans, self references, and duplicates, cycles are only reported",
+			},
+		},
+	},
+
+	{
+		EndPoint: "entry/scan",
+		Handler: ScanLibrary,
+		Description: `Scans the folders in the LibraryRoots setting, and matches what is found with entries by location
+Entries whose location no longer exists are matched with new folders by folder name or title to detect moves,
+ones that are not found are reported missing, and are never deleted`,
+		Returns: "ScanReport",
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Description: "Reports what would be created and moved without changing anything",
+				Params: QueryParams{
+					"root": MkQueryInfo(P_NotEmpty, false),
+				},
+			},
+			"POST": {
+				Description: "Creates entries for new items (seasons and volumes become children), and updates the location of moved entries",
+				Params: QueryParams{
+					"root": MkQueryInfo(P_NotEmpty, false),
+				},
+			},
+		},
+	},
+
 	{
 		Aliases: []string{"stream-entry"},
 		EndPoint: "entry/stream",
@@ -652,11 +850,39 @@ var mainEndpointList = []ApiEndPoint{
 				Params: QueryParams{
 					"id":      MkQueryInfo(P_VerifyIdAndGetInfoEntry, true),
 					"subfile": MkQueryInfo(P_NotEmpty, false),
+					"format":  MkQueryInfo(P_PlaylistFormat, false),
+				},
+				Uncompressed:    true,
+				StreamTokenAuth: true,
+			},
+		},
+		Description: `Download the file located by the {id}'s location, range requests are supported<br>
+		the urls in playlists carry ?uid, ?expires and ?token, which authorize the request without a password for a day<br>
+		?subfile is a path relative to the location, it cannot leave the location<br>
+		if the file is a folder, a playlist of the audio and video files in it is returned instead<br>
+		at the root of the location, the playlist includes the entry's children, see /entry/playlist`,
+		Returns: "any",
+	},
+
+	{
+		EndPoint: "entry/playlist",
+		Handler:  Playlist,
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Params: QueryParams{
+					"id":       MkQueryInfo(P_VerifyIdAndGetInfoEntry, true),
+					"format":   MkQueryInfo(P_PlaylistFormat, false),
+					"children": MkQueryInfo(P_Bool, false),
 				},
 			},
 		},
-		Description: "Download the file located by the {id}'s location",
-		Returns:     "any",
+		Description: `Creates a playlist of the audio and video files of {id}, and all of its children (recursively), in order<br>
+		children are ordered by their season/volume/episode number, then their title<br>
+		?format can be m3u8 (default), or xspf<br>
+		if ?children is false, only {id}'s files are included<br>
+		each track links to /entry/stream with a token that lasts a day, so players do not need the password<br>
+		the links use the server's PublicURL setting, or the host of the request when it is not set`,
+		Returns: "m3u8 | xspf",
 	},
 
 	{
@@ -725,6 +951,49 @@ var mainEndpointList = []ApiEndPoint{
 
 // `/metadata` endpoints {{{
 var metadataEndpointList = []ApiEndPoint{
+	{
+		EndPoint: "providers",
+		Handler:  ListProviders,
+		Methods: map[string]MethodSpec {
+			"GET": {
+				Params: QueryParams{},
+			},
+		},
+		Description: `List the metadata providers<br>
+Capabilities is a list of: fetch, search, by-id, location, thumbnails<br>
+	fetch providers can be given to /metadata/fetch, search providers to /metadata/search,<br>
+	by-id providers to /metadata/apply, and location providers to /metadata/fetch-location<br>
+RequiredSettings are the names of user settings, or environment variables that need to be set<br>
+Configured is whether all of RequiredSettings are set for the current user<br>
+if MediaTypes or Formats is empty, the provider is not limited to specific types/formats`,
+		Returns: "{Name, MediaTypes, Formats, Capabilities, RequiredSettings, Configured}[]",
+	},
+
+	{
+		EndPoint: "refresh",
+		Handler:  MetadataRefreshResource,
+		Methods: map[string]MethodSpec {
+			"GET": {
+				Params: QueryParams{
+					"id":    MkQueryInfo(P_Int64, false),
+					"limit": MkQueryInfo(P_Int64, false),
+				},
+				Description: `Lists the refresh log, newest first<br>
+	if id is given, only refreshes of that entry are listed<br>
+	limit defaults to 100`,
+			},
+			"POST": {
+				Params:      QueryParams{},
+				Description: "Refreshes the entries selected by the MetadataRefresh setting now, even if it is not Enabled",
+			},
+		},
+		Description: `Metadata is refreshed in the background for the entries selected by the MetadataRefresh setting<br>
+Only entries that have a Provider and ProviderID are refreshed<br>
+Locked fields, Datapoints, thumbnails that were uploaded, and fields that the provider no longer has are kept<br>
+Changes in each MetadataRefresh is a json object of {field: {Old, New}}`,
+		Returns: "JSONL<MetadataRefresh>",
+	},
+
 	{
 		EndPoint: "fetch-location",
 		Handler:  FetchLocation,
@@ -803,7 +1072,73 @@ when using finalize-identify`,
 		Returns: "MetadataEntry",
 		Description: `Fetch the metadata for an entry based on the type<br>
 	and using EntryInfo.En_Title as the title search<br>
-	if provider is not given, it is automatically chosen based on type`,
+	if provider is not given, it is automatically chosen based on type<br>
+	fields are then replaced following the MetadataPriority setting, and locked fields are kept`,
+	},
+
+	{
+		EndPoint: "preview",
+		Handler:  PreviewMetadataForEntry,
+		Methods: map[string]MethodSpec {
+			"GET": {
+				Params: QueryParams{
+					"id":       MkQueryInfo(P_VerifyIdAndGetInfoEntry, true),
+					"provider": MkQueryInfo(P_NotEmpty, false),
+				},
+			},
+		},
+		Returns: "{Metadata: MetadataEntry, Changes: Record<string, {Old, New}>, Sources: Record<string, string>}",
+		Description: `Shows what /metadata/fetch would do, without saving anything<br>
+	Metadata is the metadata that would be saved<br>
+	Changes is field -> the current, and new value, for every field that would change<br>
+	Sources is field -> the provider the new value came from`,
+	},
+
+	{
+		EndPoint: "nfo",
+		Handler:  NFOResource,
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Description: "Shows the nfo file that would be written",
+				Params: QueryParams{
+					"id": MkQueryInfo(P_VerifyIdAndGetInfoEntry, true),
+				},
+			},
+			"POST": {
+				Description: "Writes the nfo file, and returns its path",
+				Params: QueryParams{
+					"id": MkQueryInfo(P_VerifyIdAndGetInfoEntry, true),
+				},
+			},
+		},
+		Description: `Writes an entry's metadata as a kodi/jellyfin nfo file next to its location<br>
+	If an nfo already exists, the elements that aio does not set (eg: actors) are kept<br>
+	Only Movie, MovieShort, Documentary, Show, Albumn, and Soundtrack entries can have an nfo`,
+	},
+
+	{
+		EndPoint: "lock",
+		Handler:  LockMetadataFields,
+		Methods: map[string]MethodSpec {
+			"POST": {
+				Params: QueryParams{
+					"id":     MkQueryInfo(P_VerifyIdAndGetMetaEntry, true),
+					"fields": MkQueryInfo(P_TList(",", func(in string) string { return in }), true),
+				},
+				Description: "Locks fields",
+			},
+			"DELETE": {
+				Params: QueryParams{
+					"id":     MkQueryInfo(P_VerifyIdAndGetMetaEntry, true),
+					"fields": MkQueryInfo(P_TList(",", func(in string) string { return in }), true),
+				},
+				Description: "Unlocks fields",
+			},
+		},
+		Returns: "MetadataEntry",
+		Description: `Locked fields are not changed by fetching, applying, or refreshing metadata<br>
+	fields is a comma separated list of: Title, Native_Title, Description, Rating, RatingMax, ReleaseYear, Thumbnail, MediaDependant, Genres, Country<br>
+	Rating and RatingMax are always locked together`,
 	},
 
 	{
@@ -1383,6 +1718,19 @@ var typeEndpoints = []ApiEndPoint{
 		},
 	},
 
+	{
+		EndPoint:        "relation",
+		Handler:         ListRelationKinds,
+		Description:     "Lists the kinds of relations between entries",
+		Returns:         "{Id: number, Name: string, InverseName: string, Symmetric: boolean, Descendant: boolean}[]",
+		Methods: map[string]MethodSpec {
+			"GET": {
+				GuestAllowed:    true,
+				UserIndependant: true,
+			},
+		},
+	},
+
 	{
 		EndPoint:        "artstyle",
 		Handler:         ListArtStyles,
@@ -1396,6 +1744,443 @@ var typeEndpoints = []ApiEndPoint{
 	},
 } // }}}
 
+// `/report` endpoints {{{
+var reportEndpointList = []ApiEndPoint{
+	{
+		EndPoint: "review",
+		Handler:  ReviewReport,
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Params: QueryParams{
+					"year":  MkQueryInfo(P_Int64, false),
+					"start": MkQueryInfo(P_Int64, false),
+					"end":   MkQueryInfo(P_Int64, false),
+				},
+				GuestAllowed: true,
+			},
+		},
+		Description: `Generates a year in review report for a user<br>
+	if ?year is not given, the current year is used<br>
+	?start and ?end (unix ms timestamps) can be used to give an arbitrary date range, they override ?year<br>
+	for an html version, see /html/report`,
+		Returns: "Report",
+	},
+} // }}}
+
+// `/ledger` endpoints {{{
+var ledgerEndpointList = []ApiEndPoint{
+	{
+		EndPoint: "item/{id}",
+		Handler:  LedgerItem,
+		PathParams: QueryParams{
+			"id": MkQueryInfo(P_VerifyIdAndGetInfoEntry, true),
+		},
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Params: QueryParams{
+					"currency": MkQueryInfo(P_Currency, false),
+				},
+			},
+		},
+		Description: `Totals the transactions of {id} and all of its descendants<br>
+	amounts are converted to ?currency, or the PreferredCurrency setting, and are in the minor unit of that currency`,
+		Returns: "LedgerTotal",
+	},
+
+	{
+		EndPoint: "collection",
+		Handler:  LedgerCollections,
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Params: QueryParams{
+					"currency": MkQueryInfo(P_Currency, false),
+				},
+			},
+		},
+		Description: "Totals the transactions of each Collection entry and all of its descendants",
+		Returns:     "{ItemId: number, Title: string, Total: LedgerTotal}[]",
+	},
+
+	{
+		EndPoint: "format",
+		Handler:  LedgerFormats,
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Params: QueryParams{
+					"currency": MkQueryInfo(P_Currency, false),
+				},
+			},
+		},
+		Description: "Totals the transactions for each format",
+		Returns:     "Record<string, LedgerTotal>",
+	},
+
+	{
+		EndPoint: "period",
+		Handler:  LedgerPeriods,
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Params: QueryParams{
+					"currency": MkQueryInfo(P_Currency, false),
+					"period":   MkQueryInfo(P_NotEmpty, false),
+				},
+			},
+		},
+		Description: "Totals the transactions for each ?period, which can be year, month (default), or day",
+		Returns:     "Record<string, LedgerTotal>",
+	},
+
+	{
+		EndPoint: "profit",
+		Handler:  LedgerProfits,
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Params: QueryParams{
+					"currency": MkQueryInfo(P_Currency, false),
+				},
+			},
+		},
+		Description: "Lists the profit/loss of every item that has been sold",
+		Returns:     "{ItemId: number, Title: string, Currency: string, Cost: number, Proceeds: number, Profit: number}[]",
+	},
+
+	{
+		EndPoint: "amortized",
+		Handler:  LedgerAmortized,
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Params: QueryParams{
+					"currency": MkQueryInfo(P_Currency, false),
+				},
+			},
+		},
+		Description: `Spreads the cost of each Subscription transaction across the entries in that service's library
+	that had events during the period the transaction paid for`,
+		Returns: "{Currency: string, Items: {ItemId: number, Title: string, Total: LedgerTotal}[], Unallocated: LedgerTotal}",
+	},
+
+	{
+		EndPoint: "rate",
+		Handler:  ExchangeRateResource,
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Description: "Lists the user's exchange rates",
+				Returns:     "JSONL<ExchangeRate>",
+			},
+			"POST": {
+				Description: "Sets the exchange rate such that 1 ?from == ?rate ?to",
+				Params: QueryParams{
+					"from": MkQueryInfo(P_Currency, true),
+					"to":   MkQueryInfo(P_Currency, true),
+					"rate": MkQueryInfo(P_Float64, true),
+				},
+			},
+			"DELETE": {
+				Description: "Deletes the exchange rate from ?from to ?to",
+				Params: QueryParams{
+					"from": MkQueryInfo(P_Currency, true),
+					"to":   MkQueryInfo(P_Currency, true),
+				},
+			},
+		},
+		Description: "Manage exchange rates, used to convert transactions into the preferred currency",
+	},
+
+	{
+		EndPoint: "rate/import",
+		Handler:  ImportExchangeRates,
+		Methods: map[string]MethodSpec{
+			"POST": {},
+		},
+		Description: "Imports exchange rates from a csv in the post body, each line must be <code>from,to,rate</code>, a header line is allowed",
+	},
+} // }}}
+
+// `/inventory` endpoints {{{
+var inventoryEndpointList = []ApiEndPoint{
+	{
+		EndPoint: "list",
+		Handler:  ListInventory,
+		Methods: map[string]MethodSpec{
+			"GET": {
+				GuestAllowed:    true,
+				UserIndependant: true,
+			},
+		},
+		Description: "Lists the physical copy information of all entries that have it",
+		Returns:     "JSONL<InventoryEntry>",
+	},
+
+	{
+		EndPoint: "item/{id}",
+		Handler:  InventoryResource,
+		PathParams: QueryParams{
+			"id": MkQueryInfo(P_VerifyIdAndGetInfoEntry, true),
+		},
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Description:     "Get the physical copy information of {id}",
+				Returns:         "InventoryEntry",
+				GuestAllowed:    true,
+				UserIndependant: true,
+			},
+			"PATCH": {
+				Description: `Update the physical copy information of {id}<br>
+				?condition can be one of New, Like new, Very good, Good, Fair, Poor, or empty<br>
+				?storage is where the copy is kept, eg: a shelf or box`,
+				Params: QueryParams{
+					"condition":     MkQueryInfo(P_Condition, false),
+					"edition":       MkQueryInfo(P_True, false),
+					"storage":       MkQueryInfo(P_True, false),
+					"barcode":       MkQueryInfo(P_True, false),
+					"purchase-date": MkQueryInfo(P_Int64, false),
+				},
+			},
+			"DELETE": {
+				Description: "Delete the physical copy information of {id}",
+			},
+		},
+	},
+
+	{
+		EndPoint: "loans",
+		Handler:  LoansResource,
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Description: `Lists loans<br>
+				?id only lists loans of that entry<br>
+				?active only lists loans that have not been returned<br>
+				?overdue only lists loans that have not been returned and are past their due date`,
+				Returns: "JSONL<Loan>",
+				Params: QueryParams{
+					"id":      MkQueryInfo(P_VerifyIdAndGetInfoEntry, false),
+					"active":  MkQueryInfo(P_Bool, false),
+					"overdue": MkQueryInfo(P_Bool, false),
+				},
+			},
+			"POST": {
+				Description: `Lend ?id to ?borrower<br>
+				?lent and ?due are unix timestamps in ms, ?lent defaults to now<br>
+				an entry can only be lent to 1 borrower at a time, to lend multiple copies, make each a copy (see R_Copy) of the original`,
+				Params: QueryParams{
+					"id":       MkQueryInfo(P_VerifyIdAndGetInfoEntry, true),
+					"borrower": MkQueryInfo(P_NotEmpty, true),
+					"lent":     MkQueryInfo(P_Int64, false),
+					"due":      MkQueryInfo(P_Int64, false),
+					"notes":    MkQueryInfo(P_True, false),
+				},
+			},
+		},
+	},
+
+	{
+		EndPoint: "loans/{id}",
+		Handler:  LoanResource,
+		PathParams: QueryParams{
+			"id": MkQueryInfo(P_Int64, true),
+		},
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Description: "Get a loan",
+				Returns:     "Loan",
+			},
+			"RETURN": {
+				Description: "Marks a loan as returned at ?returned (unix ms), defaulting to now",
+				Params: QueryParams{
+					"returned": MkQueryInfo(P_Int64, false),
+				},
+			},
+			"PATCH": {
+				Description: "Modify a loan",
+				Params: QueryParams{
+					"borrower": MkQueryInfo(P_NotEmpty, false),
+					"due":      MkQueryInfo(P_Int64, false),
+					"returned": MkQueryInfo(P_Int64, false),
+					"notes":    MkQueryInfo(P_True, false),
+				},
+			},
+			"DELETE": {
+				Description: "Delete a loan",
+			},
+		},
+	},
+} // }}}
+
+// `/webhook` endpoints {{{
+var webhookEndpointList = []ApiEndPoint{
+	{
+		EndPoint: "outgoing",
+		Handler:  WebhooksResource,
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Description: "Lists outgoing webhooks",
+				Returns:     "JSONL<Webhook>",
+			},
+			"POST": {
+				Description: `Create an outgoing webhook that is POSTed ?events as json<br>
+				?events is a , separated list of events, or groups of events, eg: status.*,rating.changed, if it is empty every event is sent, see /webhook/events<br>
+				?secret signs each payload, the X-AIO-Signature header is sha256= followed by the hex HMAC-SHA256 of the body keyed with ?secret, if it is not given one is generated<br>
+				a delivery that does not get a 2xx response is retried with backoff, up to 10 attempts`,
+				Returns: "Webhook",
+				Params: QueryParams{
+					"url":     MkQueryInfo(P_HttpUrl, true),
+					"secret":  MkQueryInfo(P_True, false),
+					"events":  MkQueryInfo(P_WebhookEvents, false),
+					"enabled": MkQueryInfo(P_Bool, false),
+				},
+			},
+		},
+		Description: `Each payload is a json object of {Event, Timestamp, Uid, ItemId, Title, Summary, Data}<br>
+		Summary is a sentence about what happened, eg: Finished Toy Story (rated 85)<br>
+		Data depends on the event, eg: the InfoEntry that was added, the TransactionEntry that was created, or {Status, UserRating, ViewCount} of a status.* event`,
+	},
+
+	{
+		EndPoint: "outgoing/{id}",
+		Handler:  WebhookResource,
+		PathParams: QueryParams{
+			"id": MkQueryInfo(P_Int64, true),
+		},
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Description: "Get a webhook",
+				Returns:     "Webhook",
+			},
+			"PATCH": {
+				Description: "Modify a webhook, deliveries that are already queued are not changed",
+				Params: QueryParams{
+					"url":     MkQueryInfo(P_HttpUrl, false),
+					"secret":  MkQueryInfo(P_NotEmpty, false),
+					"events":  MkQueryInfo(P_WebhookEvents, false),
+					"enabled": MkQueryInfo(P_Bool, false),
+				},
+			},
+			"PING": {
+				Description: "Sends a ping event to the webhook now, even if it is disabled",
+				Returns:     "WebhookDelivery",
+			},
+			"DELETE": {
+				Description: "Delete a webhook and its deliveries",
+			},
+		},
+	},
+
+	{
+		EndPoint: "events",
+		Handler:  ListWebhookEvents,
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Description:     "Lists the events that webhooks can be sent",
+				Returns:         "string[]",
+				GuestAllowed:    true,
+				UserIndependant: true,
+			},
+		},
+	},
+
+	{
+		EndPoint: "deliveries",
+		Handler:  ListWebhookDeliveries,
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Description: `Lists the delivery log, newest first<br>
+				?webhook only lists deliveries of that webhook<br>
+				?status is one of pending, delivered, or failed<br>
+				?limit defaults to 100`,
+				Returns: "JSONL<WebhookDelivery>",
+				Params: QueryParams{
+					"webhook": MkQueryInfo(P_Int64, false),
+					"status":  MkQueryInfo(P_DeliveryStatus, false),
+					"limit":   MkQueryInfo(P_Int64, false),
+				},
+			},
+		},
+	},
+
+	{
+		EndPoint: "deliveries/{id}",
+		Handler:  WebhookDeliveryResource,
+		PathParams: QueryParams{
+			"id": MkQueryInfo(P_Int64, true),
+		},
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Description: "Get a delivery",
+				Returns:     "WebhookDelivery",
+			},
+			"RETRY": {
+				Description: "Queues the delivery again with a fresh set of attempts, and clears the result of the last one, eg: after it failed",
+			},
+		},
+	},
+} // }}}
+
+// `/events` endpoints {{{
+var eventsEndpointList = []ApiEndPoint{
+	{
+		EndPoint: "stream",
+		Handler:  StreamChanges,
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Params: QueryParams{
+					"since": MkQueryInfo(P_Int64, false),
+					"kinds": MkQueryInfo(P_ChangeKinds, false),
+				},
+			},
+		},
+		Description: `A Server-Sent Events stream of every change to the user's library<br>
+		each event's type is the kind of row that changed: info, metadata, user, event, relation, transaction, subscription, inventory, loan, or exchangeRate<br>
+		each event's id is the change's Seq, which only ever increases<br>
+		the stream starts after the Last-Event-ID header (sent by EventSource when it reconnects), or ?since, otherwise only new changes are sent<br>
+		?kinds is a , separated list of the kinds to send, by default every kind is sent<br>
+		the row itself is not sent, use eg: /entry/{ItemId}/{kind}, or /engagement/event/list to get it<br>
+		every change is kept for 7 days, after that only the latest change to each row is kept, so a stream that starts further back gets fewer events`,
+		Returns: "text/event-stream of Change",
+	},
+} // }}}
+
+// `/sync` endpoints {{{
+var syncEndpointList = []ApiEndPoint{
+	{
+		EndPoint: "changes",
+		Handler:  SyncChangesSince,
+		Methods: map[string]MethodSpec{
+			"GET": {
+				Params: QueryParams{
+					"since": MkQueryInfo(P_Int64, false),
+					"kinds": MkQueryInfo(P_ChangeKinds, false),
+				},
+			},
+		},
+		Description: `Every row of the user's library that was inserted, updated, or deleted after the change ?since<br>
+		rows are sent as they are now, once each, in Upserts, or in Deletes if they no longer exist<br>
+		?since=0 (the default) sends the whole library<br>
+		the response's Seq is the ?since to use next time, if More is true there are more changes, and it should be called again right away<br>
+		?kinds is a , separated list of the kinds to send, by default every kind is sent`,
+		Returns: "SyncChanges",
+	},
+	{
+		EndPoint: "push",
+		Handler:  SyncPush,
+		Methods: map[string]MethodSpec{
+			"POST": {},
+		},
+		Description: `Applies edits that were made offline, the body is json: {"Edits": [SyncEdit]}<br>
+		an edit updates the row of Kind (info, metadata, user, inventory, event, transaction, subscription, or loan) identified by ItemId, or RowId<br>
+		Fields maps a field of the row to {"Old": value when last pulled, "New": value}<br>
+		Base is the Seq the client last pulled, and Timestamp is the unix ms of when the edit was made<br>
+		a field conflicts if its value is not Old, or if Old is not given and the row was changed after Base<br>
+		the newer of the edit, and the server's last change to the row wins each conflict, every conflict is reported<br>
+		Timestamp is clamped to when the push is received<br>
+		an edit with Op: "insert" adds an event to ItemId, the other edits of the push are still applied if one is rejected<br>
+		rows cannot be deleted, only events can be inserted, and relations and exchange rates cannot be pushed,
+		use their own endpoints for those<br>
+		values are checked like the parameters of the row's endpoints, an edit with an invalid value is rejected<br>
+		a user entry's Status cannot be pushed, use /engagement/begin, /engagement/finish, etc, so that their events are recorded`,
+		Returns: "SyncPushResponse",
+	},
+} // }}}
+
 // `/docs` endpoints {{{
 var MainDocs = ApiEndPoint{
 	EndPoint:        "",
@@ -1415,6 +2200,12 @@ var Endpoints = map[string][]ApiEndPoint{
 	"/engagement": engagementEndpointList,
 	"/type":       typeEndpoints,
 	"/resource":   resourceEndpointList,
+	"/report":     reportEndpointList,
+	"/ledger":     ledgerEndpointList,
+	"/inventory":  inventoryEndpointList,
+	"/webhook":    webhookEndpointList,
+	"/events":     eventsEndpointList,
+	"/sync":       syncEndpointList,
 	"/transact": {
 		{
 			EndPoint: "{id}",
@@ -1425,12 +2216,18 @@ var Endpoints = map[string][]ApiEndPoint{
 			Description: "perform operations on a transaction",
 			Methods: map[string]MethodSpec {
 				"PATCH": {
-					Description: "Modify a transaction",
+					Description: `Modify a transaction<br>
+					if ?currency is given without ?price, the price stays the same in the new currency`,
 					Params: QueryParams {
 						"price": MkQueryInfo(P_Float64, false),
-						"currency": MkQueryInfo(P_NotEmpty, false),
+						"currency": MkQueryInfo(P_Currency, false),
 						"eventId": MkQueryInfo(P_Int64, false),
 						"itemId": MkQueryInfo(P_Int64, false),
+						"kind": MkQueryInfo(P_TransactionKind, false),
+						"vendor": MkQueryInfo(P_True, false),
+						"order-id": MkQueryInfo(P_True, false),
+						"receipt": MkQueryInfo(P_True, false),
+						"notes": MkQueryInfo(P_True, false),
 					},
 				},
 				"DELETE": {
@@ -1438,6 +2235,54 @@ var Endpoints = map[string][]ApiEndPoint{
 				},
 			},
 		},
+		{
+			EndPoint: "subscription",
+			Handler: SubscriptionsResource,
+			Methods: map[string]MethodSpec {
+				"GET": {
+					Description: "Lists subscriptions",
+					Returns: "JSONL<Subscription>",
+				},
+				"POST": {
+					Description: `Create a subscription for ?id (usually a Library entry for the service)<br>
+					a Subscription transaction is created for ?id every ?period (day, week, month, year) starting at ?start until ?until`,
+					Params: QueryParams {
+						"id": MkQueryInfo(P_VerifyIdAndGetInfoEntry, true),
+						"price": MkQueryInfo(P_Float64, true),
+						"currency": MkQueryInfo(P_Currency, true),
+						"period": MkQueryInfo(P_SubscriptionPeriod, true),
+						"start": MkQueryInfo(P_Int64, false),
+						"until": MkQueryInfo(P_Int64, false),
+						"vendor": MkQueryInfo(P_True, false),
+						"notes": MkQueryInfo(P_True, false),
+					},
+				},
+			},
+		},
+		{
+			EndPoint: "subscription/{id}",
+			Handler: SubscriptionResource,
+			PathParams: QueryParams {
+				"id": MkQueryInfo(P_Int64, true),
+			},
+			Description: "perform operations on a subscription",
+			Methods: map[string]MethodSpec {
+				"PATCH": {
+					Description: "Modify a subscription, already created transactions are not changed",
+					Params: QueryParams {
+						"price": MkQueryInfo(P_Float64, false),
+						"currency": MkQueryInfo(P_Currency, false),
+						"period": MkQueryInfo(P_SubscriptionPeriod, false),
+						"until": MkQueryInfo(P_Int64, false),
+						"vendor": MkQueryInfo(P_True, false),
+						"notes": MkQueryInfo(P_True, false),
+					},
+				},
+				"DELETE": {
+					Description: "Delete a subscription, already created transactions are kept",
+				},
+			},
+		},
 		{
 			EndPoint: "list",
 			Handler: ListTransactions,
@@ -1461,9 +2306,14 @@ var Endpoints = map[string][]ApiEndPoint{
 					Params: QueryParams {
 						"id": MkQueryInfo(P_VerifyIdAndGetInfoEntry, true),
 						"price": MkQueryInfo(P_Float64, true),
-						"currency": MkQueryInfo(P_NotEmpty, true),
+						"currency": MkQueryInfo(P_Currency, true),
 						"timezone": MkQueryInfo(P_NotEmpty, false),
-						"eventId": MkQueryInfo(P_NotEmpty, false),
+						"eventId": MkQueryInfo(P_Int64, false),
+						"kind": MkQueryInfo(P_TransactionKind, false),
+						"vendor": MkQueryInfo(P_True, false),
+						"order-id": MkQueryInfo(P_True, false),
+						"receipt": MkQueryInfo(P_True, false),
+						"notes": MkQueryInfo(P_True, false),
 					},
 				},
 			},
@@ -1477,9 +2327,14 @@ var Endpoints = map[string][]ApiEndPoint{
 					Params: QueryParams {
 						"id": MkQueryInfo(P_Int64, true),
 						"price": MkQueryInfo(P_Float64, false),
-						"currency": MkQueryInfo(P_NotEmpty, false),
+						"currency": MkQueryInfo(P_Currency, false),
 						"eventId": MkQueryInfo(P_Int64, false),
 						"itemId": MkQueryInfo(P_Int64, false),
+						"kind": MkQueryInfo(P_TransactionKind, false),
+						"vendor": MkQueryInfo(P_True, false),
+						"order-id": MkQueryInfo(P_True, false),
+						"receipt": MkQueryInfo(P_True, false),
+						"notes": MkQueryInfo(P_True, false),
 					},
 				},
 			},
@@ -1510,7 +2365,7 @@ func DocHTML(ctx RequestContext) {
 		tableOfContents := "<p>Table of contents</p><ul>"
 		docsHTML := ""
 		for _, root := range []string {
-			"", "/engagement", "/metadata", "/transact", "/resource", "/account", "/type",
+			"", "/engagement", "/metadata", "/transact", "/resource", "/report", "/ledger", "/inventory", "/webhook", "/events", "/sync", "/account", "/type",
 		} {
 			if root != "" {
 				tableOfContents += fmt.Sprintf("<li><a href=\"#%s\">%s</a></li>", root, root)
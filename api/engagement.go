@@ -148,6 +148,12 @@ func FinishMedia(ctx RequestContext) {
 		return
 	}
 
+	if us.AutoFinishParents {
+		if _, err := db.FinishCompletedAncestors(ctx.Uid, timezone, entry.ItemId); err != nil {
+			logging.ELog(err)
+		}
+	}
+
 	w.WriteHeader(200)
 	fmt.Fprintf(w, "%d finished\n", entry.ItemId)
 }
@@ -452,7 +458,7 @@ func RegisterEvent(ctx RequestContext) {
 		Before: int64(before),
 	}
 
-	err = db.RegisterUserEvent(ctx.Uid, event)
+	event.EventId, err = db.RegisterUserEvent(ctx.Uid, event)
 
 	if err != nil{
 		util.WError(w, 500, "Could not register event\n%s", err.Error())
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"fmt"
+
+	"aiolimas/db"
+	"aiolimas/metadata"
+	"aiolimas/settings"
+	db_types "aiolimas/types"
+	"aiolimas/util"
+)
+
+// creates children (seasons, volumes, or episodes) of an entry from its metadata
+// children that were created by a previous expand are skipped
+func ExpandEntry(ctx RequestContext) {
+	parent := ctx.PP["id"].(db_types.InfoEntry)
+
+	meta, err := db.GetMetadataEntryById(actx2dctx(ctx), parent.ItemId)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not get metadata\n%s", err.Error())
+		return
+	}
+
+	us, err := settings.GetUserSettings(ctx.Uid)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not get user settings\n%s", err.Error())
+		return
+	}
+	timezone := ctx.PP.Get("timezone", us.DefaultTimeZone).(string)
+
+	kind := ctx.PP.Get("kind", metadata.DefaultExpandKind(parent.Type)).(metadata.ExpandKind)
+
+	expansions, err := metadata.Expand(kind, parent.Type, meta, us)
+	if err != nil {
+		util.WError(ctx.W, 400, "Could not expand entry\n%s", err.Error())
+		return
+	}
+
+	children, err := db.GetRelation(actx2dctx(ctx), parent.ItemId, db_types.R_Child, false)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not get children\n%s", err.Error())
+		return
+	}
+
+	existing := map[int64]bool{}
+	for _, child := range children {
+		childMeta, err := db.GetMetadataEntryById(actx2dctx(ctx), child.ItemId)
+		if err != nil {
+			continue
+		}
+		if n := metadata.ExpansionNumber(kind, parent.Type, childMeta); n != 0 {
+			existing[n] = true
+		}
+	}
+
+	created := []db_types.InfoEntry{}
+	for _, expansion := range expansions {
+		if existing[expansion.Number] {
+			continue
+		}
+
+		info := db_types.InfoEntry{
+			En_Title:     fmt.Sprintf("%s %s", parent.En_Title, expansion.Title),
+			Format:       parent.Format,
+			Type:         kind.ChildType(parent.Type),
+			ArtStyle:     parent.ArtStyle,
+			Library:      parent.Library,
+		}
+		childMeta := expansion.Meta
+		childMeta.Title = info.En_Title
+		user := db_types.UserViewingEntry{}
+
+		if err := db.AddEntry(ctx.Uid, timezone, &info, &childMeta, &user); err != nil {
+			util.WError(ctx.W, 500, "Could not add %s\n%s", expansion.Title, err.Error())
+			return
+		}
+
+		if err := db.AddRelation(ctx.Uid, info.ItemId, db_types.R_Child, parent.ItemId); err != nil {
+			util.WError(ctx.W, 500, "Could not make %s a child\n%s", expansion.Title, err.Error())
+			return
+		}
+
+		created = append(created, info)
+	}
+
+	ctx.W.WriteHeader(200)
+	writeSQLRowResults(ctx.W, created)
+}
@@ -1,12 +1,13 @@
 package api
 
 import (
-	"compress/gzip"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"reflect"
 	"regexp"
@@ -19,8 +20,12 @@ import (
 
 	"aiolimas/accounts"
 	"aiolimas/db"
+	"aiolimas/graph"
 	"aiolimas/logging"
 	"aiolimas/metadata"
+	"aiolimas/playback"
+	"aiolimas/settings"
+	"aiolimas/stream"
 	"aiolimas/types"
 )
 
@@ -86,6 +91,18 @@ type MethodSpec struct {
 	// whether or not a user id is a required parameter
 	UserIndependant bool
 
+	// the request may authorize with ?uid and ?secret (or the X-Webhook-Secret header)
+	// where secret is the user's WebhookSecret, instead of the Authorization header
+	// this is for services that can only be given a url
+	WebhookAuth bool
+
+	// the request may authorize with the ?uid, ?expires and ?token of a url from stream.StreamURL
+	// instead of the Authorization header, so that the tracks of a playlist can be given to players
+	StreamTokenAuth bool
+
+	// the response is never gzipped, for files that are served with range requests
+	Uncompressed bool
+
 	Deprecated string
 }
 
@@ -132,6 +149,10 @@ func (self *ApiEndPoint) GenerateDocHTML(root string) string {
 			mthdTags += "<div class='tag'>UID</div>"
 		}
 
+		if mthd.WebhookAuth {
+			mthdTags += "<div class='tag'>Secret</div>"
+		}
+
 		paramHTMLBuilder := strings.Builder{}
 		if len(mthd.Params) > 0 {
 			paramHTMLBuilder.WriteString("<h4>URL Parameters</h4><dl class='params'>")
@@ -227,6 +248,52 @@ unauthorized:
 	return "", errors.New(estring)
 }
 
+// whether the request has the WebhookSecret of the user with id uid
+func ckWebhookSecret(uid string, req *http.Request) (int64, bool) {
+	secret := req.URL.Query().Get("secret")
+	if secret == "" {
+		secret = req.Header.Get("X-Webhook-Secret")
+	}
+	if secret == "" || uid == "" {
+		return 0, false
+	}
+
+	uidInt, err := strconv.ParseInt(uid, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	us, err := settings.GetUserSettings(uidInt)
+	if err != nil || us.WebhookSecret == "" {
+		return 0, false
+	}
+
+	return uidInt, subtle.ConstantTimeCompare([]byte(secret), []byte(us.WebhookSecret)) == 1
+}
+
+func ckStreamToken(uid string, req *http.Request) (int64, bool) {
+	query := req.URL.Query()
+	token := query.Get("token")
+	if token == "" || uid == "" {
+		return 0, false
+	}
+
+	uidInt, err := strconv.ParseInt(uid, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(query.Get("id"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return uidInt, stream.VerifyToken(uidInt, id, query.Get("subfile"), expires, token)
+}
+
 func (self *ApiEndPoint) Listener(w http.ResponseWriter, req *http.Request) {
 	parsedParams := ParsedParams{}
 
@@ -260,7 +327,17 @@ func (self *ApiEndPoint) Listener(w http.ResponseWriter, req *http.Request) {
 	authorized := true
 
 	privateAll := os.Getenv("AIO_PRIVATE")
-	if !methodSpec.GuestAllowed || privateAll != "" {
+	secretUid, hasSecret := int64(0), false
+	if methodSpec.WebhookAuth {
+		secretUid, hasSecret = ckWebhookSecret(uidStr, req)
+	}
+	if methodSpec.StreamTokenAuth && !hasSecret {
+		secretUid, hasSecret = ckStreamToken(uidStr, req)
+	}
+
+	if hasSecret {
+		ctx.Authorized = secretUid
+	} else if !methodSpec.GuestAllowed || privateAll != "" {
 		auth := req.Header.Get("Authorization")
 
 		if auth == "" {
@@ -369,14 +446,11 @@ func (self *ApiEndPoint) Listener(w http.ResponseWriter, req *http.Request) {
 
 	r := ctx.Req
 
-	var gz *gzip.Writer
-
 	acceptedEncoding := r.Header.Get("Accept-Encoding")
-	if strings.Contains(acceptedEncoding, "gzip") {
-		w.Header().Set("Content-Encoding", "gzip")
-		gz = gzip.NewWriter(w)
+	if strings.Contains(acceptedEncoding, "gzip") && !methodSpec.Uncompressed {
+		gz := &gzipResponseWriter{ResponseWriter: w}
 		defer gz.Close()
-		w = gzipResponseWriter{Writer: gz, ResponseWriter: w}
+		w = gz
 	}
 
 	ctx.W = w
@@ -507,6 +581,128 @@ func P_UserStatus(ctx RequestContext, in string) (any, error) {
 	return "Planned", fmt.Errorf("Invalid user status: '%s'", in)
 }
 
+func P_Currency(ctx RequestContext, in string) (any, error) {
+	if db_types.IsValidCurrency(in) {
+		return strings.ToUpper(in), nil
+	}
+	return "USD", fmt.Errorf("Invalid ISO 4217 currency code: '%s'", in)
+}
+
+func P_TransactionKind(ctx RequestContext, in string) (any, error) {
+	if db_types.IsValidTransactionKind(in) {
+		return db_types.Transaction(in), nil
+	}
+	return db_types.TRANSACTION_BUY, fmt.Errorf("Invalid transaction kind: '%s'", in)
+}
+
+func P_SubscriptionPeriod(ctx RequestContext, in string) (any, error) {
+	if db_types.IsValidSubscriptionPeriod(in) {
+		return in, nil
+	}
+	return "month", fmt.Errorf("Invalid subscription period: '%s'", in)
+}
+
+func P_Condition(ctx RequestContext, in string) (any, error) {
+	if db_types.IsValidCondition(in) {
+		return db_types.Condition(in), nil
+	}
+	return db_types.C_UNKNOWN, fmt.Errorf("Invalid condition: '%s'", in)
+}
+
+func P_CodeType(ctx RequestContext, in string) (any, error) {
+	if slices.Contains(metadata.ListCodeTypes(), metadata.CodeType(in)) {
+		return in, nil
+	}
+	return "", fmt.Errorf("Invalid code type: '%s'", in)
+}
+
+func P_RelationKind(ctx RequestContext, in string) (any, error) {
+	if _, _, ok := db_types.LookupRelationKind(in); ok {
+		return in, nil
+	}
+	return "", fmt.Errorf("Invalid relation kind: '%s'", in)
+}
+
+func P_ExpandKind(ctx RequestContext, in string) (any, error) {
+	if slices.Contains(metadata.ListExpandKinds(), metadata.ExpandKind(in)) {
+		return metadata.ExpandKind(in), nil
+	}
+	return metadata.EK_SEASONS, fmt.Errorf("Invalid expand kind: '%s'", in)
+}
+
+func P_GraphFormat(ctx RequestContext, in string) (any, error) {
+	if slices.Contains(graph.ListFormats(), graph.Format(in)) {
+		return graph.Format(in), nil
+	}
+	return graph.F_JSON, fmt.Errorf("Invalid graph format: '%s'", in)
+}
+
+func P_PlaylistFormat(ctx RequestContext, in string) (any, error) {
+	if slices.Contains(stream.ListFormats(), stream.Format(in)) {
+		return stream.Format(in), nil
+	}
+	return stream.F_M3U8, fmt.Errorf("Invalid playlist format: '%s'", in)
+}
+
+func P_PlayerFormat(ctx RequestContext, in string) (any, error) {
+	if slices.Contains(playback.ListFormats(), playback.Format(in)) {
+		return playback.Format(in), nil
+	}
+	return playback.F_GENERIC, fmt.Errorf("Invalid player format: '%s'", in)
+}
+
+// a , separated list of webhook events, or groups of events, eg: status.*
+// an empty list means every event
+func P_WebhookEvents(ctx RequestContext, in string) (any, error) {
+	events := []string{}
+	for _, item := range strings.Split(in, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !db_types.IsValidWebhookFilter(item) {
+			return events, fmt.Errorf("Invalid webhook event: '%s'", item)
+		}
+		events = append(events, item)
+	}
+	return events, nil
+}
+
+// a , separated list of change kinds, see db_types.ListChangeKinds
+func P_ChangeKinds(ctx RequestContext, in string) (any, error) {
+	kinds := []string{}
+	for _, item := range strings.Split(in, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !slices.Contains(db_types.ListChangeKinds(), item) {
+			return kinds, fmt.Errorf("Invalid change kind: '%s'", item)
+		}
+		kinds = append(kinds, item)
+	}
+	return kinds, nil
+}
+
+func P_DeliveryStatus(ctx RequestContext, in string) (any, error) {
+	if db_types.IsValidDeliveryStatus(in) {
+		return db_types.DeliveryStatus(in), nil
+	}
+	return db_types.DS_PENDING, fmt.Errorf("Invalid delivery status: '%s'", in)
+}
+
+// an absolute http(s) url
+func P_HttpUrl(ctx RequestContext, in string) (any, error) {
+	u, err := url.Parse(in)
+	if err != nil {
+		return in, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return in, fmt.Errorf("Not an http(s) url: '%s'", in)
+	}
+	return in, nil
+}
+
 func P_TList[T any](sep string, toT func(in string) T) func(RequestContext, string) (any, error) {
 	return func(ctx RequestContext, in string) (any, error) {
 		var arr []T
@@ -518,6 +714,19 @@ func P_TList[T any](sep string, toT func(in string) T) func(RequestContext, stri
 	}
 }
 
+// a , separated list of ints
+func P_Int64List(ctx RequestContext, in string) (any, error) {
+	arr := []int64{}
+	for _, item := range strings.Split(in, ",") {
+		n, err := strconv.ParseInt(strings.TrimSpace(item), 10, 64)
+		if err != nil {
+			return arr, fmt.Errorf("Invalid int: '%s'", item)
+		}
+		arr = append(arr, n)
+	}
+	return arr, nil
+}
+
 func P_Uint64Array(ctx RequestContext, in string) (any, error) {
 	var arr []uint64
 	err := json.Unmarshal([]byte(in), &arr)
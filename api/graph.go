@@ -0,0 +1,28 @@
+package api
+
+import (
+	"fmt"
+
+	"aiolimas/graph"
+	"aiolimas/util"
+)
+
+func ExportGraph(ctx RequestContext) {
+	search := ctx.PP.Get("search", "").(string)
+	// can be -1 if user does not provide uid
+	if search != "" && ctx.Uid > 0 {
+		search += fmt.Sprintf(" & {entryInfo.uid = %d}", ctx.Uid)
+	}
+
+	g, err := graph.Build(actx2dctx(ctx), search, ctx.PP.Get("thumbnails", false).(bool))
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not build graph\n%s", err.Error())
+		return
+	}
+
+	format := ctx.PP.Get("format", graph.F_JSON).(graph.Format)
+
+	ctx.W.Header().Set("Content-Type", format.ContentType())
+	ctx.W.WriteHeader(200)
+	g.Write(ctx.W, format)
+}
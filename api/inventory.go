@@ -0,0 +1,199 @@
+package api
+
+import (
+	"time"
+
+	"aiolimas/db"
+	db_types "aiolimas/types"
+	"aiolimas/util"
+)
+
+func GetInventoryEntry(ctx RequestContext) {
+	entry, err := db.GetInventoryEntry(actx2dctx(ctx), ctx.PP["id"].(db_types.InfoEntry).ItemId)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not get inventory entry\n%s", err.Error())
+		return
+	}
+
+	j, err := entry.ToJson()
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not convert inventory entry to json\n%s", err.Error())
+		return
+	}
+
+	ctx.W.WriteHeader(200)
+	ctx.W.Write(j)
+}
+
+func EditInventoryEntry(ctx RequestContext) {
+	entry, err := db.GetInventoryEntry(actx2dctx(ctx), ctx.PP["id"].(db_types.InfoEntry).ItemId)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not get inventory entry\n%s", err.Error())
+		return
+	}
+
+	if condition := ctx.PP.Get("condition", nil); condition != nil {
+		entry.Condition = condition.(db_types.Condition)
+	}
+
+	if edition := ctx.PP.Get("edition", nil); edition != nil {
+		entry.Edition = edition.(string)
+	}
+
+	if storage := ctx.PP.Get("storage", nil); storage != nil {
+		entry.Storage = storage.(string)
+	}
+
+	if barcode := ctx.PP.Get("barcode", nil); barcode != nil {
+		entry.Barcode = barcode.(string)
+	}
+
+	if purchaseDate := ctx.PP.Get("purchase-date", nil); purchaseDate != nil {
+		entry.PurchaseDate = purchaseDate.(int64)
+	}
+
+	if err := db.SetInventoryEntry(ctx.Uid, &entry); err != nil {
+		util.WError(ctx.W, 500, "Could not update inventory entry\n%s", err.Error())
+		return
+	}
+
+	success(ctx.W)
+}
+
+func InventoryResource(ctx RequestContext) {
+	switch ctx.Req.Method {
+	case "GET":
+		GetInventoryEntry(ctx)
+	case "PATCH":
+		EditInventoryEntry(ctx)
+	case "DELETE":
+		if err := db.DeleteInventoryEntry(ctx.Uid, ctx.PP["id"].(db_types.InfoEntry).ItemId); err != nil {
+			util.WError(ctx.W, 500, "Could not delete inventory entry\n%s", err.Error())
+			return
+		}
+		success(ctx.W)
+	}
+}
+
+func ListInventory(ctx RequestContext) {
+	entries, err := db.ListInventory(actx2dctx(ctx))
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not list inventory\n%s", err.Error())
+		return
+	}
+
+	ctx.W.WriteHeader(200)
+	writeSQLRowResults(ctx.W, entries)
+}
+
+func ListLoans(ctx RequestContext) {
+	var id int64 = 0
+	if item, ok := ctx.PP["id"]; ok {
+		id = item.(db_types.InfoEntry).ItemId
+	}
+
+	overdue := ctx.PP.Get("overdue", false).(bool)
+	active := ctx.PP.Get("active", false).(bool) || overdue
+
+	loans, err := db.ListLoans(actx2dctx(ctx), id, active)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not list loans\n%s", err.Error())
+		return
+	}
+
+	if overdue {
+		now := time.Now().UnixMilli()
+		overdueLoans := []db_types.Loan{}
+		for _, loan := range loans {
+			if loan.IsOverdue(now) {
+				overdueLoans = append(overdueLoans, loan)
+			}
+		}
+		loans = overdueLoans
+	}
+
+	ctx.W.WriteHeader(200)
+	writeSQLRowResults(ctx.W, loans)
+}
+
+func Lend(ctx RequestContext) {
+	loan := db_types.Loan{
+		ItemId:   ctx.PP["id"].(db_types.InfoEntry).ItemId,
+		Borrower: ctx.PP["borrower"].(string),
+		Lent:     ctx.PP.Get("lent", int64(0)).(int64),
+		Due:      ctx.PP.Get("due", int64(0)).(int64),
+		Notes:    ctx.PP.Get("notes", "").(string),
+	}
+
+	if err := db.Lend(ctx.Uid, &loan); err != nil {
+		util.WError(ctx.W, 400, "Could not lend item\n%s", err.Error())
+		return
+	}
+
+	success(ctx.W)
+}
+
+func LoansResource(ctx RequestContext) {
+	switch ctx.Req.Method {
+	case "GET":
+		ListLoans(ctx)
+	case "POST":
+		Lend(ctx)
+	}
+}
+
+func LoanResource(ctx RequestContext) {
+	loan, err := db.GetLoan(actx2dctx(ctx), ctx.PP["id"].(int64))
+	if err != nil {
+		util.WError(ctx.W, 404, "Could not find loan\n%s", err.Error())
+		return
+	}
+
+	switch ctx.Req.Method {
+	case "GET":
+		j, err := loan.ToJson()
+		if err != nil {
+			util.WError(ctx.W, 500, "Could not convert loan to json\n%s", err.Error())
+			return
+		}
+		ctx.W.WriteHeader(200)
+		ctx.W.Write(j)
+		return
+	case "RETURN":
+		if loan.Returned != 0 {
+			util.WError(ctx.W, 400, "Loan %d was already returned\n", loan.LoanId)
+			return
+		}
+		loan.Returned = ctx.PP.Get("returned", time.Now().UnixMilli()).(int64)
+	case "PATCH":
+		if borrower := ctx.PP.Get("borrower", nil); borrower != nil {
+			loan.Borrower = borrower.(string)
+		}
+
+		if due := ctx.PP.Get("due", nil); due != nil {
+			loan.Due = due.(int64)
+		}
+
+		if returned := ctx.PP.Get("returned", nil); returned != nil {
+			loan.Returned = returned.(int64)
+		}
+
+		if notes := ctx.PP.Get("notes", nil); notes != nil {
+			loan.Notes = notes.(string)
+		}
+	case "DELETE":
+		if err := db.DeleteLoan(ctx.Uid, loan.LoanId); err != nil {
+			util.WError(ctx.W, 500, "Could not delete loan\n%s", err.Error())
+			return
+		}
+		success(ctx.W)
+		return
+	}
+
+	if err := db.UpdateLoan(ctx.Uid, &loan); err != nil {
+		util.WError(ctx.W, 500, "Could not update loan\n%s", err.Error())
+		return
+	}
+
+	success(ctx.W)
+}
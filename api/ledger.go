@@ -0,0 +1,214 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"aiolimas/db"
+	"aiolimas/ledger"
+	db_types "aiolimas/types"
+	"aiolimas/util"
+)
+
+func mkLedger(ctx RequestContext) (ledger.Ledger, bool) {
+	l, err := ledger.NewLedger(actx2dctx(ctx), ctx.PP.Get("currency", "").(string))
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not load ledger\n%s", err.Error())
+		return l, false
+	}
+	return l, true
+}
+
+func writeLedgerJson(ctx RequestContext, data any) {
+	out, err := json.Marshal(data)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not marshal ledger\n%s", err.Error())
+		return
+	}
+
+	ctx.W.Header().Set("Content-Type", "application/json")
+	ctx.W.WriteHeader(200)
+	ctx.W.Write(out)
+}
+
+func LedgerItem(ctx RequestContext) {
+	l, ok := mkLedger(ctx)
+	if !ok {
+		return
+	}
+
+	total, err := l.ItemTotal(ctx.PP["id"].(db_types.InfoEntry).ItemId)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not calculate total\n%s", err.Error())
+		return
+	}
+
+	writeLedgerJson(ctx, total)
+}
+
+func LedgerCollections(ctx RequestContext) {
+	l, ok := mkLedger(ctx)
+	if !ok {
+		return
+	}
+
+	totals, err := l.CollectionTotals()
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not calculate totals\n%s", err.Error())
+		return
+	}
+
+	writeLedgerJson(ctx, totals)
+}
+
+func LedgerFormats(ctx RequestContext) {
+	l, ok := mkLedger(ctx)
+	if !ok {
+		return
+	}
+
+	writeLedgerJson(ctx, l.FormatTotals())
+}
+
+func LedgerPeriods(ctx RequestContext) {
+	period := ctx.PP.Get("period", "month").(string)
+	if period != "year" && period != "month" && period != "day" {
+		util.WError(ctx.W, 400, "?period must be one of year, month, day\n")
+		return
+	}
+
+	l, ok := mkLedger(ctx)
+	if !ok {
+		return
+	}
+
+	writeLedgerJson(ctx, l.PeriodTotals(period))
+}
+
+func LedgerProfits(ctx RequestContext) {
+	l, ok := mkLedger(ctx)
+	if !ok {
+		return
+	}
+
+	writeLedgerJson(ctx, l.Profits())
+}
+
+func LedgerAmortized(ctx RequestContext) {
+	l, ok := mkLedger(ctx)
+	if !ok {
+		return
+	}
+
+	writeLedgerJson(ctx, l.AmortizedTotals())
+}
+
+func ListExchangeRates(ctx RequestContext) {
+	rates, err := db.ListExchangeRates(ctx.Uid)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not list exchange rates\n%s", err.Error())
+		return
+	}
+
+	ctx.W.WriteHeader(200)
+	writeSQLRowResults(ctx.W, rates)
+}
+
+func ExchangeRateResource(ctx RequestContext) {
+	switch ctx.Req.Method {
+	case "GET":
+		ListExchangeRates(ctx)
+	case "POST":
+		rate, has := ctx.PP["rate"]
+		if !has {
+			util.WError(ctx.W, 400, "?rate is required\n")
+			return
+		}
+
+		err := db.SetExchangeRate(ctx.Uid, db_types.ExchangeRate{
+			From: ctx.PP["from"].(string),
+			To:   ctx.PP["to"].(string),
+			Rate: rate.(float64),
+		})
+		if err != nil {
+			util.WError(ctx.W, 500, "Could not set exchange rate\n%s", err.Error())
+			return
+		}
+		success(ctx.W)
+	case "DELETE":
+		err := db.DeleteExchangeRate(ctx.Uid, ctx.PP["from"].(string), ctx.PP["to"].(string))
+		if err != nil {
+			util.WError(ctx.W, 500, "Could not delete exchange rate\n%s", err.Error())
+			return
+		}
+		success(ctx.W)
+	}
+}
+
+// parses csv in the form of from,to,rate
+// a header line is allowed
+func parseExchangeRateCSV(data io.Reader) ([]db_types.ExchangeRate, error) {
+	out := []db_types.ExchangeRate{}
+
+	reader := csv.NewReader(data)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return out, err
+	}
+
+	for i, record := range records {
+		from := strings.ToUpper(record[0])
+		to := strings.ToUpper(record[1])
+		rate, err := strconv.ParseFloat(record[2], 64)
+
+		if i == 0 && err != nil {
+			// header
+			continue
+		} else if err != nil {
+			return out, fmt.Errorf("line %d: invalid rate '%s'", i+1, record[2])
+		}
+
+		if !db_types.IsValidCurrency(from) || !db_types.IsValidCurrency(to) {
+			return out, fmt.Errorf("line %d: invalid currency", i+1)
+		}
+
+		if rate <= 0 {
+			return out, fmt.Errorf("line %d: rate must be positive", i+1)
+		}
+
+		out = append(out, db_types.ExchangeRate{
+			From: from,
+			To:   to,
+			Rate: rate,
+		})
+	}
+
+	return out, nil
+}
+
+func ImportExchangeRates(ctx RequestContext) {
+	defer ctx.Req.Body.Close()
+
+	rates, err := parseExchangeRateCSV(ctx.Req.Body)
+	if err != nil {
+		util.WError(ctx.W, 400, "Invalid csv\n%s", err.Error())
+		return
+	}
+
+	for _, rate := range rates {
+		if err := db.SetExchangeRate(ctx.Uid, rate); err != nil {
+			util.WError(ctx.W, 500, "Could not set exchange rate\n%s", err.Error())
+			return
+		}
+	}
+
+	ctx.W.WriteHeader(200)
+	fmt.Fprintf(ctx.W, "%d rates imported\n", len(rates))
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"aiolimas/db"
+	db_types "aiolimas/types"
+)
+
+// the handlers read and write the user's library, so the tests share one fresh database
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "aio-api-test")
+	if err != nil {
+		panic(err.Error())
+	}
+	os.Setenv("AIO_DIR", dir)
+
+	// the schema, and the docs are read relative to the root of the repo
+	if err := os.Chdir(".."); err != nil {
+		panic(err.Error())
+	}
+	if err := db.InitDb(); err != nil {
+		panic(err.Error())
+	}
+
+	// metadata providers fail as if there was no connection, instead of reaching real services
+	http.DefaultTransport = offlineTransport{}
+
+	code := m.Run()
+	db.DB.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// adds an entry with the title to uid, and returns it
+func addTestEntry(t *testing.T, uid int64, title string) db_types.InfoEntry {
+	t.Helper()
+	info := db_types.InfoEntry{En_Title: title, Type: db_types.TY_MOVIE}
+	var meta db_types.MetadataEntry
+	var user db_types.UserViewingEntry
+	if err := db.AddEntry(uid, "", &info, &meta, &user); err != nil {
+		t.Fatalf("could not add %s: %s", title, err.Error())
+	}
+	return info
+}
+
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("the tests are offline")
+}
+
+// sends the payload in the file at path (relative to the root of the repo) to the hook handler as uid
+func sendTestHook(t *testing.T, handler func(RequestContext), uid int64, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler(RequestContext{
+		Uid:        uid,
+		Authorized: uid,
+		Req:        httptest.NewRequest("POST", "/", bytes.NewReader(body)),
+		W:          w,
+		PP:         ParsedParams{},
+	})
+	return w
+}
+
+// the entries of uid with the title
+func testEntriesTitled(t *testing.T, uid int64, title string) []db_types.InfoEntry {
+	t.Helper()
+	entries, err := db.ListEntries(db.RequestContext{UID: uid, Auth: uid}, "entryInfo.itemId")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := []db_types.InfoEntry{}
+	for _, entry := range entries {
+		if entry.En_Title == title {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// the only child of id, it fails the test if id does not have exactly 1
+func testOnlyChild(t *testing.T, uid int64, id int64) db_types.InfoEntry {
+	t.Helper()
+	children, err := db.GetRelation(db.RequestContext{UID: uid, Auth: uid}, id, db_types.R_Child, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(children) != 1 {
+		t.Fatalf("%d has %d children", id, len(children))
+	}
+	return children[0]
+}
@@ -7,6 +7,7 @@ import (
 	"aiolimas/db"
 	"aiolimas/logging"
 	"aiolimas/metadata"
+	"aiolimas/settings"
 	"aiolimas/types"
 	"aiolimas/util"
 )
@@ -49,6 +50,31 @@ func FetchLocation(ctx RequestContext) {
 	ctx.W.Write([]byte(location))
 }
 
+// gets new metadata for entry, merged from the providers in the user's MetadataPriority
+// the fields that current has locked are kept
+// returns the new metadata, and field -> the provider it came from
+func proposeMetadata(uid int64, entry *db_types.InfoEntry, current db_types.MetadataEntry, providerOverride string) (db_types.MetadataEntry, map[string]string, error) {
+	if !metadata.IsValidProvider(providerOverride) {
+		providerOverride = ""
+	}
+
+	newMeta, sources, err := metadata.GetMergedMetadata(&metadata.GetMetadataInfo{
+		Entry:         entry,
+		MetadataEntry: &current,
+		Override:      providerOverride,
+		Uid:           uid,
+	})
+	if err != nil {
+		return newMeta, sources, err
+	}
+
+	for _, field := range metadata.ParseLocks(current) {
+		delete(sources, field)
+	}
+
+	return metadata.ApplyLocks(current, newMeta), sources, nil
+}
+
 func FetchMetadataForEntry(ctx RequestContext) {
 	pp := ctx.PP
 	w := ctx.W
@@ -61,23 +87,11 @@ func FetchMetadataForEntry(ctx RequestContext) {
 		return
 	}
 
-	providerOverride := req.URL.Query().Get("provider")
-	if !metadata.IsValidProvider(providerOverride) {
-		providerOverride = ""
-	}
-
-	newMeta, err := metadata.GetMetadata(&metadata.GetMetadataInfo{
-		Entry:         &mainEntry,
-		MetadataEntry: &metadataEntry,
-		Override:      providerOverride,
-		Uid:           ctx.Uid,
-	})
+	newMeta, _, err := proposeMetadata(ctx.Uid, &mainEntry, metadataEntry, req.URL.Query().Get("provider"))
 	if err != nil {
 		util.WError(w, 500, "%s\n", err.Error())
 		return
 	}
-	newMeta.ItemId = mainEntry.ItemId
-	newMeta.Uid = mainEntry.Uid
 	err = db.UpdateMetadataEntry(ctx.Uid, &newMeta)
 	if err != nil {
 		util.WError(w, 500, "%s\n", err.Error())
@@ -88,6 +102,7 @@ func FetchMetadataForEntry(ctx RequestContext) {
 		util.WError(w, 500, "%s\n", err.Error())
 		return
 	}
+	writeNFOIfEnabled(ctx.Uid, &mainEntry, newMeta)
 
 	data, err := newMeta.ToJson()
 	if err != nil {
@@ -99,6 +114,66 @@ func FetchMetadataForEntry(ctx RequestContext) {
 	w.Write(data)
 }
 
+type metadataPreview struct {
+	Metadata db_types.MetadataEntry
+	Changes  map[string]metadata.FieldChange
+	Sources  map[string]string
+}
+
+// the same as FetchMetadataForEntry, without saving anything
+func PreviewMetadataForEntry(ctx RequestContext) {
+	mainEntry := ctx.PP["id"].(db_types.InfoEntry)
+
+	metadataEntry, err := db.GetMetadataEntryById(actx2dctx(ctx), mainEntry.ItemId)
+	if err != nil {
+		util.WError(ctx.W, 500, "%s\n", err.Error())
+		return
+	}
+
+	newMeta, sources, err := proposeMetadata(ctx.Uid, &mainEntry, metadataEntry, ctx.PP.Get("provider", "").(string))
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not get metadata\n%s", err.Error())
+		return
+	}
+
+	text, err := json.Marshal(metadataPreview{
+		Metadata: newMeta,
+		Changes:  metadata.Diff(metadataEntry, newMeta),
+		Sources:  sources,
+	})
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not encode preview\n%s", err.Error())
+		return
+	}
+
+	ctx.W.Header().Set("Content-Type", "application/json")
+	ctx.W.WriteHeader(200)
+	ctx.W.Write(text)
+}
+
+func LockMetadataFields(ctx RequestContext) {
+	meta := ctx.PP["id"].(db_types.MetadataEntry)
+	fields := ctx.PP["fields"].([]string)
+
+	if err := metadata.SetLocks(&meta, fields, ctx.Req.Method == "POST"); err != nil {
+		util.WError(ctx.W, 400, "%s\n", err.Error())
+		return
+	}
+
+	if err := db.UpdateMetadataEntry(ctx.Uid, &meta); err != nil {
+		util.WError(ctx.W, 500, "Could not update metadata\n%s", err.Error())
+		return
+	}
+
+	text, err := meta.ToJson()
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not serialize metadata\n%s", err.Error())
+		return
+	}
+	ctx.W.WriteHeader(200)
+	ctx.W.Write(text)
+}
+
 func RetrieveMetadataForEntry(ctx RequestContext) {
 	pp := ctx.PP
 	w := ctx.W
@@ -250,8 +325,7 @@ func FinalizeIdentification(ctx RequestContext) {
 	}
 
 	if itemToApplyTo.ItemId != 0 {
-		data.ItemId = itemToApplyTo.ItemId
-		data.Uid = itemToApplyTo.Uid
+		data = metadata.ApplyLocks(itemToApplyTo, data)
 		err = db.UpdateMetadataEntry(ctx.Uid, &data)
 		if err != nil {
 			util.WError(w, 500, "Failed to update metadata\n%s", err.Error())
@@ -266,3 +340,111 @@ func FinalizeIdentification(ctx RequestContext) {
 	}
 	w.Write(j)
 }
+
+type providerInfo struct {
+	Name             string
+	MediaTypes       []db_types.MediaTypes
+	Formats          []db_types.Format
+	Capabilities     []metadata.Capability
+	RequiredSettings []string
+	Configured       bool
+}
+
+func ListProviders(ctx RequestContext) {
+	w := ctx.W
+
+	us, err := settings.GetUserSettings(ctx.Uid)
+	if err != nil {
+		util.WError(w, 500, "Could not get user settings\n%s", err.Error())
+		return
+	}
+
+	out := []providerInfo{}
+	for _, p := range metadata.ListProviders("") {
+		info := providerInfo{
+			Name:             p.Name(),
+			MediaTypes:       p.MediaTypes(),
+			Formats:          p.Formats(),
+			Capabilities:     p.Capabilities(),
+			RequiredSettings: p.RequiredSettings(),
+			Configured:       metadata.IsConfigured(p, us),
+		}
+		if info.MediaTypes == nil {
+			info.MediaTypes = []db_types.MediaTypes{}
+		}
+		if info.Formats == nil {
+			info.Formats = []db_types.Format{}
+		}
+		if info.RequiredSettings == nil {
+			info.RequiredSettings = []string{}
+		}
+		out = append(out, info)
+	}
+
+	text, err := json.Marshal(out)
+	if err != nil {
+		util.WError(w, 500, "Could not encode providers\n%s", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(text)
+}
+
+// writes the nfo for entry if the user has WriteNFO on
+// metadata that came from the nfo is not written back, as that would only lose what aio does not understand
+func writeNFOIfEnabled(uid int64, entry *db_types.InfoEntry, meta db_types.MetadataEntry) {
+	if meta.Provider == "nfo" || entry.Location == "" || !metadata.CanWriteNFO(entry.Type) {
+		return
+	}
+
+	us, err := settings.GetUserSettings(uid)
+	if err != nil {
+		logging.ELog(err)
+		return
+	}
+	if !us.WriteNFO {
+		return
+	}
+
+	if _, err := metadata.WriteNFO(entry, meta, us); err != nil {
+		logging.ELog(err)
+	}
+}
+
+func NFOResource(ctx RequestContext) {
+	entry := ctx.PP["id"].(db_types.InfoEntry)
+
+	meta, err := db.GetMetadataEntryById(actx2dctx(ctx), entry.ItemId)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not get metadata\n%s", err.Error())
+		return
+	}
+
+	us, err := settings.GetUserSettings(ctx.Uid)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not get user settings\n%s", err.Error())
+		return
+	}
+
+	switch ctx.Req.Method {
+	case "GET":
+		_, data, err := metadata.BuildNFO(&entry, meta, us)
+		if err != nil {
+			util.WError(ctx.W, 400, "Could not build nfo\n%s", err.Error())
+			return
+		}
+		ctx.W.Header().Set("Content-Type", "application/xml")
+		ctx.W.WriteHeader(200)
+		ctx.W.Write(data)
+
+	case "POST":
+		nfoPath, err := metadata.WriteNFO(&entry, meta, us)
+		if err != nil {
+			util.WError(ctx.W, 500, "Could not write nfo\n%s", err.Error())
+			return
+		}
+		ctx.W.WriteHeader(200)
+		ctx.W.Write([]byte(settings.CondensePathWithLocationAliases(us.LocationAliases, nfoPath)))
+	}
+}
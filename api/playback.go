@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+
+	"aiolimas/playback"
+	"aiolimas/settings"
+	"aiolimas/util"
+)
+
+func HookPlayer(ctx RequestContext) {
+	format := ctx.PP.Get("format", playback.F_GENERIC).(playback.Format)
+
+	var body []byte
+	var err error
+	// plex sends a multipart form with the json in the payload field
+	if mediaType, _, _ := mime.ParseMediaType(ctx.Req.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		if err = ctx.Req.ParseMultipartForm(1 << 20); err == nil {
+			body = []byte(ctx.Req.FormValue("payload"))
+		}
+	} else {
+		body, err = io.ReadAll(ctx.Req.Body)
+		ctx.Req.Body.Close()
+	}
+	if err != nil {
+		util.WError(ctx.W, 400, "Failed to read body\n%s", err.Error())
+		return
+	}
+
+	event, err := playback.Parse(format, body)
+	if err != nil {
+		util.WError(ctx.W, 400, "Failed to parse event\n%s", err.Error())
+		return
+	}
+
+	us, err := settings.GetUserSettings(ctx.Uid)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not get user settings\n%s", err.Error())
+		return
+	}
+
+	entry, file, err := playback.Resolve(actx2dctx(ctx), us, event)
+	if errors.Is(err, playback.ErrNoEntry) {
+		util.WError(ctx.W, 404, "No entry matches %s\n", event.Path+event.Title)
+		return
+	} else if err != nil {
+		util.WError(ctx.W, 500, "Could not find entry\n%s", err.Error())
+		return
+	}
+
+	result, err := playback.Apply(ctx.Uid, us, entry, file, event)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not update entry\n%s", err.Error())
+		return
+	}
+
+	j, err := json.Marshal(result)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not marshal result\n%s", err.Error())
+		return
+	}
+
+	ctx.W.Header().Set("Content-Type", "application/json")
+	ctx.W.WriteHeader(200)
+	ctx.W.Write(j)
+}
@@ -0,0 +1,345 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"aiolimas/db"
+	meta "aiolimas/metadata"
+	"aiolimas/settings"
+	db_types "aiolimas/types"
+	"aiolimas/util"
+)
+
+// https://wiki.servarr.com/radarr/settings#connections
+type RadarrPostWebhook struct {
+	Movie struct {
+		Id          int
+		Title       string
+		Year        int
+		ReleaseDate string
+		FolderPath  string
+		TmdbId      int
+		ImdbId      string
+		Tags        []string
+	}
+	RemoteMovie struct {
+		TmdbId int
+		ImdbId string
+		Title  string
+		year   int
+	}
+	MovieFile struct {
+		Id           int
+		RelativePath string
+		Path         string
+	}
+	RenamedMovieFiles []struct {
+		RelativePath         string
+		Path                 string
+		PreviousRelativePath string
+		PreviousPath         string
+	}
+	Release struct {
+		Quality           string
+		QualityVersion    int
+		ReleaseGroup      string
+		ReleaseTitle      string
+		Indexer           string
+		Size              int
+		CustomFormatScore int
+	}
+	IsUpgrade bool
+	// MovieFileDelete: missingFromDisk, manual, upgrade, etc
+	DeleteReason string
+	// MovieDelete: whether the movie's folder was deleted too
+	DeletedFiles   bool
+	EventType      string
+	InstanceName   string
+	ApplicationUrl string
+}
+
+func (self *RadarrPostWebhook) imdbId() string {
+	if self.RemoteMovie.ImdbId != "" {
+		return self.RemoteMovie.ImdbId
+	}
+	return self.Movie.ImdbId
+}
+
+// the entry of data.Movie, it is created if it does not exist
+// returns false if one of the movie's tags skips it, and there is no entry
+func _radarrMovie(ctx RequestContext, data RadarrPostWebhook, us settings.SettingsData) (db_types.InfoEntry, bool, bool, error) {
+	radarrId := fmt.Sprintf("%d", data.Movie.Id)
+
+	entryInfo, found, err := findArrEntry(ctx, us, "radarr-id", radarrId, data.Movie.FolderPath)
+	if err != nil {
+		return entryInfo, false, false, err
+	}
+	if found {
+		return entryInfo, true, false, updateDatapoints(ctx, entryInfo.ItemId, map[string]string{"radarr-id": radarrId})
+	}
+
+	var userEntry db_types.UserViewingEntry
+
+	entryInfo.En_Title = data.Movie.Title
+	entryInfo.Type = db_types.TY_MOVIE
+	entryInfo.Format = db_types.F_DIGITAL
+	entryInfo.ItemId = 0
+	entryInfo.Location = settings.CondensePathWithLocationAliases(us.LocationAliases, data.Movie.FolderPath)
+
+	aioTags, ok := applyArrTags(us, data.Movie.Tags, &entryInfo, &userEntry)
+	if !ok {
+		return entryInfo, false, false, nil
+	}
+
+	timezone := us.DefaultTimeZone
+
+	metadata := db_types.MetadataEntry{}
+	if !entryInfo.IsAnime() && data.imdbId() != "" {
+		metadata, err = meta.GetMetadataById(data.imdbId(), ctx.Uid, "omdb")
+		if err != nil {
+			metadata = db_types.MetadataEntry{}
+		}
+	} else {
+		metadata, err = meta.GetMetadata(&meta.GetMetadataInfo{
+			Entry:         &entryInfo,
+			MetadataEntry: &metadata,
+			Uid:           ctx.Uid,
+		})
+		if err != nil {
+			metadata = db_types.MetadataEntry{}
+		}
+	}
+
+	ids := map[string]string{
+		"radarr-id": radarrId,
+		"imdb-id":   data.imdbId(),
+	}
+	if data.Movie.TmdbId != 0 {
+		ids["tmdb-id"] = fmt.Sprintf("%d", data.Movie.TmdbId)
+	}
+	setDatapoints(&metadata, ids)
+
+	if err := db.AddEntry(ctx.Uid, timezone, &entryInfo, &metadata, &userEntry); err != nil {
+		return entryInfo, false, false, err
+	}
+	if len(aioTags) > 0 {
+		if err := db.AddTags(ctx.Uid, entryInfo.ItemId, aioTags); err != nil {
+			return entryInfo, true, true, err
+		}
+		entryInfo, err = db.GetInfoEntryById(actx2dctx(ctx), entryInfo.ItemId)
+		if err != nil {
+			return entryInfo, true, true, err
+		}
+	}
+	return entryInfo, true, true, nil
+}
+
+func writeRadarrEntry(ctx RequestContext, entryInfo db_types.InfoEntry, status int) {
+	j, err := entryInfo.ToJson()
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not convert entry to json\n%s", err.Error())
+		return
+	}
+
+	ctx.W.WriteHeader(status)
+	ctx.W.Write(j)
+}
+
+func _radarrAdd(ctx RequestContext, data RadarrPostWebhook, us settings.SettingsData) {
+	entryInfo, ok, created, err := _radarrMovie(ctx, data, us)
+	if err != nil {
+		util.WError(ctx.W, 500, "Error adding entry\n%s", err.Error())
+		return
+	}
+	if !ok {
+		ctx.W.WriteHeader(200)
+		return
+	}
+
+	if created {
+		writeRadarrEntry(ctx, entryInfo, 201)
+	} else {
+		writeRadarrEntry(ctx, entryInfo, 200)
+	}
+}
+
+// the Location that an entry should have after a file of the movie changed
+// entries that point at a file keep pointing at a file, otherwise they point at the movie's folder
+func radarrLocation(us settings.SettingsData, entryInfo db_types.InfoEntry, folder string, file string) string {
+	if folder == "" {
+		return entryInfo.Location
+	}
+	current := filepath.Clean(settings.ExpandPathWithLocationAliases(us.LocationAliases, entryInfo.Location))
+	folder = filepath.Clean(folder)
+	if file != "" && entryInfo.Location != "" && strings.HasPrefix(current, folder+string(filepath.Separator)) {
+		return settings.CondensePathWithLocationAliases(us.LocationAliases, file)
+	}
+	return settings.CondensePathWithLocationAliases(us.LocationAliases, folder)
+}
+
+// a file of the movie was imported, the entry is created if it does not exist
+func _radarrDownload(ctx RequestContext, data RadarrPostWebhook, us settings.SettingsData) {
+	entryInfo, ok, created, err := _radarrMovie(ctx, data, us)
+	if err != nil {
+		util.WError(ctx.W, 500, "Error adding entry\n%s", err.Error())
+		return
+	}
+	if !ok {
+		ctx.W.WriteHeader(200)
+		return
+	}
+
+	if !created {
+		if err := setLocation(ctx, &entryInfo, radarrLocation(us, entryInfo, data.Movie.FolderPath, data.MovieFile.Path)); err != nil {
+			util.WError(ctx.W, 500, "Could not update entry\n%s", err.Error())
+			return
+		}
+	}
+
+	if err := updateDatapoints(ctx, entryInfo.ItemId, map[string]string{"file-deleted": ""}); err != nil {
+		util.WError(ctx.W, 500, "Could not update metadata\n%s", err.Error())
+		return
+	}
+
+	if created {
+		writeRadarrEntry(ctx, entryInfo, 201)
+	} else {
+		writeRadarrEntry(ctx, entryInfo, 200)
+	}
+}
+
+func _radarrRename(ctx RequestContext, data RadarrPostWebhook, us settings.SettingsData) {
+	entryInfo, found, err := findArrEntry(ctx, us, "radarr-id", fmt.Sprintf("%d", data.Movie.Id), data.Movie.FolderPath)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not find entry\n%s", err.Error())
+		return
+	}
+	if !found {
+		ctx.W.WriteHeader(200)
+		return
+	}
+
+	location := radarrLocation(us, entryInfo, data.Movie.FolderPath, "")
+	current := filepath.Clean(settings.ExpandPathWithLocationAliases(us.LocationAliases, entryInfo.Location))
+	for _, file := range data.RenamedMovieFiles {
+		if file.PreviousPath != "" && filepath.Clean(file.PreviousPath) == current {
+			location = settings.CondensePathWithLocationAliases(us.LocationAliases, file.Path)
+		}
+	}
+
+	if err := setLocation(ctx, &entryInfo, location); err != nil {
+		util.WError(ctx.W, 500, "Could not update entry\n%s", err.Error())
+		return
+	}
+
+	writeRadarrEntry(ctx, entryInfo, 200)
+}
+
+// the file is marked with the "file-deleted" datapoint (the reason it was deleted)
+// if the entry pointed at the file, its Location is moved to the movie's folder
+func _radarrFileDelete(ctx RequestContext, data RadarrPostWebhook, us settings.SettingsData) {
+	// the new file is sent in a Download event
+	if data.DeleteReason == "upgrade" {
+		ctx.W.WriteHeader(200)
+		return
+	}
+
+	entryInfo, found, err := findArrEntry(ctx, us, "radarr-id", fmt.Sprintf("%d", data.Movie.Id), data.Movie.FolderPath)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not find entry\n%s", err.Error())
+		return
+	}
+	if !found {
+		ctx.W.WriteHeader(200)
+		return
+	}
+
+	current := filepath.Clean(settings.ExpandPathWithLocationAliases(us.LocationAliases, entryInfo.Location))
+	if data.MovieFile.Path != "" && filepath.Clean(data.MovieFile.Path) == current {
+		if err := setLocation(ctx, &entryInfo, settings.CondensePathWithLocationAliases(us.LocationAliases, data.Movie.FolderPath)); err != nil {
+			util.WError(ctx.W, 500, "Could not update entry\n%s", err.Error())
+			return
+		}
+	}
+
+	reason := data.DeleteReason
+	if reason == "" {
+		reason = "unknown"
+	}
+	if err := updateDatapoints(ctx, entryInfo.ItemId, map[string]string{"file-deleted": reason}); err != nil {
+		util.WError(ctx.W, 500, "Could not update metadata\n%s", err.Error())
+		return
+	}
+
+	writeRadarrEntry(ctx, entryInfo, 200)
+}
+
+// the movie was removed from radarr, see arrDelete
+func _radarrDelete(ctx RequestContext, data RadarrPostWebhook, us settings.SettingsData) {
+	entryInfo, found, err := entryByDatapoint(ctx, "radarr-id", fmt.Sprintf("%d", data.Movie.Id))
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not find entry\n%s", err.Error())
+		return
+	}
+	if !found {
+		ctx.W.WriteHeader(200)
+		return
+	}
+
+	if err := arrDelete(ctx, us, entryInfo, "radarr-id", data.DeletedFiles); err != nil {
+		util.WError(ctx.W, 500, "Could not remove entry\n%s", err.Error())
+		return
+	}
+
+	success(ctx.W)
+}
+
+func HookRadarr(ctx RequestContext) {
+	body, err := io.ReadAll(ctx.Req.Body)
+
+	defer ctx.Req.Body.Close()
+	if err != nil {
+		util.WError(ctx.W, 500, "Failed to read body\n%s", err.Error())
+		return
+	}
+
+	data := RadarrPostWebhook{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		util.WError(ctx.W, 400, "Failed to parse body\n%s", err.Error())
+		return
+	}
+
+	us, err := settings.GetUserSettings(ctx.Uid)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not get user settings\n%s", err.Error())
+		return
+	}
+
+	if slices.Contains(arrIgnoredEvents, data.EventType) {
+		ctx.W.WriteHeader(200)
+		return
+	}
+
+	switch data.EventType {
+	case "Test":
+		ctx.W.WriteHeader(200)
+		ctx.W.Write([]byte("OK"))
+	case "MovieAdded":
+		_radarrAdd(ctx, data, us)
+	case "Download":
+		_radarrDownload(ctx, data, us)
+	case "Rename":
+		_radarrRename(ctx, data, us)
+	case "MovieFileDelete":
+		_radarrFileDelete(ctx, data, us)
+	case "MovieDelete":
+		_radarrDelete(ctx, data, us)
+	default:
+		util.WError(ctx.W, 422, "Unknown event: %s\n", data.EventType)
+	}
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"aiolimas/accounts"
+	"aiolimas/db"
+	"aiolimas/logging"
+	"aiolimas/metadata"
+	"aiolimas/settings"
+	db_types "aiolimas/types"
+	"aiolimas/util"
+)
+
+const (
+	defaultRefreshInterval = 24 * time.Hour
+	defaultRefreshLimit    = 25
+)
+
+func parsePolicyDuration(text string, fallback time.Duration) (time.Duration, error) {
+	if text == "" {
+		return fallback, nil
+	}
+	return time.ParseDuration(text)
+}
+
+// the entries that the policy says should be refreshed at now, least recently refreshed first
+// entries that were never identified (no provider id) are skipped,
+// searching by title again could pick a different result
+// so are entries whose metadata came from an nfo that aio wrote, it only has what aio already knows
+func refreshCandidates(uid int64, policy settings.MetadataRefreshSettings, now time.Time) ([]db_types.MetadataEntry, error) {
+	minInterval, err := parsePolicyDuration(policy.MinInterval, defaultRefreshInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MinInterval: %s", err.Error())
+	}
+	maxAge, err := parsePolicyDuration(policy.MaxAge, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxAge: %s", err.Error())
+	}
+
+	limit := policy.Limit
+	if limit <= 0 {
+		limit = defaultRefreshLimit
+	}
+
+	us, err := settings.GetUserSettings(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := db.RequestContext{UID: uid, Auth: uid}
+
+	metas, err := db.ListMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := db.AllUserEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := map[int64]string{}
+	for _, user := range users {
+		statuses[user.ItemId] = string(user.Status)
+	}
+
+	lastRefreshes, err := db.LastMetadataRefreshes(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []db_types.MetadataEntry{}
+	for _, meta := range metas {
+		if meta.Provider == "" || meta.ProviderID == "" || !metadata.IsValidIdIdentifier(meta.Provider) {
+			continue
+		}
+		if meta.Provider == "nfo" && metadata.IsGeneratedNFO(settings.ExpandPathWithLocationAliases(us.LocationAliases, meta.ProviderID)) {
+			continue
+		}
+
+		last := lastRefreshes[meta.ItemId]
+		age := time.Duration(now.UnixMilli()-last) * time.Millisecond
+		if last != 0 && age < minInterval {
+			continue
+		}
+
+		if slices.Contains(policy.Statuses, statuses[meta.ItemId]) ||
+			(maxAge > 0 && (last == 0 || age >= maxAge)) {
+			out = append(out, meta)
+		}
+	}
+
+	slices.SortStableFunc(out, func(a db_types.MetadataEntry, b db_types.MetadataEntry) int {
+		return int(lastRefreshes[a.ItemId] - lastRefreshes[b.ItemId])
+	})
+
+	if int64(len(out)) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// refreshes the entries that the policy selects, and records each refresh in the refresh log
+// the requests go through the metadata cache, so provider rate limits are respected
+func refreshMetadata(uid int64, policy settings.MetadataRefreshSettings) ([]db_types.MetadataRefresh, error) {
+	out := []db_types.MetadataRefresh{}
+
+	candidates, err := refreshCandidates(uid, policy, time.Now())
+	if err != nil {
+		return out, err
+	}
+
+	for _, meta := range candidates {
+		refresh := db_types.MetadataRefresh{
+			ItemId:    meta.ItemId,
+			Timestamp: time.Now().UnixMilli(),
+			Provider:  meta.Provider,
+		}
+
+		fresh, err := fetchRefresh(uid, meta)
+		if err != nil {
+			refresh.Error = err.Error()
+		} else {
+			merged, changes := metadata.MergeRefresh(meta, fresh)
+			if len(changes) > 0 {
+				if err := db.UpdateMetadataEntry(uid, &merged); err != nil {
+					refresh.Error = err.Error()
+				} else if info, err := db.GetInfoEntryById(db.RequestContext{UID: uid, Auth: uid}, meta.ItemId); err == nil {
+					writeNFOIfEnabled(uid, &info, merged)
+				}
+			}
+
+			changesJson, err := json.Marshal(changes)
+			if err != nil {
+				logging.ELog(err)
+			}
+			refresh.Changes = string(changesJson)
+		}
+
+		if err := db.AddMetadataRefresh(uid, &refresh); err != nil {
+			return out, err
+		}
+		out = append(out, refresh)
+	}
+
+	return out, nil
+}
+
+// providers panic on responses they do not expect,
+// which would take down the server when running in the background
+func fetchRefresh(uid int64, meta db_types.MetadataEntry) (fresh db_types.MetadataEntry, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s returned an unexpected response: %v", meta.Provider, r)
+		}
+	}()
+
+	return metadata.GetMetadataById(meta.ProviderID, uid, meta.Provider)
+}
+
+// refreshes metadata for every user that has MetadataRefresh.Enabled, every interval
+func RefreshMetadataEvery(interval time.Duration) {
+	for {
+		users, err := accounts.ListUsers(os.Getenv("AIO_DIR"))
+		if err != nil {
+			logging.ELog(err)
+		}
+
+		for _, user := range users {
+			us, err := settings.GetUserSettings(user.Id)
+			if err != nil {
+				logging.ELog(err)
+				continue
+			}
+
+			if !us.MetadataRefresh.Enabled {
+				continue
+			}
+
+			refreshes, err := refreshMetadata(user.Id, us.MetadataRefresh)
+			if err != nil {
+				logging.ELog(err)
+			}
+			if len(refreshes) > 0 {
+				logging.Info(fmt.Sprintf("refreshed metadata for %d entries of user %d", len(refreshes), user.Id))
+			}
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+func MetadataRefreshResource(ctx RequestContext) {
+	switch ctx.Req.Method {
+	case "GET":
+		refreshes, err := db.ListMetadataRefreshes(actx2dctx(ctx), ctx.PP.Get("id", int64(0)).(int64), ctx.PP.Get("limit", int64(100)).(int64))
+		if err != nil {
+			util.WError(ctx.W, 500, "Could not list refreshes\n%s", err.Error())
+			return
+		}
+		ctx.W.WriteHeader(200)
+		writeSQLRowResults(ctx.W, refreshes)
+
+	case "POST":
+		us, err := settings.GetUserSettings(ctx.Uid)
+		if err != nil {
+			util.WError(ctx.W, 500, "Could not get user settings\n%s", err.Error())
+			return
+		}
+
+		refreshes, err := refreshMetadata(ctx.Uid, us.MetadataRefresh)
+		if err != nil {
+			util.WError(ctx.W, 500, "Could not refresh metadata\n%s", err.Error())
+			return
+		}
+		ctx.W.WriteHeader(200)
+		writeSQLRowResults(ctx.W, refreshes)
+	}
+}
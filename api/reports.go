@@ -0,0 +1,38 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+
+	"aiolimas/reports"
+	"aiolimas/util"
+)
+
+func ReviewReport(ctx RequestContext) {
+	year := ctx.PP.Get("year", int64(time.Now().Year())).(int64)
+
+	start, end := reports.YearRange(int(year))
+	start = ctx.PP.Get("start", start).(int64)
+	end = ctx.PP.Get("end", end).(int64)
+
+	if end < start {
+		util.WError(ctx.W, 400, "end must be after start\n")
+		return
+	}
+
+	report, err := reports.BuildReport(actx2dctx(ctx), start, end)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not build report\n%s", err.Error())
+		return
+	}
+
+	out, err := json.Marshal(report)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not marshal report\n%s", err.Error())
+		return
+	}
+
+	ctx.W.Header().Set("Content-Type", "application/json")
+	ctx.W.WriteHeader(200)
+	ctx.W.Write(out)
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"compress/gzip"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/hex"
@@ -16,13 +17,60 @@ import (
 	"aiolimas/logging"
 )
 
+// compresses the response, unless it is a range of a file
+// that is only known once the headers are written, so the gzip writer is made then
 type gzipResponseWriter struct {
-	io.Writer
 	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
 }
 
-func (self gzipResponseWriter) Write(b []byte) (int, error) {
-	return self.Writer.Write(b)
+func (self *gzipResponseWriter) WriteHeader(code int) {
+	if self.wroteHeader {
+		return
+	}
+	self.wroteHeader = true
+
+	// Content-Range and Content-Length are of the uncompressed body,
+	// and a 204 or 304 has no body to compress
+	header := self.Header()
+	if header.Get("Content-Range") == "" && header.Get("Content-Encoding") == "" && code != 204 && code != 304 {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		header.Add("Vary", "Accept-Encoding")
+		self.gz = gzip.NewWriter(self.ResponseWriter)
+	}
+	self.ResponseWriter.WriteHeader(code)
+}
+
+func (self *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !self.wroteHeader {
+		self.WriteHeader(200)
+	}
+	if self.gz == nil {
+		return self.ResponseWriter.Write(b)
+	}
+	return self.gz.Write(b)
+}
+
+// streamed responses, eg: /events/stream, have to get through the gzip buffer
+func (self *gzipResponseWriter) Flush() {
+	if !self.wroteHeader {
+		self.WriteHeader(200)
+	}
+	if self.gz != nil {
+		self.gz.Flush()
+	}
+	if flusher, ok := self.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (self *gzipResponseWriter) Close() error {
+	if self.gz == nil {
+		return nil
+	}
+	return self.gz.Close()
 }
 
 func serveThumbnail(w http.ResponseWriter, req *http.Request, path string) {
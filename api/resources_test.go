@@ -0,0 +1,76 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGzipResponseWriter(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	serve := func(w http.ResponseWriter, req *http.Request) {
+		http.ServeContent(w, req, "a.mkv", time.Time{}, strings.NewReader(content))
+	}
+
+	tests := []struct {
+		name     string
+		rangeHdr string
+		status   int
+		gzipped  bool
+		body     string
+	}{
+		{"whole file", "", 200, true, content},
+		{"range", "bytes=10-19", 206, false, content[10:20]},
+		{"unsatisfiable range", "bytes=5000-", 416, false, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			if test.rangeHdr != "" {
+				req.Header.Set("Range", test.rangeHdr)
+			}
+
+			rec := httptest.NewRecorder()
+			w := &gzipResponseWriter{ResponseWriter: rec}
+			serve(w, req)
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if rec.Code != test.status {
+				t.Fatalf("got status %d, want %d", rec.Code, test.status)
+			}
+			gzipped := rec.Header().Get("Content-Encoding") == "gzip"
+			if gzipped != test.gzipped {
+				t.Fatalf("gzipped is %v, want %v", gzipped, test.gzipped)
+			}
+			if test.status == 416 {
+				return
+			}
+
+			body := rec.Body.Bytes()
+			if gzipped {
+				if rec.Header().Get("Content-Length") != "" {
+					t.Error("a gzipped response has the Content-Length of the file")
+				}
+				r, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body, err = io.ReadAll(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if string(body) != test.body {
+				t.Errorf("got %q, want %q", body, test.body)
+			}
+		})
+	}
+}
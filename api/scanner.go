@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+
+	"aiolimas/scanner"
+	"aiolimas/settings"
+	"aiolimas/util"
+)
+
+func ScanLibrary(ctx RequestContext) {
+	us, err := settings.GetUserSettings(ctx.Uid)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not get user settings\n%s", err.Error())
+		return
+	}
+
+	roots := us.LibraryRoots
+	if root, ok := ctx.PP["root"].(string); ok {
+		roots = []settings.LibraryRoot{}
+		for _, r := range us.LibraryRoots {
+			if r.Path == root {
+				roots = append(roots, r)
+			}
+		}
+		if len(roots) == 0 {
+			util.WError(ctx.W, 400, "%s is not in LibraryRoots", root)
+			return
+		}
+	}
+
+	if len(roots) == 0 {
+		util.WError(ctx.W, 400, "No LibraryRoots are set")
+		return
+	}
+
+	report, err := scanner.Scan(ctx.Uid, us, roots, ctx.Req.Method == "POST")
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not scan library\n%s", err.Error())
+		return
+	}
+
+	j, err := json.Marshal(report)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not marshal report\n%s", err.Error())
+		return
+	}
+
+	ctx.W.Header().Set("Content-Type", "application/json")
+	ctx.W.WriteHeader(200)
+	ctx.W.Write(j)
+}
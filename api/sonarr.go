@@ -0,0 +1,319 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+
+	"aiolimas/db"
+	meta "aiolimas/metadata"
+	"aiolimas/settings"
+	db_types "aiolimas/types"
+	"aiolimas/util"
+)
+
+// https://wiki.servarr.com/sonarr/settings#connections
+type SonarrPostWebhook struct {
+	Series struct {
+		Id     int
+		Title  string
+		Path   string
+		TvdbId int
+		TmdbId int
+		ImdbId string
+		Type   string // standard, daily, or anime
+		Year   int
+		Tags   []string
+	}
+	Episodes []struct {
+		Id            int
+		EpisodeNumber int64
+		SeasonNumber  int64
+		Title         string
+		Overview      string
+		AirDate       string
+	}
+	EpisodeFile struct {
+		Id           int
+		RelativePath string
+		Path         string
+	}
+	IsUpgrade      bool
+	DeletedFiles   bool
+	EventType      string
+	InstanceName   string
+	ApplicationUrl string
+}
+
+// the show entry of data.Series, it is created if it does not exist
+// returns false if the series is tagged no-add and there is no entry
+func _sonarrShow(ctx RequestContext, data SonarrPostWebhook, us settings.SettingsData) (db_types.InfoEntry, bool, bool, error) {
+	sonarrId := fmt.Sprintf("%d", data.Series.Id)
+
+	entry, found, err := findArrEntry(ctx, us, "sonarr-id", sonarrId, data.Series.Path)
+	if err != nil {
+		return entry, false, false, err
+	}
+	if found {
+		return entry, true, false, updateDatapoints(ctx, entry.ItemId, map[string]string{"sonarr-id": sonarrId})
+	}
+
+	var userEntry db_types.UserViewingEntry
+
+	entry.En_Title = data.Series.Title
+	entry.Type = db_types.TY_SHOW
+	entry.Format = db_types.F_DIGITAL
+	entry.Location = settings.CondensePathWithLocationAliases(us.LocationAliases, data.Series.Path)
+	if data.Series.Type == "anime" {
+		entry.ArtStyle |= db_types.AS_ANIME
+	}
+
+	aioTags, ok := applyArrTags(us, data.Series.Tags, &entry, &userEntry)
+	if !ok {
+		return entry, false, false, nil
+	}
+
+	metadata, err := meta.GetMetadataById(sonarrId, ctx.Uid, "sonarr")
+	if err != nil && !entry.IsAnime() && data.Series.ImdbId != "" {
+		metadata, err = meta.GetMetadataById(data.Series.ImdbId, ctx.Uid, "omdb")
+	}
+	if err != nil {
+		metadata, err = meta.GetMetadata(&meta.GetMetadataInfo{
+			Entry:         &entry,
+			MetadataEntry: &metadata,
+			Uid:           ctx.Uid,
+		})
+	}
+	if err != nil {
+		metadata = db_types.MetadataEntry{}
+	}
+
+	ids := map[string]string{
+		"sonarr-id": sonarrId,
+		"imdb-id":   data.Series.ImdbId,
+	}
+	if data.Series.TvdbId != 0 {
+		ids["tvdb-id"] = fmt.Sprintf("%d", data.Series.TvdbId)
+	}
+	if data.Series.TmdbId != 0 {
+		ids["tmdb-id"] = fmt.Sprintf("%d", data.Series.TmdbId)
+	}
+	setDatapoints(&metadata, ids)
+
+	if err := db.AddEntry(ctx.Uid, us.DefaultTimeZone, &entry, &metadata, &userEntry); err != nil {
+		return entry, false, false, err
+	}
+	if len(aioTags) > 0 {
+		if err := db.AddTags(ctx.Uid, entry.ItemId, aioTags); err != nil {
+			return entry, true, true, err
+		}
+		entry, err = db.GetInfoEntryById(actx2dctx(ctx), entry.ItemId)
+		if err != nil {
+			return entry, true, true, err
+		}
+	}
+	return entry, true, true, nil
+}
+
+// the child of parent with the given season/episode number, it is created if it does not exist
+// if location is not "", it becomes the child's Location
+func _sonarrChild(ctx RequestContext, parent db_types.InfoEntry, kind meta.ExpandKind, number int64, location string, childMeta db_types.MetadataEntry, us settings.SettingsData) (db_types.InfoEntry, bool, error) {
+	children, err := db.GetRelation(actx2dctx(ctx), parent.ItemId, db_types.R_Child, false)
+	if err != nil {
+		return db_types.InfoEntry{}, false, err
+	}
+
+	for _, child := range children {
+		m, err := db.GetMetadataEntryById(actx2dctx(ctx), child.ItemId)
+		if err != nil || meta.ExpansionNumber(kind, parent.Type, m) != number {
+			continue
+		}
+		if location == "" || child.Location == location {
+			return child, false, nil
+		}
+		child.Location = location
+		return child, true, db.UpdateInfoEntry(ctx.Uid, &child)
+	}
+
+	info := db_types.InfoEntry{
+		En_Title: fmt.Sprintf("%s %s %d", parent.En_Title, kind.Title(), number),
+		Format:   parent.Format,
+		Type:     kind.ChildType(parent.Type),
+		ArtStyle: parent.ArtStyle,
+		Library:  parent.Library,
+		Location: location,
+	}
+
+	md := map[string]string{}
+	json.Unmarshal([]byte(childMeta.MediaDependant), &md)
+	md[kind.NumberKey(parent.Type)] = fmt.Sprintf("%d", number)
+	mdJson, _ := json.Marshal(md)
+	childMeta.MediaDependant = string(mdJson)
+	if childMeta.Title == "" {
+		childMeta.Title = info.En_Title
+	}
+
+	user := db_types.UserViewingEntry{}
+	if err := db.AddEntry(ctx.Uid, us.DefaultTimeZone, &info, &childMeta, &user); err != nil {
+		return info, false, err
+	}
+	if err := db.AddRelation(ctx.Uid, info.ItemId, db_types.R_Child, parent.ItemId); err != nil {
+		return info, false, err
+	}
+	return info, true, nil
+}
+
+func _sonarrAdd(ctx RequestContext, data SonarrPostWebhook, us settings.SettingsData) {
+	entry, ok, created, err := _sonarrShow(ctx, data, us)
+	if err != nil {
+		util.WError(ctx.W, 500, "Error adding entry\n%s", err.Error())
+		return
+	}
+	if !ok {
+		ctx.W.WriteHeader(200)
+		return
+	}
+
+	location := settings.CondensePathWithLocationAliases(us.LocationAliases, data.Series.Path)
+	if !created && location != "" {
+		if err := setLocation(ctx, &entry, location); err != nil {
+			util.WError(ctx.W, 500, "Could not update entry\n%s", err.Error())
+			return
+		}
+	}
+
+	j, err := entry.ToJson()
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not convert new entry to json\n%s", err.Error())
+		return
+	}
+
+	if created {
+		ctx.W.WriteHeader(201)
+	} else {
+		ctx.W.WriteHeader(200)
+	}
+	ctx.W.Write(j)
+}
+
+// an episode was imported, its season and episode children are created, or moved to the new file
+func _sonarrDownload(ctx RequestContext, data SonarrPostWebhook, us settings.SettingsData) {
+	show, ok, _, err := _sonarrShow(ctx, data, us)
+	if err != nil {
+		util.WError(ctx.W, 500, "Error adding entry\n%s", err.Error())
+		return
+	}
+	if !ok {
+		ctx.W.WriteHeader(200)
+		return
+	}
+
+	// sonarr puts seasons in their own folder unless it is told not to
+	seasonLocation := ""
+	episodeLocation := ""
+	if data.EpisodeFile.Path != "" {
+		episodeLocation = settings.CondensePathWithLocationAliases(us.LocationAliases, data.EpisodeFile.Path)
+		dir := filepath.Dir(data.EpisodeFile.Path)
+		if filepath.Clean(dir) != filepath.Clean(data.Series.Path) {
+			seasonLocation = settings.CondensePathWithLocationAliases(us.LocationAliases, dir)
+		}
+	}
+
+	changed := []db_types.InfoEntry{}
+	for _, episode := range data.Episodes {
+		season, seasonChanged, err := _sonarrChild(ctx, show, meta.EK_SEASONS, episode.SeasonNumber, seasonLocation, db_types.MetadataEntry{}, us)
+		if err != nil {
+			util.WError(ctx.W, 500, "Could not add season %d\n%s", episode.SeasonNumber, err.Error())
+			return
+		}
+		if seasonChanged && !slices.ContainsFunc(changed, func(e db_types.InfoEntry) bool { return e.ItemId == season.ItemId }) {
+			changed = append(changed, season)
+		}
+
+		episodeMeta := db_types.MetadataEntry{
+			Title:       episode.Title,
+			Description: episode.Overview,
+		}
+		if len(episode.AirDate) >= 4 {
+			fmt.Sscanf(episode.AirDate[:4], "%d", &episodeMeta.ReleaseYear)
+		}
+		setDatapoints(&episodeMeta, map[string]string{
+			"sonarr-episode-id": fmt.Sprintf("%d", episode.Id),
+		})
+
+		ep, epChanged, err := _sonarrChild(ctx, season, meta.EK_EPISODES, episode.EpisodeNumber, episodeLocation, episodeMeta, us)
+		if err != nil {
+			util.WError(ctx.W, 500, "Could not add episode %d\n%s", episode.EpisodeNumber, err.Error())
+			return
+		}
+		if epChanged {
+			changed = append(changed, ep)
+		}
+	}
+
+	ctx.W.WriteHeader(200)
+	writeSQLRowResults(ctx.W, changed)
+}
+
+// the series was removed from sonarr, see arrDelete
+func _sonarrDelete(ctx RequestContext, data SonarrPostWebhook, us settings.SettingsData) {
+	show, found, err := entryByDatapoint(ctx, "sonarr-id", fmt.Sprintf("%d", data.Series.Id))
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not find entry\n%s", err.Error())
+		return
+	}
+	if !found {
+		ctx.W.WriteHeader(200)
+		return
+	}
+
+	if err := arrDelete(ctx, us, show, "sonarr-id", data.DeletedFiles); err != nil {
+		util.WError(ctx.W, 500, "Could not remove entry\n%s", err.Error())
+		return
+	}
+
+	success(ctx.W)
+}
+
+func HookSonarr(ctx RequestContext) {
+	body, err := io.ReadAll(ctx.Req.Body)
+
+	defer ctx.Req.Body.Close()
+	if err != nil {
+		util.WError(ctx.W, 500, "Failed to read body\n%s", err.Error())
+		return
+	}
+
+	data := SonarrPostWebhook{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		util.WError(ctx.W, 400, "Failed to parse body\n%s", err.Error())
+		return
+	}
+
+	us, err := settings.GetUserSettings(ctx.Uid)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not get user settings\n%s", err.Error())
+		return
+	}
+
+	if slices.Contains(arrIgnoredEvents, data.EventType) {
+		ctx.W.WriteHeader(200)
+		return
+	}
+
+	switch data.EventType {
+	case "Test":
+		ctx.W.WriteHeader(200)
+		ctx.W.Write([]byte("OK"))
+	case "SeriesAdd":
+		_sonarrAdd(ctx, data, us)
+	case "Download":
+		_sonarrDownload(ctx, data, us)
+	case "SeriesDelete":
+		_sonarrDelete(ctx, data, us)
+	default:
+		util.WError(ctx.W, 422, "Unknown event: %s\n", data.EventType)
+	}
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"aiolimas/db"
+	db_types "aiolimas/types"
+)
+
+func TestHookSonarr(t *testing.T) {
+	const uid = 20
+	const title = "Frieren: Beyond Journey's End"
+	const showPath = "/media/anime/Frieren - Beyond Journey's End"
+	ctx := db.RequestContext{UID: uid, Auth: uid}
+
+	// the show, its season, and its episode
+	tree := func(t *testing.T) (db_types.InfoEntry, db_types.InfoEntry, db_types.InfoEntry) {
+		shows := testEntriesTitled(t, uid, title)
+		if len(shows) != 1 {
+			t.Fatalf("there are %d shows", len(shows))
+		}
+		season := testOnlyChild(t, uid, shows[0].ItemId)
+		return shows[0], season, testOnlyChild(t, uid, season.ItemId)
+	}
+
+	// the steps build on each other, the way sonarr would send them
+	tests := []struct {
+		name     string
+		fixture  string
+		wantCode int
+		check    func(t *testing.T)
+	}{
+		{"series added", "docs/hooks/sonarr/series-add.json", 201, func(t *testing.T) {
+			shows := testEntriesTitled(t, uid, title)
+			if len(shows) != 1 {
+				t.Fatalf("there are %d shows", len(shows))
+			}
+			show := shows[0]
+			if show.Type != db_types.TY_SHOW || show.Location != showPath || !show.IsAnime() {
+				t.Errorf("wrong show: %+v", show)
+			}
+		}},
+		{"series added again", "docs/hooks/sonarr/series-add.json", 200, func(t *testing.T) {
+			if shows := testEntriesTitled(t, uid, title); len(shows) != 1 {
+				t.Errorf("there are %d shows", len(shows))
+			}
+		}},
+		{"episode downloaded", "docs/hooks/sonarr/download.json", 200, func(t *testing.T) {
+			_, season, episode := tree(t)
+			if season.Type != db_types.TY_SHOW || season.Location != showPath+"/Season 01" {
+				t.Errorf("wrong season: %+v", season)
+			}
+			if episode.Type != db_types.TY_EPISODE || episode.Location != showPath+"/Season 01/Frieren - S01E01 - The Journey's End.mkv" {
+				t.Errorf("wrong episode: %+v", episode)
+			}
+
+			meta, err := db.GetMetadataEntryById(ctx, episode.ItemId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Title != "The Journey's End" || meta.ReleaseYear != 2023 {
+				t.Errorf("wrong episode metadata: %+v", meta)
+			}
+		}},
+		{"episode downloaded again", "docs/hooks/sonarr/download.json", 200, func(t *testing.T) {
+			// tree fails if the season, or episode was added twice
+			tree(t)
+		}},
+		{"series deleted", "docs/hooks/sonarr/series-delete.json", 200, func(t *testing.T) {
+			show, season, episode := tree(t)
+			for _, entry := range []db_types.InfoEntry{show, season, episode} {
+				if entry.Location != "" {
+					t.Errorf("the files were deleted, but %s is at %s", entry.En_Title, entry.Location)
+				}
+			}
+
+			meta, err := db.GetMetadataEntryById(ctx, show.ItemId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			datapoints := map[string]string{}
+			json.Unmarshal([]byte(meta.Datapoints), &datapoints)
+			if datapoints["sonarr-id"] != "" {
+				t.Errorf("the show is still linked to sonarr: %v", datapoints)
+			}
+		}},
+		{"series deleted again", "docs/hooks/sonarr/series-delete.json", 200, func(t *testing.T) {}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := sendTestHook(t, HookSonarr, uid, test.fixture)
+			if w.Code != test.wantCode {
+				t.Fatalf("got %d: %s", w.Code, w.Body.String())
+			}
+			test.check(t)
+		})
+	}
+}
+
+func TestHookSonarrEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{"test", `{"eventType": "Test"}`, 200},
+		{"ignored", `{"eventType": "Grab"}`, 200},
+		{"unknown", `{"eventType": "Nope"}`, 422},
+		{"not json", `{`, 400},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := t.TempDir() + "/payload.json"
+			if err := os.WriteFile(path, []byte(test.body), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			w := sendTestHook(t, HookSonarr, 21, path)
+			if w.Code != test.wantCode {
+				t.Errorf("got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
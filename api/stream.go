@@ -0,0 +1,130 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"aiolimas/settings"
+	"aiolimas/stream"
+	db_types "aiolimas/types"
+	"aiolimas/util"
+)
+
+// the scheme and host that players reach the server at, playlist urls must be absolute
+// the request can only choose it when there is no PublicURL, the forwarded headers are only read when they are trusted
+func requestBase(req *http.Request) (string, error) {
+	ss, err := settings.GetServerSettings()
+	if err != nil {
+		return "", err
+	}
+	if ss.PublicURL != "" {
+		return strings.TrimSuffix(ss.PublicURL, "/"), nil
+	}
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	host := req.Host
+	if ss.TrustForwardedHeaders {
+		if proto := req.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fwdHost := req.Header.Get("X-Forwarded-Host"); fwdHost != "" {
+			host = fwdHost
+		}
+	}
+	return fmt.Sprintf("%s://%s", scheme, host), nil
+}
+
+func writePlaylist(ctx RequestContext, entry db_types.InfoEntry, subfile string, withChildren bool) {
+	us, err := settings.GetUserSettings(ctx.Uid)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not get user settings\n%s", err.Error())
+		return
+	}
+
+	base, err := requestBase(ctx.Req)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not get server settings\n%s", err.Error())
+		return
+	}
+
+	format := ctx.PP.Get("format", stream.F_M3U8).(stream.Format)
+
+	tracks, err := stream.Tracks(actx2dctx(ctx), us, base, entry, subfile, withChildren)
+	if errors.Is(err, stream.ErrOutsideLocation) {
+		util.WError(ctx.W, 400, "%s", err.Error())
+		return
+	} else if err != nil {
+		util.WError(ctx.W, 500, "Could not build playlist\n%s", err.Error())
+		return
+	}
+
+	title := entry.En_Title
+	if subfile != "" {
+		title += " - " + filepath.Base(subfile)
+	}
+
+	ctx.W.Header().Set("Content-Type", format.ContentType())
+	ctx.W.WriteHeader(200)
+	stream.WritePlaylist(ctx.W, format, title, tracks)
+}
+
+func Stream(ctx RequestContext) {
+	entry := ctx.PP["id"].(db_types.InfoEntry)
+	subfile := ctx.PP.Get("subfile", "").(string)
+
+	us, err := settings.GetUserSettings(ctx.Uid)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not get user settings\n%s", err.Error())
+		return
+	}
+
+	if entry.Location == "" {
+		util.WError(ctx.W, 400, "The entry has no location")
+		return
+	}
+
+	location := settings.ExpandPathWithLocationAliases(us.LocationAliases, entry.Location)
+	fullPath, err := stream.Resolve(location, subfile)
+	if err != nil {
+		util.WError(ctx.W, 400, "%s", err.Error())
+		return
+	}
+
+	file, err := os.Open(fullPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		util.WError(ctx.W, 404, "%s does not exist", entry.Location)
+		return
+	} else if err != nil {
+		util.WError(ctx.W, 500, "Could not open file\n%s", err.Error())
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not stat file\n%s", err.Error())
+		return
+	}
+
+	// folders become a playlist, the root of an entry also includes its children
+	if stat.IsDir() {
+		writePlaylist(ctx, entry, subfile, subfile == "")
+		return
+	}
+
+	ctx.W.Header().Set("Content-Type", stream.ContentType(fullPath))
+	http.ServeContent(ctx.W, ctx.Req, stat.Name(), stat.ModTime(), file)
+}
+
+func Playlist(ctx RequestContext) {
+	entry := ctx.PP["id"].(db_types.InfoEntry)
+	writePlaylist(ctx, entry, "", ctx.PP.Get("children", true).(bool))
+}
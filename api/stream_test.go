@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"aiolimas/db"
+	"aiolimas/settings"
+)
+
+// points AIO_CONFIG_FILE at a config with ss
+func writeServerSettings(t *testing.T, ss settings.ServerSettings) {
+	t.Helper()
+	text, err := json.Marshal(ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, text, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AIO_CONFIG_FILE", path)
+}
+
+func TestRequestBase(t *testing.T) {
+	tests := []struct {
+		name string
+		ss   settings.ServerSettings
+		want string
+	}{
+		{"the host of the request", settings.ServerSettings{}, "http://aio.example.com"},
+		{"public url", settings.ServerSettings{PublicURL: "https://media.example.com/"}, "https://media.example.com"},
+		{"public url is kept behind a proxy", settings.ServerSettings{PublicURL: "https://media.example.com", TrustForwardedHeaders: true}, "https://media.example.com"},
+		{"trusted forwarded headers", settings.ServerSettings{TrustForwardedHeaders: true}, "https://proxy.example.com"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writeServerSettings(t, test.ss)
+			req := httptest.NewRequest("GET", "http://aio.example.com/api/v1/entry/playlist", nil)
+			req.Header.Set("X-Forwarded-Proto", "https")
+			req.Header.Set("X-Forwarded-Host", "proxy.example.com")
+
+			got, err := requestBase(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// the api endpoint with the name
+func testEndPoint(t *testing.T, list []ApiEndPoint, name string) *ApiEndPoint {
+	t.Helper()
+	for i := range list {
+		if list[i].EndPoint == name {
+			return &list[i]
+		}
+	}
+	t.Fatalf("there is no %s endpoint", name)
+	return nil
+}
+
+func TestStreamPlaylistTracks(t *testing.T) {
+	const uid = 44
+
+	dir := t.TempDir()
+	files := map[string]string{"01.mp3": "the first track", "02.mp3": "the second track"}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entry := addTestEntry(t, uid, "Stream Album")
+	entry.Location = dir
+	if err := db.UpdateInfoEntry(uid, &entry); err != nil {
+		t.Fatal(err)
+	}
+	other := addTestEntry(t, uid, "Another Album")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/entry/stream", testEndPoint(t, mainEndpointList, "entry/stream").Listener)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	writeServerSettings(t, settings.ServerSettings{PublicURL: server.URL})
+
+	w := httptest.NewRecorder()
+	Playlist(RequestContext{
+		Uid:        uid,
+		Authorized: uid,
+		Req:        httptest.NewRequest("GET", "/api/v1/entry/playlist", nil),
+		W:          w,
+		PP:         ParsedParams{"id": entry},
+	})
+	if w.Code != 200 {
+		t.Fatalf("playlist: got status %d\n%s", w.Code, w.Body.String())
+	}
+
+	tracks := []string{}
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			tracks = append(tracks, line)
+		}
+	}
+	if len(tracks) != len(files) {
+		t.Fatalf("got %d tracks, want %d\n%s", len(tracks), len(files), w.Body.String())
+	}
+
+	get := func(rawURL string, header http.Header) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest("GET", rawURL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header != nil {
+			req.Header = header
+		}
+		res, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(body)
+	}
+
+	for _, track := range tracks {
+		u, err := url.Parse(track)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := files[u.Query().Get("subfile")]
+
+		// players send no credentials
+		if status, body := get(track, nil); status != 200 || body != want {
+			t.Errorf("%s: got %d '%s', want 200 '%s'", track, status, body, want)
+		}
+		if status, body := get(track, http.Header{"Range": {"bytes=4-8"}}); status != 206 || body != want[4:9] {
+			t.Errorf("%s range: got %d '%s', want 206 '%s'", track, status, body, want[4:9])
+		}
+	}
+
+	track, err := url.Parse(tracks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := map[string]func(url.Values){
+		"without a token":   func(q url.Values) { q.Del("token") },
+		"another entry":     func(q url.Values) { q.Set("id", strconv.FormatInt(other.ItemId, 10)) },
+		"another file":      func(q url.Values) { q.Set("subfile", "02.mp3") },
+		"another user":      func(q url.Values) { q.Set("uid", "45") },
+		"a later expiry":    func(q url.Values) { q.Set("expires", "99999999999") },
+		"an earlier expiry": func(q url.Values) { q.Set("expires", "1") },
+	}
+	for name, tamper := range tampered {
+		u := *track
+		q := u.Query()
+		tamper(q)
+		u.RawQuery = q.Encode()
+		if status, _ := get(u.String(), nil); status != 401 {
+			t.Errorf("%s: got status %d, want 401", name, status)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"aiolimas/db"
+	db_types "aiolimas/types"
+	"aiolimas/util"
+)
+
+/*
+	Incremental sync is built on the changes table (see /events/stream)
+
+	pulling: /sync/changes?since=<Seq> gives the rows that changed after Seq as they are now,
+	the client stores the returned Seq, and pulls from it next time
+
+	pushing: /sync/push takes the edits that a client made offline, each edit says what the fields were
+	when the client last pulled (Old), so that edits made by other devices in the meantime are noticed,
+	those are conflicts, and the edit that was made last wins, per field
+	each edit is read, compared, and saved in 1 transaction, so nothing can change the row in between
+
+	pushed values are checked like the parameters of the row's endpoints (see checkSyncRow)
+
+	pushes cannot delete rows, or insert anything other than events,
+	and relations and exchange rates (which have nothing to edit) are only pulled,
+	for those, the client calls their endpoints when it is back online
+*/
+
+const syncPageSize = 1000
+
+type SyncRow struct {
+	Kind      string
+	ItemId    int64
+	RowId     int64
+	Key       string
+	Seq       int64 // the latest change to the row
+	Timestamp int64 // unix ms of the latest change to the row
+	// the row as it is now, nil if it was deleted
+	Row any `json:",omitempty"`
+}
+
+type SyncChanges struct {
+	// pull from this next time
+	Seq     int64
+	Upserts []SyncRow
+	Deletes []SyncRow
+	// there are more changes after Seq, pull again
+	More bool
+}
+
+func SyncChangesSince(ctx RequestContext) {
+	since := ctx.PP.Get("since", int64(0)).(int64)
+	kinds := ctx.PP.Get("kinds", []string{}).([]string)
+
+	changes, err := db.ListChanges(ctx.Uid, since, syncPageSize)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not list changes\n%s", err.Error())
+		return
+	}
+
+	out := SyncChanges{
+		Seq:     since,
+		Upserts: []SyncRow{},
+		Deletes: []SyncRow{},
+		More:    len(changes) == syncPageSize,
+	}
+
+	// only the latest change to each row matters, since the row is sent as it is now
+	latest := map[string]db_types.Change{}
+	order := []string{}
+	for _, change := range changes {
+		out.Seq = change.Seq
+		if len(kinds) > 0 && !slices.Contains(kinds, change.Kind) {
+			continue
+		}
+		id := change.Kind + ":" + change.Identity()
+		if _, ok := latest[id]; ok {
+			order = slices.DeleteFunc(order, func(o string) bool { return o == id })
+		}
+		latest[id] = change
+		order = append(order, id)
+	}
+
+	for _, id := range order {
+		change := latest[id]
+		row, exists, err := db.GetChangedRow(ctx.Uid, change)
+		if err != nil {
+			util.WError(ctx.W, 500, "Could not get %s %s\n%s", change.Kind, change.Identity(), err.Error())
+			return
+		}
+
+		syncRow := SyncRow{
+			Kind:      change.Kind,
+			ItemId:    change.ItemId,
+			RowId:     change.RowId,
+			Key:       change.Key,
+			Seq:       change.Seq,
+			Timestamp: change.Timestamp,
+		}
+		if exists {
+			syncRow.Row = row
+			out.Upserts = append(out.Upserts, syncRow)
+		} else {
+			out.Deletes = append(out.Deletes, syncRow)
+		}
+	}
+
+	j, err := json.Marshal(out)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not convert changes to json\n%s", err.Error())
+		return
+	}
+	ctx.W.WriteHeader(200)
+	ctx.W.Write(j)
+}
+
+type SyncField struct {
+	// the value when the client last pulled, if it is not given, any change to the row after Base is a conflict
+	Old json.RawMessage
+	New json.RawMessage
+}
+
+type SyncEdit struct {
+	// update, or insert (only for events)
+	Op     string
+	Kind   string
+	ItemId int64
+	RowId  int64
+	// the Seq that the client last pulled
+	Base int64
+	// unix ms of when the edit was made, it wins conflicts against changes made before it
+	// it is clamped to when the push was received, so it cannot win against changes that are made later
+	Timestamp int64
+	Fields    map[string]SyncField
+}
+
+type SyncConflict struct {
+	Field  string
+	Old    json.RawMessage
+	Client json.RawMessage
+	Server any
+	// client, or server
+	Winner string
+}
+
+type SyncResult struct {
+	// applied, or rejected
+	Status    string
+	Error     string `json:",omitempty"`
+	Kind      string
+	ItemId    int64
+	RowId     int64
+	Conflicts []SyncConflict
+	// the row after the edit
+	Row any `json:",omitempty"`
+}
+
+type SyncPushBody struct {
+	Edits []SyncEdit
+}
+
+type SyncPushResponse struct {
+	// the latest change, including the ones made by the push
+	Seq     int64
+	Results []SyncResult
+}
+
+// fields that identify a row, and cannot be edited
+// Status is also left to /engagement/begin, finish, etc, which record events and notify webhooks when it changes
+var syncLockedFields = []string{"Uid", "ItemId", "EventId", "TransactionId", "SubscriptionId", "LoanId", "Status"}
+
+// the field of a row, nil if it does not exist, or cannot be edited
+func syncField(row reflect.Value, name string) (reflect.Value, bool) {
+	if slices.Contains(syncLockedFields, name) {
+		return reflect.Value{}, false
+	}
+	field, ok := row.Type().FieldByName(name)
+	if !ok || field.Tag.Get("runtime") == "true" {
+		return reflect.Value{}, false
+	}
+	return row.FieldByName(name), true
+}
+
+func syncEqual(field reflect.Value, raw json.RawMessage) (bool, error) {
+	other := reflect.New(field.Type())
+	if err := json.Unmarshal(raw, other.Interface()); err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(field.Interface(), other.Elem().Interface()), nil
+}
+
+// checks the values of a row that a push edited, the same way that its endpoints check their parameters
+// before is the row before the edit, it returns the row as it should be saved
+func checkSyncRow(before any, row any, fields map[string]SyncField) (any, error) {
+	_, pushedAmount := fields["Amount"]
+
+	// Amount is in the minor unit of the currency, the price is kept when only the currency is pushed (see EditTransaction)
+	checkCurrency := func(currency *string, amount *int64, old string) error {
+		if !db_types.IsValidCurrency(*currency) {
+			return fmt.Errorf("invalid ISO 4217 currency code: '%s'", *currency)
+		}
+		*currency = strings.ToUpper(*currency)
+		if !pushedAmount && !strings.EqualFold(*currency, old) {
+			*amount = db_types.MajorToMinor(db_types.MinorToMajor(*amount, old), *currency)
+		}
+		return nil
+	}
+
+	switch r := row.(type) {
+	case db_types.InfoEntry:
+		if !db_types.IsValidType(string(r.Type)) {
+			return nil, fmt.Errorf("invalid entry type: '%s'", r.Type)
+		}
+		if !db_types.IsValidFormat(int64(r.Format)) {
+			return nil, fmt.Errorf("invalid format: %d", r.Format)
+		}
+	case db_types.UserViewingEvent:
+		if r.Event == "" {
+			return nil, errors.New("the event name cannot be empty")
+		}
+	case db_types.TransactionEntry:
+		if err := checkCurrency(&r.Currency, &r.Amount, before.(db_types.TransactionEntry).Currency); err != nil {
+			return nil, err
+		}
+		if !db_types.IsValidTransactionKind(string(r.Kind)) {
+			return nil, fmt.Errorf("invalid transaction kind: '%s'", r.Kind)
+		}
+		r.Amount = r.Kind.NormalizeAmount(r.Amount)
+		return r, nil
+	case db_types.Subscription:
+		if err := checkCurrency(&r.Currency, &r.Amount, before.(db_types.Subscription).Currency); err != nil {
+			return nil, err
+		}
+		if !db_types.IsValidSubscriptionPeriod(string(r.Period)) {
+			return nil, fmt.Errorf("invalid subscription period: '%s'", r.Period)
+		}
+		return r, nil
+	case db_types.Loan:
+		if r.Borrower == "" {
+			return nil, errors.New("the borrower cannot be empty")
+		}
+	}
+	return row, nil
+}
+
+func insertSyncEvent(uid int64, edit SyncEdit) (SyncResult, error) {
+	result := SyncResult{Status: "applied", Kind: edit.Kind, ItemId: edit.ItemId, Conflicts: []SyncConflict{}}
+
+	if edit.Kind != "event" {
+		return result, fmt.Errorf("only events can be inserted")
+	}
+	if _, err := db.GetInfoEntryById(db.RequestContext{UID: uid, Auth: uid}, edit.ItemId); err != nil {
+		return result, err
+	}
+
+	event := reflect.New(reflect.TypeFor[db_types.UserViewingEvent]()).Elem()
+	for name, value := range edit.Fields {
+		field, ok := syncField(event, name)
+		if !ok {
+			return result, fmt.Errorf("invalid field: '%s'", name)
+		}
+		if err := json.Unmarshal(value.New, field.Addr().Interface()); err != nil {
+			return result, fmt.Errorf("invalid value for '%s': %s", name, err.Error())
+		}
+	}
+
+	e := event.Interface().(db_types.UserViewingEvent)
+	e.ItemId = edit.ItemId
+	if _, err := checkSyncRow(db_types.UserViewingEvent{}, e, edit.Fields); err != nil {
+		return result, err
+	}
+	eventId, err := db.RegisterUserEvent(uid, e)
+	if err != nil {
+		return result, err
+	}
+
+	result.RowId = eventId
+	result.Row, _, err = db.GetChangedRow(uid, db_types.Change{Kind: "event", ItemId: edit.ItemId, RowId: eventId})
+	return result, err
+}
+
+// received is when the push was received (unix ms)
+func applySyncEdit(uid int64, edit SyncEdit, received int64) (SyncResult, error) {
+	result := SyncResult{Status: "applied", Kind: edit.Kind, ItemId: edit.ItemId, RowId: edit.RowId, Conflicts: []SyncConflict{}}
+
+	if edit.Kind == "relation" || edit.Kind == "exchangeRate" {
+		return result, fmt.Errorf("%s cannot be pushed, use its endpoints", edit.Kind)
+	}
+
+	// a client with a clock that is ahead would otherwise win every conflict
+	timestamp := min(edit.Timestamp, received)
+
+	target := db_types.Change{Kind: edit.Kind, ItemId: edit.ItemId, RowId: edit.RowId}
+	row, err := db.EditChangedRow(uid, target, edit.Base, func(current any, serverChanged int64) (any, bool, error) {
+		result.Conflicts = []SyncConflict{}
+
+		row := reflect.New(reflect.TypeOf(current)).Elem()
+		row.Set(reflect.ValueOf(current))
+
+		changed := false
+		for name, value := range edit.Fields {
+			field, ok := syncField(row, name)
+			if !ok {
+				return nil, false, fmt.Errorf("invalid field: '%s'", name)
+			}
+
+			same, err := syncEqual(field, value.New)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid value for '%s': %s", name, err.Error())
+			}
+			if same {
+				continue
+			}
+
+			conflict := serverChanged != 0
+			if value.Old != nil {
+				unchanged, err := syncEqual(field, value.Old)
+				if err != nil {
+					return nil, false, fmt.Errorf("invalid old value for '%s': %s", name, err.Error())
+				}
+				conflict = !unchanged
+			}
+
+			if conflict {
+				winner := "server"
+				if timestamp > serverChanged {
+					winner = "client"
+				}
+				result.Conflicts = append(result.Conflicts, SyncConflict{
+					Field:  name,
+					Old:    value.Old,
+					Client: value.New,
+					Server: field.Interface(),
+					Winner: winner,
+				})
+				if winner == "server" {
+					continue
+				}
+			}
+
+			if err := json.Unmarshal(value.New, field.Addr().Interface()); err != nil {
+				return nil, false, fmt.Errorf("invalid value for '%s': %s", name, err.Error())
+			}
+			changed = true
+		}
+
+		if !changed {
+			return row.Interface(), false, nil
+		}
+		checked, err := checkSyncRow(current, row.Interface(), edit.Fields)
+		return checked, err == nil, err
+	})
+	result.Row = row
+	return result, err
+}
+
+func SyncPush(ctx RequestContext) {
+	text, err := io.ReadAll(ctx.Req.Body)
+	defer ctx.Req.Body.Close()
+	if err != nil {
+		util.WError(ctx.W, 500, "Failed to read body\n%s", err.Error())
+		return
+	}
+
+	var body SyncPushBody
+	if err := json.Unmarshal(text, &body); err != nil {
+		util.WError(ctx.W, 400, "Failed to parse body\n%s", err.Error())
+		return
+	}
+
+	received := time.Now().UnixMilli()
+
+	out := SyncPushResponse{Results: []SyncResult{}}
+	for _, edit := range body.Edits {
+		var result SyncResult
+		var err error
+		switch edit.Op {
+		case "insert":
+			result, err = insertSyncEvent(ctx.Uid, edit)
+		case "update", "":
+			result, err = applySyncEdit(ctx.Uid, edit, received)
+		default:
+			err = fmt.Errorf("invalid op: '%s', expected update, or insert", edit.Op)
+		}
+
+		if err != nil {
+			result.Status = "rejected"
+			result.Error = err.Error()
+			result.Kind = edit.Kind
+			result.ItemId = edit.ItemId
+			result.RowId = edit.RowId
+			result.Row = nil
+		}
+		out.Results = append(out.Results, result)
+	}
+
+	out.Seq, err = db.LastChangeSeq(ctx.Uid)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not get the latest change\n%s", err.Error())
+		return
+	}
+
+	j, err := json.Marshal(out)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not convert results to json\n%s", err.Error())
+		return
+	}
+	ctx.W.WriteHeader(200)
+	ctx.W.Write(j)
+}
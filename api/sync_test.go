@@ -0,0 +1,272 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"aiolimas/db"
+	db_types "aiolimas/types"
+)
+
+func syncValue(t *testing.T, v any) json.RawMessage {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestApplySyncEdit(t *testing.T) {
+	const uid = 1
+	far := time.Now().Add(time.Hour).UnixMilli()
+
+	tests := []struct {
+		name string
+		// the title that the server changes the entry to after the client pulls, "" to not change it
+		serverTitle string
+		old         string
+		timestamp   func(serverChanged int64) int64
+		// received relative to the server's change
+		receivedAfter bool
+		wantTitle     string
+		wantWinner    string
+	}{
+		{"no conflict", "", "pulled", func(int64) int64 { return far }, true, "client", ""},
+		{"the newer client edit wins", "server", "pulled", func(c int64) int64 { return c + 1 }, true, "client", "client"},
+		{"the newer server change wins", "server", "pulled", func(c int64) int64 { return c - 1 }, true, "server", "server"},
+		{"a timestamp after the push was received is clamped", "server", "pulled", func(int64) int64 { return far }, false, "server", "server"},
+		{"the old value is what the server changed it to", "server", "server", func(c int64) int64 { return c - 1 }, true, "client", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info := addTestEntry(t, uid, "pulled")
+			base, err := db.LastChangeSeq(uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			serverChanged := time.Now().UnixMilli()
+			if test.serverTitle != "" {
+				info.En_Title = test.serverTitle
+				if err := db.UpdateInfoEntry(uid, &info); err != nil {
+					t.Fatal(err)
+				}
+				serverChanged, err = db.LastRowChange(uid, db_types.Change{Kind: "info", ItemId: info.ItemId}, base)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			received := serverChanged + 1
+			if !test.receivedAfter {
+				received = serverChanged - 1
+			}
+
+			result, err := applySyncEdit(uid, SyncEdit{
+				Kind:      "info",
+				ItemId:    info.ItemId,
+				Base:      base,
+				Timestamp: test.timestamp(serverChanged),
+				Fields: map[string]SyncField{
+					"En_Title": {Old: syncValue(t, test.old), New: syncValue(t, "client")},
+				},
+			}, received)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := db.GetInfoEntryById(db.RequestContext{UID: uid, Auth: uid}, info.ItemId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.En_Title != test.wantTitle {
+				t.Errorf("title is %s, want %s", got.En_Title, test.wantTitle)
+			}
+			if result.Row.(db_types.InfoEntry).En_Title != test.wantTitle {
+				t.Errorf("returned row has the title %s", result.Row.(db_types.InfoEntry).En_Title)
+			}
+
+			if test.wantWinner == "" {
+				if len(result.Conflicts) != 0 {
+					t.Errorf("unexpected conflicts: %+v", result.Conflicts)
+				}
+			} else if len(result.Conflicts) != 1 || result.Conflicts[0].Winner != test.wantWinner {
+				t.Errorf("conflicts are %+v, want 1 won by %s", result.Conflicts, test.wantWinner)
+			}
+		})
+	}
+}
+
+func TestApplySyncEditRejects(t *testing.T) {
+	const uid = 1
+	info := addTestEntry(t, uid, "rejected")
+
+	tests := []struct {
+		name string
+		edit SyncEdit
+	}{
+		{"locked field", SyncEdit{Kind: "info", ItemId: info.ItemId, Fields: map[string]SyncField{"Uid": {New: syncValue(t, 2)}}}},
+		{"unknown field", SyncEdit{Kind: "info", ItemId: info.ItemId, Fields: map[string]SyncField{"Nope": {New: syncValue(t, 2)}}}},
+		{"wrong type", SyncEdit{Kind: "info", ItemId: info.ItemId, Fields: map[string]SyncField{"En_Title": {New: syncValue(t, 2)}}}},
+		{"missing row", SyncEdit{Kind: "info", ItemId: -1, Fields: map[string]SyncField{"En_Title": {New: syncValue(t, "x")}}}},
+		{"relation", SyncEdit{Kind: "relation", ItemId: info.ItemId, Fields: map[string]SyncField{"Right": {New: syncValue(t, 1)}}}},
+		{"exchange rate", SyncEdit{Kind: "exchangeRate", Fields: map[string]SyncField{"Rate": {New: syncValue(t, 1)}}}},
+		{"a later field is invalid", SyncEdit{Kind: "info", ItemId: info.ItemId, Fields: map[string]SyncField{
+			"En_Title": {New: syncValue(t, "partly")},
+			"Format":   {New: syncValue(t, "not a number")},
+		}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := applySyncEdit(uid, test.edit, time.Now().UnixMilli()); err == nil {
+				t.Error("the edit was applied")
+			}
+
+			got, err := db.GetInfoEntryById(db.RequestContext{UID: uid, Auth: uid}, info.ItemId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.En_Title != "rejected" || got.Uid != uid {
+				t.Errorf("the entry was changed: %+v", got)
+			}
+		})
+	}
+}
+
+func TestInsertSyncEvent(t *testing.T) {
+	const uid = 1
+	info := addTestEntry(t, uid, "sync events")
+	// other events, so that the new one is not simply the latest
+	other := addTestEntry(t, uid, "other sync events")
+	if err := db.RegisterBasicUserEvent(uid, "UTC", "Viewed", other.ItemId); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := insertSyncEvent(uid, SyncEdit{
+		Op:     "insert",
+		Kind:   "event",
+		ItemId: info.ItemId,
+		Fields: map[string]SyncField{
+			"Event":     {New: syncValue(t, "Finished")},
+			"Timestamp": {New: syncValue(t, 1000)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event, ok := result.Row.(db_types.UserViewingEvent)
+	if !ok {
+		t.Fatalf("row is %T", result.Row)
+	}
+	if event.EventId != result.RowId || event.ItemId != info.ItemId || event.Event != "Finished" || event.Timestamp != 1000 {
+		t.Errorf("wrong event %+v, for row %d", event, result.RowId)
+	}
+
+	if _, err := insertSyncEvent(uid, SyncEdit{Op: "insert", Kind: "info", ItemId: info.ItemId}); err == nil {
+		t.Error("inserted an info entry")
+	}
+}
+
+func TestApplySyncEditChecksValues(t *testing.T) {
+	const uid = 50
+	ctx := db.RequestContext{UID: uid, Auth: uid}
+	now := time.Now().UnixMilli()
+
+	info := addTestEntry(t, uid, "checked")
+	transaction := db_types.TransactionEntry{ItemId: info.ItemId, Currency: "USD", Amount: 1234, Kind: db_types.TRANSACTION_BUY}
+	if err := db.CreateTransaction(uid, "UTC", &transaction); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, borrower := range []string{"first", "second"} {
+		if err := db.Lend(uid, &db_types.Loan{ItemId: info.ItemId, Borrower: borrower}); err != nil {
+			t.Fatal(err)
+		}
+		loans, err := db.ListLoans(ctx, info.ItemId, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loans[0].Returned = now
+		if err := db.UpdateLoan(uid, &loans[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loans, err := db.ListLoans(ctx, info.ItemId, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Lend(uid, &db_types.Loan{ItemId: info.ItemId, Borrower: "third"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// pulled after everything above, so that nothing conflicts
+	base, err := db.LastChangeSeq(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit := func(kind string, rowId int64, name string, value any) SyncEdit {
+		return SyncEdit{Kind: kind, ItemId: info.ItemId, RowId: rowId, Base: base, Timestamp: now, Fields: map[string]SyncField{name: {New: syncValue(t, value)}}}
+	}
+
+	rejected := []struct {
+		name string
+		edit SyncEdit
+	}{
+		{"invalid currency", edit("transaction", transaction.TransactionId, "Currency", "XYZ")},
+		{"invalid transaction kind", edit("transaction", transaction.TransactionId, "Kind", "Stolen")},
+		{"invalid entry type", edit("info", 0, "Type", "Painting")},
+		{"status", edit("user", 0, "Status", "Finished")},
+		{"loan without a borrower", edit("loan", loans[0].LoanId, "Borrower", "")},
+		{"a loan that overlaps another", edit("loan", loans[0].LoanId, "Returned", 0)},
+	}
+	for _, test := range rejected {
+		if _, err := applySyncEdit(uid, test.edit, now); err == nil {
+			t.Errorf("%s: the edit was applied", test.name)
+		}
+	}
+	if _, err := insertSyncEvent(uid, SyncEdit{Op: "insert", Kind: "event", ItemId: info.ItemId, Fields: map[string]SyncField{"Timestamp": {New: syncValue(t, 1000)}}}); err == nil {
+		t.Error("inserted an event without a name")
+	}
+
+	got, err := db.GetTransaction(ctx, transaction.TransactionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Currency != "USD" || got.Kind != db_types.TRANSACTION_BUY || got.Amount != 1234 {
+		t.Fatalf("a rejected edit changed the transaction: %+v", got)
+	}
+
+	applied := []struct {
+		name     string
+		edit     SyncEdit
+		currency string
+		amount   int64
+	}{
+		{"the price is kept when the currency changes", edit("transaction", transaction.TransactionId, "Currency", "jpy"), "JPY", 12},
+		{"the amount is normalized for the kind", edit("transaction", transaction.TransactionId, "Kind", db_types.TRANSACTION_SELL), "JPY", -12},
+	}
+	for _, test := range applied {
+		if test.edit.Base, err = db.LastChangeSeq(uid); err != nil {
+			t.Fatal(err)
+		}
+		result, err := applySyncEdit(uid, test.edit, now)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+		got, err := db.GetTransaction(ctx, transaction.TransactionId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Currency != test.currency || got.Amount != test.amount {
+			t.Errorf("%s: got %d %s, want %d %s", test.name, got.Amount, got.Currency, test.amount, test.currency)
+		}
+		if row := result.Row.(db_types.TransactionEntry); row.Amount != got.Amount {
+			t.Errorf("%s: the returned row has the amount %d, not the saved %d", test.name, row.Amount, got.Amount)
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package api
 
 import (
+	"os"
+	"strings"
+	"time"
+
+	"aiolimas/accounts"
 	"aiolimas/db"
 	"aiolimas/logging"
 	"aiolimas/settings"
@@ -15,20 +20,37 @@ func Transact(ctx RequestContext) {
 		return
 	}
 
-	ty := "Purchased"
-	if ctx.PP["price"].(float64) < 0 {
-		ty = "Sold"
+	price := ctx.PP["price"].(float64)
+
+	ty := db_types.TRANSACTION_BUY
+	if price < 0 {
+		ty = db_types.TRANSACTION_SELL
+	}
+	if kind, ok := ctx.PP["kind"]; ok {
+		ty = kind.(db_types.Transaction)
 	}
 
-	db.CreateTransaction(
-		db_types.Transaction(ty),
+	currency := ctx.PP["currency"].(string)
+
+	err = db.CreateTransaction(
 		ctx.Uid,
-		ctx.PP["id"].(db_types.InfoEntry).ItemId,
-		ctx.PP.Get("eventId", int64(0)).(int64),
 		ctx.PP.Get("timezone", us.DefaultTimeZone).(string),
-		ctx.PP["price"].(float64),
-		ctx.PP["currency"].(string),
+		&db_types.TransactionEntry{
+			ItemId:      ctx.PP["id"].(db_types.InfoEntry).ItemId,
+			EventId:     ctx.PP.Get("eventId", int64(0)).(int64),
+			Currency:    currency,
+			Amount:      db_types.MajorToMinor(price, currency),
+			Kind:        ty,
+			Vendor:      ctx.PP.Get("vendor", "").(string),
+			OrderId:     ctx.PP.Get("order-id", "").(string),
+			ReceiptPath: ctx.PP.Get("receipt", "").(string),
+			Notes:       ctx.PP.Get("notes", "").(string),
+		},
 	)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not create transaction\n%s", err.Error())
+		return
+	}
 
 	ctx.W.WriteHeader(200)
 }
@@ -48,12 +70,16 @@ func EditTransaction(ctx RequestContext) {
 		return
 	}
 
-	if price := ctx.PP.Get("price", nil); price != nil {
-		t.Price = price.(float64)
+	if currency := ctx.PP.Get("currency", nil); currency != nil {
+		// Amount is in the minor unit of the currency, so the same price in the new currency has a different Amount
+		// (eg: 12.34 USD is 1234, and 12.34 JPY would be 12)
+		old := t.Currency
+		t.Currency = strings.ToUpper(currency.(string))
+		t.Amount = db_types.MajorToMinor(db_types.MinorToMajor(t.Amount, old), t.Currency)
 	}
 
-	if currency := ctx.PP.Get("currency", nil); currency != nil {
-		t.Currency = currency.(string)
+	if price := ctx.PP.Get("price", nil); price != nil {
+		t.Amount = db_types.MajorToMinor(price.(float64), t.Currency)
 	}
 
 	if eventId := ctx.PP.Get("eventId", nil); eventId != nil {
@@ -64,7 +90,31 @@ func EditTransaction(ctx RequestContext) {
 		t.ItemId = eventId.(int64)
 	}
 
-	db.UpdateTransaction(ctx.Uid, &t)
+	if kind := ctx.PP.Get("kind", nil); kind != nil {
+		t.Kind = kind.(db_types.Transaction)
+	}
+	t.Amount = t.Kind.NormalizeAmount(t.Amount)
+
+	if vendor := ctx.PP.Get("vendor", nil); vendor != nil {
+		t.Vendor = vendor.(string)
+	}
+
+	if orderId := ctx.PP.Get("order-id", nil); orderId != nil {
+		t.OrderId = orderId.(string)
+	}
+
+	if receipt := ctx.PP.Get("receipt", nil); receipt != nil {
+		t.ReceiptPath = receipt.(string)
+	}
+
+	if notes := ctx.PP.Get("notes", nil); notes != nil {
+		t.Notes = notes.(string)
+	}
+
+	if err := db.UpdateTransaction(ctx.Uid, &t); err != nil {
+		util.WError(ctx.W, 500, "Could not update transaction\n%s", err.Error())
+		return
+	}
 	ctx.W.WriteHeader(200)
 }
 
@@ -101,3 +151,131 @@ func TransactResource(ctx RequestContext) {
 		DeleteTransaction(ctx)
 	}
 }
+
+func ListSubscriptions(ctx RequestContext) {
+	subs, err := db.ListSubscriptions(actx2dctx(ctx))
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not list subscriptions\n%s", err.Error())
+		return
+	}
+
+	ctx.W.WriteHeader(200)
+	writeSQLRowResults(ctx.W, subs)
+}
+
+func CreateSubscription(ctx RequestContext) {
+	currency := ctx.PP["currency"].(string)
+
+	sub := db_types.Subscription{
+		ItemId:   ctx.PP["id"].(db_types.InfoEntry).ItemId,
+		Currency: currency,
+		Amount:   db_types.MajorToMinor(ctx.PP["price"].(float64), currency),
+		Period:   db_types.SubscriptionPeriod(ctx.PP["period"].(string)),
+		Start:    ctx.PP.Get("start", time.Now().UnixMilli()).(int64),
+		Until:    ctx.PP.Get("until", int64(0)).(int64),
+		Vendor:   ctx.PP.Get("vendor", "").(string),
+		Notes:    ctx.PP.Get("notes", "").(string),
+	}
+
+	if err := db.CreateSubscription(ctx.Uid, &sub); err != nil {
+		util.WError(ctx.W, 500, "Could not create subscription\n%s", err.Error())
+		return
+	}
+
+	chargeSubscriptions(ctx.Uid)
+
+	success(ctx.W)
+}
+
+func EditSubscription(ctx RequestContext) {
+	sub, err := db.GetSubscription(actx2dctx(ctx), ctx.PP["id"].(int64))
+	if err != nil {
+		util.WError(ctx.W, 404, "Could not find subscription\n%s", err.Error())
+		return
+	}
+
+	if currency := ctx.PP.Get("currency", nil); currency != nil {
+		// keep the price, see EditTransaction
+		old := sub.Currency
+		sub.Currency = currency.(string)
+		sub.Amount = db_types.MajorToMinor(db_types.MinorToMajor(sub.Amount, old), sub.Currency)
+	}
+
+	if price := ctx.PP.Get("price", nil); price != nil {
+		sub.Amount = db_types.MajorToMinor(price.(float64), sub.Currency)
+	}
+
+	if period := ctx.PP.Get("period", nil); period != nil {
+		sub.Period = db_types.SubscriptionPeriod(period.(string))
+	}
+
+	if until := ctx.PP.Get("until", nil); until != nil {
+		sub.Until = until.(int64)
+	}
+
+	if vendor := ctx.PP.Get("vendor", nil); vendor != nil {
+		sub.Vendor = vendor.(string)
+	}
+
+	if notes := ctx.PP.Get("notes", nil); notes != nil {
+		sub.Notes = notes.(string)
+	}
+
+	if err := db.UpdateSubscription(ctx.Uid, &sub); err != nil {
+		util.WError(ctx.W, 500, "Could not update subscription\n%s", err.Error())
+		return
+	}
+
+	success(ctx.W)
+}
+
+func SubscriptionsResource(ctx RequestContext) {
+	switch ctx.Req.Method {
+	case "GET":
+		ListSubscriptions(ctx)
+	case "POST":
+		CreateSubscription(ctx)
+	}
+}
+
+func SubscriptionResource(ctx RequestContext) {
+	switch ctx.Req.Method {
+	case "PATCH":
+		EditSubscription(ctx)
+	case "DELETE":
+		if err := db.DeleteSubscription(ctx.Uid, ctx.PP["id"].(int64)); err != nil {
+			util.WError(ctx.W, 500, "Could not delete subscription\n%s", err.Error())
+			return
+		}
+		success(ctx.W)
+	}
+}
+
+// charges any subscription periods that have passed since the subscription was last charged
+func chargeSubscriptions(uid int64) {
+	us, err := settings.GetUserSettings(uid)
+	if err != nil {
+		logging.ELog(err)
+		return
+	}
+
+	if err := db.ChargeSubscriptions(uid, us.DefaultTimeZone, time.Now().UnixMilli()); err != nil {
+		logging.ELog(err)
+	}
+}
+
+// charges subscriptions for every user, every interval
+func ChargeSubscriptionsEvery(interval time.Duration) {
+	for {
+		users, err := accounts.ListUsers(os.Getenv("AIO_DIR"))
+		if err != nil {
+			logging.ELog(err)
+		}
+
+		for _, user := range users {
+			chargeSubscriptions(user.Id)
+		}
+
+		time.Sleep(interval)
+	}
+}
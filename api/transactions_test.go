@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"aiolimas/db"
+	db_types "aiolimas/types"
+)
+
+func TestEditSubscriptionCurrency(t *testing.T) {
+	const uid = 28
+
+	service := addTestEntry(t, uid, "Streaming Service")
+	sub := db_types.Subscription{
+		ItemId:   service.ItemId,
+		Currency: "USD",
+		Amount:   1234,
+		Period:   db_types.SP_MONTH,
+	}
+	if err := db.CreateSubscription(uid, &sub); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		params   ParsedParams
+		currency string
+		amount   int64
+	}{
+		{"the price is kept in a currency without a minor unit", ParsedParams{"currency": "JPY"}, "JPY", 12},
+		{"and in one with a minor unit", ParsedParams{"currency": "EUR"}, "EUR", 1200},
+		{"a new price is in the new currency", ParsedParams{"currency": "JPY", "price": 1500.0}, "JPY", 1500},
+		{"a new price alone", ParsedParams{"price": 9.99}, "JPY", 10},
+	}
+
+	for _, test := range tests {
+		test.params["id"] = sub.SubscriptionId
+		w := httptest.NewRecorder()
+		EditSubscription(RequestContext{
+			Uid:        uid,
+			Authorized: uid,
+			Req:        httptest.NewRequest("PATCH", "/", nil),
+			W:          w,
+			PP:         test.params,
+		})
+		if w.Code != 200 {
+			t.Fatalf("%s: got status %d\n%s", test.name, w.Code, w.Body.String())
+		}
+
+		got, err := db.GetSubscription(db.RequestContext{UID: uid, Auth: uid}, sub.SubscriptionId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Currency != test.currency || got.Amount != test.amount {
+			t.Errorf("%s: got %d %s, want %d %s", test.name, got.Amount, got.Currency, test.amount, test.currency)
+		}
+	}
+}
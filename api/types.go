@@ -43,3 +43,16 @@ func ListArtStyles(ctx RequestContext) {
 	w.WriteHeader(200)
 	w.Write(text)
 }
+
+func ListRelationKinds(ctx RequestContext) {
+	w := ctx.W
+	text, err := json.Marshal(db_types.ListRelationKinds())
+	if err != nil{
+		util.WError(w, 500, "Could not encode relation kinds\n%s", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(text)
+}
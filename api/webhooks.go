@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+
+	"aiolimas/db"
+	db_types "aiolimas/types"
+	"aiolimas/util"
+	"aiolimas/webhooks"
+)
+
+func writeWebhookRow(ctx RequestContext, row db_types.TableRepresentation) {
+	j, err := row.ToJson()
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not convert to json\n%s", err.Error())
+		return
+	}
+	ctx.W.WriteHeader(200)
+	ctx.W.Write(j)
+}
+
+func ListWebhooks(ctx RequestContext) {
+	hooks, err := db.ListWebhooks(ctx.Uid)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not list webhooks\n%s", err.Error())
+		return
+	}
+
+	ctx.W.WriteHeader(200)
+	writeSQLRowResults(ctx.W, hooks)
+}
+
+func CreateWebhook(ctx RequestContext) {
+	secret := ctx.PP.Get("secret", "").(string)
+	if secret == "" {
+		var err error
+		secret, err = webhooks.NewSecret()
+		if err != nil {
+			util.WError(ctx.W, 500, "Could not create secret\n%s", err.Error())
+			return
+		}
+	}
+
+	events, _ := json.Marshal(ctx.PP.Get("events", []string{}).([]string))
+
+	hook := db_types.Webhook{
+		Url:     ctx.PP["url"].(string),
+		Secret:  secret,
+		Events:  string(events),
+		Enabled: ctx.PP.Get("enabled", true).(bool),
+	}
+
+	if err := db.CreateWebhook(ctx.Uid, &hook); err != nil {
+		util.WError(ctx.W, 500, "Could not create webhook\n%s", err.Error())
+		return
+	}
+
+	writeWebhookRow(ctx, hook)
+}
+
+func WebhooksResource(ctx RequestContext) {
+	switch ctx.Req.Method {
+	case "GET":
+		ListWebhooks(ctx)
+	case "POST":
+		CreateWebhook(ctx)
+	}
+}
+
+// queues a ping for hook, and attempts to deliver it now
+func PingWebhook(ctx RequestContext, hook db_types.Webhook) {
+	delivery, err := db.QueueWebhookDelivery(ctx.Uid, hook, db_types.WebhookPayload{
+		Event:     db_types.WE_PING,
+		Timestamp: time.Now().UnixMilli(),
+		Uid:       ctx.Uid,
+		Summary:   "Ping",
+	})
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not queue ping\n%s", err.Error())
+		return
+	}
+
+	// a failed ping is retried like any other delivery, its result is in the response
+	// if webhooks.DeliverDue claimed it first, it is sent from there, and the response has it as pending
+	webhooks.Deliver(hook, &delivery)
+
+	writeWebhookRow(ctx, delivery)
+}
+
+func WebhookResource(ctx RequestContext) {
+	hook, err := db.GetWebhook(ctx.Uid, ctx.PP["id"].(int64))
+	if err != nil {
+		util.WError(ctx.W, 404, "Could not find webhook\n%s", err.Error())
+		return
+	}
+
+	switch ctx.Req.Method {
+	case "GET":
+		writeWebhookRow(ctx, hook)
+		return
+	case "PING":
+		PingWebhook(ctx, hook)
+		return
+	case "PATCH":
+		if url := ctx.PP.Get("url", nil); url != nil {
+			hook.Url = url.(string)
+		}
+
+		if secret := ctx.PP.Get("secret", nil); secret != nil {
+			hook.Secret = secret.(string)
+		}
+
+		if events := ctx.PP.Get("events", nil); events != nil {
+			text, _ := json.Marshal(events.([]string))
+			hook.Events = string(text)
+		}
+
+		if enabled := ctx.PP.Get("enabled", nil); enabled != nil {
+			hook.Enabled = enabled.(bool)
+		}
+	case "DELETE":
+		if err := db.DeleteWebhook(ctx.Uid, hook.WebhookId); err != nil {
+			util.WError(ctx.W, 500, "Could not delete webhook\n%s", err.Error())
+			return
+		}
+		success(ctx.W)
+		return
+	}
+
+	if hook.Secret == "" {
+		util.WError(ctx.W, 400, "The secret cannot be empty\n")
+		return
+	}
+
+	if err := db.UpdateWebhook(ctx.Uid, &hook); err != nil {
+		util.WError(ctx.W, 500, "Could not update webhook\n%s", err.Error())
+		return
+	}
+
+	success(ctx.W)
+}
+
+func ListWebhookEvents(ctx RequestContext) {
+	j, err := json.Marshal(db_types.ListWebhookEvents())
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not convert events to json\n%s", err.Error())
+		return
+	}
+	ctx.W.WriteHeader(200)
+	ctx.W.Write(j)
+}
+
+func ListWebhookDeliveries(ctx RequestContext) {
+	deliveries, err := db.ListWebhookDeliveries(
+		ctx.Uid,
+		ctx.PP.Get("webhook", int64(0)).(int64),
+		ctx.PP.Get("status", db_types.DeliveryStatus("")).(db_types.DeliveryStatus),
+		ctx.PP.Get("limit", int64(100)).(int64),
+	)
+	if err != nil {
+		util.WError(ctx.W, 500, "Could not list deliveries\n%s", err.Error())
+		return
+	}
+
+	ctx.W.WriteHeader(200)
+	writeSQLRowResults(ctx.W, deliveries)
+}
+
+func WebhookDeliveryResource(ctx RequestContext) {
+	delivery, err := db.GetWebhookDelivery(ctx.Uid, ctx.PP["id"].(int64))
+	if err != nil {
+		util.WError(ctx.W, 404, "Could not find delivery\n%s", err.Error())
+		return
+	}
+
+	switch ctx.Req.Method {
+	case "GET":
+		writeWebhookRow(ctx, delivery)
+	case "RETRY":
+		// the claim of db.ClaimWebhookDelivery is its NextAttempt, so this also releases it
+		delivery.Status = db_types.DS_PENDING
+		delivery.Attempts = 0
+		delivery.NextAttempt = time.Now().UnixMilli()
+		delivery.ResponseCode = 0
+		delivery.LastError = ""
+		delivery.Delivered = 0
+		if err := db.UpdateWebhookDelivery(ctx.Uid, &delivery); err != nil {
+			util.WError(ctx.W, 500, "Could not update delivery\n%s", err.Error())
+			return
+		}
+		success(ctx.W)
+	}
+}
+
+// sends the webhook deliveries that are due, every interval
+func DeliverWebhooksEvery(interval time.Duration) {
+	for {
+		webhooks.DeliverDue()
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"aiolimas/db"
+	db_types "aiolimas/types"
+)
+
+func TestRetryWebhookDelivery(t *testing.T) {
+	const uid = 48
+
+	hook := db_types.Webhook{Url: "http://127.0.0.1:0", Secret: "secret", Enabled: true}
+	if err := db.CreateWebhook(uid, &hook); err != nil {
+		t.Fatal(err)
+	}
+	delivery, err := db.QueueWebhookDelivery(uid, hook, db_types.WebhookPayload{Event: db_types.WE_PING, Uid: uid})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// claimed by an attempt that never finished, after earlier attempts failed
+	if claimed, err := db.ClaimWebhookDelivery(&delivery, time.Now().Add(time.Hour).UnixMilli()); err != nil || !claimed {
+		t.Fatalf("could not claim the delivery: %v", err)
+	}
+	delivery.Attempts = 3
+	delivery.ResponseCode = 500
+	delivery.LastError = "500 Internal Server Error"
+	if err := db.UpdateWebhookDelivery(uid, &delivery); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	WebhookDeliveryResource(RequestContext{
+		Uid:        uid,
+		Authorized: uid,
+		Req:        httptest.NewRequest("RETRY", "/", nil),
+		W:          w,
+		PP:         ParsedParams{"id": delivery.DeliveryId},
+	})
+	if w.Code != 200 {
+		t.Fatalf("got status %d\n%s", w.Code, w.Body.String())
+	}
+
+	got, err := db.GetWebhookDelivery(uid, delivery.DeliveryId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != db_types.DS_PENDING || got.Attempts != 0 || got.ResponseCode != 0 || got.LastError != "" {
+		t.Errorf("the delivery still has the last attempt: %+v", got)
+	}
+	if got.NextAttempt > time.Now().UnixMilli() {
+		t.Error("the delivery is still claimed")
+	}
+	if claimed, err := db.ClaimWebhookDelivery(&got, time.Now().Add(time.Minute).UnixMilli()); err != nil || !claimed {
+		t.Errorf("the retried delivery could not be claimed: %v", err)
+	}
+}
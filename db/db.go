@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
 	"aiolimas/logging"
@@ -21,7 +22,7 @@ type RequestContext struct {
 	Auth int64 // authenticated uid
 }
 
-const DB_VERSION = 18
+const DB_VERSION = 31
 
 var DB *sql.DB
 
@@ -196,29 +197,210 @@ func Select[T db_types.TableRepresentation](ctx RequestContext, scanTo T, statem
 	return out, nil
 }
 
-func BuildEntryTree(ctx RequestContext, id int64) (map[int64]db_types.EntryTree, error) {
-	out := map[int64]db_types.EntryTree{}
+// aggregates over the descendants of the entries in roots (a list of ids for IN), or of every entry if roots is ""
+// relation 1 is R_Child, the only Descendant relation kind (see db_types.ListDescendantRelations)
+// spent is currency -> the net amount, in minor units, of transactions that move money
+//
+// the walk only starts at roots, so asking for a few entries does not walk the whole library
+func rollupsQuery(roots string) string {
+	seed := ""
+	if roots != "" {
+		seed = " AND right IN (" + roots + ")"
+	}
+	return `
+	WITH RECURSIVE descendants(root, itemId) AS (
+		SELECT right, left FROM relations WHERE relation = 1` + seed + `
+		UNION
+		SELECT descendants.root, relations.left FROM relations
+		JOIN descendants ON relations.right = descendants.itemId
+		WHERE relations.relation = 1
+	),
+	d AS MATERIALIZED (
+		SELECT root, itemId FROM descendants WHERE itemId != root
+	),
+	statusCounts(root, counts) AS (
+		SELECT root, json_group_object(status, count) FROM (
+			SELECT d.root, u.status, COUNT(*) AS count FROM d
+			JOIN userViewingInfo u ON u.itemId = d.itemId
+			GROUP BY d.root, u.status
+		) GROUP BY root
+	),
+	spent(root, totals) AS (
+		SELECT root, json_group_object(currency, total) FROM (
+			SELECT d.root, t.currency, SUM(t.amount) AS total FROM d
+			JOIN transactions t ON t.itemId = d.itemId
+			WHERE t.kind NOT IN ('Gift received', 'Gift given')
+			GROUP BY d.root, t.currency
+		) GROUP BY root
+	),
+	events(root, firstEvent, lastEvent) AS (
+		SELECT d.root,
+			MIN(NULLIF(CASE e.timestamp WHEN 0 THEN e.after ELSE e.timestamp END, 0)),
+			MAX(NULLIF(CASE e.timestamp WHEN 0 THEN e.after ELSE e.timestamp END, 0))
+		FROM d
+		JOIN userEventInfo e ON e.itemId = d.itemId
+		GROUP BY d.root
+	)
+	SELECT
+		d.root AS itemId,
+		COUNT(*) AS descendants,
+		COALESCE(SUM(u.minutes), 0) AS minutes,
+		COALESCE(AVG(NULLIF(u.userRating, 0)), 0) AS averageRating,
+		100.0 * SUM(u.status = 'Finished') / COUNT(*) AS percentFinished,
+		COALESCE(statusCounts.counts, '{}') AS statusCounts,
+		COALESCE(spent.totals, '{}') AS spent,
+		COALESCE(events.firstEvent, 0) AS firstEvent,
+		COALESCE(events.lastEvent, 0) AS lastEvent
+	FROM d
+	JOIN userViewingInfo u ON u.itemId = d.itemId
+	LEFT JOIN statusCounts ON statusCounts.root = d.root
+	LEFT JOIN spent ON spent.root = d.root
+	LEFT JOIN events ON events.root = d.root
+	GROUP BY d.root`
+}
+
+// if ids is empty, the rollup of every entry that has descendants is returned
+// entries without descendants have no rollup
+func GetRollups(ctx RequestContext, ids []int64) (map[int64]db_types.Rollup, error) {
+	out := map[int64]db_types.Rollup{}
+
+	strIds := []string{}
+	for _, id := range ids {
+		strIds = append(strIds, fmt.Sprintf("%d", id))
+	}
+
+	query := "SELECT rollups.* FROM (" + rollupsQuery(strings.Join(strIds, ",")) + ") rollups JOIN entryInfo ON entryInfo.itemId = rollups.itemId %s"
+
+	rollups, err := Select(ctx, db_types.Rollup{}, query, uidWhere(ctx, "entryInfo.uid", "entryInfo.itemid"))
+	if err != nil {
+		return out, err
+	}
+
+	for _, rollup := range rollups {
+		out[rollup.ItemId] = rollup
+	}
+	return out, nil
+}
 
+// incremented by triggers whenever an entry, its metadata, user info, relations, or settings change
+func GetLibraryVersion() (int64, error) {
+	var version int64
+	err := DB.QueryRow("SELECT version FROM libraryVersion").Scan(&version)
+	return version, err
+}
+
+// if roots is empty, every entry is in the tree
+// otherwise only the roots and their descendants (through R_Child) are,
+// going at most maxDepth levels below the roots, 0 means no limit
+//
+// each entry is given to yield as it is read, in order of ItemId, the walk stops at the first error yield returns
+// Children and Copies list every child and copy, even ones that were cut off by maxDepth
+func BuildEntryTree(ctx RequestContext, roots []int64, maxDepth int64, yield func(db_types.EntryTree) error) error {
 	whereClause := uidWhere(ctx, "entryInfo.uid", "entryInfo.itemid")
-	
-	if id != 0 {
-		whereClause += fmt.Sprintf(" AND entryInfo.itemid = %d", id)
+
+	// the walk is done by sqlite, so only the entries in the tree are ever read
+	walk := ""
+	args := []any{}
+	if len(roots) > 0 {
+		strRoots := []string{}
+		for _, root := range roots {
+			strRoots = append(strRoots, fmt.Sprintf("%d", root))
+		}
+		// without a depth limit every row has depth 0, so UNION also stops the walk at a cycle
+		walk = fmt.Sprintf(`tree(itemId, depth) AS (
+		SELECT itemId, 0 FROM entryInfo WHERE itemId IN (%s)
+		UNION
+		SELECT relations.left, CASE WHEN ? = 0 THEN 0 ELSE tree.depth + 1 END FROM relations
+		JOIN tree ON relations.right = tree.itemId
+		WHERE relations.relation = %d AND (? = 0 OR tree.depth < ?)
+	),`, strings.Join(strRoots, ","), db_types.R_Child)
+		whereClause += " AND entryInfo.itemId IN (SELECT itemId FROM tree)"
+		args = append(args, maxDepth, maxDepth, maxDepth)
 	}
 
-	allRows, err := QueryDB(`
-	SELECT * FROM entryInfo
+	query := fmt.Sprintf(`
+	WITH RECURSIVE %s
+	children(itemId, ids) AS (
+		SELECT relations.right, group_concat(relations.left) FROM relations
+		JOIN entryInfo ON entryInfo.itemId = relations.left
+		%s AND relations.relation = %d
+		GROUP BY relations.right
+	),
+	copies(itemId, ids) AS (
+		SELECT pairs.itemId, group_concat(pairs.other) FROM (
+			SELECT left AS itemId, right AS other FROM relations WHERE relation = %[4]d
+			UNION
+			SELECT right, left FROM relations WHERE relation = %[4]d
+		) pairs
+		JOIN entryInfo ON entryInfo.itemId = pairs.other
+		%[2]s
+		GROUP BY pairs.itemId
+	)
+	SELECT entryInfo.*, metadata.*, uvi.*, COALESCE(children.ids, ''), COALESCE(copies.ids, '') FROM entryInfo
 	JOIN metadata ON entryInfo.itemid = metadata.itemid
 	JOIN userViewingInfo uvi ON entryInfo.itemid = uvi.itemid
-	` + whereClause)
+	LEFT JOIN children ON children.itemId = entryInfo.itemId
+	LEFT JOIN copies ON copies.itemId = entryInfo.itemId
+	%[5]s AND entryInfo.itemId > ?
+	ORDER BY entryInfo.itemId
+	LIMIT %[6]d`,
+		walk, uidWhere(ctx, "entryInfo.uid", "entryInfo.itemid"), db_types.R_Child, db_types.R_Copy, whereClause, treeBatchSize,
+	)
+
+	// read a batch at a time, so that the connection is not held while the caller writes the batch out
+	after := int64(0)
+	for {
+		batch, err := readTreeBatch(query, append(args, after)...)
+		if err != nil {
+			log.ELog(err)
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		ids := []int64{}
+		for _, cur := range batch {
+			ids = append(ids, cur.EntryInfo.ItemId)
+		}
+		rollups, err := GetRollups(ctx, ids)
+		if err != nil {
+			log.ELog(err)
+			return err
+		}
+
+		for _, cur := range batch {
+			if rollup, ok := rollups[cur.EntryInfo.ItemId]; ok {
+				cur.Rollup = &rollup
+			}
+			if err := yield(cur); err != nil {
+				return err
+			}
+		}
+
+		if len(batch) < treeBatchSize {
+			return nil
+		}
+		after = batch[len(batch)-1].EntryInfo.ItemId
+	}
+}
+
+const treeBatchSize = 500
+
+func readTreeBatch(query string, args ...any) ([]db_types.EntryTree, error) {
+	out := []db_types.EntryTree{}
+
+	rows, err := QueryDB(query, args...)
 	if err != nil {
-		log.ELog(err)
 		return out, err
 	}
+	defer rows.Close()
 
-	for allRows.Next() {
+	for rows.Next() {
 		var cur db_types.EntryTree
+		var children, copies string
 
-		allRows.Scan(
+		err := rows.Scan(
 			&cur.EntryInfo.Uid,
 			&cur.EntryInfo.ItemId,
 			&cur.EntryInfo.En_Title,
@@ -248,6 +430,7 @@ func BuildEntryTree(ctx RequestContext, id int64) (map[int64]db_types.EntryTree,
 			&cur.MetaInfo.ProviderID,
 			&cur.MetaInfo.Genres,
 			&cur.MetaInfo.Country,
+			&cur.MetaInfo.Locks,
 
 			&cur.UserInfo.Uid,
 			&cur.UserInfo.ItemId,
@@ -258,7 +441,13 @@ func BuildEntryTree(ctx RequestContext, id int64) (map[int64]db_types.EntryTree,
 			&cur.UserInfo.CurrentPosition,
 			&cur.UserInfo.Extra,
 			&cur.UserInfo.Minutes,
+
+			&children,
+			&copies,
 		)
+		if err != nil {
+			return out, err
+		}
 
 		for _, name := range strings.Split(cur.EntryInfo.Collection, "\x1F") {
 			if name == "" {
@@ -267,41 +456,21 @@ func BuildEntryTree(ctx RequestContext, id int64) (map[int64]db_types.EntryTree,
 			cur.EntryInfo.Tags = append(cur.EntryInfo.Tags, name)
 		}
 
-		out[cur.EntryInfo.ItemId] = cur
-	}
-	allRows.Close()
-
-	for id, cur := range out {
-		var copies, children []db_types.InfoEntry
-
-		cur.Copies = []string{}
-		cur.Children = []string{}
-
-		children, err = GetRelation(ctx, cur.EntryInfo.ItemId, db_types.R_Child, false)
-		if err != nil {
-			log.ELog(err)
-			goto next
-		}
-
-		for _, child := range children {
-			cur.Children = append(cur.Children, fmt.Sprintf("%d", child.ItemId))
-		}
+		cur.Children = splitIds(children)
+		cur.Copies = splitIds(copies)
 
-		copies, err = GetCopiesOf(ctx, cur.EntryInfo.ItemId)
-		if err != nil {
-			log.ELog(err)
-			goto next
-		}
+		out = append(out, cur)
+	}
 
-		for _, c := range copies {
-			cur.Copies = append(cur.Copies, fmt.Sprintf("%d", c.ItemId))
-		}
+	return out, rows.Err()
+}
 
-	next:
-		out[id] = cur
+// splits the output of group_concat
+func splitIds(ids string) []string {
+	if ids == "" {
+		return []string{}
 	}
-
-	return out, nil
+	return strings.Split(ids, ",")
 }
 
 func getById[T db_types.TableRepresentation](ctx RequestContext, id int64, tblName string, out *T) error {
@@ -368,15 +537,50 @@ func ensureMetadataJsonNotEmpty(metadata *db_types.MetadataEntry) {
 	if metadata.Datapoints == "" {
 		metadata.Datapoints = "{}"
 	}
+	if metadata.Locks == "" {
+		metadata.Locks = "[]"
+	}
 }
 
 func ListMetadata(ctx RequestContext) ([]db_types.MetadataEntry, error) {
 	return Select(ctx, db_types.MetadataEntry{}, "SELECT * FROM metadata %s", uidWhere(ctx, "metadata.uid", "metadata.itemid"))
 }
 
+// the distinct Locations of uid's entries, as they are stored (location aliases are not expanded)
+func ListLocations(uid int64) ([]string, error) {
+	out := []string{}
+	rows, err := QueryDB(`SELECT DISTINCT location FROM entryInfo WHERE uid = ? AND location != ''`, uid)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var location string
+		if err := rows.Scan(&location); err != nil {
+			return out, err
+		}
+		out = append(out, location)
+	}
+	return out, rows.Err()
+}
+
 func Search3(ctx RequestContext, searchQuery string, orderby string) ([]db_types.InfoEntry, error) {
 	var out []db_types.InfoEntry
 
+	safeQuery, err := search.Search2String(searchQuery)
+	if err != nil {
+		log.ELog(err)
+		return out, err
+	}
+
+	// rollups walk every relation, so only join them when they are used
+	rollupJoin := ""
+	if strings.Contains(safeQuery, "rollups.") || strings.Contains(orderby, "rollups.") {
+		rollupJoin = `LEFT JOIN (` + rollupsQuery("") + `) rollups ON
+	entryInfo.itemId == rollups.itemId `
+	}
+
 	query := `SELECT DISTINCT entryInfo.*
 	FROM entryInfo
 	JOIN userViewingInfo ON
@@ -385,15 +589,10 @@ func Search3(ctx RequestContext, searchQuery string, orderby string) ([]db_types
 	entryInfo.itemId == metadata.itemId
 	LEFT JOIN userEventInfo ON
 	entryInfo.itemId == userEventInfo.itemId ` +
+	rollupJoin +
 	uidWhere(ctx, "metadata.uid", "entryinfo.itemid") +
 	" and %s"
 
-	safeQuery, err := search.Search2String(searchQuery)
-	if err != nil {
-		log.ELog(err)
-		return out, err
-	}
-
 	fullQuery := fmt.Sprintf(query, safeQuery)
 
 	if orderby != "" {
@@ -597,53 +796,52 @@ func ListRelations(uid int64) (map[int64]db_types.Relations, error) {
 
 		res.Scan(&row.Left, &row.Relation, &row.Right)
 
-		switch row.Relation {
-		case db_types.R_Child:
-			{
-				r, ok := out[row.Right]
-				if !ok {
-					r = db_types.Relations{}
-				}
-				r.Children = append(out[row.Right].Children, row.Left)
-				out[row.Right] = r
-			}
-		case db_types.R_Copy:
-			{
-				r, ok := out[row.Right]
-				if !ok {
-					r = db_types.Relations{}
-				}
-
-				r.Copies = append(r.Copies, row.Left)
-
-				out[row.Right] = r
-
-				// copies are symetrical, add to both
-				r, ok = out[row.Left]
-				if !ok {
-					r = db_types.Relations{}
-				}
-
-				r.Copies = append(r.Copies, row.Right)
+		kind, ok := db_types.GetRelationKind(row.Relation)
+		if !ok {
+			continue
+		}
 
-				out[row.Left] = r
+		add := func(id int64, name string, other int64) {
+			r, ok := out[id]
+			if !ok {
+				r = db_types.Relations{}
 			}
-		case db_types.R_Requires:
-			{
-				r, ok := out[row.Left]
-				if !ok {
-					r = db_types.Relations{}
-				}
-
-				r.Requires = append(r.Requires, row.Right)
-				out[row.Left] = r
+			if r.Related == nil {
+				r.Related = map[string][]int64{}
+			}
+			r.Related[name] = append(r.Related[name], other)
+
+			switch name {
+			case "parent-of":
+				r.Children = append(r.Children, other)
+			case "requires":
+				r.Requires = append(r.Requires, other)
+			case "copy-of":
+				r.Copies = append(r.Copies, other)
 			}
+
+			out[id] = r
 		}
+
+		add(row.Left, kind.Name, row.Right)
+		add(row.Right, kind.InverseName, row.Left)
 	}
 
 	return out, nil
 }
 
+// every relation row, if uid is 0, relations from every user are listed
+func ListRelationRows(uid int64) ([]RelationRow, error) {
+	query := "SELECT rowid, left, relation, right FROM relations"
+	args := []any{}
+	if uid > 0 {
+		query += " WHERE uid = ?"
+		args = append(args, uid)
+	}
+	_, rows, err := selectRelationRows(query, args...)
+	return rows, err
+}
+
 // /sort must be valid sql
 func ListEntries(ctx RequestContext, sort string) ([]db_types.InfoEntry, error) {
 	return Select(
@@ -683,30 +881,117 @@ func AllUserEntries(ctx RequestContext) ([]db_types.UserViewingEntry, error) {
 	)
 }
 
-func getDescendants(ctx RequestContext, id int64, recurse uint64, maxRecurse uint64) ([]db_types.InfoEntry, error) {
-	var out []db_types.InfoEntry
-	if recurse > maxRecurse {
-		return out, nil
+func relationList(relations []db_types.Relation) string {
+	ids := []string{}
+	for _, r := range relations {
+		ids = append(ids, fmt.Sprintf("%d", r))
+	}
+	return strings.Join(ids, ",")
+}
+
+// walks the relations graph from id
+// if up is false, it follows right -> left (eg: from a parent to its children)
+// if up is true, it follows left -> right (eg: from a child to its parents)
+func walkRelations(ctx RequestContext, id int64, relations []db_types.Relation, up bool) ([]db_types.InfoEntry, error) {
+	from, to := "right", "left"
+	if up {
+		from, to = to, from
 	}
 
-	children, err := GetRelation(ctx, id, db_types.R_Child, false)
+	// UNION (not UNION ALL) stops the walk if there is a cycle
+	query := fmt.Sprintf(`
+		WITH RECURSIVE walk(itemId) AS (
+			SELECT %[2]s FROM relations WHERE %[1]s = ? AND relation IN (%[3]s)
+			UNION
+			SELECT r.%[2]s FROM relations r
+			JOIN walk ON r.%[1]s = walk.itemId
+			WHERE r.relation IN (%[3]s)
+		)
+		SELECT * FROM entryInfo %%s AND itemId IN (SELECT itemId FROM walk) AND itemId != ?`,
+		from, to, relationList(relations))
+
+	return Select(
+		ctx,
+		db_types.InfoEntry{},
+		query,
+		uidWhere(ctx, "entryInfo.uid", "entryInfo.itemid"),
+		id, id,
+	)
+}
+
+// every entry that is a descendant of id, through any of the Descendant relation kinds
+func GetDescendants(ctx RequestContext, id int64) ([]db_types.InfoEntry, error) {
+	return walkRelations(ctx, id, db_types.ListDescendantRelations(), false)
+}
+
+// every entry that id is a descendant of, through any of the Descendant relation kinds
+func GetAncestors(ctx RequestContext, id int64) ([]db_types.InfoEntry, error) {
+	return walkRelations(ctx, id, db_types.ListDescendantRelations(), true)
+}
+
+// finds a path from -> to by following left -> right through relations
+// returns the ids along the path, including from and to, or nil if there is no path
+func FindRelationPath(uid int64, from int64, to int64, relations []db_types.Relation) ([]int64, error) {
+	// the walk only finds which entries are reachable, each one is visited once
+	// carrying the path in the walk would visit an entry once per path to it
+	query := fmt.Sprintf(`
+		WITH RECURSIVE walk(itemId) AS (
+			SELECT ?
+			UNION
+			SELECT r.right FROM relations r
+			JOIN walk ON r.left = walk.itemId
+			WHERE r.uid = ? AND r.relation IN (%[1]s)
+		)
+		SELECT left, right FROM relations
+		WHERE uid = ? AND relation IN (%[1]s) AND left IN (SELECT itemId FROM walk)
+		ORDER BY rowid`,
+		relationList(relations))
+
+	rows, err := QueryDB(query, from, uid, uid)
 	if err != nil {
-		return out, err
+		return nil, err
 	}
+	defer rows.Close()
 
-	for _, item := range children {
-		out = append(out, item)
-		newItems, err := getDescendants(ctx, item.ItemId, recurse+1, maxRecurse)
-		if err != nil {
-			continue
+	edges := map[int64][]int64{}
+	for rows.Next() {
+		var left, right int64
+		if err := rows.Scan(&left, &right); err != nil {
+			return nil, err
 		}
-		out = append(out, newItems...)
+		edges[left] = append(edges[left], right)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
-	return out, nil
-}
 
-func GetDescendants(ctx RequestContext, id int64) ([]db_types.InfoEntry, error) {
-	return getDescendants(ctx, id, 0, 10)
+	// breadth first, so the path found is a shortest one
+	parent := map[int64]int64{}
+	visited := map[int64]bool{from: true}
+	queue := []int64{from}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, next := range edges[cur] {
+			// to is checked before visited, so a path from an entry back to itself is found
+			if next == to {
+				path := []int64{to}
+				for id := cur; id != from; id = parent[id] {
+					path = append(path, id)
+				}
+				path = append(path, from)
+				slices.Reverse(path)
+				return path, nil
+			}
+			if visited[next] {
+				continue
+			}
+			visited[next] = true
+			parent[next] = cur
+			queue = append(queue, next)
+		}
+	}
+	return nil, nil
 }
 
 func GetRecommendersList(ctx RequestContext) ([]string, error) {
@@ -736,6 +1021,124 @@ func ListTransactions(ctx RequestContext, itemid int64) ([]db_types.TransactionE
 	)
 }
 
+func ListSubscriptions(ctx RequestContext) ([]db_types.Subscription, error) {
+	return Select(
+		ctx,
+		db_types.Subscription{},
+		`SELECT rowid, * FROM subscriptions %s`,
+		uidWhere(ctx, "uid", "itemid"),
+	)
+}
+
+func GetSubscription(ctx RequestContext, id int64) (db_types.Subscription, error) {
+	subs, err := Select(
+		ctx,
+		db_types.Subscription{},
+		`SELECT rowid, * FROM subscriptions %s AND rowid = ?`,
+		uidWhere(ctx, "uid", "itemid"), id,
+	)
+	if err != nil {
+		return db_types.Subscription{}, err
+	}
+	if len(subs) == 0 {
+		return db_types.Subscription{}, fmt.Errorf("could not find subscription %d", id)
+	}
+	return subs[0], nil
+}
+
+// newest first, if itemid is 0, refreshes for every entry are listed
+func ListMetadataRefreshes(ctx RequestContext, itemid int64, limit int64) ([]db_types.MetadataRefresh, error) {
+	return Select(
+		ctx,
+		db_types.MetadataRefresh{},
+		`SELECT rowid, * FROM metadataRefreshes %s AND (? = 0 OR itemid = ?) ORDER BY timestamp DESC, rowid DESC LIMIT ?`,
+		uidWhere(ctx, "uid", "itemid"), itemid, itemid, limit,
+	)
+}
+
+// itemid -> the time (unix ms) of the last refresh, including ones that failed
+func LastMetadataRefreshes(uid int64) (map[int64]int64, error) {
+	out := map[int64]int64{}
+
+	rows, err := QueryDB(`SELECT itemId, MAX(timestamp) FROM metadataRefreshes WHERE uid = ? GROUP BY itemId`, uid)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var itemId, timestamp int64
+		if err := rows.Scan(&itemId, &timestamp); err != nil {
+			return out, err
+		}
+		out[itemId] = timestamp
+	}
+	return out, rows.Err()
+}
+
+// if there is no inventory information for the item, an empty InventoryEntry is returned
+func GetInventoryEntry(ctx RequestContext, itemId int64) (db_types.InventoryEntry, error) {
+	entries, err := Select(
+		ctx,
+		db_types.InventoryEntry{},
+		`SELECT * FROM inventory %s AND itemId = ?`,
+		uidWhere(ctx, "uid", "itemid"), itemId,
+	)
+	if err != nil {
+		return db_types.InventoryEntry{}, err
+	}
+	if len(entries) == 0 {
+		return db_types.InventoryEntry{Uid: ctx.UID, ItemId: itemId}, nil
+	}
+	return entries[0], nil
+}
+
+func ListInventory(ctx RequestContext) ([]db_types.InventoryEntry, error) {
+	return Select(
+		ctx,
+		db_types.InventoryEntry{},
+		`SELECT * FROM inventory %s`,
+		uidWhere(ctx, "uid", "itemid"),
+	)
+}
+
+// the local code -> item cache, finds the entries whose physical copy has barcode
+func FindInventoryByBarcode(ctx RequestContext, barcode string) ([]db_types.InventoryEntry, error) {
+	return Select(
+		ctx,
+		db_types.InventoryEntry{},
+		`SELECT * FROM inventory %s AND barcode = ? ORDER BY itemId`,
+		uidWhere(ctx, "uid", "itemid"), barcode,
+	)
+}
+
+// if itemId is 0, loans for all items are listed
+// if activeOnly is true, only loans that have not been returned are listed
+func ListLoans(ctx RequestContext, itemId int64, activeOnly bool) ([]db_types.Loan, error) {
+	return Select(
+		ctx,
+		db_types.Loan{},
+		`SELECT rowid, * FROM loans %s AND (? = 0 OR itemId = ?) AND (? = 0 OR returned = 0) ORDER BY lent`,
+		uidWhere(ctx, "uid", "itemid"), itemId, itemId, activeOnly,
+	)
+}
+
+func GetLoan(ctx RequestContext, id int64) (db_types.Loan, error) {
+	loans, err := Select(
+		ctx,
+		db_types.Loan{},
+		`SELECT rowid, * FROM loans %s AND rowid = ?`,
+		uidWhere(ctx, "uid", "itemid"), id,
+	)
+	if err != nil {
+		return db_types.Loan{}, err
+	}
+	if len(loans) == 0 {
+		return db_types.Loan{}, fmt.Errorf("could not find loan %d", id)
+	}
+	return loans[0], nil
+}
+
 func GetTransaction(ctx RequestContext, id int64) (db_types.TransactionEntry, error) {
 	whereClause := uidWhere(ctx, "transactions.uid", "transactions.itemid") + " AND rowid = ?"
 	rows, err := QueryDB("select rowid, * from transactions " + whereClause, id)
@@ -767,3 +1170,270 @@ func GetEvent(ctx RequestContext, eventID int64) (db_types.UserViewingEvent, err
 
 	return e, err
 }
+
+func ListExchangeRates(uid int64) ([]db_types.ExchangeRate, error) {
+	rows, err := QueryDB(`SELECT uid, fromCurrency, toCurrency, rate FROM exchangeRates WHERE uid = ?`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []db_types.ExchangeRate{}
+	for rows.Next() {
+		var rate db_types.ExchangeRate
+		if err := rate.ReadEntry(rows); err != nil {
+			return out, err
+		}
+		out = append(out, rate)
+	}
+	return out, nil
+}
+
+func ListWebhooks(uid int64) ([]db_types.Webhook, error) {
+	return Select(
+		RequestContext{UID: uid, Auth: uid},
+		db_types.Webhook{},
+		`SELECT rowid, * FROM webhooks WHERE uid = ? ORDER BY rowid`,
+		"", uid,
+	)
+}
+
+func GetWebhook(uid int64, id int64) (db_types.Webhook, error) {
+	hooks, err := Select(
+		RequestContext{UID: uid, Auth: uid},
+		db_types.Webhook{},
+		`SELECT rowid, * FROM webhooks WHERE uid = ? AND rowid = ?`,
+		"", uid, id,
+	)
+	if err != nil {
+		return db_types.Webhook{}, err
+	}
+	if len(hooks) == 0 {
+		return db_types.Webhook{}, fmt.Errorf("could not find webhook %d", id)
+	}
+	return hooks[0], nil
+}
+
+// newest first
+// if webhookId is 0, deliveries of every webhook are listed, if status is "", deliveries of every status are listed
+func ListWebhookDeliveries(uid int64, webhookId int64, status db_types.DeliveryStatus, limit int64) ([]db_types.WebhookDelivery, error) {
+	return Select(
+		RequestContext{UID: uid, Auth: uid},
+		db_types.WebhookDelivery{},
+		`SELECT rowid, * FROM webhookDeliveries WHERE uid = ? AND (? = 0 OR webhookId = ?) AND (? = '' OR status = ?) ORDER BY rowid DESC LIMIT ?`,
+		"", uid, webhookId, webhookId, status, status, limit,
+	)
+}
+
+func GetWebhookDelivery(uid int64, id int64) (db_types.WebhookDelivery, error) {
+	deliveries, err := Select(
+		RequestContext{UID: uid, Auth: uid},
+		db_types.WebhookDelivery{},
+		`SELECT rowid, * FROM webhookDeliveries WHERE uid = ? AND rowid = ?`,
+		"", uid, id,
+	)
+	if err != nil {
+		return db_types.WebhookDelivery{}, err
+	}
+	if len(deliveries) == 0 {
+		return db_types.WebhookDelivery{}, fmt.Errorf("could not find delivery %d", id)
+	}
+	return deliveries[0], nil
+}
+
+// the pending deliveries of every user whose next attempt is at, or before now (unix ms), oldest first
+// deliveries of disabled webhooks wait until the webhook is enabled again
+func DueWebhookDeliveries(now int64, limit int64) ([]db_types.WebhookDelivery, error) {
+	rows, err := QueryDB(
+		`SELECT rowid, * FROM webhookDeliveries
+		WHERE status = ? AND nextAttempt <= ? AND webhookId IN (SELECT rowid FROM webhooks WHERE enabled = 1)
+		ORDER BY rowid LIMIT ?`,
+		db_types.DS_PENDING, now, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []db_types.WebhookDelivery{}
+	for rows.Next() {
+		var delivery db_types.WebhookDelivery
+		if err := delivery.ReadEntry(rows); err != nil {
+			return out, err
+		}
+		out = append(out, delivery)
+	}
+	return out, rows.Err()
+}
+
+// the changes of uid after since, oldest first
+// this is polled by every /events/stream, so it does not go through Select, which logs every statement
+func ListChanges(uid int64, since int64, limit int64) ([]db_types.Change, error) {
+	rows, err := QueryDB(`SELECT * FROM changes WHERE uid = ? AND seq > ? ORDER BY seq LIMIT ?`, uid, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []db_types.Change{}
+	for rows.Next() {
+		var change db_types.Change
+		if err := change.ReadEntry(rows); err != nil {
+			return out, err
+		}
+		out = append(out, change)
+	}
+	return out, rows.Err()
+}
+
+// deletes the changes made before before (unix ms) that have a later change to the same row
+// sync only sends the latest change to each row, so it is not affected,
+// streams that resume from before before get the latest change to each row instead of every change
+// returns how many changes were deleted
+func CompactChanges(before int64) (int64, error) {
+	kindsByColumn := map[string][]any{}
+	for _, kind := range db_types.ListChangeKinds() {
+		column := db_types.ChangeIdentityColumn(kind)
+		kindsByColumn[column] = append(kindsByColumn[column], kind)
+	}
+
+	var deleted int64
+	for _, column := range []string{"itemId", "rowId", "key"} {
+		kinds := kindsByColumn[column]
+		if len(kinds) == 0 {
+			continue
+		}
+
+		res, err := DB.Exec(fmt.Sprintf(`
+			DELETE FROM changes
+			WHERE kind IN (%[1]s) AND timestamp < ? AND EXISTS (
+				SELECT 1 FROM changes later
+				WHERE later.uid = changes.uid AND later.kind = changes.kind AND later.%[2]s = changes.%[2]s AND later.seq > changes.seq
+			)`, strings.TrimSuffix(strings.Repeat("?,", len(kinds)), ","), column),
+			append(kinds, before)...,
+		)
+		if err != nil {
+			return deleted, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+	return deleted, nil
+}
+
+// the Seq of the latest change of uid, 0 if there are none
+func LastChangeSeq(uid int64) (int64, error) {
+	rows, err := QueryDB(`SELECT COALESCE(MAX(seq), 0) FROM changes WHERE uid = ?`, uid)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var seq int64
+	if rows.Next() {
+		err = rows.Scan(&seq)
+	}
+	return seq, err
+}
+
+func selectOne[T db_types.TableRepresentation](q querier, scanTo T, query string, args ...any) (T, bool, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return scanTo, false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return scanTo, false, rows.Err()
+	}
+	row, err := scanTo.ReadEntryCopy(rows)
+	if err != nil {
+		return scanTo, false, err
+	}
+	return row.(T), true, nil
+}
+
+// the row that change is about as it is now, false if it no longer exists
+func GetChangedRow(uid int64, change db_types.Change) (any, bool, error) {
+	return getChangedRow(DB, uid, change)
+}
+
+func getChangedRow(q querier, uid int64, change db_types.Change) (any, bool, error) {
+	switch change.Kind {
+	case "info":
+		return selectOne(q, db_types.InfoEntry{}, `SELECT * FROM entryInfo WHERE uid = ? AND itemId = ?`, uid, change.ItemId)
+	case "metadata":
+		return selectOne(q, db_types.MetadataEntry{}, `SELECT * FROM metadata WHERE uid = ? AND itemId = ?`, uid, change.ItemId)
+	case "user":
+		return selectOne(q, db_types.UserViewingEntry{}, `SELECT * FROM userViewingInfo WHERE uid = ? AND itemId = ?`, uid, change.ItemId)
+	case "inventory":
+		return selectOne(q, db_types.InventoryEntry{}, `SELECT * FROM inventory WHERE uid = ? AND itemId = ?`, uid, change.ItemId)
+	case "event":
+		return selectOne(q, db_types.UserViewingEvent{}, `SELECT *, rowid FROM userEventInfo WHERE uid = ? AND rowid = ?`, uid, change.RowId)
+	case "transaction":
+		return selectOne(q, db_types.TransactionEntry{}, `SELECT rowid, * FROM transactions WHERE uid = ? AND rowid = ?`, uid, change.RowId)
+	case "subscription":
+		return selectOne(q, db_types.Subscription{}, `SELECT rowid, * FROM subscriptions WHERE uid = ? AND rowid = ?`, uid, change.RowId)
+	case "loan":
+		return selectOne(q, db_types.Loan{}, `SELECT rowid, * FROM loans WHERE uid = ? AND rowid = ?`, uid, change.RowId)
+	case "exchangeRate":
+		from, to, _ := strings.Cut(change.Key, ",")
+		return selectOne(
+			q,
+			db_types.ExchangeRate{},
+			`SELECT uid, fromCurrency, toCurrency, rate FROM exchangeRates WHERE uid = ? AND fromCurrency = ? AND toCurrency = ?`,
+			uid, from, to,
+		)
+	case "relation":
+		var row RelationRow
+		if _, err := fmt.Sscanf(change.Key, "%d,%d,%d", &row.Left, &row.Relation, &row.Right); err != nil {
+			return row, false, fmt.Errorf("invalid relation key: '%s'", change.Key)
+		}
+		rows, err := q.Query(
+			`SELECT 1 FROM relations WHERE uid = ? AND left = ? AND relation = ? AND right = ?`,
+			uid, row.Left, row.Relation, row.Right,
+		)
+		if err != nil {
+			return row, false, err
+		}
+		defer rows.Close()
+		return row, rows.Next(), rows.Err()
+	}
+	return nil, false, fmt.Errorf("unknown change kind: '%s'", change.Kind)
+}
+
+// the Timestamp of the latest change to the row of change after the change sequence after, 0 if there is none
+func LastRowChange(uid int64, change db_types.Change, after int64) (int64, error) {
+	return lastRowChange(DB, uid, change, after)
+}
+
+func lastRowChange(q querier, uid int64, change db_types.Change, after int64) (int64, error) {
+	column := db_types.ChangeIdentityColumn(change.Kind)
+	var identity any
+	switch column {
+	case "itemId":
+		identity = change.ItemId
+	case "key":
+		identity = change.Key
+	default:
+		identity = change.RowId
+	}
+
+	rows, err := q.Query(
+		`SELECT COALESCE(MAX(timestamp), 0) FROM changes WHERE uid = ? AND kind = ? AND seq > ? AND `+column+` = ?`,
+		uid, change.Kind, after, identity,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var timestamp int64
+	if rows.Next() {
+		err = rows.Scan(&timestamp)
+	}
+	return timestamp, err
+}
@@ -0,0 +1,422 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	db_types "aiolimas/types"
+)
+
+// every test in the package shares one fresh database
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "aio-db-test")
+	if err != nil {
+		panic(err.Error())
+	}
+	os.Setenv("AIO_DIR", dir)
+
+	// the schema is read relative to the root of the repo
+	if err := os.Chdir(".."); err != nil {
+		panic(err.Error())
+	}
+	if err := InitDb(); err != nil {
+		panic(err.Error())
+	}
+
+	code := m.Run()
+	DB.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// adds an entry with the title to uid, and returns its id
+func addTestEntry(t *testing.T, uid int64, title string) int64 {
+	t.Helper()
+	info := db_types.InfoEntry{En_Title: title, Type: db_types.TY_MOVIE}
+	var meta db_types.MetadataEntry
+	var user db_types.UserViewingEntry
+	if err := AddEntry(uid, "", &info, &meta, &user); err != nil {
+		t.Fatalf("could not add %s: %s", title, err.Error())
+	}
+	return info.ItemId
+}
+
+func TestListRelationRows(t *testing.T) {
+	a := addTestEntry(t, 1, "relation rows a")
+	b := addTestEntry(t, 1, "relation rows b")
+	c := addTestEntry(t, 2, "relation rows c")
+	d := addTestEntry(t, 2, "relation rows d")
+
+	if err := AddRelation(1, a, db_types.R_Child, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddRelation(2, c, db_types.R_Child, d); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		uid  int64
+		want []RelationRow
+	}{
+		{"no uid lists every user", 0, []RelationRow{{a, db_types.R_Child, b}, {c, db_types.R_Child, d}}},
+		{"negative uid lists every user", -1, []RelationRow{{a, db_types.R_Child, b}, {c, db_types.R_Child, d}}},
+		{"uid 1", 1, []RelationRow{{a, db_types.R_Child, b}}},
+		{"uid 2", 2, []RelationRow{{c, db_types.R_Child, d}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rows, err := ListRelationRows(test.uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range test.want {
+				found := false
+				for _, row := range rows {
+					found = found || row == want
+				}
+				if !found {
+					t.Errorf("%v is not in %v", want, rows)
+				}
+			}
+			for _, row := range rows {
+				if test.uid > 0 && (row.Left == a) != (test.uid == 1) {
+					t.Errorf("%v is from another user", row)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildEntryTree(t *testing.T) {
+	root := addTestEntry(t, 3, "tree root")
+	child := addTestEntry(t, 3, "tree child")
+	grandchild := addTestEntry(t, 3, "tree grandchild")
+	copyOf := addTestEntry(t, 3, "tree copy")
+
+	for _, relation := range [][3]int64{
+		{child, int64(db_types.R_Child), root},
+		{grandchild, int64(db_types.R_Child), child},
+		{root, int64(db_types.R_Copy), copyOf},
+	} {
+		if err := AddRelation(3, relation[0], db_types.Relation(relation[1]), relation[2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		ctx      RequestContext
+		roots    []int64
+		maxDepth int64
+		want     []int64
+	}{
+		{"no uid", RequestContext{}, []int64{root}, 0, []int64{root, child, grandchild}},
+		{"whole subtree", RequestContext{UID: 3, Auth: 3}, []int64{root}, 0, []int64{root, child, grandchild}},
+		{"depth 1", RequestContext{UID: 3, Auth: 3}, []int64{root}, 1, []int64{root, child}},
+		{"leaf", RequestContext{UID: 3, Auth: 3}, []int64{grandchild}, 0, []int64{grandchild}},
+		{"no roots", RequestContext{UID: 3, Auth: 3}, nil, 0, []int64{root, child, grandchild, copyOf}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := map[int64]db_types.EntryTree{}
+			err := BuildEntryTree(test.ctx, test.roots, test.maxDepth, func(entry db_types.EntryTree) error {
+				got[entry.EntryInfo.ItemId] = entry
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.roots == nil {
+				// other tests add entries too
+				for id := range got {
+					if got[id].EntryInfo.Uid != 3 {
+						delete(got, id)
+					}
+				}
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d entries, want %v", len(got), test.want)
+			}
+			for _, id := range test.want {
+				if _, ok := got[id]; !ok {
+					t.Errorf("%d is not in the tree", id)
+				}
+			}
+
+			if entry, ok := got[root]; ok {
+				if len(entry.Children) != 1 || entry.Children[0] != fmt.Sprint(child) {
+					t.Errorf("children of the root are %v", entry.Children)
+				}
+				if len(entry.Copies) != 1 || entry.Copies[0] != fmt.Sprint(copyOf) {
+					t.Errorf("copies of the root are %v", entry.Copies)
+				}
+				if entry.Rollup == nil || entry.Rollup.Descendants != 2 {
+					t.Errorf("rollup of the root is %v", entry.Rollup)
+				}
+			}
+		})
+	}
+}
+
+func TestGetRollups(t *testing.T) {
+	parent := addTestEntry(t, 4, "rollup parent")
+	other := addTestEntry(t, 4, "rollup other parent")
+	children := []int64{}
+	for i, status := range []db_types.Status{db_types.S_FINISHED, db_types.S_VIEWING} {
+		child := addTestEntry(t, 4, fmt.Sprintf("rollup child %d", i))
+		if err := AddRelation(4, child, db_types.R_Child, parent); err != nil {
+			t.Fatal(err)
+		}
+		user := db_types.UserViewingEntry{ItemId: child, Status: status, Minutes: 30, UserRating: float64(50 + 20*i)}
+		if err := UpdateUserViewingEntry(4, &user); err != nil {
+			t.Fatal(err)
+		}
+		children = append(children, child)
+	}
+	otherChild := addTestEntry(t, 4, "rollup other child")
+	if err := AddRelation(4, otherChild, db_types.R_Child, other); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := RequestContext{UID: 4, Auth: 4}
+
+	tests := []struct {
+		name string
+		ids  []int64
+		want []int64
+	}{
+		{"only the requested entries", []int64{parent}, []int64{parent}},
+		{"entries without descendants have no rollup", []int64{children[0]}, []int64{}},
+		{"every entry", nil, []int64{parent, other}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rollups, err := GetRollups(ctx, test.ids)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.ids != nil && len(rollups) != len(test.want) {
+				t.Fatalf("got %d rollups, want %v", len(rollups), test.want)
+			}
+			for _, id := range test.want {
+				if _, ok := rollups[id]; !ok {
+					t.Errorf("%d has no rollup", id)
+				}
+			}
+		})
+	}
+
+	rollups, err := GetRollups(ctx, []int64{parent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollup := rollups[parent]
+	if rollup.Descendants != 2 || rollup.Minutes != 60 || rollup.AverageRating != 60 || rollup.PercentFinished != 50 {
+		t.Errorf("wrong totals: %+v", rollup)
+	}
+	if rollup.StatusCounts[db_types.S_FINISHED] != 1 || rollup.StatusCounts[db_types.S_VIEWING] != 1 {
+		t.Errorf("wrong status counts: %v", rollup.StatusCounts)
+	}
+}
+
+func TestFindRelationPath(t *testing.T) {
+	// 20 layers of 2 entries, each entry requires both entries of the next layer
+	// there are 2^20 paths from the first layer to the last
+	layers := [][2]int64{}
+	for i := range 20 {
+		layers = append(layers, [2]int64{
+			addTestEntry(t, 4, fmt.Sprintf("path %d a", i)),
+			addTestEntry(t, 4, fmt.Sprintf("path %d b", i)),
+		})
+	}
+	for i := 1; i < len(layers); i++ {
+		for _, left := range layers[i-1] {
+			for _, right := range layers[i] {
+				if err := AddRelation(4, left, db_types.R_Requires, right); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	first, last := layers[0][0], layers[len(layers)-1][1]
+	shortcut := addTestEntry(t, 4, "path shortcut")
+	if err := AddRelation(4, layers[3][1], db_types.R_Requires, shortcut); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddRelation(4, shortcut, db_types.R_Requires, last); err != nil {
+		t.Fatal(err)
+	}
+	other := addTestEntry(t, 5, "path other user")
+	if err := AddRelation(5, last, db_types.R_Requires, other); err != nil {
+		t.Fatal(err)
+	}
+
+	requires := []db_types.Relation{db_types.R_Requires}
+	tests := []struct {
+		name      string
+		uid       int64
+		from      int64
+		to        int64
+		relations []db_types.Relation
+		want      []int64
+	}{
+		{"shortest path", 4, first, last, requires, []int64{first, layers[1][0], layers[2][0], layers[3][1], shortcut, last}},
+		{"one step", 4, first, layers[1][1], requires, []int64{first, layers[1][1]}},
+		{"against the relations", 4, last, first, requires, nil},
+		{"other relations", 4, first, last, []db_types.Relation{db_types.R_Child}, nil},
+		{"other user", 4, last, other, requires, nil},
+		{"no path back to itself", 4, first, first, requires, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, err := FindRelationPath(test.uid, test.from, test.to, test.relations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(path) != fmt.Sprint(test.want) {
+				t.Errorf("got %v, want %v", path, test.want)
+			}
+		})
+	}
+}
+
+// the ops of the changes of uid after since, as "kind op"
+func testChangeOps(t *testing.T, uid int64, since int64) []string {
+	t.Helper()
+	changes, err := ListChanges(uid, since, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := []string{}
+	for _, change := range changes {
+		out = append(out, change.Kind+" "+change.Op)
+	}
+	return out
+}
+
+func TestUpdateWithoutChanges(t *testing.T) {
+	id := addTestEntry(t, 6, "unchanged")
+	eventId, err := RegisterUserEvent(6, db_types.UserViewingEvent{ItemId: id, Event: "Viewed", Timestamp: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := RequestContext{UID: 6, Auth: 6}
+	info, err := GetInfoEntryById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := GetEvents(ctx, id)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("got %v (%v), want the 1 event", events, err)
+	}
+	event := events[0]
+	if event.EventId != eventId {
+		t.Fatalf("got event %d, want %d", event.EventId, eventId)
+	}
+
+	since, err := LastChangeSeq(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UpdateInfoEntry(6, &info); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateEvent(6, &event); err != nil {
+		t.Fatal(err)
+	}
+	if ops := testChangeOps(t, 6, since); len(ops) != 0 {
+		t.Errorf("saving unchanged rows added %v", ops)
+	}
+
+	info.Collection = "changed"
+	event.Event = "Finished"
+	if err := UpdateInfoEntry(6, &info); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateEvent(6, &event); err != nil {
+		t.Fatal(err)
+	}
+	if ops := testChangeOps(t, 6, since); fmt.Sprint(ops) != "[info update event update]" {
+		t.Errorf("got %v, want an update of each", ops)
+	}
+}
+
+func TestCompactChanges(t *testing.T) {
+	since, err := LastChangeSeq(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := addTestEntry(t, 7, "compact a")
+	b := addTestEntry(t, 7, "compact b")
+	ctx := RequestContext{UID: 7, Auth: 7}
+	for i := range 3 {
+		info, err := GetInfoEntryById(ctx, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info.Collection = fmt.Sprintf("collection %d", i)
+		if err := UpdateInfoEntry(7, &info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := AddRelation(7, a, db_types.R_Child, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := DelRelation(7, a, db_types.R_Child, b, false); err != nil {
+		t.Fatal(err)
+	}
+	before := testChangeOps(t, 7, since)
+
+	// nothing is old enough
+	if _, err := CompactChanges(0); err != nil {
+		t.Fatal(err)
+	}
+	if ops := testChangeOps(t, 7, since); fmt.Sprint(ops) != fmt.Sprint(before) {
+		t.Errorf("compacting before 0 changed %v to %v", before, ops)
+	}
+
+	if _, err := CompactChanges(time.Now().Add(time.Minute).UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := ListChanges(7, since, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := map[string]string{}
+	for _, change := range changes {
+		id := change.Kind + ":" + change.Identity()
+		if _, ok := latest[id]; ok {
+			t.Errorf("%s has more than 1 change", id)
+		}
+		latest[id] = change.Op
+	}
+
+	want := map[string]string{
+		fmt.Sprintf("info:%d", a):                                "update",
+		fmt.Sprintf("info:%d", b):                                "insert",
+		fmt.Sprintf("relation:%d,%d,%d", a, db_types.R_Child, b): "delete",
+	}
+	for id, op := range want {
+		if latest[id] != op {
+			t.Errorf("the change to %s is '%s', want '%s'", id, latest[id], op)
+		}
+	}
+
+	// the latest change still gives the row as it is now
+	last, err := LastRowChange(7, db_types.Change{Kind: "info", ItemId: a}, since)
+	if err != nil || last == 0 {
+		t.Errorf("got %d (%v), want the time of the last update", last, err)
+	}
+}
@@ -1,12 +1,18 @@
 package db
 
 import (
+	"aiolimas/logging"
 	"aiolimas/types"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"time"
 	"os"
 	"fmt"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 func Wait(uid int64, timezone string, entry *db_types.UserViewingEntry) error {
@@ -16,6 +22,7 @@ func Wait(uid int64, timezone string, entry *db_types.UserViewingEntry) error {
 	}
 
 	entry.Status = db_types.S_WAITING
+	entry.StatusEvents = append(entry.StatusEvents, db_types.WE_STATUS_WAITING)
 	return nil
 }
 
@@ -31,6 +38,7 @@ func Begin(uid int64, timezone string, entry *db_types.UserViewingEntry) error {
 		entry.Status = db_types.S_VIEWING
 	}
 
+	entry.StatusEvents = append(entry.StatusEvents, db_types.WE_STATUS_STARTED)
 	return nil
 }
 
@@ -43,9 +51,67 @@ func Finish(uid int64, timezone string, entry *db_types.UserViewingEntry) error
 	entry.Status = db_types.S_FINISHED
 	entry.ViewCount += 1
 
+	entry.StatusEvents = append(entry.StatusEvents, db_types.WE_STATUS_FINISHED)
 	return nil
 }
 
+// finishes every ancestor of itemId whose descendants are all Finished
+// returns the ids of the ancestors that were finished
+func FinishCompletedAncestors(uid int64, timezone string, itemId int64) ([]int64, error) {
+	finished := []int64{}
+	ctx := RequestContext{UID: uid, Auth: uid}
+
+	ancestors, err := GetAncestors(ctx, itemId)
+	if err != nil {
+		return finished, err
+	}
+	if len(ancestors) == 0 {
+		return finished, nil
+	}
+
+	ids := []int64{}
+	for _, ancestor := range ancestors {
+		ids = append(ids, ancestor.ItemId)
+	}
+
+	// finishing a parent can complete a grandparent, so keep going until nothing changes
+	for {
+		rollups, err := GetRollups(ctx, ids)
+		if err != nil {
+			return finished, err
+		}
+
+		changed := false
+		for id, rollup := range rollups {
+			if !rollup.AllFinished() {
+				continue
+			}
+
+			entry, err := GetUserEntry(ctx, id)
+			if err != nil {
+				return finished, err
+			}
+			if entry.Status == db_types.S_FINISHED {
+				continue
+			}
+
+			if err := Finish(uid, timezone, &entry); err != nil {
+				return finished, err
+			}
+			if err := UpdateUserViewingEntry(uid, &entry); err != nil {
+				return finished, err
+			}
+
+			finished = append(finished, id)
+			changed = true
+		}
+
+		if !changed {
+			return finished, nil
+		}
+	}
+}
+
 func Plan(uid int64, timezone string, entry *db_types.UserViewingEntry) error {
 	err := RegisterBasicUserEvent(uid, timezone, "Planned", entry.ItemId)
 	if err != nil {
@@ -54,6 +120,7 @@ func Plan(uid int64, timezone string, entry *db_types.UserViewingEntry) error {
 
 	entry.Status = db_types.S_PLANNED
 
+	entry.StatusEvents = append(entry.StatusEvents, db_types.WE_STATUS_PLANNED)
 	return nil
 }
 
@@ -68,6 +135,7 @@ func Resume(uid int64, timezone string, entry *db_types.UserViewingEntry) error
 	} else {
 		entry.Status = db_types.S_REVIEWING
 	}
+	entry.StatusEvents = append(entry.StatusEvents, db_types.WE_STATUS_RESUMED)
 	return nil
 }
 
@@ -79,6 +147,7 @@ func Drop(uid int64, timezone string, entry *db_types.UserViewingEntry) error {
 
 	entry.Status = db_types.S_DROPPED
 
+	entry.StatusEvents = append(entry.StatusEvents, db_types.WE_STATUS_DROPPED)
 	return nil
 }
 
@@ -90,6 +159,7 @@ func Pause(uid int64, timezone string, entry *db_types.UserViewingEntry) error {
 
 	entry.Status = db_types.S_PAUSED
 
+	entry.StatusEvents = append(entry.StatusEvents, db_types.WE_STATUS_PAUSED)
 	return nil
 }
 
@@ -167,7 +237,7 @@ func AddEntry(uid int64, timezone string, entryInfo *db_types.InfoEntry, metadat
 			case "ReViewing":
 				eName = "Started"
 		}
-		err := RegisterUserEvent(uid, db_types.UserViewingEvent{
+		_, err := RegisterUserEvent(uid, db_types.UserViewingEvent{
 			ItemId:    userViewingEntry.ItemId,
 			Timestamp: int64(time.Now().UnixMilli()),
 			Event:     eName,
@@ -187,14 +257,37 @@ func AddEntry(uid int64, timezone string, entryInfo *db_types.InfoEntry, metadat
 		}
 	}
 
+	notifyWebhooks(uid, db_types.WE_ENTRY_ADDED, id, entryInfo.En_Title, *entryInfo, "Added %s")
+
 	return nil
 }
 
-func RegisterUserEvent(uid int64, event db_types.UserViewingEvent) error {
-	return ExecUserDb(uid, `
+// the parts of *sql.DB and *sql.Tx that inserts use, so that they can be part of a transaction
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// the same, for functions that also read
+type querier interface {
+	execer
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// returns the rowid of the event
+func insertUserEvent(ex execer, uid int64, event db_types.UserViewingEvent) (int64, error) {
+	res, err := ex.Exec(`
 		INSERT INTO userEventInfo (uid, itemId, timestamp, event, after, timezone, beforeTS)
 		VALUES (?, ?, ?, ?, ?, ?, ?)
 	`, uid, event.ItemId, event.Timestamp, event.Event, event.After, event.TimeZone, event.Before)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// returns the EventId of the new event
+func RegisterUserEvent(uid int64, event db_types.UserViewingEvent) (int64, error) {
+	return insertUserEvent(DB, uid, event)
 }
 
 func RegisterBasicUserEvent(uid int64, timezone string, event string, itemId int64) error {
@@ -203,12 +296,45 @@ func RegisterBasicUserEvent(uid int64, timezone string, event string, itemId int
 	e.Timestamp = int64(time.Now().UnixMilli())
 	e.ItemId = itemId
 	e.TimeZone = timezone
-	return RegisterUserEvent(uid, e)
+	_, err := RegisterUserEvent(uid, e)
+	return err
 }
 
 func UpdateUserViewingEntry(uid int64, entry *db_types.UserViewingEntry) error {
 	ensureUserJsonNotEmpty(entry)
-	return updateTable(uid, *entry, "userViewingInfo")
+
+	var oldRating float64
+	ratingKnown := false
+	if rows, err := QueryDB(`SELECT userRating FROM userViewingInfo WHERE itemId = ? AND uid = ?`, entry.ItemId, uid); err == nil {
+		if rows.Next() {
+			ratingKnown = rows.Scan(&oldRating) == nil
+		}
+		rows.Close()
+	}
+
+	if err := updateTable(DB, uid, *entry, "userViewingInfo"); err != nil {
+		return err
+	}
+
+	for _, event := range entry.StatusEvents {
+		notifyStatus(uid, event, entry)
+	}
+	entry.StatusEvents = nil
+
+	if ratingKnown {
+		notifyRatingChanged(uid, entry.ItemId, oldRating, entry.UserRating)
+	}
+	return nil
+}
+
+func notifyRatingChanged(uid int64, itemId int64, oldRating float64, rating float64) {
+	if oldRating == rating {
+		return
+	}
+	notifyWebhooks(uid, db_types.WE_RATING_CHANGED, itemId, "", map[string]any{
+		"OldRating":  oldRating,
+		"UserRating": rating,
+	}, "Rated %s %s", formatRating(rating))
 }
 
 func MoveUserViewingEntry(uid int64, oldEntry *db_types.UserViewingEntry, newId int64) error {
@@ -219,7 +345,7 @@ func MoveUserViewingEntry(uid int64, oldEntry *db_types.UserViewingEntry, newId
 func MoveUserEventEntries(uid int64, eventList []db_types.UserViewingEvent, newId int64) error {
 	for _, e := range eventList {
 		e.ItemId = newId
-		err := RegisterUserEvent(uid, e)
+		_, err := RegisterUserEvent(uid, e)
 		if err != nil {
 			return err
 		}
@@ -236,17 +362,21 @@ func ClearUserEventEntries(uid int64, id int64) error {
 	return nil
 }
 
-func updateTable(uid int64, tblRepr db_types.TableRepresentation, tblName string) error {
+func updateTable(ex execer, uid int64, tblRepr db_types.TableRepresentation, tblName string) error {
 	updateStr := `UPDATE ` + tblName + ` SET `
 
 	data := db_types.StructNamesToDict(tblRepr, map[string]string{})
 
 	updateArgs := []any{}
+	changedArgs := []any{}
+	changed := []string{}
 
 	for k, v := range data {
 		updateArgs = append(updateArgs, v)
+		changedArgs = append(changedArgs, v)
 
 		updateStr += k + "= ?,"
+		changed = append(changed, k+" IS NOT ?")
 	}
 
 	// append the user id
@@ -258,51 +388,75 @@ func updateTable(uid int64, tblRepr db_types.TableRepresentation, tblName string
 	updateStr = updateStr[:len(updateStr)-1]
 	updateStr += "\nWHERE " + tblName + ".uid = ? and itemId = ?"
 
-	err := ExecUserDb(uid, updateStr, updateArgs...)
+	// a row that would not change is not updated, so that it does not add to the changes table
+	updateStr += " AND (" + strings.Join(changed, " OR ") + ")"
+	updateArgs = append(updateArgs, changedArgs...)
+
+	_, err := ex.Exec(updateStr, updateArgs...)
 	return err
 }
 
-func updateRowidTable(uid int64, rowid int64, tblRepr db_types.TableRepresentation, tblName string, replacements map[string]string) error {
+func updateRowidTable(ex execer, uid int64, rowid int64, tblRepr db_types.TableRepresentation, tblName string, replacements map[string]string) error {
 	set := ""
 	data := db_types.StructNamesToDict(tblRepr, replacements)
 	updateArgs := []any{}
+	changedArgs := []any{}
+	changed := []string{}
 
 	for k, v := range data {
-		if k == "eventId" || k == "transactionId" {
+		if k == "eventId" || k == "transactionId" || k == "subscriptionId" || k == "loanId" || k == "webhookId" || k == "deliveryId" {
 			continue
 		}
 		updateArgs = append(updateArgs, v)
+		changedArgs = append(changedArgs, v)
 
 		set += k + "= ?,"
+		changed = append(changed, k+" IS NOT ?")
 	}
 	updateArgs = append(updateArgs, rowid)
+	updateArgs = append(updateArgs, changedArgs...)
 	set = set[:len(set)-1]
-	return ExecUserDb(uid, `UPDATE ` + tblName + ` SET ` + set + ` WHERE rowid = ?`, updateArgs...)
+	// see updateTable
+	_, err := ex.Exec(`UPDATE ` + tblName + ` SET ` + set + ` WHERE rowid = ? AND (` + strings.Join(changed, " OR ") + `)`, updateArgs...)
+	return err
 }
 
 func UpdateEvent(uid int64, event *db_types.UserViewingEvent) error {
-	return updateRowidTable(uid, event.EventId, *event, "userEventInfo", map[string]string { "Before": "beforets"})
+	return updateRowidTable(DB, uid, event.EventId, *event, "userEventInfo", map[string]string { "Before": "beforets"})
 }
 
 func DeleteTransaction(uid int64, id int64) error {
-	return ExecUserDb(uid, `DELETE FROM transactions WHERE rowid = ?`, id)
+	transaction, err := GetTransaction(RequestContext{UID: uid, Auth: uid}, id)
+	if err != nil {
+		return err
+	}
+
+	if err := ExecUserDb(uid, `DELETE FROM transactions WHERE rowid = ?`, id); err != nil {
+		return err
+	}
+
+	notifyWebhooks(uid, db_types.WE_TRANSACTION_DELETED, transaction.ItemId, "", transaction, "Deleted a transaction of %s")
+	return nil
 }
 
 func UpdateTransaction(uid int64, transaction *db_types.TransactionEntry) error {
-	return updateRowidTable(uid, transaction.TransactionId, *transaction, "transactions", map[string]string{})
+	return updateRowidTable(DB, uid, transaction.TransactionId, *transaction, "transactions", map[string]string{})
 }
 
 func UpdateMetadataEntry(uid int64, entry *db_types.MetadataEntry) error {
 	ensureMetadataJsonNotEmpty(entry)
-	return updateTable(uid, *entry, "metadata")
+	return updateTable(DB, uid, *entry, "metadata")
 }
 
 func UpdateInfoEntry(uid int64, entry *db_types.InfoEntry) error {
 	ensureRecommendedByNotEmpty(entry)
-	return updateTable(uid, *entry, "entryInfo")
+	return updateTable(DB, uid, *entry, "entryInfo")
 }
 
 func Delete(uid int64, id int64) error {
+	// the title is gone once the entry is
+	title := entryTitle(uid, id)
+
 	transact, err := DB.Begin()
 	if err != nil {
 		return err
@@ -315,14 +469,35 @@ func Delete(uid int64, id int64) error {
 		os.Remove(thumbPath)
 	}
 
-	transact.Exec(`DELETE FROM entryInfo WHERE itemId = ? and entryInfo.uid = ?`, id, uid)
-	transact.Exec(`DELETE FROM metadata WHERE itemId = ? and metadata.uid = ?`, id, uid)
-	transact.Exec(`DELETE FROM userViewingInfo WHERE itemId = ? and userViewingInfo.uid = ?`, id, uid)
-	transact.Exec(`DELETE FROM userEventInfo WHERE itemId = ? and userEventInfo.uid = ?`, id, uid)
-	transact.Exec(`DELETE FROM relations WHERE left = ? or right = ?`, id, id)
-	transact.Exec(`DELETE FROM transactions WHERE itemid = ?`, id)
+	deletions := []string{
+		`DELETE FROM entryInfo WHERE itemId = ? and entryInfo.uid = ?`,
+		`DELETE FROM metadata WHERE itemId = ? and metadata.uid = ?`,
+		`DELETE FROM userViewingInfo WHERE itemId = ? and userViewingInfo.uid = ?`,
+		`DELETE FROM userEventInfo WHERE itemId = ? and userEventInfo.uid = ?`,
+		`DELETE FROM transactions WHERE itemid = ? and transactions.uid = ?`,
+		`DELETE FROM subscriptions WHERE itemid = ? and subscriptions.uid = ?`,
+		`DELETE FROM inventory WHERE itemid = ? and inventory.uid = ?`,
+		`DELETE FROM loans WHERE itemid = ? and loans.uid = ?`,
+	}
+	for _, query := range deletions {
+		if _, err := transact.Exec(query, id, uid); err != nil {
+			transact.Rollback()
+			return err
+		}
+	}
 
-	return transact.Commit()
+	// relations from either side must go, otherwise they are left pointing at nothing
+	if _, err := transact.Exec(`DELETE FROM relations WHERE (left = ? or right = ?) and relations.uid = ?`, id, id, uid); err != nil {
+		transact.Rollback()
+		return err
+	}
+
+	if err := transact.Commit(); err != nil {
+		return err
+	}
+
+	notifyWebhooks(uid, db_types.WE_ENTRY_DELETED, id, title, nil, "Deleted %s")
+	return nil
 }
 
 func DeleteByUID(uid int64) error {
@@ -330,13 +505,19 @@ func DeleteByUID(uid int64) error {
 	if err != nil {
 		return err
 	}
+	defer transact.Rollback()
 
-	transact.Exec(`DELETE FROM entryInfo WHERE entryInfo.uid = ?`, uid)
-	transact.Exec(`DELETE FROM metadata WHERE metadata.uid = ?`, uid)
-	transact.Exec(`DELETE FROM userViewingInfo WHERE userViewingInfo.uid = ?`, uid)
-	transact.Exec(`DELETE FROM userEventInfo WHERE userEventInfo.uid = ?`, uid)
-	transact.Exec(`DELETE FROM relations WHERE userEventInfo.uid = ?`, uid)
-	transact.Exec(`DELETE FROM transactions WHERE userEventInfo.uid = ?`, uid)
+	// changes is last, the triggers of the other tables add to it
+	tables := []string{
+		"entryInfo", "metadata", "userViewingInfo", "userEventInfo", "relations",
+		"transactions", "subscriptions", "exchangeRates", "inventory", "loans",
+		"webhookDeliveries", "webhooks", "metadataRefreshes", "changes",
+	}
+	for _, table := range tables {
+		if _, err := transact.Exec(fmt.Sprintf(`DELETE FROM %s WHERE uid = ?`, table), uid); err != nil {
+			return fmt.Errorf("could not delete from %s: %w", table, err)
+		}
+	}
 
 	return transact.Commit()
 }
@@ -355,8 +536,8 @@ func DeletEventV2(uid int64, id int64) error {
 
 func BecomeOriginal(uid int64, itemid int64) error{
 	return ExecUserDb(uid, `
-		DELETE FROM relations WHERE left = ? or right = ? and relation = ?
-	`, itemid, itemid, db_types.R_Copy)
+		DELETE FROM relations WHERE (left = ? or right = ?) and relation = ? and uid = ?
+	`, itemid, itemid, db_types.R_Copy, uid)
 }
 
 func SetParent(uid int64, itemid int64, parent int64) error {
@@ -364,15 +545,16 @@ func SetParent(uid int64, itemid int64, parent int64) error {
 		return errors.New("uid cannot be 0 to set a parent")
 	}
 
+	// check before becoming an orphan so that a rejected parent does not lose the old one
+	if err := checkRelation(uid, itemid, db_types.R_Child, parent); err != nil {
+		return err
+	}
+
 	if err := BecomeOrphan(uid, itemid); err != nil {
 		return err
 	}
 
-	return ExecUserDb(uid, `
-		INSER INTO relations (uid, left, relation, right)
-		VALUES
-		(?, ?, ?, ?)
-	`, uid, itemid, db_types.R_Child, parent)
+	return AddRelation(uid, itemid, db_types.R_Child, parent)
 }
 
 func SetCopy(uid int64, itemid int64, copyof int64) error {
@@ -380,32 +562,97 @@ func SetCopy(uid int64, itemid int64, copyof int64) error {
 		return errors.New("uid cannot be 0 to set a copy")
 	}
 
+	if err := checkRelation(uid, itemid, db_types.R_Copy, copyof); err != nil {
+		return err
+	}
+
 	err := BecomeOriginal(uid, itemid)
 	if err != nil{
 		return err
 	}
 
-	return ExecUserDb(uid, `
-		INSERT INTO relations (uid, left, relation, right)
-		VALUES
-		(?, ?, ?, ?)
-	`, uid, itemid, db_types.R_Copy, copyof)
+	return AddRelation(uid, itemid, db_types.R_Copy, copyof)
 }
 
 func BecomeOrphan(uid int64, itemid int64) error {
 	return ExecUserDb(uid, `
-		DELETE FROM relations WHERE left = ? and relation = ?
-	`, itemid, db_types.R_Child)
+		DELETE FROM relations WHERE left = ? and relation = ? and uid = ?
+	`, itemid, db_types.R_Child, uid)
+}
+
+// returned when adding a relation would create a cycle
+type CycleError struct {
+	// starts and ends with the left side of the rejected relation
+	Path []int64
+}
+
+func (self CycleError) Error() string {
+	ids := []string{}
+	for _, id := range self.Path {
+		ids = append(ids, fmt.Sprintf("%d", id))
+	}
+	return "relation would create a cycle: " + strings.Join(ids, " -> ")
+}
+
+// the relations that are followed when looking for a cycle created by relation
+// Descendant kinds share one graph, since GetDescendants walks all of them at once
+func cycleRelations(kind db_types.RelationKind) []db_types.Relation {
+	if kind.Descendant {
+		return db_types.ListDescendantRelations()
+	}
+	return []db_types.Relation{kind.Id}
 }
 
+func checkRelation(uid int64, left int64, relation db_types.Relation, right int64) error {
+	if left == right {
+		return errors.New("an entry cannot be related to itself")
+	}
+
+	kind, ok := db_types.GetRelationKind(relation)
+	if !ok {
+		return fmt.Errorf("invalid relation: %d", relation)
+	}
+
+	// a symmetric relation is its own inverse, so it cannot form a meaningful cycle
+	if kind.Symmetric {
+		return nil
+	}
+
+	path, err := FindRelationPath(uid, right, left, cycleRelations(kind))
+	if err != nil {
+		return err
+	}
+	if path != nil {
+		return CycleError{Path: append([]int64{left}, path...)}
+	}
+	return nil
+}
+
+// adding a relation that already exists does nothing
+// returns a CycleError if the relation would create a cycle
 func AddRelation(uid int64, left int64, relation db_types.Relation, right int64) error {
 	if uid == 0 {
 		return errors.New("uid cannot be 0 to add a relation")
 	}
+
+	if err := checkRelation(uid, left, relation, right); err != nil {
+		return err
+	}
+
 	return ExecUserDb(uid, `
 		INSERT INTO relations (uid, left, relation, right)
-		VALUES (?, ?, ?, ?)
-`, uid, left, relation, right)
+		SELECT ?, ?, ?, ?
+		WHERE NOT EXISTS (
+			SELECT 1 FROM relations WHERE uid = ? AND relation = ? AND (
+				(left = ? AND right = ?) OR (? AND left = ? AND right = ?)
+			)
+		)
+`, uid, left, relation, right, uid, relation, left, right, isSymmetric(relation), right, left)
+}
+
+func isSymmetric(relation db_types.Relation) bool {
+	kind, _ := db_types.GetRelationKind(relation)
+	return kind.Symmetric
 }
 
 func DelRelation(uid int64, left int64, relation db_types.Relation, right int64, reciprocal bool) error {
@@ -420,6 +667,150 @@ func DelRelation(uid int64, left int64, relation db_types.Relation, right int64,
 	}
 }
 
+type RelationRow struct {
+	Left     int64
+	Relation db_types.Relation
+	Right    int64
+}
+
+type RelationReport struct {
+	// relations where left or right is not an entry
+	Orphans        []RelationRow
+	SelfReferences []RelationRow
+	// every copy of a relation after the first
+	// symmetric relations are also duplicates if they are reversed
+	Duplicates []RelationRow
+	// cycles are only reported, as there is no way to know which relation is wrong
+	Cycles [][]int64
+	// if the orphans, self references, and duplicates were deleted
+	Fixed bool
+}
+
+func selectRelationRows(query string, args ...any) ([]int64, []RelationRow, error) {
+	rowids := []int64{}
+	out := []RelationRow{}
+
+	rows, err := QueryDB(query, args...)
+	if err != nil {
+		return rowids, out, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rowid int64
+		var row RelationRow
+		if err := rows.Scan(&rowid, &row.Left, &row.Relation, &row.Right); err != nil {
+			return rowids, out, err
+		}
+		rowids = append(rowids, rowid)
+		out = append(out, row)
+	}
+	return rowids, out, rows.Err()
+}
+
+// checks uid's relations for problems, if fix is true, the fixable ones are deleted
+func ValidateRelations(uid int64, fix bool) (RelationReport, error) {
+	report := RelationReport{
+		Cycles: [][]int64{},
+	}
+
+	symmetric := []db_types.Relation{}
+	for _, kind := range db_types.ListRelationKinds() {
+		if kind.Symmetric {
+			symmetric = append(symmetric, kind.Id)
+		}
+	}
+
+	orphanIds, orphans, err := selectRelationRows(`
+		SELECT rowid, left, relation, right FROM relations
+		WHERE uid = ? AND (
+			left NOT IN (SELECT itemId FROM entryInfo WHERE uid = ?)
+			OR right NOT IN (SELECT itemId FROM entryInfo WHERE uid = ?)
+		)`, uid, uid, uid)
+	if err != nil {
+		return report, err
+	}
+	report.Orphans = orphans
+
+	selfIds, selfRefs, err := selectRelationRows(`
+		SELECT rowid, left, relation, right FROM relations
+		WHERE uid = ? AND left = right`, uid)
+	if err != nil {
+		return report, err
+	}
+	report.SelfReferences = selfRefs
+
+	duplicateIds, duplicates, err := selectRelationRows(fmt.Sprintf(`
+		SELECT rowid, left, relation, right FROM relations r
+		WHERE uid = ? AND EXISTS (
+			SELECT 1 FROM relations o
+			WHERE o.uid = r.uid AND o.relation = r.relation AND o.rowid < r.rowid AND (
+				(o.left = r.left AND o.right = r.right)
+				OR (o.relation IN (%s) AND o.left = r.right AND o.right = r.left)
+			)
+		)`, relationList(symmetric)), uid)
+	if err != nil {
+		return report, err
+	}
+	report.Duplicates = duplicates
+
+	if fix {
+		transact, err := DB.Begin()
+		if err != nil {
+			return report, err
+		}
+
+		ids := append(append(orphanIds, selfIds...), duplicateIds...)
+		for _, rowid := range ids {
+			if _, err := transact.Exec(`DELETE FROM relations WHERE rowid = ? AND uid = ?`, rowid, uid); err != nil {
+				transact.Rollback()
+				return report, err
+			}
+		}
+
+		if err := transact.Commit(); err != nil {
+			return report, err
+		}
+		report.Fixed = true
+	}
+
+	_, directed, err := selectRelationRows(fmt.Sprintf(`
+		SELECT rowid, left, relation, right FROM relations
+		WHERE uid = ? AND left != right AND relation NOT IN (%s)`, relationList(symmetric)), uid)
+	if err != nil {
+		return report, err
+	}
+
+	// the same cycle is found once for every relation in it
+	seen := map[string]bool{}
+	for _, row := range directed {
+		kind, ok := db_types.GetRelationKind(row.Relation)
+		if !ok {
+			continue
+		}
+
+		path, err := FindRelationPath(uid, row.Right, row.Left, cycleRelations(kind))
+		if err != nil {
+			return report, err
+		}
+		if path == nil {
+			continue
+		}
+
+		cycle := append([]int64{row.Left}, path...)
+		members := slices.Clone(cycle[1:])
+		slices.Sort(members)
+		key := fmt.Sprint(members)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		report.Cycles = append(report.Cycles, cycle)
+	}
+
+	return report, nil
+}
+
 func AddTags(uid int64, id int64, tags []string) error {
 	tagsString := strings.Join(tags, "\x1F\x1F")
 	return ExecUserDb(uid, "UPDATE entryInfo SET collection = (collection || char(31) || ? || char(31)) WHERE itemId = ? and entryInfo.uid = ?", tagsString, id, uid)
@@ -439,34 +830,265 @@ func DelTags(uid int64, id int64, tags []string) error {
 	return nil
 }
 
-func CreateTransaction(transactionType db_types.Transaction, uid int64, itemId int64, eventId int64, timezone string, price float64, currency string) error {
+// amount is in the minor unit of currency, see db_types.MajorToMinor
+// if transaction.EventId is 0, an event named after transaction.Kind is registered for it
+func CreateTransaction(uid int64, timezone string, transaction *db_types.TransactionEntry) error {
+	return createTransactionAt(uid, timezone, time.Now().UnixMilli(), transaction)
+}
+
+func createTransactionAt(uid int64, timezone string, timestamp int64, transaction *db_types.TransactionEntry) error {
+	if err := insertTransaction(DB, uid, timezone, timestamp, transaction); err != nil {
+		return err
+	}
+	notifyTransactionCreated(uid, *transaction)
+	return nil
+}
+
+// validates, and inserts transaction (and its event, if it has none) with ex
+// sets the transaction's Uid, EventId, and TransactionId
+func insertTransaction(ex execer, uid int64, timezone string, timestamp int64, transaction *db_types.TransactionEntry) error {
 	if uid == 0 {
 		return errors.New("uid cannot be 0 for creating a transaction")
 	}
 
-	if eventId == 0 {
-		if err := RegisterBasicUserEvent(uid, timezone, string(transactionType), itemId); err != nil {
-			return err;
+	if !db_types.IsValidCurrency(transaction.Currency) {
+		return fmt.Errorf("invalid currency: '%s'", transaction.Currency)
+	}
+	transaction.Currency = strings.ToUpper(transaction.Currency)
+
+	if transaction.Kind == "" {
+		transaction.Kind = db_types.TRANSACTION_BUY
+	}
+	if !db_types.IsValidTransactionKind(string(transaction.Kind)) {
+		return fmt.Errorf("invalid transaction kind: '%s'", transaction.Kind)
+	}
+	transaction.Amount = transaction.Kind.NormalizeAmount(transaction.Amount)
+
+	transaction.Uid = uid
+
+	if transaction.EventId == 0 {
+		eventId, err := insertUserEvent(ex, uid, db_types.UserViewingEvent{
+			ItemId:    transaction.ItemId,
+			Event:     string(transaction.Kind),
+			Timestamp: timestamp,
+			TimeZone:  timezone,
+		})
+		if err != nil {
+			return err
+		}
+		transaction.EventId = eventId
+	}
+
+	res, err := ex.Exec(`
+		INSERT INTO transactions (uid, itemId, eventId, currency, amount, kind, vendor, orderId, receiptPath, notes, subscription)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		uid,
+		transaction.ItemId,
+		transaction.EventId,
+		transaction.Currency,
+		transaction.Amount,
+		transaction.Kind,
+		transaction.Vendor,
+		transaction.OrderId,
+		transaction.ReceiptPath,
+		transaction.Notes,
+		transaction.Subscription,
+	)
+	if err != nil {
+		return err
+	}
+	transaction.TransactionId, err = res.LastInsertId()
+	return err
+}
+
+func notifyTransactionCreated(uid int64, transaction db_types.TransactionEntry) {
+	price := strconv.FormatFloat(
+		db_types.MinorToMajor(transaction.Amount, transaction.Currency),
+		'f', db_types.CurrencyMinorUnits(transaction.Currency), 64,
+	)
+	// eg: Purchased <title> for 12.34 USD
+	notifyWebhooks(
+		uid, db_types.WE_TRANSACTION_CREATED, transaction.ItemId, "", transaction,
+		"%[2]s %[1]s for %[3]s %[4]s", transaction.Kind, price, transaction.Currency,
+	)
+}
+
+func CreateSubscription(uid int64, sub *db_types.Subscription) error {
+	if uid == 0 {
+		return errors.New("uid cannot be 0 for creating a subscription")
+	}
+
+	if !db_types.IsValidCurrency(sub.Currency) {
+		return fmt.Errorf("invalid currency: '%s'", sub.Currency)
+	}
+	sub.Currency = strings.ToUpper(sub.Currency)
+
+	if !db_types.IsValidSubscriptionPeriod(string(sub.Period)) {
+		return fmt.Errorf("invalid subscription period: '%s'", sub.Period)
+	}
+
+	sub.Uid = uid
+
+	res, err := DB.Exec(`
+		INSERT INTO subscriptions (uid, itemId, currency, amount, period, start, until, lastCharged, vendor, notes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, uid, sub.ItemId, sub.Currency, sub.Amount, sub.Period, sub.Start, sub.Until, sub.LastCharged, sub.Vendor, sub.Notes)
+	if err != nil {
+		return err
+	}
+	sub.SubscriptionId, err = res.LastInsertId()
+	return err
+}
+
+func UpdateSubscription(uid int64, sub *db_types.Subscription) error {
+	return updateRowidTable(DB, uid, sub.SubscriptionId, *sub, "subscriptions", map[string]string{})
+}
+
+func DeleteSubscription(uid int64, id int64) error {
+	return ExecUserDb(uid, `DELETE FROM subscriptions WHERE rowid = ? AND uid = ?`, id, uid)
+}
+
+// ChargeSubscriptions runs in the background, and when a subscription is created
+// two runs at once would both see the same periods as uncharged
+var chargeLock sync.Mutex
+
+// creates a Subscription transaction for every period of every subscription that
+// has started since it was last charged, up to now (unix ms)
+// each charge is saved together with the subscription's LastCharged, so a failure never charges a period twice
+func ChargeSubscriptions(uid int64, timezone string, now int64) error {
+	chargeLock.Lock()
+	defer chargeLock.Unlock()
+
+	subs, err := ListSubscriptions(RequestContext{UID: uid, Auth: uid})
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		// periods are counted from Start, so that charges do not drift when a month is short
+		n := int64(0)
+		next := sub.Start
+		for sub.LastCharged != 0 && next <= sub.LastCharged {
+			n++
+			next = sub.Period.Nth(sub.Start, n)
+		}
+
+		for next <= now && (sub.Until == 0 || next < sub.Until) {
+			transaction := db_types.TransactionEntry{
+				ItemId:       sub.ItemId,
+				Currency:     sub.Currency,
+				Amount:       sub.Amount,
+				Kind:         db_types.TRANSACTION_SUBSCRIPTION,
+				Vendor:       sub.Vendor,
+				Subscription: sub.SubscriptionId,
+			}
+			if err := chargeSubscription(uid, timezone, next, sub, &transaction); err != nil {
+				return err
+			}
+			notifyTransactionCreated(uid, transaction)
+
+			sub.LastCharged = next
+			n++
+			next = sub.Period.Nth(sub.Start, n)
 		}
-		return ExecUserDb(uid, `
-			INSERT INTO transactions VALUES (
-				?,
-				?,
-				(SELECT MAX(rowid) FROM userEventInfo WHERE uid = ? AND itemId = ?),
-				?,
-				?
-			)
-		`, uid, itemId, uid, itemId, price, currency)
 	}
 
+	return nil
+}
+
+// creates transaction for the period of sub that starts at charge, and marks the period as charged
+func chargeSubscription(uid int64, timezone string, charge int64, sub db_types.Subscription, transaction *db_types.TransactionEntry) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := insertTransaction(tx, uid, timezone, charge, transaction); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE subscriptions SET lastCharged = ? WHERE rowid = ? AND uid = ?`, charge, sub.SubscriptionId, uid); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func SetExchangeRate(uid int64, rate db_types.ExchangeRate) error {
+	if uid == 0 {
+		return errors.New("uid cannot be 0 for setting an exchange rate")
+	}
+
+	return ExecUserDb(uid, `
+		INSERT OR REPLACE INTO exchangeRates (uid, fromCurrency, toCurrency, rate)
+		VALUES (?, ?, ?, ?)
+	`, uid, strings.ToUpper(rate.From), strings.ToUpper(rate.To), rate.Rate)
+}
+
+func DeleteExchangeRate(uid int64, from string, to string) error {
 	return ExecUserDb(uid, `
-		INSERT INTO transactions VALUES (
-			?,
-			?,
-			?,
-			?,
-			?
-	`, uid, itemId, eventId, price, currency)
+		DELETE FROM exchangeRates WHERE uid = ? AND fromCurrency = ? AND toCurrency = ?
+	`, uid, strings.ToUpper(from), strings.ToUpper(to))
+}
+
+func SetInventoryEntry(uid int64, entry *db_types.InventoryEntry) error {
+	if !db_types.IsValidCondition(string(entry.Condition)) {
+		return fmt.Errorf("invalid condition: '%s'", entry.Condition)
+	}
+
+	entry.Uid = uid
+
+	err := ExecUserDb(uid, `INSERT OR IGNORE INTO inventory (uid, itemId) VALUES (?, ?)`, uid, entry.ItemId)
+	if err != nil {
+		return err
+	}
+
+	return updateTable(DB, uid, *entry, "inventory")
+}
+
+func DeleteInventoryEntry(uid int64, itemId int64) error {
+	return ExecUserDb(uid, `DELETE FROM inventory WHERE uid = ? AND itemId = ?`, uid, itemId)
+}
+
+// an item can only be lent to 1 person at a time
+func Lend(uid int64, loan *db_types.Loan) error {
+	if uid == 0 {
+		return errors.New("uid cannot be 0 for lending an item")
+	}
+
+	if loan.Borrower == "" {
+		return errors.New("borrower cannot be empty")
+	}
+
+	active, err := ListLoans(RequestContext{UID: uid, Auth: uid}, loan.ItemId, true)
+	if err != nil {
+		return err
+	}
+	if len(active) > 0 {
+		return fmt.Errorf("item %d is already lent to %s", loan.ItemId, active[0].Borrower)
+	}
+
+	if loan.Lent == 0 {
+		loan.Lent = time.Now().UnixMilli()
+	}
+
+	loan.Uid = uid
+
+	return ExecUserDb(uid, `
+		INSERT INTO loans (uid, itemId, borrower, lent, due, returned, notes)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, uid, loan.ItemId, loan.Borrower, loan.Lent, loan.Due, loan.Returned, loan.Notes)
+}
+
+func UpdateLoan(uid int64, loan *db_types.Loan) error {
+	return updateRowidTable(DB, uid, loan.LoanId, *loan, "loans", map[string]string{})
+}
+
+func DeleteLoan(uid int64, id int64) error {
+	return ExecUserDb(uid, `DELETE FROM loans WHERE rowid = ? AND uid = ?`, id, uid)
 }
 
 func GetEntrySettings(id int64) (db_types.EntrySettings, error) {
@@ -496,3 +1118,282 @@ func SetEntrySettings(settings db_types.EntrySettings) error {
 
 	return err
 }
+
+func AddMetadataRefresh(uid int64, refresh *db_types.MetadataRefresh) error {
+	refresh.Uid = uid
+	if refresh.Changes == "" {
+		refresh.Changes = "{}"
+	}
+
+	err := ExecUserDb(uid, `
+		INSERT INTO metadataRefreshes (uid, itemId, timestamp, provider, changes, error)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, uid, refresh.ItemId, refresh.Timestamp, refresh.Provider, refresh.Changes, refresh.Error)
+	if err != nil {
+		return err
+	}
+
+	// refreshes that changed nothing happen all the time, and are not worth sending
+	if refresh.Error != "" {
+		notifyWebhooks(uid, db_types.WE_METADATA_REFRESHED, refresh.ItemId, "", *refresh, "Could not refresh the metadata of %s")
+	} else if refresh.Changes != "{}" {
+		notifyWebhooks(uid, db_types.WE_METADATA_REFRESHED, refresh.ItemId, "", *refresh, "Refreshed the metadata of %s")
+	}
+	return nil
+}
+
+func CreateWebhook(uid int64, hook *db_types.Webhook) error {
+	hook.Uid = uid
+	if hook.Events == "" {
+		hook.Events = "[]"
+	}
+	if hook.Created == 0 {
+		hook.Created = time.Now().UnixMilli()
+	}
+
+	res, err := DB.Exec(`
+		INSERT INTO webhooks (uid, url, secret, events, enabled, created)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, uid, hook.Url, hook.Secret, hook.Events, hook.Enabled, hook.Created)
+	if err != nil {
+		return err
+	}
+	hook.WebhookId, err = res.LastInsertId()
+	return err
+}
+
+func UpdateWebhook(uid int64, hook *db_types.Webhook) error {
+	return updateRowidTable(DB, uid, hook.WebhookId, *hook, "webhooks", map[string]string{})
+}
+
+// the delivery log of the webhook is deleted with it
+func DeleteWebhook(uid int64, id int64) error {
+	if err := ExecUserDb(uid, `DELETE FROM webhookDeliveries WHERE webhookId = ? AND uid = ?`, id, uid); err != nil {
+		return err
+	}
+	return ExecUserDb(uid, `DELETE FROM webhooks WHERE rowid = ? AND uid = ?`, id, uid)
+}
+
+// queues payload for hook, even if hook is disabled or does not want payload.Event
+func QueueWebhookDelivery(uid int64, hook db_types.Webhook, payload db_types.WebhookPayload) (db_types.WebhookDelivery, error) {
+	text, err := json.Marshal(payload)
+	if err != nil {
+		return db_types.WebhookDelivery{}, err
+	}
+
+	now := time.Now().UnixMilli()
+	delivery := db_types.WebhookDelivery{
+		Uid:         uid,
+		WebhookId:   hook.WebhookId,
+		Event:       payload.Event,
+		ItemId:      payload.ItemId,
+		Payload:     string(text),
+		Status:      db_types.DS_PENDING,
+		NextAttempt: now,
+		Created:     now,
+	}
+
+	res, err := DB.Exec(`
+		INSERT INTO webhookDeliveries (uid, webhookId, event, itemId, payload, status, nextAttempt, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, uid, delivery.WebhookId, delivery.Event, delivery.ItemId, delivery.Payload, delivery.Status, delivery.NextAttempt, delivery.Created)
+	if err != nil {
+		return delivery, err
+	}
+	delivery.DeliveryId, err = res.LastInsertId()
+	return delivery, err
+}
+
+// claims the pending delivery until until (unix ms), by moving its next attempt there if nothing else has moved it,
+// so that it is not sent by 2 things at once (eg: a ping, and webhooks.DeliverDue)
+// returns false if it was already claimed, or is no longer pending
+func ClaimWebhookDelivery(delivery *db_types.WebhookDelivery, until int64) (bool, error) {
+	res, err := DB.Exec(
+		`UPDATE webhookDeliveries SET nextAttempt = ? WHERE rowid = ? AND status = ? AND nextAttempt = ?`,
+		until, delivery.DeliveryId, db_types.DS_PENDING, delivery.NextAttempt,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	delivery.NextAttempt = until
+	return true, nil
+}
+
+func UpdateWebhookDelivery(uid int64, delivery *db_types.WebhookDelivery) error {
+	return updateRowidTable(DB, uid, delivery.DeliveryId, *delivery, "webhookDeliveries", map[string]string{})
+}
+
+func entryTitle(uid int64, id int64) string {
+	rows, err := QueryDB(`SELECT en_title FROM entryInfo WHERE itemId = ? AND uid = ?`, id, uid)
+	if err != nil {
+		return ""
+	}
+	defer rows.Close()
+
+	title := ""
+	if rows.Next() {
+		rows.Scan(&title)
+	}
+	return title
+}
+
+func formatRating(rating float64) string {
+	return strconv.FormatFloat(rating, 'f', -1, 64)
+}
+
+// queues the event for every enabled webhook of uid that wants it
+// summary is a format string, its first argument is the title of the entry (looked up if title is ""), followed by args
+// titles and other user data must only be arguments, they can contain %
+// what caused the event already happened, so failing to queue it is only logged
+func notifyWebhooks(uid int64, event db_types.WebhookEvent, itemId int64, title string, data any, summary string, args ...any) {
+	hooks, err := ListWebhooks(uid)
+	if err != nil {
+		logging.ELog(err)
+		return
+	}
+
+	hooks = slices.DeleteFunc(hooks, func(hook db_types.Webhook) bool {
+		return !hook.Enabled || !hook.Wants(event)
+	})
+	if len(hooks) == 0 {
+		return
+	}
+
+	if title == "" && itemId != 0 {
+		title = entryTitle(uid, itemId)
+	}
+
+	payload := db_types.WebhookPayload{
+		Event:     event,
+		Timestamp: time.Now().UnixMilli(),
+		Uid:       uid,
+		ItemId:    itemId,
+		Title:     title,
+		Summary:   fmt.Sprintf(summary, append([]any{title}, args...)...),
+		Data:      data,
+	}
+	for _, hook := range hooks {
+		if _, err := QueueWebhookDelivery(uid, hook, payload); err != nil {
+			logging.ELog(err)
+		}
+	}
+}
+
+// entry is as it was saved
+func notifyStatus(uid int64, event db_types.WebhookEvent, entry *db_types.UserViewingEntry) {
+	summary := ""
+	args := []any{}
+	switch event {
+	case db_types.WE_STATUS_STARTED:
+		summary = "Started %s"
+	case db_types.WE_STATUS_FINISHED:
+		summary = "Finished %s"
+		if entry.UserRating != 0 {
+			summary += " (rated %s)"
+			args = append(args, formatRating(entry.UserRating))
+		}
+	case db_types.WE_STATUS_DROPPED:
+		summary = "Dropped %s"
+	case db_types.WE_STATUS_PAUSED:
+		summary = "Paused %s"
+	case db_types.WE_STATUS_RESUMED:
+		summary = "Resumed %s"
+	case db_types.WE_STATUS_PLANNED:
+		summary = "Planned %s"
+	case db_types.WE_STATUS_WAITING:
+		summary = "Waiting for %s"
+	}
+
+	notifyWebhooks(uid, event, entry.ItemId, "", map[string]any{
+		"Status":     entry.Status,
+		"UserRating": entry.UserRating,
+		"ViewCount":  entry.ViewCount,
+	}, summary, args...)
+}
+
+// saves row, an edited copy of the row of change, with ex
+// the rows are the ones that GetChangedRow returns, relations and exchange rates have nothing to edit
+func saveChangedRow(q querier, uid int64, row any) error {
+	switch r := row.(type) {
+	case db_types.InfoEntry:
+		ensureRecommendedByNotEmpty(&r)
+		return updateTable(q, uid, r, "entryInfo")
+	case db_types.MetadataEntry:
+		ensureMetadataJsonNotEmpty(&r)
+		return updateTable(q, uid, r, "metadata")
+	case db_types.UserViewingEntry:
+		ensureUserJsonNotEmpty(&r)
+		return updateTable(q, uid, r, "userViewingInfo")
+	case db_types.InventoryEntry:
+		if !db_types.IsValidCondition(string(r.Condition)) {
+			return fmt.Errorf("invalid condition: '%s'", r.Condition)
+		}
+		return updateTable(q, uid, r, "inventory")
+	case db_types.UserViewingEvent:
+		return updateRowidTable(q, uid, r.EventId, r, "userEventInfo", map[string]string{"Before": "beforets"})
+	case db_types.TransactionEntry:
+		return updateRowidTable(q, uid, r.TransactionId, r, "transactions", map[string]string{})
+	case db_types.Subscription:
+		return updateRowidTable(q, uid, r.SubscriptionId, r, "subscriptions", map[string]string{})
+	case db_types.Loan:
+		// a loan that is not returned cannot overlap another one, see Lend
+		if r.Returned == 0 {
+			active, lent, err := selectOne(q, db_types.Loan{}, `SELECT rowid, * FROM loans WHERE uid = ? AND itemId = ? AND returned = 0 AND rowid != ?`, uid, r.ItemId, r.LoanId)
+			if err != nil {
+				return err
+			}
+			if lent {
+				return fmt.Errorf("item %d is already lent to %s", r.ItemId, active.Borrower)
+			}
+		}
+		return updateRowidTable(q, uid, r.LoanId, r, "loans", map[string]string{})
+	}
+	return fmt.Errorf("%T cannot be edited", row)
+}
+
+// reads the row of change, and the Timestamp of its latest change after the change sequence after,
+// and saves the row that edit returns if it says that it changed it
+// this is done in 1 transaction, so that nothing changes the row between reading and saving it
+//
+// edit must not use the database, the transaction holds the only connection
+func EditChangedRow(uid int64, change db_types.Change, after int64, edit func(row any, lastChange int64) (any, bool, error)) (any, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row, exists, err := getChangedRow(tx, uid, change)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%s %s does not exist", change.Kind, change.Identity())
+	}
+
+	lastChange, err := lastRowChange(tx, uid, change, after)
+	if err != nil {
+		return nil, err
+	}
+
+	edited, changed, err := edit(row, lastChange)
+	if err != nil || !changed {
+		return edited, err
+	}
+
+	if err := saveChangedRow(tx, uid, edited); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if old, ok := row.(db_types.UserViewingEntry); ok {
+		notifyRatingChanged(uid, old.ItemId, old.UserRating, edited.(db_types.UserViewingEntry).UserRating)
+	}
+	return edited, nil
+}
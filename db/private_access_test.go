@@ -0,0 +1,252 @@
+package db
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	db_types "aiolimas/types"
+)
+
+func TestChargeSubscriptions(t *testing.T) {
+	const uid = 5
+	date := func(month time.Month, day int) int64 {
+		return time.Date(2024, month, day, 12, 0, 0, 0, time.UTC).UnixMilli()
+	}
+
+	service := addTestEntry(t, uid, "subscription service")
+	sub := db_types.Subscription{
+		ItemId:   service,
+		Currency: "usd",
+		Amount:   999,
+		Period:   db_types.SP_MONTH,
+		Start:    date(time.January, 31),
+	}
+	if err := CreateSubscription(uid, &sub); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := RequestContext{UID: uid, Auth: uid}
+
+	tests := []struct {
+		name string
+		now  int64
+		want []int64
+	}{
+		{"charges every period up to now", date(time.April, 15), []int64{date(time.January, 31), date(time.February, 29), date(time.March, 31)}},
+		{"charging again does not charge twice", date(time.April, 15), []int64{date(time.January, 31), date(time.February, 29), date(time.March, 31)}},
+		{"the next period is counted from the start", date(time.May, 1), []int64{date(time.January, 31), date(time.February, 29), date(time.March, 31), date(time.April, 30)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := ChargeSubscriptions(uid, "", test.now); err != nil {
+				t.Fatal(err)
+			}
+
+			transactions, err := ListTransactions(ctx, service)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(transactions) != len(test.want) {
+				t.Fatalf("got %d transactions, want %d", len(transactions), len(test.want))
+			}
+
+			charged := map[int64]bool{}
+			for _, transaction := range transactions {
+				event, err := GetEvent(ctx, transaction.EventId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				charged[event.Timestamp] = true
+				if transaction.Subscription != sub.SubscriptionId || transaction.Kind != db_types.TRANSACTION_SUBSCRIPTION {
+					t.Errorf("wrong transaction: %+v", transaction)
+				}
+			}
+			for _, want := range test.want {
+				if !charged[want] {
+					t.Errorf("%s was not charged", time.UnixMilli(want).UTC())
+				}
+			}
+
+			got, err := GetSubscription(ctx, sub.SubscriptionId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.LastCharged != test.want[len(test.want)-1] {
+				t.Errorf("last charged at %s", time.UnixMilli(got.LastCharged).UTC())
+			}
+		})
+	}
+}
+
+func TestChargeSubscriptionsConcurrently(t *testing.T) {
+	const uid = 6
+	service := addTestEntry(t, uid, "concurrent subscription service")
+	sub := db_types.Subscription{
+		ItemId:   service,
+		Currency: "USD",
+		Amount:   500,
+		Period:   db_types.SP_WEEK,
+		Start:    time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC).UnixMilli(),
+	}
+	if err := CreateSubscription(uid, &sub); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ChargeSubscriptions(uid, "", now); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	transactions, err := ListTransactions(RequestContext{UID: uid, Auth: uid}, service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Jan 1 to Feb 26
+	if len(transactions) != 9 {
+		t.Errorf("got %d transactions, want 9", len(transactions))
+	}
+}
+
+// the summaries of the deliveries queued for webhookId, oldest first
+func testDeliverySummaries(t *testing.T, uid int64, webhookId int64) []string {
+	t.Helper()
+	deliveries, err := ListWebhookDeliveries(uid, webhookId, "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := []string{}
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		var payload db_types.WebhookPayload
+		if err := json.Unmarshal([]byte(deliveries[i].Payload), &payload); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, payload.Summary)
+	}
+	return out
+}
+
+func TestStatusWebhooks(t *testing.T) {
+	const uid = 7
+	hook := db_types.Webhook{Url: "http://127.0.0.1:0", Secret: "secret", Events: `["status.*"]`, Enabled: true}
+	if err := CreateWebhook(uid, &hook); err != nil {
+		t.Fatal(err)
+	}
+
+	id := addTestEntry(t, uid, "100% Orange Juice %d")
+	entry, err := GetUserEntry(RequestContext{UID: uid, Auth: uid}, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Begin(uid, "UTC", &entry); err != nil {
+		t.Fatal(err)
+	}
+	if got := testDeliverySummaries(t, uid, hook.WebhookId); len(got) != 0 {
+		t.Fatalf("queued before the entry was saved: %v", got)
+	}
+
+	entry.UserRating = 95
+	if err := Finish(uid, "UTC", &entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateUserViewingEntry(uid, &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"Started 100% Orange Juice %d", "Finished 100% Orange Juice %d (rated 95)"}
+	got := testDeliverySummaries(t, uid, hook.WebhookId)
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// saving again does not send them again
+	if err := UpdateUserViewingEntry(uid, &entry); err != nil {
+		t.Fatal(err)
+	}
+	if got := testDeliverySummaries(t, uid, hook.WebhookId); len(got) != len(want) {
+		t.Errorf("queued again: %q", got)
+	}
+}
+
+func TestTransactionWebhookSummary(t *testing.T) {
+	const uid = 8
+	hook := db_types.Webhook{Url: "http://127.0.0.1:0", Secret: "secret", Events: `["transaction.created"]`, Enabled: true}
+	if err := CreateWebhook(uid, &hook); err != nil {
+		t.Fatal(err)
+	}
+
+	id := addTestEntry(t, uid, "50% off %s")
+	transaction := db_types.TransactionEntry{ItemId: id, Currency: "USD", Amount: 1234, Vendor: "%v"}
+	if err := CreateTransaction(uid, "UTC", &transaction); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"Purchased 50% off %s for 12.34 USD"}
+	if got := testDeliverySummaries(t, uid, hook.WebhookId); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDeleteByUID(t *testing.T) {
+	const uid, other = 48, 49
+
+	for _, u := range []int64{uid, other} {
+		hook := db_types.Webhook{Url: "http://127.0.0.1:0", Secret: "secret", Enabled: true}
+		if err := CreateWebhook(u, &hook); err != nil {
+			t.Fatal(err)
+		}
+		id := addTestEntry(t, u, "deleted with its user")
+		if err := AddMetadataRefresh(u, &db_types.MetadataRefresh{ItemId: id, Provider: "omdb", Error: "offline"}); err != nil {
+			t.Fatal(err)
+		}
+		transaction := db_types.TransactionEntry{ItemId: id, Currency: "USD", Amount: 100}
+		if err := CreateTransaction(u, "UTC", &transaction); err != nil {
+			t.Fatal(err)
+		}
+		if err := Lend(u, &db_types.Loan{ItemId: id, Borrower: "someone"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := DeleteByUID(uid); err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []string{
+		"entryInfo", "metadata", "userViewingInfo", "userEventInfo", "relations",
+		"transactions", "subscriptions", "exchangeRates", "inventory", "loans",
+		"webhooks", "webhookDeliveries", "metadataRefreshes", "changes",
+	}
+	count := func(table string, u int64) int {
+		var n int
+		if err := DB.QueryRow(`SELECT count(*) FROM `+table+` WHERE uid = ?`, u).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	for _, table := range tables {
+		if n := count(table, uid); n != 0 {
+			t.Errorf("%s has %d rows of the deleted user", table, n)
+		}
+	}
+
+	// the other user is left alone
+	for _, table := range []string{"entryInfo", "webhooks", "webhookDeliveries", "metadataRefreshes", "changes", "loans"} {
+		if count(table, other) == 0 {
+			t.Errorf("%s lost the rows of another user", table)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+/* currency codes are ISO 4217, make sure they're normalized */
+UPDATE transactions SET currency = upper(trim(currency));
+UPDATE transactions SET currency = 'USD' WHERE currency IS NULL OR currency = '';
+
+/* prices are now stored as integers in the currency's minor unit, eg: cents */
+ALTER TABLE transactions
+ADD COLUMN amount INTEGER NOT NULL DEFAULT 0;
+
+UPDATE transactions SET
+    amount = CAST(round(price * (CASE
+        WHEN currency IN ('BIF', 'CLP', 'DJF', 'GNF', 'ISK', 'JPY', 'KMF', 'KRW', 'PYG', 'RWF', 'UGX', 'UYI', 'VND', 'VUV', 'XAF', 'XOF', 'XPF') THEN 1
+        WHEN currency IN ('BHD', 'IQD', 'JOD', 'KWD', 'LYD', 'OMR', 'TND') THEN 1000
+        WHEN currency IN ('CLF', 'UYW') THEN 10000
+        ELSE 100
+    END)) AS INTEGER);
+
+ALTER TABLE transactions DROP COLUMN price;
+
+/* 1 fromCurrency == rate toCurrency */
+CREATE TABLE IF NOT EXISTS exchangeRates (
+    uid INTEGER NOT NULL,
+    fromCurrency TEXT NOT NULL,
+    toCurrency TEXT NOT NULL,
+    rate NUMERIC NOT NULL,
+    PRIMARY KEY (uid, fromCurrency, toCurrency)
+);
@@ -0,0 +1,27 @@
+ALTER TABLE transactions ADD COLUMN kind TEXT NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN vendor TEXT NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN orderId TEXT NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN receiptPath TEXT NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN notes TEXT NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN subscription INTEGER NOT NULL DEFAULT 0;
+
+/* the kind used to only be stored as the name of the transaction's event */
+UPDATE transactions SET
+    kind = coalesce((SELECT event FROM userEventInfo WHERE userEventInfo.rowid = transactions.eventId), '');
+
+UPDATE transactions SET
+    kind = CASE WHEN amount < 0 THEN 'Sold' ELSE 'Purchased' END
+WHERE kind NOT IN ('Purchased', 'Sold');
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+    uid INTEGER NOT NULL,
+    itemId INTEGER NOT NULL,
+    currency TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    period TEXT NOT NULL,
+    start INTEGER NOT NULL,
+    until INTEGER NOT NULL DEFAULT 0,
+    lastCharged INTEGER NOT NULL DEFAULT 0,
+    vendor TEXT NOT NULL DEFAULT '',
+    notes TEXT NOT NULL DEFAULT ''
+);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS inventory (
+    uid INTEGER NOT NULL,
+    itemId INTEGER PRIMARY KEY NOT NULL,
+    condition TEXT NOT NULL DEFAULT '',
+    edition TEXT NOT NULL DEFAULT '',
+    storage TEXT NOT NULL DEFAULT '',
+    barcode TEXT NOT NULL DEFAULT '',
+    purchaseDate INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS loans (
+    uid INTEGER NOT NULL,
+    itemId INTEGER NOT NULL,
+    borrower TEXT NOT NULL,
+    lent INTEGER NOT NULL,
+    due INTEGER NOT NULL DEFAULT 0,
+    returned INTEGER NOT NULL DEFAULT 0,
+    notes TEXT NOT NULL DEFAULT ''
+);
@@ -0,0 +1,66 @@
+/* incremented whenever anything that is part of an entry tree changes, used as an ETag */
+CREATE TABLE libraryVersion (
+    version INTEGER NOT NULL
+);
+
+INSERT INTO libraryVersion VALUES (0);
+
+CREATE TRIGGER entryInfo_insert_version AFTER INSERT ON entryInfo BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER entryInfo_update_version AFTER UPDATE ON entryInfo BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER entryInfo_delete_version AFTER DELETE ON entryInfo BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER metadata_insert_version AFTER INSERT ON metadata BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER metadata_update_version AFTER UPDATE ON metadata BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER metadata_delete_version AFTER DELETE ON metadata BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER userViewingInfo_insert_version AFTER INSERT ON userViewingInfo BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER userViewingInfo_update_version AFTER UPDATE ON userViewingInfo BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER userViewingInfo_delete_version AFTER DELETE ON userViewingInfo BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER relations_insert_version AFTER INSERT ON relations BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER relations_update_version AFTER UPDATE ON relations BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER relations_delete_version AFTER DELETE ON relations BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER entrySettings_insert_version AFTER INSERT ON entrySettings BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER entrySettings_update_version AFTER UPDATE ON entrySettings BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER entrySettings_delete_version AFTER DELETE ON entrySettings BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
@@ -0,0 +1,75 @@
+/*
+    aggregates over the descendants of every entry that has any
+    relation 1 is R_Child, the only Descendant relation kind (see db_types.ListDescendantRelations)
+    spent is currency -> the net amount, in minor units, of transactions that move money
+*/
+CREATE VIEW rollups AS
+WITH RECURSIVE descendants(root, itemId) AS (
+    SELECT right, left FROM relations WHERE relation = 1
+    UNION
+    SELECT descendants.root, relations.left FROM relations
+    JOIN descendants ON relations.right = descendants.itemId
+    WHERE relations.relation = 1
+)
+SELECT
+    d.root AS itemId,
+    COUNT(*) AS descendants,
+    COALESCE(SUM(u.minutes), 0) AS minutes,
+    COALESCE(AVG(NULLIF(u.userRating, 0)), 0) AS averageRating,
+    100.0 * SUM(u.status = 'Finished') / COUNT(*) AS percentFinished,
+    (
+        SELECT json_group_object(status, count) FROM (
+            SELECT u2.status, COUNT(*) AS count FROM descendants d2
+            JOIN userViewingInfo u2 ON u2.itemId = d2.itemId
+            WHERE d2.root = d.root AND d2.itemId != d2.root
+            GROUP BY u2.status
+        )
+    ) AS statusCounts,
+    (
+        SELECT json_group_object(currency, total) FROM (
+            SELECT t.currency, SUM(t.amount) AS total FROM descendants d2
+            JOIN transactions t ON t.itemId = d2.itemId
+            WHERE d2.root = d.root AND d2.itemId != d2.root AND t.kind NOT IN ('Gift received', 'Gift given')
+            GROUP BY t.currency
+        )
+    ) AS spent,
+    COALESCE((
+        SELECT MIN(NULLIF(CASE e.timestamp WHEN 0 THEN e.after ELSE e.timestamp END, 0)) FROM descendants d2
+        JOIN userEventInfo e ON e.itemId = d2.itemId
+        WHERE d2.root = d.root AND d2.itemId != d2.root
+    ), 0) AS firstEvent,
+    COALESCE((
+        SELECT MAX(NULLIF(CASE e.timestamp WHEN 0 THEN e.after ELSE e.timestamp END, 0)) FROM descendants d2
+        JOIN userEventInfo e ON e.itemId = d2.itemId
+        WHERE d2.root = d.root AND d2.itemId != d2.root
+    ), 0) AS lastEvent
+FROM descendants d
+JOIN userViewingInfo u ON u.itemId = d.itemId
+WHERE d.itemId != d.root
+GROUP BY d.root;
+
+/* rollups are part of the entry tree, so events and transactions also change it */
+
+CREATE TRIGGER userEventInfo_insert_version AFTER INSERT ON userEventInfo BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER userEventInfo_update_version AFTER UPDATE ON userEventInfo BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER userEventInfo_delete_version AFTER DELETE ON userEventInfo BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER transactions_insert_version AFTER INSERT ON transactions BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER transactions_update_version AFTER UPDATE ON transactions BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
+
+CREATE TRIGGER transactions_delete_version AFTER DELETE ON transactions BEGIN
+    UPDATE libraryVersion SET version = version + 1;
+END;
@@ -0,0 +1,11 @@
+/* every background metadata refresh, changes is JSON {field: {Old, New}} */
+CREATE TABLE IF NOT EXISTS metadataRefreshes (
+    uid INTEGER NOT NULL,
+    itemId INTEGER NOT NULL,
+    timestamp INTEGER NOT NULL,
+    provider TEXT NOT NULL DEFAULT '',
+    changes TEXT NOT NULL DEFAULT '{}',
+    error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS metadataRefreshes_item ON metadataRefreshes (uid, itemId, timestamp);
@@ -0,0 +1,2 @@
+/* a JSON list of the metadata fields that fetching and refreshing must not change */
+ALTER TABLE metadata ADD COLUMN locks TEXT NOT NULL DEFAULT '[]';
@@ -0,0 +1,27 @@
+/* outgoing webhooks, events is a JSON list of the events that are sent, [] sends every event */
+CREATE TABLE IF NOT EXISTS webhooks (
+    uid INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT NOT NULL DEFAULT '[]',
+    enabled INTEGER NOT NULL DEFAULT 1,
+    created INTEGER NOT NULL
+);
+
+/* the queue of events for webhooks, delivered and failed rows are kept as the delivery log */
+CREATE TABLE IF NOT EXISTS webhookDeliveries (
+    uid INTEGER NOT NULL,
+    webhookId INTEGER NOT NULL,
+    event TEXT NOT NULL,
+    itemId INTEGER NOT NULL DEFAULT 0,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    nextAttempt INTEGER NOT NULL,
+    responseCode INTEGER NOT NULL DEFAULT 0,
+    lastError TEXT NOT NULL DEFAULT '',
+    created INTEGER NOT NULL,
+    delivered INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS webhookDeliveries_due ON webhookDeliveries (status, nextAttempt);
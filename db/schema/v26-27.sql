@@ -0,0 +1,104 @@
+/* a log of every change to the library, seq is the change sequence that clients resume from, see /events/stream
+kind is the table that changed (info, metadata, user, event, relation, transaction), op is insert, update, or delete
+rowId is the rowid of the row in its table, itemId is the entry that it belongs to (left for relations) */
+CREATE TABLE IF NOT EXISTS changes (
+    seq INTEGER PRIMARY KEY AUTOINCREMENT,
+    uid INTEGER NOT NULL,
+    kind TEXT NOT NULL,
+    op TEXT NOT NULL,
+    itemId INTEGER NOT NULL,
+    rowId INTEGER NOT NULL,
+    timestamp INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS changes_uid ON changes (uid, seq);
+
+CREATE TRIGGER entryInfo_insert_change AFTER INSERT ON entryInfo BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (NEW.uid, 'info', 'insert', NEW.itemId, NEW.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER entryInfo_update_change AFTER UPDATE ON entryInfo BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (NEW.uid, 'info', 'update', NEW.itemId, NEW.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER entryInfo_delete_change AFTER DELETE ON entryInfo BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (OLD.uid, 'info', 'delete', OLD.itemId, OLD.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER metadata_insert_change AFTER INSERT ON metadata BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (NEW.uid, 'metadata', 'insert', NEW.itemId, NEW.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER metadata_update_change AFTER UPDATE ON metadata BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (NEW.uid, 'metadata', 'update', NEW.itemId, NEW.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER metadata_delete_change AFTER DELETE ON metadata BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (OLD.uid, 'metadata', 'delete', OLD.itemId, OLD.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER userViewingInfo_insert_change AFTER INSERT ON userViewingInfo BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (NEW.uid, 'user', 'insert', NEW.itemId, NEW.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER userViewingInfo_update_change AFTER UPDATE ON userViewingInfo BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (NEW.uid, 'user', 'update', NEW.itemId, NEW.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER userViewingInfo_delete_change AFTER DELETE ON userViewingInfo BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (OLD.uid, 'user', 'delete', OLD.itemId, OLD.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER userEventInfo_insert_change AFTER INSERT ON userEventInfo BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (NEW.uid, 'event', 'insert', NEW.itemId, NEW.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER userEventInfo_update_change AFTER UPDATE ON userEventInfo BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (NEW.uid, 'event', 'update', NEW.itemId, NEW.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER userEventInfo_delete_change AFTER DELETE ON userEventInfo BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (OLD.uid, 'event', 'delete', OLD.itemId, OLD.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER relations_insert_change AFTER INSERT ON relations BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (NEW.uid, 'relation', 'insert', NEW.left, NEW.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER relations_update_change AFTER UPDATE ON relations BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (NEW.uid, 'relation', 'update', NEW.left, NEW.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER relations_delete_change AFTER DELETE ON relations BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (OLD.uid, 'relation', 'delete', OLD.left, OLD.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER transactions_insert_change AFTER INSERT ON transactions BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (NEW.uid, 'transaction', 'insert', NEW.itemId, NEW.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER transactions_update_change AFTER UPDATE ON transactions BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (NEW.uid, 'transaction', 'update', NEW.itemId, NEW.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER transactions_delete_change AFTER DELETE ON transactions BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, timestamp)
+    VALUES (OLD.uid, 'transaction', 'delete', OLD.itemId, OLD.rowid, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
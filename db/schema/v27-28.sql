@@ -0,0 +1,117 @@
+/* key identifies the rows that have neither an entry nor a stable rowid: "left,relation,right" for relations, and "FROM,TO" for exchange rates */
+ALTER TABLE changes ADD COLUMN key TEXT NOT NULL DEFAULT '';
+
+DROP TRIGGER IF EXISTS relations_insert_change;
+DROP TRIGGER IF EXISTS relations_update_change;
+DROP TRIGGER IF EXISTS relations_delete_change;
+
+CREATE TRIGGER relations_insert_change AFTER INSERT ON relations BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    VALUES (NEW.uid, 'relation', 'insert', NEW.left, NEW.rowid, NEW.left || ',' || NEW.relation || ',' || NEW.right, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER relations_update_change AFTER UPDATE ON relations BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    VALUES (NEW.uid, 'relation', 'update', NEW.left, NEW.rowid, NEW.left || ',' || NEW.relation || ',' || NEW.right, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER relations_delete_change AFTER DELETE ON relations BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    VALUES (OLD.uid, 'relation', 'delete', OLD.left, OLD.rowid, OLD.left || ',' || OLD.relation || ',' || OLD.right, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER subscriptions_insert_change AFTER INSERT ON subscriptions BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    VALUES (NEW.uid, 'subscription', 'insert', NEW.itemId, NEW.rowid, '', CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER subscriptions_update_change AFTER UPDATE ON subscriptions BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    VALUES (NEW.uid, 'subscription', 'update', NEW.itemId, NEW.rowid, '', CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER subscriptions_delete_change AFTER DELETE ON subscriptions BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    VALUES (OLD.uid, 'subscription', 'delete', OLD.itemId, OLD.rowid, '', CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER inventory_insert_change AFTER INSERT ON inventory BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    VALUES (NEW.uid, 'inventory', 'insert', NEW.itemId, NEW.rowid, '', CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER inventory_update_change AFTER UPDATE ON inventory BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    VALUES (NEW.uid, 'inventory', 'update', NEW.itemId, NEW.rowid, '', CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER inventory_delete_change AFTER DELETE ON inventory BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    VALUES (OLD.uid, 'inventory', 'delete', OLD.itemId, OLD.rowid, '', CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER loans_insert_change AFTER INSERT ON loans BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    VALUES (NEW.uid, 'loan', 'insert', NEW.itemId, NEW.rowid, '', CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER loans_update_change AFTER UPDATE ON loans BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    VALUES (NEW.uid, 'loan', 'update', NEW.itemId, NEW.rowid, '', CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER loans_delete_change AFTER DELETE ON loans BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    VALUES (OLD.uid, 'loan', 'delete', OLD.itemId, OLD.rowid, '', CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER exchangeRates_insert_change AFTER INSERT ON exchangeRates BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    VALUES (NEW.uid, 'exchangeRate', 'insert', 0, NEW.rowid, NEW.fromCurrency || ',' || NEW.toCurrency, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER exchangeRates_update_change AFTER UPDATE ON exchangeRates BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    VALUES (NEW.uid, 'exchangeRate', 'update', 0, NEW.rowid, NEW.fromCurrency || ',' || NEW.toCurrency, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+CREATE TRIGGER exchangeRates_delete_change AFTER DELETE ON exchangeRates BEGIN
+    INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    VALUES (OLD.uid, 'exchangeRate', 'delete', 0, OLD.rowid, OLD.fromCurrency || ',' || OLD.toCurrency, CAST(unixepoch('subsec') * 1000 AS INTEGER));
+END;
+
+/* changes logged for relations before key existed, if the relation was deleted since, what it was is not known, and it cannot be synced */
+DELETE FROM changes WHERE kind = 'relation' AND key = '' AND rowId NOT IN (SELECT rowid FROM relations);
+UPDATE changes SET key = (SELECT relations.left || ',' || relations.relation || ',' || relations.right FROM relations WHERE relations.rowid = changes.rowId)
+    WHERE kind = 'relation' AND key = '';
+
+/*
+    rows from before the log started, so that syncing from 0 gets everything
+    the tables that were already logged only need the rows from before v27, the others need every row
+*/
+INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    SELECT uid, 'info', 'insert', itemId, rowid, '', CAST(unixepoch('subsec') * 1000 AS INTEGER) FROM entryInfo
+    WHERE NOT EXISTS (SELECT 1 FROM changes WHERE changes.kind = 'info' AND changes.itemId = entryInfo.itemId);
+INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    SELECT uid, 'metadata', 'insert', itemId, rowid, '', CAST(unixepoch('subsec') * 1000 AS INTEGER) FROM metadata
+    WHERE NOT EXISTS (SELECT 1 FROM changes WHERE changes.kind = 'metadata' AND changes.itemId = metadata.itemId);
+INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    SELECT uid, 'user', 'insert', itemId, rowid, '', CAST(unixepoch('subsec') * 1000 AS INTEGER) FROM userViewingInfo
+    WHERE NOT EXISTS (SELECT 1 FROM changes WHERE changes.kind = 'user' AND changes.itemId = userViewingInfo.itemId);
+INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    SELECT uid, 'event', 'insert', itemId, rowid, '', CAST(unixepoch('subsec') * 1000 AS INTEGER) FROM userEventInfo
+    WHERE NOT EXISTS (SELECT 1 FROM changes WHERE changes.kind = 'event' AND changes.rowId = userEventInfo.rowid);
+INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    SELECT uid, 'transaction', 'insert', itemId, rowid, '', CAST(unixepoch('subsec') * 1000 AS INTEGER) FROM transactions
+    WHERE NOT EXISTS (SELECT 1 FROM changes WHERE changes.kind = 'transaction' AND changes.rowId = transactions.rowid);
+INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    SELECT uid, 'relation', 'insert', relations.left, rowid, relations.left || ',' || relations.relation || ',' || relations.right, CAST(unixepoch('subsec') * 1000 AS INTEGER) FROM relations
+    WHERE NOT EXISTS (SELECT 1 FROM changes WHERE changes.kind = 'relation' AND changes.rowId = relations.rowid);
+INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    SELECT uid, 'subscription', 'insert', subscriptions.itemId, rowid, '', CAST(unixepoch('subsec') * 1000 AS INTEGER) FROM subscriptions;
+INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    SELECT uid, 'inventory', 'insert', inventory.itemId, rowid, '', CAST(unixepoch('subsec') * 1000 AS INTEGER) FROM inventory;
+INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    SELECT uid, 'loan', 'insert', loans.itemId, rowid, '', CAST(unixepoch('subsec') * 1000 AS INTEGER) FROM loans;
+INSERT INTO changes (uid, kind, op, itemId, rowId, key, timestamp)
+    SELECT uid, 'exchangeRate', 'insert', 0, rowid, exchangeRates.fromCurrency || ',' || exchangeRates.toCurrency, CAST(unixepoch('subsec') * 1000 AS INTEGER) FROM exchangeRates;
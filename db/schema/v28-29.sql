@@ -0,0 +1,3 @@
+/* the entry tree walks relations from parents to children, and looks up copies from both sides */
+CREATE INDEX IF NOT EXISTS relations_right ON relations (right, relation);
+CREATE INDEX IF NOT EXISTS relations_left ON relations (left, relation);
@@ -0,0 +1,5 @@
+/*
+    rollups are built by db.rollupsQuery, which only walks the descendants of the entries that are asked for
+    the view walked every relation in the library whenever it was read
+*/
+DROP VIEW IF EXISTS rollups;
@@ -0,0 +1,4 @@
+/* LastRowChange looks up the latest change to one row after a seq, rows are identified by one of these columns depending on their kind, see ChangeIdentityColumn */
+CREATE INDEX IF NOT EXISTS changes_itemId ON changes (uid, kind, itemId, seq);
+CREATE INDEX IF NOT EXISTS changes_rowId ON changes (uid, kind, rowId, seq);
+CREATE INDEX IF NOT EXISTS changes_key ON changes (uid, kind, key, seq);
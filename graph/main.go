@@ -0,0 +1,237 @@
+package graph
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"aiolimas/db"
+	db_types "aiolimas/types"
+)
+
+type Format string
+
+const (
+	F_JSON    Format = "json"
+	F_DOT     Format = "dot"
+	F_GRAPHML Format = "graphml"
+)
+
+func ListFormats() []Format {
+	return []Format{F_JSON, F_DOT, F_GRAPHML}
+}
+
+func (self Format) ContentType() string {
+	switch self {
+	case F_DOT:
+		return "text/vnd.graphviz"
+	case F_GRAPHML:
+		return "application/graphml+xml"
+	}
+	return "application/json"
+}
+
+type Node struct {
+	ItemId    int64
+	Title     string
+	Type      db_types.MediaTypes
+	Status    db_types.Status
+	Rating    float64
+	Thumbnail string `json:",omitempty"`
+}
+
+// Source is Relation Target, eg: Source is a child-of Target
+type Edge struct {
+	Source    int64
+	Target    int64
+	Relation  string
+	Symmetric bool
+}
+
+type Graph struct {
+	Nodes []Node
+	Edges []Edge
+}
+
+// if search is "", every entry is included, otherwise it is a query-v3 search
+// only relations where both sides are in the graph are included
+func Build(ctx db.RequestContext, search string, thumbnails bool) (Graph, error) {
+	out := Graph{
+		Nodes: []Node{},
+		Edges: []Edge{},
+	}
+
+	var entries []db_types.InfoEntry
+	var err error
+	if search == "" {
+		entries, err = db.ListEntries(ctx, "entryInfo.itemid")
+	} else {
+		entries, err = db.Search3(ctx, search, "")
+	}
+	if err != nil {
+		return out, err
+	}
+
+	users, err := db.AllUserEntries(ctx)
+	if err != nil {
+		return out, err
+	}
+	statuses := map[int64]db_types.UserViewingEntry{}
+	for _, user := range users {
+		statuses[user.ItemId] = user
+	}
+
+	thumbs := map[int64]string{}
+	if thumbnails {
+		metas, err := db.ListMetadata(ctx)
+		if err != nil {
+			return out, err
+		}
+		for _, meta := range metas {
+			thumbs[meta.ItemId] = meta.Thumbnail
+		}
+	}
+
+	ids := map[int64]bool{}
+	for _, entry := range entries {
+		ids[entry.ItemId] = true
+		out.Nodes = append(out.Nodes, Node{
+			ItemId:    entry.ItemId,
+			Title:     entry.En_Title,
+			Type:      entry.Type,
+			Status:    statuses[entry.ItemId].Status,
+			Rating:    statuses[entry.ItemId].UserRating,
+			Thumbnail: thumbs[entry.ItemId],
+		})
+	}
+
+	rows, err := db.ListRelationRows(ctx.UID)
+	if err != nil {
+		return out, err
+	}
+
+	for _, row := range rows {
+		if !ids[row.Left] || !ids[row.Right] {
+			continue
+		}
+
+		kind, ok := db_types.GetRelationKind(row.Relation)
+		if !ok {
+			continue
+		}
+
+		out.Edges = append(out.Edges, Edge{
+			Source:    row.Left,
+			Target:    row.Right,
+			Relation:  kind.Name,
+			Symmetric: kind.Symmetric,
+		})
+	}
+
+	slices.SortFunc(out.Nodes, func(a Node, b Node) int {
+		return int(a.ItemId - b.ItemId)
+	})
+
+	return out, nil
+}
+
+func (self Graph) Write(w io.Writer, format Format) error {
+	switch format {
+	case F_DOT:
+		return self.WriteDOT(w)
+	case F_GRAPHML:
+		return self.WriteGraphML(w)
+	case F_JSON:
+		return json.NewEncoder(w).Encode(self)
+	}
+	return fmt.Errorf("invalid graph format: '%s'", format)
+}
+
+func dotQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
+
+func (self Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("digraph entries {\n")
+	for _, node := range self.Nodes {
+		fmt.Fprintf(&b, "\t%d [label=%s, type=%s, status=%s, rating=%g",
+			node.ItemId,
+			dotQuote(node.Title),
+			dotQuote(string(node.Type)),
+			dotQuote(string(node.Status)),
+			node.Rating,
+		)
+		if node.Thumbnail != "" {
+			fmt.Fprintf(&b, ", thumbnail=%s", dotQuote(node.Thumbnail))
+		}
+		b.WriteString("];\n")
+	}
+
+	for _, edge := range self.Edges {
+		fmt.Fprintf(&b, "\t%d -> %d [label=%s", edge.Source, edge.Target, dotQuote(edge.Relation))
+		if edge.Symmetric {
+			b.WriteString(", dir=none")
+		}
+		b.WriteString("];\n")
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (self Graph) WriteGraphML(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString(xml.Header)
+	b.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	for _, key := range [][3]string{
+		{"title", "node", "string"},
+		{"type", "node", "string"},
+		{"status", "node", "string"},
+		{"rating", "node", "double"},
+		{"thumbnail", "node", "string"},
+		{"relation", "edge", "string"},
+	} {
+		fmt.Fprintf(&b, "\t<key id=\"%[1]s\" for=\"%[2]s\" attr.name=\"%[1]s\" attr.type=\"%[3]s\"/>\n", key[0], key[1], key[2])
+	}
+
+	b.WriteString("\t<graph id=\"entries\" edgedefault=\"directed\">\n")
+	for _, node := range self.Nodes {
+		fmt.Fprintf(&b, "\t\t<node id=\"n%d\">\n", node.ItemId)
+		fmt.Fprintf(&b, "\t\t\t<data key=\"title\">%s</data>\n", xmlEscape(node.Title))
+		fmt.Fprintf(&b, "\t\t\t<data key=\"type\">%s</data>\n", xmlEscape(string(node.Type)))
+		fmt.Fprintf(&b, "\t\t\t<data key=\"status\">%s</data>\n", xmlEscape(string(node.Status)))
+		fmt.Fprintf(&b, "\t\t\t<data key=\"rating\">%g</data>\n", node.Rating)
+		if node.Thumbnail != "" {
+			fmt.Fprintf(&b, "\t\t\t<data key=\"thumbnail\">%s</data>\n", xmlEscape(node.Thumbnail))
+		}
+		b.WriteString("\t\t</node>\n")
+	}
+
+	for _, edge := range self.Edges {
+		directed := ""
+		if edge.Symmetric {
+			directed = ` directed="false"`
+		}
+		fmt.Fprintf(&b, "\t\t<edge source=\"n%d\" target=\"n%d\"%s>\n", edge.Source, edge.Target, directed)
+		fmt.Fprintf(&b, "\t\t\t<data key=\"relation\">%s</data>\n", xmlEscape(edge.Relation))
+		b.WriteString("\t\t</edge>\n")
+	}
+	b.WriteString("\t</graph>\n</graphml>\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,495 @@
+package ledger
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"aiolimas/db"
+	"aiolimas/settings"
+	db_types "aiolimas/types"
+)
+
+// from -> to -> rate
+type Rates map[string]map[string]float64
+
+func LoadRates(uid int64) (Rates, error) {
+	out := Rates{}
+
+	rates, err := db.ListExchangeRates(uid)
+	if err != nil {
+		return out, err
+	}
+
+	for _, rate := range rates {
+		out.Set(rate.From, rate.To, rate.Rate)
+	}
+
+	return out, nil
+}
+
+func (self Rates) Set(from string, to string, rate float64) {
+	if _, ok := self[from]; !ok {
+		self[from] = map[string]float64{}
+	}
+	self[from][to] = rate
+}
+
+// finds the rate to convert from -> to
+// it will try a direct rate, the inverse of to -> from,
+// and lastly any 1 intermediate currency
+func (self Rates) Rate(from string, to string) (float64, bool) {
+	direct := func(from string, to string) (float64, bool) {
+		if from == to {
+			return 1, true
+		}
+		if r, ok := self[from][to]; ok && r != 0 {
+			return r, true
+		}
+		if r, ok := self[to][from]; ok && r != 0 {
+			return 1 / r, true
+		}
+		return 0, false
+	}
+
+	if r, ok := direct(from, to); ok {
+		return r, true
+	}
+
+	intermediates := []string{}
+	for c, tos := range self {
+		intermediates = append(intermediates, c)
+		for c2 := range tos {
+			intermediates = append(intermediates, c2)
+		}
+	}
+	// when several intermediates work, they give slightly different rates,
+	// so they are tried in order, otherwise the same amount could convert differently each time
+	slices.Sort(intermediates)
+	intermediates = slices.Compact(intermediates)
+
+	for _, mid := range intermediates {
+		r1, ok := direct(from, mid)
+		if !ok {
+			continue
+		}
+		r2, ok := direct(mid, to)
+		if !ok {
+			continue
+		}
+		return r1 * r2, true
+	}
+
+	return 0, false
+}
+
+// amount is in the minor unit of from, the result is in the minor unit of to
+func (self Rates) Convert(amount int64, from string, to string) (int64, bool) {
+	if from == to {
+		return amount, true
+	}
+
+	rate, ok := self.Rate(from, to)
+	if !ok {
+		return 0, false
+	}
+
+	major := db_types.MinorToMajor(amount, from) * rate
+	return int64(math.Round(major * math.Pow10(db_types.CurrencyMinorUnits(to)))), true
+}
+
+// all amounts are in the minor unit of Currency
+type Total struct {
+	Currency string
+	Spent    int64
+	Earned   int64
+	Net      int64 // Spent - Earned
+
+	// the value of gifts, these are not part of Spent or Earned
+	GiftsReceived int64
+	GiftsGiven    int64
+
+	// the share of Subscription transactions for items watched on that service
+	// this is not part of Spent, as the subscription is already counted for the service itself
+	Amortized int64
+
+	// currency -> amount, for transactions that have no exchange rate to Currency
+	Unconverted map[string]int64
+}
+
+func NewTotal(currency string) Total {
+	return Total{
+		Currency:    currency,
+		Unconverted: map[string]int64{},
+	}
+}
+
+func (self *Total) Add(rates Rates, t db_types.TransactionEntry) {
+	amount, ok := rates.Convert(t.Amount, t.Currency, self.Currency)
+	if !ok {
+		self.Unconverted[t.Currency] += t.Amount
+		return
+	}
+
+	switch t.Kind {
+	case db_types.TRANSACTION_GIFT_RECEIVED:
+		self.GiftsReceived += amount
+		return
+	case db_types.TRANSACTION_GIFT_GIVEN:
+		self.GiftsGiven += amount
+		return
+	}
+
+	if amount > 0 {
+		self.Spent += amount
+	} else {
+		self.Earned += -amount
+	}
+	self.Net = self.Spent - self.Earned
+}
+
+type Ledger struct {
+	Currency string
+	Rates    Rates
+
+	ctx           db.RequestContext
+	transactions  []db_types.TransactionEntry
+	infos         map[int64]db_types.InfoEntry
+	events        []db_types.UserViewingEvent
+	eventTimes    map[int64]int64
+	subscriptions map[int64]db_types.Subscription
+
+	// Amortized() walks every event for every subscription transaction, so it is only done once
+	amortized   map[int64]int64
+	unallocated Total
+}
+
+// if currency is "", the user's PreferredCurrency is used, falling back to USD
+func NewLedger(ctx db.RequestContext, currency string) (Ledger, error) {
+	out := Ledger{
+		ctx:           ctx,
+		infos:         map[int64]db_types.InfoEntry{},
+		eventTimes:    map[int64]int64{},
+		subscriptions: map[int64]db_types.Subscription{},
+	}
+
+	if currency == "" {
+		us, err := settings.GetUserSettings(ctx.UID)
+		if err != nil {
+			return out, err
+		}
+		currency = us.PreferredCurrency
+	}
+	if currency == "" {
+		currency = "USD"
+	}
+	out.Currency = strings.ToUpper(currency)
+
+	rates, err := LoadRates(ctx.UID)
+	if err != nil {
+		return out, err
+	}
+	out.Rates = rates
+
+	transactions, err := db.ListTransactions(ctx, 0)
+	if err != nil {
+		return out, err
+	}
+	out.transactions = transactions
+
+	infos, err := db.ListEntries(ctx, "entryInfo.itemid")
+	if err != nil {
+		return out, err
+	}
+	for _, info := range infos {
+		out.infos[info.ItemId] = info
+	}
+
+	events, err := db.GetEvents(ctx, -1)
+	if err != nil {
+		return out, err
+	}
+	out.events = events
+	for _, ev := range events {
+		out.eventTimes[ev.EventId] = eventTime(ev)
+	}
+
+	subs, err := db.ListSubscriptions(ctx)
+	if err != nil {
+		return out, err
+	}
+	for _, sub := range subs {
+		out.subscriptions[sub.SubscriptionId] = sub
+	}
+
+	return out, nil
+}
+
+func eventTime(ev db_types.UserViewingEvent) int64 {
+	if ev.Timestamp == 0 {
+		return ev.After
+	}
+	return ev.Timestamp
+}
+
+func (self *Ledger) total(filter func(t db_types.TransactionEntry) bool) Total {
+	out := NewTotal(self.Currency)
+	for _, t := range self.transactions {
+		if filter(t) {
+			out.Add(self.Rates, t)
+		}
+	}
+	return out
+}
+
+// the total for an item, and all of its descendants
+func (self *Ledger) ItemTotal(id int64) (Total, error) {
+	descendants, err := db.GetDescendants(self.ctx, id)
+	if err != nil {
+		return NewTotal(self.Currency), err
+	}
+
+	ids := []int64{id}
+	for _, d := range descendants {
+		ids = append(ids, d.ItemId)
+	}
+
+	out := self.total(func(t db_types.TransactionEntry) bool {
+		return slices.Contains(ids, t.ItemId)
+	})
+
+	amortized, _ := self.Amortized()
+	for _, id := range ids {
+		out.Amortized += amortized[id]
+	}
+
+	return out, nil
+}
+
+type EntryTotal struct {
+	ItemId int64
+	Title  string
+	Total  Total
+}
+
+// totals for every Collection type entry (including their descendants)
+func (self *Ledger) CollectionTotals() ([]EntryTotal, error) {
+	out := []EntryTotal{}
+	for id, info := range self.infos {
+		if info.Type != db_types.TY_COLLECTION {
+			continue
+		}
+
+		total, err := self.ItemTotal(id)
+		if err != nil {
+			return out, err
+		}
+
+		out = append(out, EntryTotal{
+			ItemId: id,
+			Title:  info.En_Title,
+			Total:  total,
+		})
+	}
+
+	slices.SortFunc(out, func(a EntryTotal, b EntryTotal) int {
+		return int(a.ItemId - b.ItemId)
+	})
+
+	return out, nil
+}
+
+// format name -> total
+func (self *Ledger) FormatTotals() map[string]Total {
+	out := map[string]Total{}
+	formats := db_types.ListFormats()
+
+	for _, t := range self.transactions {
+		info, ok := self.infos[t.ItemId]
+		if !ok {
+			continue
+		}
+
+		name := formats[info.Format]
+		total, ok := out[name]
+		if !ok {
+			total = NewTotal(self.Currency)
+		}
+		total.Add(self.Rates, t)
+		out[name] = total
+	}
+
+	return out
+}
+
+// period can be "year", "month", or "day"
+// transactions without a known time are put under "unknown"
+func (self *Ledger) PeriodTotals(period string) map[string]Total {
+	out := map[string]Total{}
+
+	layout := "2006"
+	switch period {
+	case "month":
+		layout = "2006-01"
+	case "day":
+		layout = time.DateOnly
+	}
+
+	for _, t := range self.transactions {
+		name := "unknown"
+		if ts, ok := self.eventTimes[t.EventId]; ok && ts != 0 {
+			name = time.UnixMilli(ts).UTC().Format(layout)
+		}
+
+		total, ok := out[name]
+		if !ok {
+			total = NewTotal(self.Currency)
+		}
+		total.Add(self.Rates, t)
+		out[name] = total
+	}
+
+	return out
+}
+
+type ProfitEntry struct {
+	ItemId   int64
+	Title    string
+	Currency string
+	Cost     int64
+	Proceeds int64
+	Profit   int64 // Proceeds - Cost
+
+	Unconverted map[string]int64
+}
+
+// profit/loss of every item that has been sold
+func (self *Ledger) Profits() []ProfitEntry {
+	out := []ProfitEntry{}
+
+	sold := []int64{}
+	for _, t := range self.transactions {
+		if t.Kind == db_types.TRANSACTION_SELL && !slices.Contains(sold, t.ItemId) {
+			sold = append(sold, t.ItemId)
+		}
+	}
+
+	for _, id := range sold {
+		total := self.total(func(t db_types.TransactionEntry) bool {
+			return t.ItemId == id
+		})
+
+		out = append(out, ProfitEntry{
+			ItemId:      id,
+			Title:       self.infos[id].En_Title,
+			Currency:    self.Currency,
+			Cost:        total.Spent,
+			Proceeds:    total.Earned,
+			Profit:      total.Earned - total.Spent,
+			Unconverted: total.Unconverted,
+		})
+	}
+
+	return out
+}
+
+// spreads the cost of each Subscription transaction evenly across the items
+// in that service's library that had events during the period the transaction paid for
+//
+// returns itemId -> amount, and the total that could not be spread
+// because nothing was watched in that period
+func (self *Ledger) Amortized() (map[int64]int64, Total) {
+	if self.amortized == nil {
+		self.amortized, self.unallocated = self.amortize()
+	}
+	return self.amortized, self.unallocated
+}
+
+func (self *Ledger) amortize() (map[int64]int64, Total) {
+	out := map[int64]int64{}
+	unallocated := NewTotal(self.Currency)
+
+	for _, t := range self.transactions {
+		if t.Kind != db_types.TRANSACTION_SUBSCRIPTION {
+			continue
+		}
+
+		start, ok := self.eventTimes[t.EventId]
+		if !ok || start == 0 {
+			unallocated.Add(self.Rates, t)
+			continue
+		}
+
+		period := db_types.SP_MONTH
+		if sub, ok := self.subscriptions[t.Subscription]; ok {
+			period = sub.Period
+		}
+		end := period.Next(start)
+
+		watched := []int64{}
+		for _, ev := range self.events {
+			if db_types.IsValidTransactionKind(ev.Event) || slices.Contains(watched, ev.ItemId) {
+				continue
+			}
+
+			ts := eventTime(ev)
+			if ts < start || ts >= end {
+				continue
+			}
+
+			if info, ok := self.infos[ev.ItemId]; ok && info.Library == t.ItemId {
+				watched = append(watched, ev.ItemId)
+			}
+		}
+
+		amount, ok := self.Rates.Convert(t.Amount, t.Currency, self.Currency)
+		if !ok || len(watched) == 0 {
+			unallocated.Add(self.Rates, t)
+			continue
+		}
+
+		share := amount / int64(len(watched))
+		remainder := amount - share*int64(len(watched))
+		for i, id := range watched {
+			out[id] += share
+			if i == 0 {
+				out[id] += remainder
+			}
+		}
+	}
+
+	return out, unallocated
+}
+
+type AmortizedTotals struct {
+	Currency    string
+	Items       []EntryTotal
+	Unallocated Total
+}
+
+func (self *Ledger) AmortizedTotals() AmortizedTotals {
+	amortized, unallocated := self.Amortized()
+
+	out := AmortizedTotals{
+		Currency:    self.Currency,
+		Items:       []EntryTotal{},
+		Unallocated: unallocated,
+	}
+
+	for id, amount := range amortized {
+		total := NewTotal(self.Currency)
+		total.Amortized = amount
+		out.Items = append(out.Items, EntryTotal{
+			ItemId: id,
+			Title:  self.infos[id].En_Title,
+			Total:  total,
+		})
+	}
+
+	slices.SortFunc(out.Items, func(a EntryTotal, b EntryTotal) int {
+		return int(a.ItemId - b.ItemId)
+	})
+
+	return out
+}
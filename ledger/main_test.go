@@ -0,0 +1,68 @@
+package ledger
+
+import (
+	"testing"
+)
+
+func TestRate(t *testing.T) {
+	rates := Rates{}
+	rates.Set("USD", "EUR", 0.9)
+	rates.Set("USD", "JPY", 150)
+	// slightly inconsistent with the rates through USD, so the intermediate that is used matters
+	rates.Set("EUR", "JPY", 160)
+	rates.Set("GBP", "USD", 1.25)
+	rates.Set("GBP", "EUR", 1.2)
+
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want float64
+		ok   bool
+	}{
+		{"same currency", "USD", "USD", 1, true},
+		{"direct", "USD", "EUR", 0.9, true},
+		{"inverse", "JPY", "USD", 1.0 / 150, true},
+		// EUR sorts before USD, so it is always the intermediate
+		{"intermediate", "GBP", "JPY", 1.2 * 160, true},
+		{"no rate", "USD", "CAD", 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// map iteration order is random, so the same rate has to come out every time
+			for range 50 {
+				got, ok := rates.Rate(test.from, test.to)
+				if ok != test.ok || got != test.want {
+					t.Fatalf("got %v %v, want %v %v", got, ok, test.want, test.ok)
+				}
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	rates := Rates{}
+	rates.Set("USD", "JPY", 150)
+
+	tests := []struct {
+		name   string
+		amount int64
+		from   string
+		to     string
+		want   int64
+	}{
+		{"to a currency without minor units", 1234, "USD", "JPY", 1851},
+		{"from a currency without minor units", 150, "JPY", "USD", 100},
+		{"same currency", 1234, "USD", "USD", 1234},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := rates.Convert(test.amount, test.from, test.to)
+			if !ok || got != test.want {
+				t.Errorf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
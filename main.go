@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	"aiolimas/accounts"
 	api "aiolimas/api"
@@ -49,6 +50,11 @@ func startServer() {
 
 	api.MakeEndPointsFromList("/account", api.AccountEndPoints)
 
+	go api.ChargeSubscriptionsEvery(time.Hour)
+	go api.RefreshMetadataEvery(time.Hour)
+	go api.DeliverWebhooksEvery(5 * time.Second)
+	go api.CompactChangesEvery(24 * time.Hour)
+
 	http.HandleFunc("/docs", api.MainDocs.Listener)
 
 	http.HandleFunc("/html/", dynamic.HtmlEndpoint)
@@ -98,7 +104,51 @@ func main() {
 
 	accounts.InitAccountsDb(aioPath)
 
+	validateRelations := flag.Bool("validate-relations", false, "report relations that are orphaned, reference themselves, are duplicated, or form cycles, then exit")
+	fixRelations := flag.Bool("fix-relations", false, "same as -validate-relations, but also delete the orphaned, self referencing and duplicate relations")
+
 	flag.Parse()
 
+	if *validateRelations || *fixRelations {
+		if err := checkRelations(aioPath, *fixRelations); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	startServer()
 }
+
+func checkRelations(aioPath string, fix bool) error {
+	users, err := accounts.ListUsers(aioPath)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		report, err := db.ValidateRelations(user.Id, fix)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s (%d)\n", user.Username, user.Id)
+		for _, r := range report.Orphans {
+			fmt.Printf("\torphan: %d %d %d\n", r.Left, r.Relation, r.Right)
+		}
+		for _, r := range report.SelfReferences {
+			fmt.Printf("\tself reference: %d %d %d\n", r.Left, r.Relation, r.Right)
+		}
+		for _, r := range report.Duplicates {
+			fmt.Printf("\tduplicate: %d %d %d\n", r.Left, r.Relation, r.Right)
+		}
+		for _, cycle := range report.Cycles {
+			fmt.Printf("\tcycle: %v\n", cycle)
+		}
+		if report.Fixed {
+			fmt.Println("\tdeleted the orphans, self references, and duplicates")
+		}
+	}
+
+	return nil
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"strconv"
 
 	"aiolimas/settings"
@@ -108,7 +107,7 @@ func mkAnilistRequest[T any, TOut any](anilistQuery AnilistQuery[T]) (TOut, erro
 		return out, err
 	}
 	bodyReader := bytes.NewReader(bodyBytes)
-	res, err := http.Post("https://graphql.anilist.co", "application/json", bodyReader)
+	res, err := providerClient("anilist").Post("https://graphql.anilist.co", "application/json", bodyReader)
 	if err != nil {
 		return out, err
 	}
@@ -396,3 +395,37 @@ func AnilistById(id string, us settings.SettingsData) (db_types.MetadataEntry, e
 
 	return outMeta, nil
 }
+
+func init() {
+	RegisterProvider(&BasicProvider{
+		ProviderName: "anilist",
+		Types:        []db_types.MediaTypes{db_types.TY_MANGA, db_types.TY_SHOW, db_types.TY_MOVIE, db_types.TY_MOVIE_SHORT},
+		Thumbnails:   true,
+		FetchFn:      AnlistProvider,
+		SearchFn:     AnilistIdentifier,
+		// anilist id
+		ByIdFn: AnilistById,
+	})
+	RegisterProvider(&BasicProvider{
+		ProviderName: "anilist-manga",
+		Types:        []db_types.MediaTypes{db_types.TY_MANGA},
+		Thumbnails:   true,
+		FetchFn:      AnilistManga,
+		RankFn: func(entry *db_types.InfoEntry) int {
+			return 1
+		},
+	})
+	RegisterProvider(&BasicProvider{
+		ProviderName: "anilist-show",
+		Types:        []db_types.MediaTypes{db_types.TY_SHOW, db_types.TY_MOVIE, db_types.TY_MOVIE_SHORT},
+		Thumbnails:   true,
+		FetchFn:      AnilistShow,
+		// before omdb, but only for anime
+		RankFn: func(entry *db_types.InfoEntry) int {
+			if entry.IsAnime() {
+				return 2
+			}
+			return 0
+		},
+	})
+}
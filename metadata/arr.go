@@ -15,8 +15,8 @@ import (
 	for now that is sonarr, and radarr
 */
 
-func _request(fullUrl string, key string) ([]byte, error) {
-	client := http.Client {}
+func _request(provider string, fullUrl string, key string) ([]byte, error) {
+	client := providerClient(provider)
 
 	req, err := http.NewRequest("GET", fullUrl, nil)
 	if err != nil {
@@ -46,10 +46,17 @@ const (
 	ARR_Movie ARRMediaType = "movie"
 )
 
+func arrProvider(ty ARRMediaType) string {
+	if ty == ARR_Series {
+		return "sonarr"
+	}
+	return "radarr"
+}
+
 func LookupPathById(id float64, apiPath string, key string, ty ARRMediaType) (string, error) {
 	fullUrl := apiPath + "api/v3/" + ty
 
-	text, err := _request(fullUrl, key)
+	text, err := _request(arrProvider(ty), fullUrl, key)
 	if err != nil {
 		logging.ELog(err)
 		return "", err
@@ -71,12 +78,12 @@ func LookupPathById(id float64, apiPath string, key string, ty ARRMediaType) (st
 	return "", errors.New("could not find item")
 }
 
-func Lookup(query string, apiPath string, key string) ([]map[string]interface{}, error){
+func Lookup(ty ARRMediaType, query string, apiPath string, key string) ([]map[string]interface{}, error){
 	fullUrl := apiPath + "?term=" + url.QueryEscape(query)
 
 	var all []map[string]interface{}
 
-	text, err := _request(fullUrl, key)
+	text, err := _request(arrProvider(ty), fullUrl, key)
 	if err != nil {
 		logging.ELog(err)
 		return nil, err
@@ -0,0 +1,662 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// the tags that are read from audio files
+type audioTags struct {
+	Title    string
+	Artist   string
+	Album    string
+	Genre    string
+	Date     string
+	Track    int64
+	Duration time.Duration
+	Cover    []byte
+}
+
+var audioExtensions = []string{".mp3", ".flac", ".ogg", ".oga", ".opus"}
+
+func isAudioFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range audioExtensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func readAudioTags(path string) (audioTags, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return audioTags{}, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return audioTags{}, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return audioTags{}, err
+	}
+
+	switch {
+	case string(magic) == "fLaC":
+		return readFLAC(file)
+	case string(magic) == "OggS":
+		return readOgg(file)
+	case string(magic[:3]) == "ID3" || strings.ToLower(filepath.Ext(path)) == ".mp3":
+		return readMP3(file)
+	}
+	return audioTags{}, fmt.Errorf("%s is not a supported audio file", filepath.Base(path))
+}
+
+// "3/12" -> 3
+func parseTrack(text string) int64 {
+	before, _, _ := strings.Cut(strings.TrimSpace(text), "/")
+	n, _ := strconv.ParseInt(before, 10, 64)
+	return n
+}
+
+func (self *audioTags) year() int64 {
+	if len(self.Date) < 4 {
+		return 0
+	}
+	year, _ := strconv.ParseInt(self.Date[:4], 10, 64)
+	return year
+}
+
+// ID3 {{{
+
+func syncsafe(b []byte) int64 {
+	return int64(b[0])<<21 | int64(b[1])<<14 | int64(b[2])<<7 | int64(b[3])
+}
+
+// removes the 0x00 that id3 puts after every 0xff in unsynchronised tags
+func deUnsync(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte{0xff, 0x00}, []byte{0xff})
+}
+
+func decodeUTF16(data []byte, bigEndian bool) string {
+	if len(data) >= 2 {
+		if data[0] == 0xff && data[1] == 0xfe {
+			bigEndian = false
+			data = data[2:]
+		} else if data[0] == 0xfe && data[1] == 0xff {
+			bigEndian = true
+			data = data[2:]
+		}
+	}
+
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = binary.BigEndian.Uint16(data[i*2:])
+		} else {
+			units[i] = binary.LittleEndian.Uint16(data[i*2:])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+func decodeLatin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+func decodeID3Text(encoding byte, data []byte) string {
+	var text string
+	switch encoding {
+	case 1:
+		text = decodeUTF16(data, false)
+	case 2:
+		text = decodeUTF16(data, true)
+	case 3:
+		text = string(data)
+	default:
+		text = decodeLatin1(data)
+	}
+	// v2.4 separates multiple values with a null, only the first is used
+	text, _, _ = strings.Cut(text, "\x00")
+	return strings.TrimSpace(text)
+}
+
+// splits off a null terminated string in the given encoding, returns the rest of data
+func cutID3String(encoding byte, data []byte) []byte {
+	if encoding == 1 || encoding == 2 {
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				return data[i+2:]
+			}
+		}
+		return nil
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return data[i+1:]
+	}
+	return nil
+}
+
+// the picture of an APIC (or PIC in v2.2) frame
+func id3Picture(data []byte, v22 bool) []byte {
+	if len(data) < 2 {
+		return nil
+	}
+	encoding := data[0]
+	data = data[1:]
+	if v22 {
+		// 3 character image format
+		if len(data) < 3 {
+			return nil
+		}
+		data = data[3:]
+	} else {
+		// mime type
+		i := bytes.IndexByte(data, 0)
+		if i < 0 {
+			return nil
+		}
+		data = data[i+1:]
+	}
+	if len(data) < 1 {
+		return nil
+	}
+	// picture type
+	data = data[1:]
+	return cutID3String(encoding, data)
+}
+
+func readID3v2(file io.ReadSeeker, tags *audioTags) (int64, error) {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return 0, err
+	}
+	if string(header[:3]) != "ID3" {
+		return 0, nil
+	}
+
+	major := header[3]
+	flags := header[5]
+	size := syncsafe(header[6:10])
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(file, data); err != nil {
+		return 0, err
+	}
+	if flags&0x80 != 0 {
+		data = deUnsync(data)
+	}
+	if flags&0x40 != 0 && len(data) >= 4 {
+		var extSize int64
+		if major == 4 {
+			extSize = syncsafe(data[:4])
+		} else {
+			extSize = int64(binary.BigEndian.Uint32(data[:4])) + 4
+		}
+		if extSize > int64(len(data)) {
+			return 10 + size, errors.New("invalid id3 extended header")
+		}
+		data = data[extSize:]
+	}
+
+	v22 := major == 2
+	idLen, headerLen := 4, 10
+	if v22 {
+		idLen, headerLen = 3, 6
+	}
+
+	for len(data) >= headerLen && data[0] != 0 {
+		id := string(data[:idLen])
+		var frameSize int64
+		switch {
+		case v22:
+			frameSize = int64(data[3])<<16 | int64(data[4])<<8 | int64(data[5])
+		case major == 4:
+			frameSize = syncsafe(data[4:8])
+		default:
+			frameSize = int64(binary.BigEndian.Uint32(data[4:8]))
+		}
+		if frameSize <= 0 || int64(headerLen)+frameSize > int64(len(data)) {
+			break
+		}
+
+		frame := data[headerLen : int64(headerLen)+frameSize]
+		data = data[int64(headerLen)+frameSize:]
+
+		text := func() string {
+			if len(frame) < 1 {
+				return ""
+			}
+			return decodeID3Text(frame[0], frame[1:])
+		}
+
+		switch id {
+		case "TIT2", "TT2":
+			tags.Title = text()
+		case "TPE1", "TP1":
+			tags.Artist = text()
+		case "TALB", "TAL":
+			tags.Album = text()
+		case "TCON", "TCO":
+			tags.Genre = cleanID3Genre(text())
+		case "TDRC", "TYER", "TYE":
+			tags.Date = text()
+		case "TRCK", "TRK":
+			tags.Track = parseTrack(text())
+		case "TLEN", "TLE":
+			if ms, err := strconv.ParseInt(text(), 10, 64); err == nil {
+				tags.Duration = time.Duration(ms) * time.Millisecond
+			}
+		case "APIC", "PIC":
+			if tags.Cover == nil {
+				tags.Cover = id3Picture(frame, v22)
+			}
+		}
+	}
+
+	return 10 + size, nil
+}
+
+// id3v2.3 genres can be "(17)" or "(17)Rock", these refer to the id3v1 genre list
+// the name is used if there is one, otherwise the number is left as is
+func cleanID3Genre(genre string) string {
+	if strings.HasPrefix(genre, "(") {
+		if i := strings.Index(genre, ")"); i > 0 && i < len(genre)-1 {
+			return genre[i+1:]
+		}
+	}
+	return genre
+}
+
+// the 128 byte tag at the end of older mp3s
+func readID3v1(file io.ReadSeeker, tags *audioTags) {
+	if _, err := file.Seek(-128, io.SeekEnd); err != nil {
+		return
+	}
+	data := make([]byte, 128)
+	if _, err := io.ReadFull(file, data); err != nil || string(data[:3]) != "TAG" {
+		return
+	}
+
+	field := func(b []byte) string {
+		b, _, _ = bytes.Cut(b, []byte{0})
+		return strings.TrimSpace(decodeLatin1(b))
+	}
+	if tags.Title == "" {
+		tags.Title = field(data[3:33])
+	}
+	if tags.Artist == "" {
+		tags.Artist = field(data[33:63])
+	}
+	if tags.Album == "" {
+		tags.Album = field(data[63:93])
+	}
+	if tags.Date == "" {
+		tags.Date = field(data[93:97])
+	}
+	// id3v1.1 puts the track number in the last byte of the comment
+	if tags.Track == 0 && data[125] == 0 && data[126] != 0 {
+		tags.Track = int64(data[126])
+	}
+}
+
+// }}}
+
+// MP3 {{{
+
+// kbps, indexed by [mpeg 1][bitrate index] for layer 3
+var mp3Bitrates = [2][16]int64{
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+}
+
+var mp3SampleRates = [4][3]int64{
+	{11025, 12000, 8000},  // mpeg 2.5
+	{0, 0, 0},             // reserved
+	{22050, 24000, 16000}, // mpeg 2
+	{44100, 48000, 32000}, // mpeg 1
+}
+
+// the duration from the first frame, using the frame count in a xing/info header if there is one,
+// otherwise assuming the file is constant bitrate
+func mp3Duration(file io.ReadSeeker, audioStart int64, audioEnd int64) time.Duration {
+	if _, err := file.Seek(audioStart, io.SeekStart); err != nil {
+		return 0
+	}
+	buf := make([]byte, 64*1024)
+	n, _ := io.ReadFull(file, buf)
+	buf = buf[:n]
+
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xff || buf[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+		version := (buf[i+1] >> 3) & 0x3
+		layer := (buf[i+1] >> 1) & 0x3
+		bitrateIndex := buf[i+2] >> 4
+		rateIndex := (buf[i+2] >> 2) & 0x3
+		channelMode := buf[i+3] >> 6
+		// only layer 3
+		if version == 1 || layer != 1 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+			continue
+		}
+
+		mpeg1 := version == 3
+		table := 1
+		if mpeg1 {
+			table = 0
+		}
+		bitrate := mp3Bitrates[table][bitrateIndex] * 1000
+		sampleRate := mp3SampleRates[version][rateIndex]
+		samplesPerFrame := int64(1152)
+		if !mpeg1 {
+			samplesPerFrame = 576
+		}
+
+		// the xing header is after the side information
+		sideInfo := 32
+		switch {
+		case mpeg1 && channelMode == 3:
+			sideInfo = 17
+		case !mpeg1 && channelMode != 3:
+			sideInfo = 17
+		case !mpeg1 && channelMode == 3:
+			sideInfo = 9
+		}
+		xing := i + 4 + sideInfo
+		if xing+12 <= len(buf) {
+			id := string(buf[xing : xing+4])
+			if (id == "Xing" || id == "Info") && buf[xing+7]&0x1 != 0 {
+				frames := int64(binary.BigEndian.Uint32(buf[xing+8:]))
+				return time.Duration(frames*samplesPerFrame) * time.Second / time.Duration(sampleRate)
+			}
+		}
+
+		audioBytes := audioEnd - (audioStart + int64(i))
+		return time.Duration(audioBytes*8) * time.Second / time.Duration(bitrate)
+	}
+	return 0
+}
+
+func readMP3(file *os.File) (audioTags, error) {
+	var tags audioTags
+
+	tagSize, err := readID3v2(file, &tags)
+	if err != nil {
+		return tags, err
+	}
+	readID3v1(file, &tags)
+
+	if tags.Duration == 0 {
+		stat, err := file.Stat()
+		if err != nil {
+			return tags, err
+		}
+		tags.Duration = mp3Duration(file, tagSize, stat.Size())
+	}
+	return tags, nil
+}
+
+// }}}
+
+// Vorbis comments (flac, ogg) {{{
+
+func (self *audioTags) addVorbisComment(comment string) {
+	key, value, ok := strings.Cut(comment, "=")
+	if !ok {
+		return
+	}
+	value = strings.TrimSpace(value)
+
+	switch strings.ToUpper(key) {
+	case "TITLE":
+		self.Title = value
+	case "ARTIST":
+		self.Artist = value
+	case "ALBUMARTIST":
+		if self.Artist == "" {
+			self.Artist = value
+		}
+	case "ALBUM":
+		self.Album = value
+	case "GENRE":
+		self.Genre = value
+	case "DATE", "YEAR":
+		self.Date = value
+	case "TRACKNUMBER":
+		self.Track = parseTrack(value)
+	case "METADATA_BLOCK_PICTURE":
+		if self.Cover == nil {
+			if block, err := base64.StdEncoding.DecodeString(value); err == nil {
+				self.Cover = flacPicture(block)
+			}
+		}
+	}
+}
+
+func readVorbisComments(data []byte, tags *audioTags) {
+	r := bytes.NewReader(data)
+	var vendorLen uint32
+	if binary.Read(r, binary.LittleEndian, &vendorLen) != nil {
+		return
+	}
+	if _, err := r.Seek(int64(vendorLen), io.SeekCurrent); err != nil {
+		return
+	}
+
+	var count uint32
+	if binary.Read(r, binary.LittleEndian, &count) != nil {
+		return
+	}
+	for range count {
+		var length uint32
+		if binary.Read(r, binary.LittleEndian, &length) != nil || int64(length) > int64(r.Len()) {
+			return
+		}
+		comment := make([]byte, length)
+		if _, err := io.ReadFull(r, comment); err != nil {
+			return
+		}
+		tags.addVorbisComment(string(comment))
+	}
+}
+
+// }}}
+
+// FLAC {{{
+
+// the image in a flac PICTURE block
+func flacPicture(block []byte) []byte {
+	r := bytes.NewReader(block)
+	var pictureType, mimeLen uint32
+	if binary.Read(r, binary.BigEndian, &pictureType) != nil || binary.Read(r, binary.BigEndian, &mimeLen) != nil {
+		return nil
+	}
+	if _, err := r.Seek(int64(mimeLen), io.SeekCurrent); err != nil {
+		return nil
+	}
+	var descLen uint32
+	if binary.Read(r, binary.BigEndian, &descLen) != nil {
+		return nil
+	}
+	// description, then width, height, depth, and colors
+	if _, err := r.Seek(int64(descLen)+16, io.SeekCurrent); err != nil {
+		return nil
+	}
+	var dataLen uint32
+	if binary.Read(r, binary.BigEndian, &dataLen) != nil || int64(dataLen) > int64(r.Len()) {
+		return nil
+	}
+	data := make([]byte, dataLen)
+	io.ReadFull(r, data)
+	return data
+}
+
+func readFLAC(file *os.File) (audioTags, error) {
+	var tags audioTags
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return tags, err
+	}
+
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(file, header); err != nil {
+			return tags, err
+		}
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7f
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+
+		block := make([]byte, length)
+		if _, err := io.ReadFull(file, block); err != nil {
+			return tags, err
+		}
+
+		switch blockType {
+		case 0: // STREAMINFO
+			if len(block) >= 18 {
+				sampleRate := int64(block[10])<<12 | int64(block[11])<<4 | int64(block[12])>>4
+				samples := int64(block[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(block[14:18]))
+				if sampleRate > 0 {
+					tags.Duration = time.Duration(samples) * time.Second / time.Duration(sampleRate)
+				}
+			}
+		case 4: // VORBIS_COMMENT
+			readVorbisComments(block, &tags)
+		case 6: // PICTURE
+			if tags.Cover == nil {
+				tags.Cover = flacPicture(block)
+			}
+		}
+
+		if last {
+			return tags, nil
+		}
+	}
+}
+
+// }}}
+
+// Ogg (vorbis, opus) {{{
+
+// reads the first packets of the first stream in an ogg file
+func oggPackets(file io.Reader, count int) ([][]byte, error) {
+	packets := [][]byte{}
+	current := []byte{}
+
+	for len(packets) < count {
+		header := make([]byte, 27)
+		if _, err := io.ReadFull(file, header); err != nil {
+			return packets, err
+		}
+		if string(header[:4]) != "OggS" {
+			return packets, errors.New("invalid ogg page")
+		}
+
+		segments := make([]byte, header[26])
+		if _, err := io.ReadFull(file, segments); err != nil {
+			return packets, err
+		}
+		for _, size := range segments {
+			data := make([]byte, size)
+			if _, err := io.ReadFull(file, data); err != nil {
+				return packets, err
+			}
+			current = append(current, data...)
+			// a segment shorter than 255 ends the packet
+			if size < 255 {
+				packets = append(packets, current)
+				current = []byte{}
+			}
+		}
+	}
+	return packets, nil
+}
+
+// the granule position of the last page, which is the number of samples in the stream
+func oggLastGranule(file *os.File) int64 {
+	stat, err := file.Stat()
+	if err != nil {
+		return 0
+	}
+	start := max(stat.Size()-64*1024, 0)
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return 0
+	}
+	tail, err := io.ReadAll(file)
+	if err != nil {
+		return 0
+	}
+
+	i := bytes.LastIndex(tail, []byte("OggS"))
+	if i < 0 || i+14 > len(tail) {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(tail[i+6 : i+14]))
+}
+
+func readOgg(file *os.File) (audioTags, error) {
+	var tags audioTags
+
+	packets, err := oggPackets(file, 2)
+	if err != nil {
+		return tags, err
+	}
+
+	id, comments := packets[0], packets[1]
+	var sampleRate, preSkip int64
+	switch {
+	case bytes.HasPrefix(id, []byte("\x01vorbis")) && len(id) >= 16:
+		sampleRate = int64(binary.LittleEndian.Uint32(id[12:16]))
+		if bytes.HasPrefix(comments, []byte("\x03vorbis")) {
+			readVorbisComments(comments[7:], &tags)
+		}
+	case bytes.HasPrefix(id, []byte("OpusHead")) && len(id) >= 12:
+		// opus is always 48khz
+		sampleRate = 48000
+		preSkip = int64(binary.LittleEndian.Uint16(id[10:12]))
+		if bytes.HasPrefix(comments, []byte("OpusTags")) {
+			readVorbisComments(comments[8:], &tags)
+		}
+	default:
+		return tags, errors.New("the ogg file is not vorbis or opus")
+	}
+
+	if samples := oggLastGranule(file) - preSkip; sampleRate > 0 && samples > 0 {
+		tags.Duration = time.Duration(samples) * time.Second / time.Duration(sampleRate)
+	}
+	return tags, nil
+}
+
+// }}}
+
+// the duration of an audio file, 0 if it is not an audio file or the duration could not be read
+func AudioDuration(path string) time.Duration {
+	if !isAudioFile(path) {
+		return 0
+	}
+	tags, err := readAudioTags(path)
+	if err != nil {
+		return 0
+	}
+	return tags.Duration
+}
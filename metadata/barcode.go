@@ -0,0 +1,261 @@
+package metadata
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"aiolimas/settings"
+	db_types "aiolimas/types"
+)
+
+type CodeType string
+
+const (
+	CT_ISBN    CodeType = "isbn"
+	CT_EAN     CodeType = "ean"
+	CT_UPC     CodeType = "upc"
+	CT_GAMETDB CodeType = "gametdb"
+)
+
+func ListCodeTypes() []CodeType {
+	return []CodeType{CT_ISBN, CT_EAN, CT_UPC, CT_GAMETDB}
+}
+
+// a scanned, or typed in code that identifies a physical item
+type Code struct {
+	// the normalized code, ISBN-10s are converted to ISBN-13
+	Value string
+	Type  CodeType
+
+	// by-id providers to try, in order
+	// if empty, the code can only be found in the local code cache
+	Providers []string
+
+	// guesses based on the code type, Format is only meaningful if Providers is not empty
+	Format    db_types.Format
+	MediaType db_types.MediaTypes
+}
+
+var gameTDBIdRe = regexp.MustCompile(`^[A-Z0-9]{4,6}$`)
+
+// if ty is "", the type of code is detected
+func ParseCode(code string, ty CodeType) (Code, error) {
+	code = strings.ToUpper(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+
+	out := Code{
+		Value: code,
+		Type:  ty,
+	}
+
+	if ty == "" {
+		switch {
+		case isValidISBN10(code), isValidISBN13(code):
+			out.Type = CT_ISBN
+		case isValidUPC(code):
+			out.Type = CT_UPC
+		case isValidEANCode(code):
+			out.Type = CT_EAN
+		case gameTDBIdRe.MatchString(code) && strings.ContainsAny(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ"):
+			out.Type = CT_GAMETDB
+		default:
+			return out, fmt.Errorf("could not determine the type of code: '%s'", code)
+		}
+	}
+
+	switch out.Type {
+	case CT_ISBN:
+		if isValidISBN10(code) {
+			out.Value = isbn10To13(code)
+		} else if !isValidISBN13(code) {
+			return out, fmt.Errorf("invalid ISBN, expected 10 digits, or 13 starting with 978 or 979: '%s'", code)
+		}
+		out.Providers = []string{"googlebooks", "openlibrary"}
+		out.Format = db_types.F_BOOK
+		out.MediaType = db_types.TY_BOOK
+	case CT_UPC:
+		if !isValidUPC(code) {
+			return out, fmt.Errorf("invalid UPC, expected 12 digits: '%s'", code)
+		}
+		// there is no provider that can look these up
+		// the only way to find them is through the local code cache
+	case CT_EAN:
+		if !isValidEANCode(code) {
+			return out, fmt.Errorf("invalid EAN, expected 8 or 13 digits: '%s'", code)
+		}
+		// there is no provider that can look these up
+		// the only way to find them is through the local code cache
+	case CT_GAMETDB:
+		if !gameTDBIdRe.MatchString(code) {
+			return out, fmt.Errorf("invalid GameTDB id: '%s'", code)
+		}
+		out.Providers = gameTDBProviders(code)
+		out.Format = gameTDBFormats[out.Providers[0]]
+		out.MediaType = db_types.TY_GAME
+	default:
+		return out, fmt.Errorf("invalid code type: '%s'", out.Type)
+	}
+
+	return out, nil
+}
+
+// the format of an entry that provider found the code with
+// Format is only a guess for codes that more than one provider can find
+func (self Code) FormatFrom(provider string) db_types.Format {
+	if format, ok := gameTDBFormats[provider]; ok {
+		return format
+	}
+	return self.Format
+}
+
+var gameTDBFormats = map[string]db_types.Format{
+	"gtdbwii":      db_types.F_WII,
+	"gtdbgamecube": db_types.F_GAMECUBE,
+	"gtdbwiiu":     db_types.F_WII_U,
+	"gtdbswitch":   db_types.F_NIN_SWITCH,
+	"gtdbds":       db_types.F_NIN_DS,
+	"gtdbcustom":   db_types.F_WII,
+}
+
+// orders the GameTDB databases by how likely they are to contain the id
+//
+// wii and gamecube ids are 6 characters where the first character is the console,
+// wiiu ids are also 6 characters, ds ids are 4, and switch ids are 5
+func gameTDBProviders(id string) []string {
+	var first []string
+	switch len(id) {
+	case 4:
+		first = []string{"gtdbds"}
+	case 5:
+		first = []string{"gtdbswitch"}
+	case 6:
+		switch id[0] {
+		case 'G', 'D', 'P':
+			first = []string{"gtdbgamecube", "gtdbwii"}
+		case 'A', 'B':
+			first = []string{"gtdbwiiu", "gtdbwii"}
+		default:
+			first = []string{"gtdbwii", "gtdbgamecube"}
+		}
+	}
+
+	out := first
+	for _, p := range []string{"gtdbwii", "gtdbgamecube", "gtdbwiiu", "gtdbswitch", "gtdbds", "gtdbcustom"} {
+		if !slices.Contains(out, p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// tries each of code.Providers until one succeeds
+// returns the metadata, and the provider that found it
+func LookupCode(code Code, us settings.SettingsData) (db_types.MetadataEntry, string, error) {
+	if len(code.Providers) == 0 {
+		return db_types.MetadataEntry{}, "", fmt.Errorf("%s codes cannot be looked up", code.Type)
+	}
+
+	errs := []string{}
+	for _, provider := range code.Providers {
+		p, ok := getProviderWith(provider, CAP_BY_ID)
+		if !ok {
+			continue
+		}
+
+		meta, err := p.ById(code.Value, us)
+		if err == nil && meta.Title != "" {
+			return meta, provider, nil
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", provider, err.Error()))
+		} else {
+			errs = append(errs, fmt.Sprintf("%s: not found", provider))
+		}
+	}
+
+	return db_types.MetadataEntry{}, "", fmt.Errorf("could not find %s\n%s", code.Value, strings.Join(errs, "\n"))
+}
+
+// ISBN-13s are EAN-13s in the bookland prefixes
+func isValidISBN13(code string) bool {
+	return len(code) == 13 && (code[:3] == "978" || code[:3] == "979") && isValidEAN(code)
+}
+
+// UPC-A
+func isValidUPC(code string) bool {
+	return len(code) == 12 && isValidEAN(code)
+}
+
+// EAN-13, or EAN-8, which is what is given as an EAN
+func isValidEANCode(code string) bool {
+	return (len(code) == 13 || len(code) == 8) && isValidEAN(code)
+}
+
+// checks the check digit of EAN-8, UPC-A (12 digits), EAN-13 and ISBN-13
+func isValidEAN(code string) bool {
+	if len(code) != 8 && len(code) != 12 && len(code) != 13 {
+		return false
+	}
+
+	sum := 0
+	// weights alternate 3, 1 starting from the digit before the check digit
+	for i := len(code) - 2; i >= 0; i-- {
+		d := code[i]
+		if d < '0' || d > '9' {
+			return false
+		}
+		if (len(code)-2-i)%2 == 0 {
+			sum += int(d-'0') * 3
+		} else {
+			sum += int(d - '0')
+		}
+	}
+
+	check := code[len(code)-1]
+	if check < '0' || check > '9' {
+		return false
+	}
+
+	return (10-sum%10)%10 == int(check-'0')
+}
+
+func isValidISBN10(code string) bool {
+	if len(code) != 10 {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < 10; i++ {
+		d := code[i]
+		var v int
+		if d == 'X' && i == 9 {
+			v = 10
+		} else if d >= '0' && d <= '9' {
+			v = int(d - '0')
+		} else {
+			return false
+		}
+		sum += v * (10 - i)
+	}
+
+	return sum%11 == 0
+}
+
+func isbn10To13(code string) string {
+	base := "978" + code[:9]
+
+	sum := 0
+	for i := 0; i < 12; i++ {
+		d := int(base[i] - '0')
+		if i%2 == 0 {
+			sum += d
+		} else {
+			sum += d * 3
+		}
+	}
+
+	return fmt.Sprintf("%s%d", base, (10-sum%10)%10)
+}
@@ -0,0 +1,53 @@
+package metadata
+
+import "testing"
+
+func TestParseCode(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      string
+		ty        CodeType
+		wantType  CodeType
+		wantValue string
+		wantErr   bool
+	}{
+		{"isbn-13", "978-0-306-40615-7", "", CT_ISBN, "9780306406157", false},
+		{"isbn-10 is converted", "0-306-40615-2", "", CT_ISBN, "9780306406157", false},
+		{"upc", "036000291452", "", CT_UPC, "036000291452", false},
+		{"ean-13", "4006381333931", "", CT_EAN, "4006381333931", false},
+		{"ean-8", "73513537", "", CT_EAN, "73513537", false},
+		{"gametdb", "rmge01", "", CT_GAMETDB, "RMGE01", false},
+		{"bad check digit", "9780306406158", "", "", "", true},
+
+		{"explicit isbn-13", "9780306406157", CT_ISBN, CT_ISBN, "9780306406157", false},
+		{"explicit isbn-10", "0306406152", CT_ISBN, CT_ISBN, "9780306406157", false},
+		{"explicit isbn with 12 digits", "036000291452", CT_ISBN, "", "", true},
+		{"explicit isbn outside of bookland", "4006381333931", CT_ISBN, "", "", true},
+		{"explicit isbn with a bad check digit", "9780306406158", CT_ISBN, "", "", true},
+		{"explicit upc", "036000291452", CT_UPC, CT_UPC, "036000291452", false},
+		{"explicit upc with 13 digits", "4006381333931", CT_UPC, "", "", true},
+		{"explicit upc with 8 digits", "73513537", CT_UPC, "", "", true},
+		{"explicit ean-13", "4006381333931", CT_EAN, CT_EAN, "4006381333931", false},
+		{"explicit ean-8", "73513537", CT_EAN, CT_EAN, "73513537", false},
+		{"explicit ean with 12 digits", "036000291452", CT_EAN, "", "", true},
+		{"explicit ean with a bad check digit", "4006381333932", CT_EAN, "", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, err := ParseCode(test.code, test.ty)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("got %s %s, want an error", code.Type, code.Value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code.Type != test.wantType || code.Value != test.wantValue {
+				t.Errorf("got %s %s, want %s %s", code.Type, code.Value, test.wantType, test.wantValue)
+			}
+		})
+	}
+}
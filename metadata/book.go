@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
@@ -87,7 +86,7 @@ func GoogleBooksIdentifier(info IdentifyMetadata) ([]db_types.MetadataEntry, err
 	var out []db_types.MetadataEntry
 	enc := url.PathEscape(info.Title)
 	url := fmt.Sprintf("https://www.googleapis.com/books/v1/volumes?q=%s&langRestrict=en", enc)
-	res, err := http.Get(url)
+	res, err := providerClient("googlebooks").Get(url)
 	if err != nil {
 		return out, err
 	}
@@ -130,7 +129,7 @@ func OpenLibraryIdIdentifier(id string, us settings.SettingsData) (db_types.Meta
 	id = strings.ReplaceAll(id, "-", "")
 	url := fmt.Sprintf("https://openlibrary.org/works/%s.json", url.QueryEscape(id))
 
-	res, err := http.Get(url)
+	res, err := providerClient("openlibrary").Get(url)
 	if err != nil {
 		return out, err
 	}
@@ -222,7 +221,7 @@ func GoogleBooksProvider(info *GetMetadataInfo) (db_types.MetadataEntry, error)
 
 	enc := url.PathEscape(info.Entry.En_Title)
 	url := fmt.Sprintf("https://www.googleapis.com/books/v1/volumes?q=%s&langRestrict=en", enc)
-	res, err := http.Get(url)
+	res, err := providerClient("googlebooks").Get(url)
 	if err != nil {
 		return out, err
 	}
@@ -259,3 +258,25 @@ func GoogleBooksIdIdentifier(id string, us settings.SettingsData) (db_types.Meta
 	}
 	return GoogleBooksProvider(&i)
 }
+
+func init() {
+	RegisterProvider(&BasicProvider{
+		ProviderName: "googlebooks",
+		Types:        []db_types.MediaTypes{db_types.TY_BOOK},
+		Thumbnails:   true,
+		FetchFn:      GoogleBooksProvider,
+		RankFn: func(entry *db_types.InfoEntry) int {
+			return 1
+		},
+		SearchFn:     GoogleBooksIdentifier,
+		// isbn
+		ByIdFn: GoogleBooksIdIdentifier,
+	})
+	RegisterProvider(&BasicProvider{
+		ProviderName: "openlibrary",
+		Types:        []db_types.MediaTypes{db_types.TY_BOOK},
+		Thumbnails:   true,
+		// isbn
+		ByIdFn: OpenLibraryIdIdentifier,
+	})
+}
@@ -0,0 +1,275 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"aiolimas/logging"
+	"aiolimas/settings"
+)
+
+/*
+	Every request a provider makes goes through providerClient(name),
+	responses are stored in the metadata cache directory, one file per request,
+	as the raw http response.
+
+	Because of this, a cache directory can be copied and used with Offline
+	to run against recorded responses
+*/
+
+var ErrNotCached = errors.New("offline, and the response is not cached")
+
+const defaultCacheTTL = 24 * time.Hour
+
+// the providers that talk to the user's own servers, these change whenever media is downloaded
+var defaultProviderTTLs = map[string]time.Duration{
+	"sonarr": 0,
+	"radarr": 0,
+	"seerr":  0,
+}
+
+var defaultRateLimits = map[string]time.Duration{
+	// anilist allows 90 requests per minute
+	"graphql.anilist.co": 700 * time.Millisecond,
+}
+
+// query parameters that are left out of the cache key so that keys are not stored on disk
+var credentialParams = []string{"apikey", "api_key", "key"}
+
+func providerClient(provider string) *http.Client {
+	return &http.Client{
+		Transport: &cachingTransport{provider: provider},
+	}
+}
+
+type cachingTransport struct {
+	provider string
+}
+
+func cacheDir(cfg settings.MetadataCacheSettings) string {
+	if cfg.Dir != "" {
+		return cfg.Dir
+	}
+	return filepath.Join(os.Getenv("AIO_DIR"), "metadata-cache")
+}
+
+func parseDuration(text string, fallback time.Duration) time.Duration {
+	if text == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(text)
+	if err != nil {
+		logging.ELog(err)
+		return fallback
+	}
+	return d
+}
+
+func cacheTTL(cfg settings.MetadataCacheSettings, provider string) time.Duration {
+	if ttl, ok := cfg.TTL[provider]; ok {
+		return parseDuration(ttl, defaultCacheTTL)
+	}
+	if ttl, ok := defaultProviderTTLs[provider]; ok && cfg.DefaultTTL == "" {
+		return ttl
+	}
+	return parseDuration(cfg.DefaultTTL, defaultCacheTTL)
+}
+
+func rateLimit(cfg settings.MetadataCacheSettings, host string) time.Duration {
+	if limit, ok := cfg.RateLimit[host]; ok {
+		return parseDuration(limit, 0)
+	}
+	return defaultRateLimits[host]
+}
+
+// the key is the provider, method, url (without credentials) and body
+func cacheKey(provider string, req *http.Request, body []byte) string {
+	u := *req.URL
+	query := u.Query()
+	for _, param := range credentialParams {
+		query.Del(param)
+	}
+	u.RawQuery = query.Encode()
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s %s\n", provider, req.Method, u.String())
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+var hostLimits = struct {
+	sync.Mutex
+	next map[string]time.Time
+}{next: map[string]time.Time{}}
+
+// blocks until a request to host is allowed
+func waitForHost(host string, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	hostLimits.Lock()
+	now := time.Now()
+	at := hostLimits.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	hostLimits.next[host] = at.Add(interval)
+	hostLimits.Unlock()
+
+	time.Sleep(time.Until(at))
+}
+
+func readCachedResponse(path string, req *http.Request) (*http.Response, time.Time, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return res, stat.ModTime(), nil
+}
+
+func writeCachedResponse(path string, res *http.Response) error {
+	data, err := httputil.DumpResponse(res, true)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// the MetadataCache server settings, only read again when the config file changes
+var loadedCacheSettings = struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	size    int64
+	cfg     settings.MetadataCacheSettings
+}{size: -1}
+
+func cacheSettings() settings.MetadataCacheSettings {
+	path := os.Getenv("AIO_CONFIG_FILE")
+	var modTime time.Time
+	var size int64 = -1
+	if stat, err := os.Stat(path); err == nil {
+		modTime, size = stat.ModTime(), stat.Size()
+	}
+
+	loaded := &loadedCacheSettings
+	loaded.Lock()
+	defer loaded.Unlock()
+
+	if loaded.path == path && loaded.modTime.Equal(modTime) && loaded.size == size {
+		return loaded.cfg
+	}
+
+	server, err := settings.GetServerSettings()
+	if err != nil {
+		// keep the settings that were last read, the file may be half written
+		logging.ELog(err)
+		return loaded.cfg
+	}
+	loaded.path, loaded.modTime, loaded.size = path, modTime, size
+	loaded.cfg = server.MetadataCache
+	return loaded.cfg
+}
+
+func (self *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cfg := cacheSettings()
+
+	var body []byte
+	var err error
+	if req.Body != nil {
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	path := filepath.Join(cacheDir(cfg), self.provider, cacheKey(self.provider, req, body)+".http")
+	ttl := cacheTTL(cfg, self.provider)
+
+	cached, cachedAt, cacheErr := readCachedResponse(path, req)
+	if cacheErr == nil {
+		if cfg.Offline || time.Since(cachedAt) < ttl {
+			cached.Header.Set("X-Aio-Cache", "hit")
+			return cached, nil
+		}
+	}
+
+	if cfg.Offline {
+		return nil, fmt.Errorf("%w: %s %s", ErrNotCached, req.Method, req.URL.Host+req.URL.Path)
+	}
+
+	waitForHost(req.URL.Host, rateLimit(cfg, req.URL.Host))
+
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil || res.StatusCode >= 500 {
+		// the upstream is down, an old response is better than nothing
+		if cacheErr == nil {
+			if res != nil {
+				res.Body.Close()
+			}
+			logging.Info(fmt.Sprintf("serving stale %s response for %s", self.provider, req.URL.Host+req.URL.Path))
+			cached.Header.Set("X-Aio-Cache", "stale")
+			return cached, nil
+		}
+		return res, err
+	}
+
+	if cacheErr == nil {
+		cached.Body.Close()
+	}
+
+	if res.StatusCode != 200 || ttl <= 0 {
+		return res, nil
+	}
+
+	resBody, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(resBody))
+	res.ContentLength = int64(len(resBody))
+	res.TransferEncoding = nil
+
+	if err := writeCachedResponse(path, res); err != nil {
+		logging.ELog(err)
+	}
+
+	res.Body = io.NopCloser(bytes.NewReader(resBody))
+	res.Header.Set("X-Aio-Cache", "miss")
+	return res, nil
+}
@@ -0,0 +1,181 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"aiolimas/settings"
+)
+
+// points AIO_CONFIG_FILE at a config with cfg as the MetadataCache settings
+func writeCacheConfig(t *testing.T, path string, cfg settings.MetadataCacheSettings) {
+	t.Helper()
+	text, err := json.Marshal(settings.ServerSettings{MetadataCache: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, text, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AIO_CONFIG_FILE", path)
+}
+
+func TestCachingTransport(t *testing.T) {
+	var requests atomic.Int64
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		if failing.Load() {
+			w.WriteHeader(503)
+			return
+		}
+		fmt.Fprintf(w, "response %d", n)
+	}))
+	defer server.Close()
+
+	config := filepath.Join(t.TempDir(), "config.json")
+	cfg := settings.MetadataCacheSettings{Dir: t.TempDir()}
+	writeCacheConfig(t, config, cfg)
+
+	client := providerClient("test-cache")
+	get := func(path string) (string, string, error) {
+		t.Helper()
+		res, err := client.Get(server.URL + path)
+		if err != nil {
+			return "", "", err
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body), res.Header.Get("X-Aio-Cache"), nil
+	}
+
+	steps := []struct {
+		name   string
+		path   string
+		setup  func()
+		body   string
+		status string
+	}{
+		{"first request", "/a?apikey=1", nil, "response 1", "miss"},
+		{"cached", "/a?apikey=1", nil, "response 1", "hit"},
+		{"the key is not part of the cache key", "/a?apikey=2", nil, "response 1", "hit"},
+		{"another url", "/b", nil, "response 2", "miss"},
+		{"expired", "/a", func() {
+			cfg.TTL = map[string]string{"test-cache": "1ns"}
+			writeCacheConfig(t, config, cfg)
+		}, "response 3", "miss"},
+		{"stale while the server fails", "/a", func() { failing.Store(true) }, "response 3", "stale"},
+		{"offline serves expired responses", "/b", func() {
+			failing.Store(false)
+			cfg.Offline = true
+			writeCacheConfig(t, config, cfg)
+		}, "response 2", "hit"},
+	}
+
+	for _, step := range steps {
+		if step.setup != nil {
+			step.setup()
+		}
+		body, status, err := get(step.path)
+		if err != nil {
+			t.Fatalf("%s: %s", step.name, err.Error())
+		}
+		if body != step.body || status != step.status {
+			t.Errorf("%s: got '%s' (%s), want '%s' (%s)", step.name, body, status, step.body, step.status)
+		}
+	}
+
+	before := requests.Load()
+	if _, _, err := get("/c"); !errors.Is(err, ErrNotCached) {
+		t.Errorf("offline request for an uncached url: got %v, want ErrNotCached", err)
+	}
+	if requests.Load() != before {
+		t.Error("offline request reached the server")
+	}
+
+	files, err := os.ReadDir(filepath.Join(cfg.Dir, "test-cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(cfg.Dir, "test-cache", file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "apikey") {
+			t.Errorf("%s contains the key", file.Name())
+		}
+	}
+}
+
+func TestCachingTransportTTLZero(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "response %d", requests.Add(1))
+	}))
+	defer server.Close()
+
+	// sonarr defaults to a ttl of 0
+	writeCacheConfig(t, filepath.Join(t.TempDir(), "config.json"), settings.MetadataCacheSettings{Dir: t.TempDir()})
+	client := providerClient("sonarr")
+	for range 2 {
+		res, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if status := res.Header.Get("X-Aio-Cache"); status != "" {
+			t.Errorf("got cache status '%s', want none", status)
+		}
+	}
+	if requests.Load() != 2 {
+		t.Errorf("the server got %d requests, want 2", requests.Load())
+	}
+}
+
+func TestCacheSettings(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.json")
+	writeCacheConfig(t, config, settings.MetadataCacheSettings{DefaultTTL: "1h"})
+	if cfg := cacheSettings(); cfg.DefaultTTL != "1h" {
+		t.Fatalf("got ttl '%s', want 1h", cfg.DefaultTTL)
+	}
+
+	// the same size and modification time, so the file is not read again
+	stat, err := os.Stat(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbage := strings.Repeat("x", int(stat.Size()))
+	if err := os.WriteFile(config, []byte(garbage), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(config, stat.ModTime(), stat.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if cfg := cacheSettings(); cfg.DefaultTTL != "1h" {
+		t.Errorf("unchanged file: got ttl '%s', want 1h", cfg.DefaultTTL)
+	}
+
+	writeCacheConfig(t, config, settings.MetadataCacheSettings{DefaultTTL: "30m"})
+	if cfg := cacheSettings(); cfg.DefaultTTL != "30m" {
+		t.Errorf("changed file: got ttl '%s', want 30m", cfg.DefaultTTL)
+	}
+
+	other := filepath.Join(t.TempDir(), "other.json")
+	writeCacheConfig(t, other, settings.MetadataCacheSettings{DefaultTTL: "5m"})
+	if cfg := cacheSettings(); cfg.DefaultTTL != "5m" {
+		t.Errorf("another file: got ttl '%s', want 5m", cfg.DefaultTTL)
+	}
+}
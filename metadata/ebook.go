@@ -0,0 +1,324 @@
+package metadata
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// the metadata that is read from epubs, comic archives, and pdfs
+type bookInfo struct {
+	Title       string
+	Authors     []string
+	Description string
+	Date        string
+	Publisher   string
+	Language    string
+	Genres      []string
+	ISBN10      string
+	ISBN13      string
+	Series      string
+	Volume      int64
+	PageCount   int64
+	Cover       []byte
+}
+
+func (self *bookInfo) year() int64 {
+	if len(self.Date) < 4 {
+		return 0
+	}
+	year, _ := strconv.ParseInt(self.Date[:4], 10, 64)
+	return year
+}
+
+var htmlTagRe = regexp.MustCompile(`<[^>]*>`)
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	r, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func findZipFile(archive *zip.Reader, name string) *zip.File {
+	for _, file := range archive.File {
+		if strings.EqualFold(file.Name, name) {
+			return file
+		}
+	}
+	return nil
+}
+
+func isImageName(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+		return true
+	}
+	return false
+}
+
+// EPUB {{{
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type opfPackage struct {
+	Metadata struct {
+		Titles      []string `xml:"title"`
+		Creators    []string `xml:"creator"`
+		Description string   `xml:"description"`
+		Dates       []string `xml:"date"`
+		Publisher   string   `xml:"publisher"`
+		Language    string   `xml:"language"`
+		Subjects    []string `xml:"subject"`
+		Identifiers []string `xml:"identifier"`
+		Metas       []struct {
+			Name     string `xml:"name,attr"`
+			Content  string `xml:"content,attr"`
+			Property string `xml:"property,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Manifest struct {
+		Items []struct {
+			Id         string `xml:"id,attr"`
+			Href       string `xml:"href,attr"`
+			MediaType  string `xml:"media-type,attr"`
+			Properties string `xml:"properties,attr"`
+		} `xml:"item"`
+	} `xml:"manifest"`
+}
+
+// "urn:isbn:978-0-..." -> "9780..."
+func cleanISBN(identifier string) string {
+	identifier = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(identifier)), "urn:isbn:")
+	identifier = strings.ReplaceAll(identifier, "-", "")
+	for _, c := range identifier {
+		if (c < '0' || c > '9') && c != 'x' {
+			return ""
+		}
+	}
+	return strings.ToUpper(identifier)
+}
+
+func (self *opfPackage) coverHref() string {
+	coverId := ""
+	for _, meta := range self.Metadata.Metas {
+		if meta.Name == "cover" {
+			coverId = meta.Content
+		}
+	}
+
+	for _, item := range self.Manifest.Items {
+		if (coverId != "" && item.Id == coverId) || slices.Contains(strings.Fields(item.Properties), "cover-image") {
+			return item.Href
+		}
+	}
+	for _, item := range self.Manifest.Items {
+		if strings.HasPrefix(item.MediaType, "image/") && strings.Contains(strings.ToLower(item.Id+item.Href), "cover") {
+			return item.Href
+		}
+	}
+	return ""
+}
+
+func readEPUB(filePath string) (bookInfo, error) {
+	var out bookInfo
+
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return out, err
+	}
+	defer archive.Close()
+
+	containerFile := findZipFile(&archive.Reader, "META-INF/container.xml")
+	if containerFile == nil {
+		return out, errors.New("the epub has no META-INF/container.xml")
+	}
+	data, err := readZipFile(containerFile)
+	if err != nil {
+		return out, err
+	}
+	var container epubContainer
+	if err := xml.Unmarshal(data, &container); err != nil {
+		return out, err
+	}
+	if len(container.Rootfiles) == 0 {
+		return out, errors.New("the epub has no rootfile")
+	}
+
+	opfPath := container.Rootfiles[0].FullPath
+	opfFile := findZipFile(&archive.Reader, opfPath)
+	if opfFile == nil {
+		return out, errors.New("the epub is missing " + opfPath)
+	}
+	data, err = readZipFile(opfFile)
+	if err != nil {
+		return out, err
+	}
+	var opf opfPackage
+	if err := xml.Unmarshal(data, &opf); err != nil {
+		return out, err
+	}
+
+	meta := opf.Metadata
+	if len(meta.Titles) > 0 {
+		out.Title = strings.TrimSpace(meta.Titles[0])
+	}
+	for _, creator := range meta.Creators {
+		out.Authors = append(out.Authors, strings.TrimSpace(creator))
+	}
+	out.Description = strings.TrimSpace(htmlTagRe.ReplaceAllString(meta.Description, ""))
+	if len(meta.Dates) > 0 {
+		out.Date = strings.TrimSpace(meta.Dates[0])
+	}
+	out.Publisher = strings.TrimSpace(meta.Publisher)
+	out.Language = strings.TrimSpace(meta.Language)
+	for _, subject := range meta.Subjects {
+		out.Genres = append(out.Genres, strings.TrimSpace(subject))
+	}
+	for _, identifier := range meta.Identifiers {
+		switch isbn := cleanISBN(identifier); len(isbn) {
+		case 10:
+			out.ISBN10 = isbn
+		case 13:
+			out.ISBN13 = isbn
+		}
+	}
+	// calibre series
+	for _, m := range meta.Metas {
+		switch {
+		case m.Name == "calibre:series":
+			out.Series = m.Content
+		case m.Name == "calibre:series_index":
+			index, _ := strconv.ParseFloat(m.Content, 64)
+			out.Volume = int64(index)
+		case m.Property == "belongs-to-collection":
+			out.Series = strings.TrimSpace(m.Value)
+		}
+	}
+
+	if href := opf.coverHref(); href != "" {
+		if unescaped, err := url.PathUnescape(href); err == nil {
+			href = unescaped
+		}
+		if coverFile := findZipFile(&archive.Reader, path.Join(path.Dir(opfPath), href)); coverFile != nil {
+			out.Cover, _ = readZipFile(coverFile)
+		}
+	}
+
+	return out, nil
+}
+
+// }}}
+
+// Comic archives {{{
+
+// https://anansi-project.github.io/docs/comicinfo/intro
+type comicInfo struct {
+	Title       string `xml:"Title"`
+	Series      string `xml:"Series"`
+	Volume      string `xml:"Volume"`
+	Summary     string `xml:"Summary"`
+	Year        string `xml:"Year"`
+	Month       string `xml:"Month"`
+	Writer      string `xml:"Writer"`
+	Publisher   string `xml:"Publisher"`
+	Genre       string `xml:"Genre"`
+	PageCount   string `xml:"PageCount"`
+	LanguageISO string `xml:"LanguageISO"`
+}
+
+func readCBZ(filePath string) (bookInfo, error) {
+	var out bookInfo
+
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return out, err
+	}
+	defer archive.Close()
+
+	pages := []*zip.File{}
+	for _, file := range archive.File {
+		if !file.FileInfo().IsDir() && isImageName(file.Name) {
+			pages = append(pages, file)
+		}
+	}
+	slices.SortFunc(pages, func(a *zip.File, b *zip.File) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	out.PageCount = int64(len(pages))
+	if len(pages) > 0 {
+		out.Cover, _ = readZipFile(pages[0])
+	}
+
+	infoFile := findZipFile(&archive.Reader, "ComicInfo.xml")
+	if infoFile == nil {
+		return out, nil
+	}
+	data, err := readZipFile(infoFile)
+	if err != nil {
+		return out, err
+	}
+	var info comicInfo
+	if err := xml.Unmarshal(data, &info); err != nil {
+		return out, err
+	}
+
+	out.Title = strings.TrimSpace(info.Title)
+	out.Series = strings.TrimSpace(info.Series)
+	out.Volume, _ = strconv.ParseInt(strings.TrimSpace(info.Volume), 10, 64)
+	out.Description = strings.TrimSpace(info.Summary)
+	out.Date = strings.TrimSpace(info.Year)
+	out.Publisher = strings.TrimSpace(info.Publisher)
+	out.Language = strings.TrimSpace(info.LanguageISO)
+	for _, writer := range strings.Split(info.Writer, ",") {
+		if writer = strings.TrimSpace(writer); writer != "" {
+			out.Authors = append(out.Authors, writer)
+		}
+	}
+	for _, genre := range strings.Split(info.Genre, ",") {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			out.Genres = append(out.Genres, genre)
+		}
+	}
+	if count, err := strconv.ParseInt(strings.TrimSpace(info.PageCount), 10, 64); err == nil && count > 0 {
+		out.PageCount = count
+	}
+
+	return out, nil
+}
+
+// }}}
+
+// PDF {{{
+
+var pdfPageRe = regexp.MustCompile(`/Type\s*/Page[^s]`)
+
+// counts the page objects, this is wrong for pdfs that compress their object streams,
+// in which case 0 is returned
+func readPDF(filePath string) (bookInfo, error) {
+	var out bookInfo
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return out, err
+	}
+	out.PageCount = int64(len(pdfPageRe.FindAllIndex(data, -1)))
+	return out, nil
+}
+
+// }}}
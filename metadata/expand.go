@@ -0,0 +1,268 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"aiolimas/settings"
+	db_types "aiolimas/types"
+)
+
+type ExpandKind string
+
+const (
+	EK_SEASONS  ExpandKind = "seasons"
+	EK_VOLUMES  ExpandKind = "volumes"
+	EK_EPISODES ExpandKind = "episodes"
+)
+
+func ListExpandKinds() []ExpandKind {
+	return []ExpandKind{EK_SEASONS, EK_VOLUMES, EK_EPISODES}
+}
+
+func (self ExpandKind) singular() string {
+	return strings.TrimSuffix(string(self), "s")
+}
+
+// the name of one child, eg: Season
+func (self ExpandKind) Title() string {
+	return titleCase(self.singular())
+}
+
+// the MediaDependant key that stores which season/volume/episode a child is, eg: Show-season-number
+// this is how children that were already created are recognized
+func (self ExpandKind) NumberKey(ty db_types.MediaTypes) string {
+	return fmt.Sprintf("%s-%s-number", ty, self.singular())
+}
+
+// the Type of a child of an entry of type parent
+// episodes are their own type, seasons and volumes are the same type as what they are a part of
+func (self ExpandKind) ChildType(parent db_types.MediaTypes) db_types.MediaTypes {
+	if self == EK_EPISODES {
+		return db_types.TY_EPISODE
+	}
+	return parent
+}
+
+// a child that an entry can be expanded into
+type Expansion struct {
+	Number int64
+	Title  string // eg: Season 2
+	Meta   db_types.MetadataEntry
+}
+
+// picks what to expand an entry into based on its type
+func DefaultExpandKind(ty db_types.MediaTypes) ExpandKind {
+	switch ty {
+	case db_types.TY_MANGA, db_types.TY_BOOK:
+		return EK_VOLUMES
+	}
+	return EK_SEASONS
+}
+
+func mediaDependantNumber(meta db_types.MetadataEntry, key string) int64 {
+	var mediaDependant map[string]string
+	if err := json.Unmarshal([]byte(meta.MediaDependant), &mediaDependant); err != nil {
+		return 0
+	}
+
+	// some providers store counts as floats, eg: 12.00
+	n, err := strconv.ParseFloat(mediaDependant[key], 64)
+	if err != nil {
+		return 0
+	}
+	return int64(n)
+}
+
+func mediaDependantString(meta db_types.MetadataEntry, key string) string {
+	var mediaDependant map[string]string
+	json.Unmarshal([]byte(meta.MediaDependant), &mediaDependant)
+	return mediaDependant[key]
+}
+
+// the number of the child that meta belongs to, 0 if it is not an expanded child
+func ExpansionNumber(kind ExpandKind, ty db_types.MediaTypes, meta db_types.MetadataEntry) int64 {
+	return mediaDependantNumber(meta, kind.NumberKey(ty))
+}
+
+func mkExpansion(kind ExpandKind, ty db_types.MediaTypes, number int64, extra map[string]string) Expansion {
+	if extra == nil {
+		extra = map[string]string{}
+	}
+	extra[kind.NumberKey(ty)] = fmt.Sprintf("%d", number)
+
+	md, _ := json.Marshal(extra)
+
+	return Expansion{
+		Number: number,
+		Title:  fmt.Sprintf("%s %d", kind.Title(), number),
+		Meta: db_types.MetadataEntry{
+			MediaDependant: string(md),
+		},
+	}
+}
+
+// lists the children that an entry with the given metadata can be expanded into
+// the provider is asked for per child information where it has any,
+// otherwise the counts in meta.MediaDependant are used
+func Expand(kind ExpandKind, ty db_types.MediaTypes, meta db_types.MetadataEntry, us settings.SettingsData) ([]Expansion, error) {
+	switch kind {
+	case EK_SEASONS:
+		switch meta.Provider {
+		case "sonarr":
+			return sonarrSeasons(ty, meta, us)
+		case "omdb":
+			return omdbSeasons(ty, meta)
+		}
+	}
+
+	count := mediaDependantNumber(meta, fmt.Sprintf("%s-%s", ty, kind))
+	if count == 0 {
+		return nil, fmt.Errorf("the metadata does not have the number of %s", kind)
+	}
+
+	out := []Expansion{}
+	for i := int64(1); i <= count; i++ {
+		out = append(out, mkExpansion(kind, ty, i, nil))
+	}
+	return out, nil
+}
+
+func sonarrSeasons(ty db_types.MediaTypes, meta db_types.MetadataEntry, us settings.SettingsData) ([]Expansion, error) {
+	if us.SonarrURL == "" || us.SonarrKey == "" {
+		return nil, errors.New("sonarr is not setup")
+	}
+
+	data, err := sonarrSeries(meta.ProviderID, us.SonarrURL, us.SonarrKey)
+	if err != nil {
+		return nil, err
+	}
+
+	seasons, ok := data["seasons"].([]interface{})
+	if !ok {
+		return nil, errors.New("sonarr did not return any seasons")
+	}
+
+	out := []Expansion{}
+	for _, s := range seasons {
+		season := s.(map[string]interface{})
+		number := int64(season["seasonNumber"].(float64))
+		// specials
+		if number == 0 {
+			continue
+		}
+
+		extra := map[string]string{}
+		if stats, ok := season["statistics"].(map[string]interface{}); ok {
+			if episodes, ok := stats["totalEpisodeCount"].(float64); ok {
+				extra[fmt.Sprintf("%s-episodes", ty)] = fmt.Sprintf("%0.2f", episodes)
+			}
+		}
+
+		expansion := mkExpansion(EK_SEASONS, ty, number, extra)
+		if images, ok := season["images"].([]interface{}); ok && len(images) > 0 {
+			if img, ok := images[0].(map[string]interface{}); ok {
+				expansion.Meta.Thumbnail, _ = img["remoteUrl"].(string)
+			}
+		}
+		out = append(out, expansion)
+	}
+
+	return out, nil
+}
+
+type omdbSeasonResponse struct {
+	TotalSeasons string `json:"totalSeasons"`
+	Episodes     []struct {
+		Title      string
+		Released   string
+		Episode    string
+		ImdbRating string `json:"imdbRating"`
+	}
+	Response string
+	Error    string
+}
+
+func omdbSeason(imdbId string, season int64) (omdbSeasonResponse, error) {
+	var out omdbSeasonResponse
+
+	key := os.Getenv("OMDB_KEY")
+	if key == "" {
+		return out, errors.New("no api key")
+	}
+
+	res, err := providerClient("omdb").Get(fmt.Sprintf(
+		"https://www.omdbapi.com/?apikey=%s&i=%s&Season=%d",
+		key,
+		url.QueryEscape(imdbId),
+		season,
+	))
+	if err != nil {
+		return out, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return out, err
+	}
+
+	if err := json.Unmarshal(body, &out); err != nil {
+		return out, err
+	}
+	if out.Response == "False" {
+		return out, errors.New(out.Error)
+	}
+	return out, nil
+}
+
+func omdbSeasons(ty db_types.MediaTypes, meta db_types.MetadataEntry) ([]Expansion, error) {
+	imdbId := mediaDependantString(meta, "Show-imdbid")
+	if imdbId == "" {
+		imdbId = "tt" + meta.ProviderID
+	}
+
+	// the season response also has the total, so ask for season 1 first
+	first, err := omdbSeason(imdbId, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := strconv.ParseInt(first.TotalSeasons, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid season count: '%s'", first.TotalSeasons)
+	}
+
+	out := []Expansion{}
+	for i := int64(1); i <= total; i++ {
+		season := first
+		if i != 1 {
+			season, err = omdbSeason(imdbId, i)
+			if err != nil {
+				return out, err
+			}
+		}
+
+		extra := map[string]string{
+			fmt.Sprintf("%s-episodes", ty): fmt.Sprintf("%d", len(season.Episodes)),
+		}
+		expansion := mkExpansion(EK_SEASONS, ty, i, extra)
+
+		if len(season.Episodes) > 0 {
+			released := season.Episodes[0].Released
+			if year, err := strconv.ParseInt(strings.Split(released, "-")[0], 10, 64); err == nil {
+				expansion.Meta.ReleaseYear = year
+			}
+		}
+
+		out = append(out, expansion)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,26 @@
+package metadata
+
+import (
+	"testing"
+
+	db_types "aiolimas/types"
+)
+
+func TestExpandKindChildType(t *testing.T) {
+	tests := []struct {
+		kind   ExpandKind
+		parent db_types.MediaTypes
+		want   db_types.MediaTypes
+	}{
+		{EK_SEASONS, db_types.TY_SHOW, db_types.TY_SHOW},
+		{EK_EPISODES, db_types.TY_SHOW, db_types.TY_EPISODE},
+		{EK_VOLUMES, db_types.TY_MANGA, db_types.TY_MANGA},
+		{EK_EPISODES, db_types.TY_DOCUMENTARY, db_types.TY_EPISODE},
+	}
+
+	for _, test := range tests {
+		if got := test.kind.ChildType(test.parent); got != test.want {
+			t.Errorf("%s of a %s are %s, want %s", test.kind, test.parent, got, test.want)
+		}
+	}
+}
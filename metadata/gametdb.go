@@ -550,3 +550,31 @@ func GTDBWiiUIdIdentify(id string, us settings.SettingsData) (db_types.MetadataE
 func GTDBWiiUIdentify(iinfo IdentifyMetadata) ([]db_types.MetadataEntry, error) {
 	return gtdbIdentify("wiiu", iinfo)
 }
+
+func init() {
+	gtdb := []struct {
+		name   string
+		search func(IdentifyMetadata) ([]db_types.MetadataEntry, error)
+		byId   IdIdentifier
+	}{
+		{"gtdbwii", GTDBWiiIdentify, GTDBWiiIdIdentify},
+		{"gtdbgamecube", GTDBGameCubeIdentify, GTDBGameCubeIdIdentify},
+		{"gtdbcustom", GTDBCustomIdentify, GTDBCustomIdIdentify},
+		{"gtdbswitch", GTDBSwitchIdentify, GTDBSwitchIdIdentify},
+		{"gtdbds", GTDBDSIdentify, GTDBDSIdIdentify},
+		{"gtdbwiiu", GTDBWiiUIdentify, GTDBWiiUIdIdentify},
+	}
+
+	for _, p := range gtdb {
+		RegisterProvider(&BasicProvider{
+			ProviderName: p.name,
+			Types:        []db_types.MediaTypes{db_types.TY_GAME},
+			FormatList:   []db_types.Format{gameTDBFormats[p.name]},
+			Settings:     []string{"GTDB_ROOT"},
+			Thumbnails:   true,
+			SearchFn:     p.search,
+			// game id, eg: RMGE01
+			ByIdFn: p.byId,
+		})
+	}
+}
@@ -1,8 +1,15 @@
 package metadata
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"os"
+	"strings"
 
 	"aiolimas/types"
 )
@@ -23,3 +30,122 @@ func ImageProvider(info *GetMetadataInfo) (db_types.MetadataEntry, error) {
 
 	return out, nil
 }
+
+func init() {
+	RegisterProvider(&BasicProvider{
+		ProviderName: "image",
+		Types:        []db_types.MediaTypes{db_types.TY_PICTURE, db_types.TY_MEME},
+		Thumbnails:   true,
+		FetchFn:      ImageProvider,
+	})
+}
+
+// the exif tags that are read from images
+type imageInfo struct {
+	Description string
+	Artist      string
+	Camera      string
+	Taken       string // YYYY:MM:DD HH:MM:SS
+	Width       int
+	Height      int
+}
+
+// reads the ascii tags of a tiff ifd, following the exif sub ifd
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32, tags map[uint16]string, depth int) {
+	if depth > 2 || int(offset)+2 > len(tiff) {
+		return
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := range count {
+		entry := int(offset) + 2 + i*12
+		if entry+12 > len(tiff) {
+			return
+		}
+		tag := order.Uint16(tiff[entry:])
+		kind := order.Uint16(tiff[entry+2:])
+		n := order.Uint32(tiff[entry+4:])
+		value := tiff[entry+8 : entry+12]
+
+		switch {
+		// the exif sub ifd
+		case tag == 0x8769 && kind == 4:
+			readIFD(tiff, order, order.Uint32(value), tags, depth+1)
+		// ascii
+		case kind == 2:
+			data := value
+			if n > 4 {
+				start := order.Uint32(value)
+				if int(start)+int(n) > len(tiff) {
+					continue
+				}
+				data = tiff[start : start+n]
+			} else {
+				data = data[:n]
+			}
+			tags[tag] = strings.TrimSpace(strings.TrimRight(string(data), "\x00"))
+		}
+	}
+}
+
+// the tiff data of a jpeg's exif segment
+func jpegExif(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return nil
+		}
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		// start of scan, there are no more headers
+		if marker == 0xda {
+			return nil
+		}
+		// the length includes its own 2 bytes, anything less is a broken file
+		if length < 2 {
+			return nil
+		}
+		segment := data[i+4 : min(i+2+length, len(data))]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i += 2 + length
+	}
+	return nil
+}
+
+func readImageInfo(path string) (imageInfo, error) {
+	var out imageInfo
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return out, err
+	}
+
+	if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		out.Width = config.Width
+		out.Height = config.Height
+	}
+
+	tiff := jpegExif(data)
+	if len(tiff) < 8 {
+		return out, nil
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if string(tiff[:2]) == "II" {
+		order = binary.LittleEndian
+	}
+
+	tags := map[uint16]string{}
+	readIFD(tiff, order, order.Uint32(tiff[4:]), tags, 0)
+
+	out.Description = tags[0x010e]
+	out.Artist = tags[0x013b]
+	out.Camera = strings.TrimSpace(tags[0x010f] + " " + tags[0x0110])
+	out.Taken = tags[0x9003]
+	if out.Taken == "" {
+		out.Taken = tags[0x0132]
+	}
+	return out, nil
+}
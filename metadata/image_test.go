@@ -0,0 +1,42 @@
+package metadata
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestJpegExif(t *testing.T) {
+	exif := append([]byte("Exif\x00\x00"), []byte("MM\x00\x2a")...)
+	segment := func(marker byte, body []byte) []byte {
+		length := len(body) + 2
+		return append([]byte{0xff, marker, byte(length >> 8), byte(length)}, body...)
+	}
+	jpeg := func(segments ...[]byte) []byte {
+		return append([]byte{0xff, 0xd8}, bytes.Join(segments, nil)...)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{"exif segment", jpeg(segment(0xe1, exif)), exif[6:]},
+		{"exif after another segment", jpeg(segment(0xe0, []byte("JFIF\x00")), segment(0xe1, exif)), exif[6:]},
+		{"no exif before the scan", jpeg(segment(0xda, nil), segment(0xe1, exif)), nil},
+		{"not a jpeg", []byte("\x89PNG\r\n\x1a\n"), nil},
+		{"too short", []byte{0xff, 0xd8}, nil},
+		{"length of 0", jpeg([]byte{0xff, 0xe1, 0x00, 0x00}), nil},
+		{"length of 1", jpeg([]byte{0xff, 0xe1, 0x00, 0x01, 'E'}), nil},
+		{"truncated header", jpeg([]byte{0xff, 0xe1, 0x00}), nil},
+		{"truncated segment", jpeg([]byte{0xff, 0xe1, 0x00, 0x40, 'E', 'x'}), nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := jpegExif(test.data)
+			if !bytes.Equal(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
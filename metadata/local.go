@@ -0,0 +1,363 @@
+package metadata
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"aiolimas/settings"
+	db_types "aiolimas/types"
+)
+
+/*
+	The local provider reads the metadata that is embedded in the files at an entry's Location:
+	audio tags (id3, flac, vorbis/opus), epub metadata, ComicInfo.xml in cbz files, pdf page counts, and exif
+
+	It never makes a request, so it works offline
+*/
+
+var bookExtensions = []string{".epub", ".cbz", ".cbr", ".pdf"}
+
+// the image files that are used as an album's cover when its files have none embedded
+var folderCovers = []string{"cover.jpg", "cover.png", "folder.jpg", "folder.png", "front.jpg", "front.png"}
+
+// stores data in the thumbnails directory the same way /resource/download-thumbnail does
+// returns the url of the thumbnail
+func saveThumbnail(data []byte) (string, error) {
+	sum := sha1.Sum(data)
+	hash := hex.EncodeToString(sum[:])
+
+	dir := fmt.Sprintf("%s/thumbnails/%c", os.Getenv("AIO_DIR"), hash[0])
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, hash)
+	if _, err := os.Stat(path); err != nil {
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return "", err
+		}
+	}
+	return "/api/v1/resource/get-thumbnail?hash=" + hash, nil
+}
+
+func setThumbnail(out *db_types.MetadataEntry, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	thumbnail, err := saveThumbnail(data)
+	if err != nil {
+		return
+	}
+	out.Thumbnail = thumbnail
+}
+
+func minutes(d time.Duration) string {
+	return fmt.Sprintf("%0.2f", d.Minutes())
+}
+
+func setGenres(out *db_types.MetadataEntry, genres []string) {
+	genres = slices.DeleteFunc(genres, func(g string) bool { return g == "" })
+	if len(genres) == 0 {
+		return
+	}
+	if data, err := json.Marshal(genres); err == nil {
+		out.Genres = string(data)
+	}
+}
+
+func setMediaDependant(out *db_types.MetadataEntry, md map[string]string) {
+	for k, v := range md {
+		if v == "" || v == "0" {
+			delete(md, k)
+		}
+	}
+	if data, err := json.Marshal(md); err == nil {
+		out.MediaDependant = string(data)
+	}
+}
+
+// lists the files in dir that match, sorted by name
+func filesIn(dir string, match func(name string) bool) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	out := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") && match(entry.Name()) {
+			out = append(out, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return out, nil
+}
+
+func stem(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+func songMetadata(path string, ty db_types.MediaTypes) (db_types.MetadataEntry, error) {
+	var out db_types.MetadataEntry
+
+	tags, err := readAudioTags(path)
+	if err != nil {
+		return out, err
+	}
+
+	out.Title = tags.Title
+	if out.Title == "" {
+		out.Title = stem(path)
+	}
+	out.ReleaseYear = tags.year()
+	setGenres(&out, []string{tags.Genre})
+	setThumbnail(&out, tags.Cover)
+	setMediaDependant(&out, map[string]string{
+		fmt.Sprintf("%s-length", ty):       minutes(tags.Duration),
+		fmt.Sprintf("%s-artist", ty):       tags.Artist,
+		fmt.Sprintf("%s-album", ty):        tags.Album,
+		fmt.Sprintf("%s-track-number", ty): fmt.Sprintf("%d", tags.Track),
+	})
+	return out, nil
+}
+
+// an album is a folder of audio files
+func albumMetadata(dir string, ty db_types.MediaTypes) (db_types.MetadataEntry, error) {
+	var out db_types.MetadataEntry
+
+	files, err := filesIn(dir, isAudioFile)
+	if err != nil {
+		return out, err
+	}
+	if len(files) == 0 {
+		return out, fmt.Errorf("there are no audio files in %s", dir)
+	}
+
+	var first audioTags
+	var length time.Duration
+	genres := []string{}
+	for i, file := range files {
+		tags, err := readAudioTags(file)
+		if err != nil {
+			continue
+		}
+		if i == 0 || first.Album == "" {
+			cover := first.Cover
+			first = tags
+			if first.Cover == nil {
+				first.Cover = cover
+			}
+		}
+		if first.Cover == nil {
+			first.Cover = tags.Cover
+		}
+		if tags.Genre != "" && !slices.Contains(genres, tags.Genre) {
+			genres = append(genres, tags.Genre)
+		}
+		length += tags.Duration
+	}
+
+	if first.Cover == nil {
+		for _, name := range folderCovers {
+			if data, err := os.ReadFile(filepath.Join(dir, name)); err == nil {
+				first.Cover = data
+				break
+			}
+		}
+	}
+
+	out.Title = first.Album
+	if out.Title == "" {
+		out.Title = filepath.Base(dir)
+	}
+	out.ReleaseYear = first.year()
+	setGenres(&out, genres)
+	setThumbnail(&out, first.Cover)
+	setMediaDependant(&out, map[string]string{
+		fmt.Sprintf("%s-artist", ty): first.Artist,
+		fmt.Sprintf("%s-tracks", ty): fmt.Sprintf("%d", len(files)),
+		fmt.Sprintf("%s-length", ty): minutes(length),
+	})
+	return out, nil
+}
+
+func readBook(path string) (bookInfo, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".epub":
+		return readEPUB(path)
+	case ".cbz":
+		return readCBZ(path)
+	case ".pdf":
+		return readPDF(path)
+	case ".cbr":
+		return bookInfo{}, errors.New("cbr (rar) archives are not supported, convert it to a cbz")
+	}
+	return bookInfo{}, fmt.Errorf("%s is not an epub, cbz, or pdf", filepath.Base(path))
+}
+
+// a book is an epub, cbz, or pdf, or a folder of them where each one is a volume
+func bookMetadata(path string, isDir bool, ty db_types.MediaTypes) (db_types.MetadataEntry, error) {
+	var out db_types.MetadataEntry
+
+	file := path
+	volumes := 0
+	if isDir {
+		files, err := filesIn(path, func(name string) bool {
+			return slices.Contains(bookExtensions, strings.ToLower(filepath.Ext(name)))
+		})
+		if err != nil {
+			return out, err
+		}
+		if len(files) == 0 {
+			return out, fmt.Errorf("there are no books in %s", path)
+		}
+		file = files[0]
+		volumes = len(files)
+	}
+
+	book, err := readBook(file)
+	if err != nil {
+		return out, err
+	}
+
+	if isDir {
+		out.Title = book.Series
+		if out.Title == "" {
+			out.Title = filepath.Base(path)
+		}
+	} else {
+		out.Title = book.Title
+		if out.Title == "" {
+			out.Title = book.Series
+		}
+		if out.Title == "" {
+			out.Title = stem(path)
+		}
+	}
+	out.Description = book.Description
+	out.ReleaseYear = book.year()
+	setGenres(&out, book.Genres)
+	setThumbnail(&out, book.Cover)
+
+	md := map[string]string{
+		fmt.Sprintf("%s-author", ty):    strings.Join(book.Authors, ", "),
+		fmt.Sprintf("%s-publisher", ty): book.Publisher,
+		fmt.Sprintf("%s-language", ty):  book.Language,
+		fmt.Sprintf("%s-isbn10", ty):    book.ISBN10,
+		fmt.Sprintf("%s-isbn13", ty):    book.ISBN13,
+	}
+	if isDir {
+		md[fmt.Sprintf("%s-volumes", ty)] = fmt.Sprintf("%d", volumes)
+	} else {
+		md[fmt.Sprintf("%s-page-count", ty)] = fmt.Sprintf("%d", book.PageCount)
+		// lets EXPAND recognize volumes that were added by hand
+		md[EK_VOLUMES.NumberKey(ty)] = fmt.Sprintf("%d", book.Volume)
+	}
+	setMediaDependant(&out, md)
+	return out, nil
+}
+
+func pictureMetadata(path string, ty db_types.MediaTypes) (db_types.MetadataEntry, error) {
+	var out db_types.MetadataEntry
+
+	info, err := readImageInfo(path)
+	if err != nil {
+		return out, err
+	}
+
+	out.Title = info.Description
+	if out.Title == "" {
+		out.Title = stem(path)
+	}
+	if taken, err := time.Parse("2006:01:02 15:04:05", info.Taken); err == nil {
+		out.ReleaseYear = int64(taken.Year())
+	}
+
+	if data, err := os.ReadFile(path); err == nil {
+		setThumbnail(&out, data)
+	}
+
+	setMediaDependant(&out, map[string]string{
+		fmt.Sprintf("%s-width", ty):  fmt.Sprintf("%d", info.Width),
+		fmt.Sprintf("%s-height", ty): fmt.Sprintf("%d", info.Height),
+		fmt.Sprintf("%s-camera", ty): info.Camera,
+		fmt.Sprintf("%s-artist", ty): info.Artist,
+		fmt.Sprintf("%s-taken", ty):  info.Taken,
+	})
+	return out, nil
+}
+
+func LocalProvider(info *GetMetadataInfo) (db_types.MetadataEntry, error) {
+	entry := info.Entry
+	if entry.Location == "" {
+		return db_types.MetadataEntry{}, errors.New("the entry has no location")
+	}
+
+	us, err := settings.GetUserSettings(info.Uid)
+	if err != nil {
+		return db_types.MetadataEntry{}, err
+	}
+	location := settings.ExpandPathWithLocationAliases(us.LocationAliases, entry.Location)
+
+	stat, err := os.Stat(location)
+	if err != nil {
+		return db_types.MetadataEntry{}, err
+	}
+
+	var out db_types.MetadataEntry
+	switch entry.Type {
+	case db_types.TY_SONG:
+		if stat.IsDir() {
+			return out, errors.New("the location of a song must be a file")
+		}
+		out, err = songMetadata(location, entry.Type)
+	case db_types.TY_ALBUMN, db_types.TY_SOUNDTRACK:
+		if !stat.IsDir() {
+			return out, errors.New("the location of an album must be a folder")
+		}
+		out, err = albumMetadata(location, entry.Type)
+	case db_types.TY_BOOK, db_types.TY_MANGA:
+		out, err = bookMetadata(location, stat.IsDir(), entry.Type)
+	case db_types.TY_PICTURE, db_types.TY_MEME:
+		if stat.IsDir() {
+			return out, errors.New("the location of a picture must be a file")
+		}
+		out, err = pictureMetadata(location, entry.Type)
+	default:
+		return out, fmt.Errorf("local metadata is not supported for %s entries", entry.Type)
+	}
+
+	out.Provider = "local"
+	return out, err
+}
+
+func init() {
+	RegisterProvider(&BasicProvider{
+		ProviderName: "local",
+		Types: []db_types.MediaTypes{
+			db_types.TY_SONG, db_types.TY_ALBUMN, db_types.TY_SOUNDTRACK,
+			db_types.TY_BOOK, db_types.TY_MANGA,
+			db_types.TY_PICTURE, db_types.TY_MEME,
+		},
+		Thumbnails: true,
+		FetchFn:    LocalProvider,
+		// music needs a location, books and manga have better providers
+		RankFn: func(entry *db_types.InfoEntry) int {
+			switch entry.Type {
+			case db_types.TY_SONG, db_types.TY_ALBUMN, db_types.TY_SOUNDTRACK:
+				if entry.Location != "" {
+					return 1
+				}
+			case db_types.TY_PICTURE, db_types.TY_MEME:
+				return 1
+			}
+			return 0
+		},
+	})
+}
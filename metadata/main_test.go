@@ -0,0 +1,30 @@
+package metadata
+
+import (
+	"os"
+	"testing"
+
+	"aiolimas/db"
+)
+
+// some providers look up the user's entries, so the tests share one fresh database
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "aio-metadata-test")
+	if err != nil {
+		panic(err.Error())
+	}
+	os.Setenv("AIO_DIR", dir)
+
+	// the schema is read relative to the root of the repo
+	if err := os.Chdir(".."); err != nil {
+		panic(err.Error())
+	}
+	if err := db.InitDb(); err != nil {
+		panic(err.Error())
+	}
+
+	code := m.Run()
+	db.DB.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,345 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"aiolimas/logging"
+	"aiolimas/settings"
+	db_types "aiolimas/types"
+)
+
+type FieldChange struct {
+	Old any
+	New any
+}
+
+// the MetadataEntry fields that come from providers,
+// these can be locked, merged from different providers, and refreshed
+var mergeableFields = []string{
+	"Title",
+	"Native_Title",
+	"Description",
+	"Rating",
+	"RatingMax",
+	"ReleaseYear",
+	"Thumbnail",
+	"MediaDependant",
+	"Genres",
+	"Country",
+}
+
+func ListMergeableFields() []string {
+	return slices.Clone(mergeableFields)
+}
+
+func IsMergeableField(name string) bool {
+	return slices.Contains(mergeableFields, name)
+}
+
+// a rating is meaningless without the max it is out of, so they are always locked and merged together
+func fieldGroup(name string) []string {
+	switch name {
+	case "Rating", "RatingMax":
+		return []string{"Rating", "RatingMax"}
+	}
+	return []string{name}
+}
+
+func ParseLocks(meta db_types.MetadataEntry) []string {
+	locks := []string{}
+	if meta.Locks == "" {
+		return locks
+	}
+	if err := json.Unmarshal([]byte(meta.Locks), &locks); err != nil {
+		logging.ELog(err)
+	}
+	return locks
+}
+
+func IsLocked(meta db_types.MetadataEntry, field string) bool {
+	locks := ParseLocks(meta)
+	for _, name := range fieldGroup(field) {
+		if slices.Contains(locks, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// adds (or removes if lock is false) fields to meta.Locks
+func SetLocks(meta *db_types.MetadataEntry, fields []string, lock bool) error {
+	locks := ParseLocks(*meta)
+	for _, field := range fields {
+		if !IsMergeableField(field) {
+			return fmt.Errorf("'%s' cannot be locked", field)
+		}
+
+		for _, name := range fieldGroup(field) {
+			has := slices.Contains(locks, name)
+			if lock && !has {
+				locks = append(locks, name)
+			} else if !lock && has {
+				locks = slices.DeleteFunc(locks, func(l string) bool { return l == name })
+			}
+		}
+	}
+
+	text, err := json.Marshal(locks)
+	if err != nil {
+		return err
+	}
+	meta.Locks = string(text)
+	return nil
+}
+
+// the mergeable fields that are different between old and new
+func Diff(old db_types.MetadataEntry, new db_types.MetadataEntry) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+
+	oldValue := reflect.ValueOf(old)
+	newValue := reflect.ValueOf(new)
+	for _, name := range mergeableFields {
+		o := oldValue.FieldByName(name).Interface()
+		n := newValue.FieldByName(name).Interface()
+		if !reflect.DeepEqual(o, n) {
+			changes[name] = FieldChange{Old: o, New: n}
+		}
+	}
+	return changes
+}
+
+// returns fresh, with every field that old has locked set back to old's value
+// old's ItemId, Uid, Datapoints, and Locks are also kept, since providers do not set those
+func ApplyLocks(old db_types.MetadataEntry, fresh db_types.MetadataEntry) db_types.MetadataEntry {
+	fresh.ItemId = old.ItemId
+	fresh.Uid = old.Uid
+	fresh.Datapoints = old.Datapoints
+	fresh.Locks = old.Locks
+
+	oldValue := reflect.ValueOf(old)
+	freshValue := reflect.ValueOf(&fresh).Elem()
+	for _, name := range ParseLocks(old) {
+		if !IsMergeableField(name) {
+			continue
+		}
+		freshValue.FieldByName(name).Set(oldValue.FieldByName(name))
+	}
+	return fresh
+}
+
+// keys in fresh replace keys in old, keys only in old are kept
+// eg: the season number that EXPAND adds to a child
+//
+// returns old as is if nothing changed
+func mergeMediaDependant(old string, fresh string) string {
+	oldData := map[string]any{}
+	freshData := map[string]any{}
+	json.Unmarshal([]byte(old), &oldData)
+	if err := json.Unmarshal([]byte(fresh), &freshData); err != nil {
+		return old
+	}
+
+	changed := false
+	for k, v := range freshData {
+		if !reflect.DeepEqual(oldData[k], v) {
+			oldData[k] = v
+			changed = true
+		}
+	}
+	if !changed {
+		return old
+	}
+
+	out, err := json.Marshal(oldData)
+	if err != nil {
+		return old
+	}
+	return string(out)
+}
+
+// applies fresh on top of old without losing anything the user set:
+// locked fields, Datapoints, thumbnails served by aio (uploaded, or local images),
+// MediaDependant keys that fresh does not have, and any field that fresh left empty are all kept
+//
+// returns the merged entry, and each field that changed
+func MergeRefresh(old db_types.MetadataEntry, fresh db_types.MetadataEntry) (db_types.MetadataEntry, map[string]FieldChange) {
+	changes := map[string]FieldChange{}
+
+	merged := old
+	mergedValue := reflect.ValueOf(&merged).Elem()
+	freshValue := reflect.ValueOf(fresh)
+
+	for _, name := range mergeableFields {
+		if IsLocked(old, name) {
+			continue
+		}
+
+		field := mergedValue.FieldByName(name)
+		newValue := freshValue.FieldByName(name)
+		if newValue.IsZero() {
+			continue
+		}
+
+		switch name {
+		case "Thumbnail":
+			if strings.HasPrefix(old.Thumbnail, "/") {
+				continue
+			}
+		case "MediaDependant":
+			newValue = reflect.ValueOf(mergeMediaDependant(old.MediaDependant, fresh.MediaDependant))
+		}
+
+		if reflect.DeepEqual(field.Interface(), newValue.Interface()) {
+			continue
+		}
+
+		changes[name] = FieldChange{
+			Old: field.Interface(),
+			New: newValue.Interface(),
+		}
+		field.Set(newValue)
+	}
+
+	return merged, changes
+}
+
+// field -> providers in order of priority, for entries of type ty
+// the "*" type applies to every type, the entry's own type overrides it per field
+func FieldPriority(us settings.SettingsData, ty db_types.MediaTypes) map[string][]string {
+	out := map[string][]string{}
+	for field, providers := range us.MetadataPriority["*"] {
+		out[field] = providers
+	}
+	for field, providers := range us.MetadataPriority[string(ty)] {
+		out[field] = providers
+	}
+	return out
+}
+
+// gets metadata for info.Entry from one provider,
+// providers that cannot fetch use the first result of a title search instead
+func FetchFrom(provider string, info *GetMetadataInfo) (meta db_types.MetadataEntry, err error) {
+	// a provider that is only used for some fields should not be able to break the whole fetch
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s returned an unexpected response: %v", provider, r)
+		}
+	}()
+
+	p, ok := GetProvider(provider)
+	if !ok {
+		return db_types.MetadataEntry{}, fmt.Errorf("invalid provider: %s", provider)
+	}
+
+	if HasCapability(p, CAP_FETCH) {
+		return p.Fetch(info)
+	}
+
+	if !HasCapability(p, CAP_SEARCH) || !HasCapability(p, CAP_BY_ID) {
+		return db_types.MetadataEntry{}, unsupported(p, CAP_FETCH)
+	}
+
+	results, err := p.Search(IdentifyMetadata{Title: info.Entry.En_Title, ForUid: info.Uid})
+	if err != nil {
+		return db_types.MetadataEntry{}, err
+	}
+	if len(results) == 0 {
+		return db_types.MetadataEntry{}, errors.New("no results")
+	}
+
+	us, err := settings.GetUserSettings(info.Uid)
+	if err != nil {
+		return db_types.MetadataEntry{}, err
+	}
+	// search results are not entries yet, their ItemId means nothing to the provider
+	if results[0].ProviderID == "" {
+		return db_types.MetadataEntry{}, fmt.Errorf("%s did not give an id for '%s'", provider, info.Entry.En_Title)
+	}
+	return p.ById(results[0].ProviderID, us)
+}
+
+// gets metadata with GetMetadata, then replaces fields with the ones from other providers,
+// following the user's MetadataPriority for the entry's type
+// if a provider in a field's list fails or does not have the field, the next one is tried
+//
+// returns the metadata, and field -> the provider it came from
+func GetMergedMetadata(info *GetMetadataInfo) (db_types.MetadataEntry, map[string]string, error) {
+	sources := map[string]string{}
+
+	base, err := GetMetadata(info)
+	if err != nil {
+		return base, sources, err
+	}
+
+	baseValue := reflect.ValueOf(base)
+	for _, name := range mergeableFields {
+		if !baseValue.FieldByName(name).IsZero() {
+			sources[name] = base.Provider
+		}
+	}
+
+	us, err := settings.GetUserSettings(info.Uid)
+	if err != nil {
+		return base, sources, err
+	}
+
+	priority := FieldPriority(us, info.Entry.Type)
+	if len(priority) == 0 {
+		return base, sources, nil
+	}
+
+	results := map[string]db_types.MetadataEntry{}
+	if base.Provider != "" {
+		results[base.Provider] = base
+	}
+	failed := map[string]bool{}
+
+	// some providers also fill in parts of the entry (eg: Location),
+	// only the main provider is allowed to do that
+	entry := *info.Entry
+	secondaryInfo := *info
+	secondaryInfo.Entry = &entry
+
+	merged := base
+	mergedValue := reflect.ValueOf(&merged).Elem()
+	for _, name := range mergeableFields {
+		// RatingMax is merged with Rating
+		if name == "RatingMax" {
+			continue
+		}
+
+		for _, provider := range priority[name] {
+			result, ok := results[provider]
+			if !ok {
+				if failed[provider] {
+					continue
+				}
+				result, err = FetchFrom(provider, &secondaryInfo)
+				if err != nil {
+					logging.ELog(fmt.Errorf("could not get metadata from %s: %w", provider, err))
+					failed[provider] = true
+					continue
+				}
+				results[provider] = result
+			}
+
+			resultValue := reflect.ValueOf(result)
+			if resultValue.FieldByName(name).IsZero() {
+				continue
+			}
+
+			for _, field := range fieldGroup(name) {
+				mergedValue.FieldByName(field).Set(resultValue.FieldByName(field))
+				sources[field] = provider
+			}
+			break
+		}
+	}
+
+	return merged, sources, nil
+}
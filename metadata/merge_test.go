@@ -0,0 +1,51 @@
+package metadata
+
+import (
+	"testing"
+
+	"aiolimas/settings"
+	db_types "aiolimas/types"
+)
+
+func TestFetchFromSearchResult(t *testing.T) {
+	var gotId string
+	RegisterProvider(&BasicProvider{
+		ProviderName: "test-search",
+		SearchFn: func(info IdentifyMetadata) ([]db_types.MetadataEntry, error) {
+			return []db_types.MetadataEntry{{ProviderID: "RMGE01", Title: info.Title}}, nil
+		},
+		ByIdFn: func(id string, us settings.SettingsData) (db_types.MetadataEntry, error) {
+			gotId = id
+			return db_types.MetadataEntry{Provider: "test-search", ProviderID: id}, nil
+		},
+	})
+	defer delete(providers, "test-search")
+
+	info := GetMetadataInfo{Entry: &db_types.InfoEntry{En_Title: "Super Mario Galaxy"}}
+	meta, err := FetchFrom("test-search", &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotId != "RMGE01" || meta.ProviderID != "RMGE01" {
+		t.Errorf("looked up '%s', want the result's provider id RMGE01", gotId)
+	}
+}
+
+func TestFetchFromNoProviderId(t *testing.T) {
+	RegisterProvider(&BasicProvider{
+		ProviderName: "test-search",
+		SearchFn: func(info IdentifyMetadata) ([]db_types.MetadataEntry, error) {
+			return []db_types.MetadataEntry{{Title: info.Title}}, nil
+		},
+		ByIdFn: func(id string, us settings.SettingsData) (db_types.MetadataEntry, error) {
+			t.Errorf("looked up '%s' for a result without an id", id)
+			return db_types.MetadataEntry{}, nil
+		},
+	})
+	defer delete(providers, "test-search")
+
+	info := GetMetadataInfo{Entry: &db_types.InfoEntry{En_Title: "Super Mario Galaxy"}}
+	if _, err := FetchFrom("test-search", &info); err == nil {
+		t.Error("expected an error")
+	}
+}
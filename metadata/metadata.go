@@ -25,66 +25,40 @@ type GetMetadataInfo struct {
 	Uid           int64
 }
 
-// entryType is used as a hint for where to get the metadata from
+// the entry's type and format pick the provider, see Provider.Rank
 func GetMetadata(info *GetMetadataInfo) (db_types.MetadataEntry, error) {
 	if info.Override != "" {
-		provider, ok := Providers[info.Override]
+		provider, ok := getProviderWith(info.Override, CAP_FETCH)
 		if ok {
-			return provider(info)
+			return provider.Fetch(info)
 		}
 	}
 
 	entry := info.Entry
-	if entry.IsAnime() && (entry.Type == db_types.TY_MANGA ||
-		entry.Type == db_types.TY_SHOW ||
-		entry.Type == db_types.TY_MOVIE ||
-		entry.Type == db_types.TY_MOVIE_SHORT) {
-		return AnilistShow(info)
-	}
 
-	if entry.Format == db_types.F_STEAM {
-		return SteamProvider(info)
+	// an override is a request for a specific provider, so it is never answered with an nfo
+	if us, err := settings.GetUserSettings(info.Uid); err == nil && info.Override == "" && preferNFO(info, us) {
+		return NFOProvider(info)
 	}
 
-	switch entry.Type {
-	case db_types.TY_GAME:
-		return SteamProvider(info)
-
-	case db_types.TY_MANGA:
-		return AnilistManga(info)
-
-	case db_types.TY_BOOK:
-		return GoogleBooksProvider(info)
-
-	case db_types.TY_MOVIE_SHORT:
-		fallthrough
-	case db_types.TY_DOCUMENTARY:
-		fallthrough
-	case db_types.TY_SHOW:
-		fallthrough
-	case db_types.TY_MOVIE:
-		return OMDBProvider(info)
-
-	case db_types.TY_PICTURE:
-		fallthrough
-	case db_types.TY_MEME:
-		return ImageProvider(info)
+	if provider, ok := bestProvider(entry, CAP_FETCH); ok {
+		return provider.Fetch(info)
 	}
 	return db_types.MetadataEntry{}, nil
 }
 
 func Identify(identifySearch IdentifyMetadata, identifier string) ([]db_types.MetadataEntry, string, error) {
-	fn, contains := IdentifyProviders[identifier]
+	provider, contains := getProviderWith(identifier, CAP_SEARCH)
 	if !contains {
 		return []db_types.MetadataEntry{}, "", fmt.Errorf("invalid provider %s", identifier)
 	}
 
-	res, err := fn(identifySearch)
+	res, err := provider.Search(identifySearch)
 	return res, identifier, err
 }
 
 func GetMetadataById(id string, foruid int64, provider string) (db_types.MetadataEntry, error) {
-	fn, contains := IdIdentifiers[provider]
+	p, contains := getProviderWith(provider, CAP_BY_ID)
 	if !contains {
 		return db_types.MetadataEntry{}, fmt.Errorf("invalid provider: %s", provider)
 	}
@@ -94,16 +68,17 @@ func GetMetadataById(id string, foruid int64, provider string) (db_types.Metadat
 		logging.ELog(err)
 		return db_types.MetadataEntry{}, err
 	}
-	return fn(id, us)
+	return p.ById(id, us)
 }
 
 func DetermineBestLocationProvider(info *db_types.InfoEntry, metadata *db_types.MetadataEntry) string {
-	if info.Format&db_types.F_STEAM == db_types.F_STEAM {
-		return "steam"
+	// the ProviderID is an id from the metadata's provider
+	if IsValidLocationProvider(metadata.Provider) {
+		return metadata.Provider
 	}
 
-	if metadata.Provider == "sonarr" {
-		return "sonarr"
+	if p, ok := bestProvider(info, CAP_LOCATION); ok {
+		return p.Name()
 	}
 
 	// could not determine
@@ -111,7 +86,7 @@ func DetermineBestLocationProvider(info *db_types.InfoEntry, metadata *db_types.
 }
 
 func GetLocation(providerID string, foruid int64, provider string) (string, error) {
-	fn, contains := LocationFinders[provider]
+	p, contains := getProviderWith(provider, CAP_LOCATION)
 	if !contains {
 		return "", fmt.Errorf("invalid provider: %s", provider)
 	}
@@ -123,113 +98,42 @@ func GetLocation(providerID string, foruid int64, provider string) (string, erro
 
 	logging.Info(fmt.Sprintf("location lookup using provider: %s", provider))
 
-	return fn(&us, providerID)
+	return p.Location(&us, providerID)
 }
 
 func ListMetadataProviders() []string {
-	keys := make([]string, 0, len(Providers))
-	for k := range Providers {
-		keys = append(keys, k)
+	keys := []string{}
+	for _, p := range ListProviders(CAP_FETCH) {
+		keys = append(keys, p.Name())
 	}
 	return keys
 }
 
 func IsValidLocationProvider(name string) bool {
-	_, contains := LocationFinders[name]
+	_, contains := getProviderWith(name, CAP_LOCATION)
 	return contains
 }
 
 func IsValidProvider(name string) bool {
-	_, contains := Providers[name]
+	_, contains := getProviderWith(name, CAP_FETCH)
 	return contains
 }
 
 func IsValidIdentifier(name string) bool {
-	_, contains := IdentifyProviders[name]
+	_, contains := getProviderWith(name, CAP_SEARCH)
 	return contains
 }
 
 func IsValidIdIdentifier(name string) bool {
-	_, contains := IdIdentifiers[name]
+	_, contains := getProviderWith(name, CAP_BY_ID)
 	return contains
 }
 
 // parameters: userSettings item_metadata
 type LocationFunc func(*settings.SettingsData, string) (string, error)
 
-type LocationMap map[string]LocationFunc
-
-var LocationFinders LocationMap = LocationMap{
-	"steam":  SteamLocationFinder,
-	"sonarr": SonarrGetLocation,
-	"radarr": RadarrGetLocation,
-}
-
-type ProviderFunc func(*GetMetadataInfo) (db_types.MetadataEntry, error)
-
-type ProviderMap map[string]ProviderFunc
-
 // uses an entry's heuristics to find the correct metadata
-var Providers ProviderMap = ProviderMap{
-	"anilist":       AnlistProvider,
-	"anilist-manga": AnilistManga,
-	"anilist-show":  AnilistShow,
-	"omdb":          OMDBProvider,
-	"sonarr":        SonarrProvider,
-	"radarr":        RadarrProvider,
-	"image":         ImageProvider,
-	"steam":         SteamProvider,
-	"googlebooks":   GoogleBooksProvider,
-	"seerr":         SeerrProvider,
-}
-
-type IdentifiersMap = map[string]func(info IdentifyMetadata) ([]db_types.MetadataEntry, error)
-
-// uses a search query
-var IdentifyProviders IdentifiersMap = IdentifiersMap{
-	"anilist":     AnilistIdentifier,
-	"omdb":        OmdbIdentifier,
-	"sonarr":      SonarrIdentifier,
-	"radarr":      RadarrIdentifier,
-	"steam":       SteamIdentifier,
-	"googlebooks": GoogleBooksIdentifier,
-	"seerr":       SeerrIdentifier,
-	"wikipedia":   WikipediaIdentifier,
-	"gtdbwii":   GTDBWiiIdentify,
-	"gtdbgamecube":        GTDBGameCubeIdentify,
-	"gtdbcustom":        GTDBCustomIdentify,
-	"gtdbswitch":   GTDBSwitchIdentify,
-	"gtdbds":   GTDBDSIdentify,
-	"gtdbwiiu":   GTDBWiiUIdentify,
-}
-
-type (
-	IdIdentifier     func(id string, us settings.SettingsData) (db_types.MetadataEntry, error)
-	IdIdentifiersMap = map[string]IdIdentifier
-)
+type ProviderFunc func(*GetMetadataInfo) (db_types.MetadataEntry, error)
 
 // does an id lookup
-var IdIdentifiers IdIdentifiersMap = IdIdentifiersMap{
-	// anilist id
-	"anilist": AnilistById,
-	// imdb id (without the tt)
-	"omdb": OmdbIdIdentifier,
-	// sonarr id
-	"sonarr": SonarrIdIdentifier,
-	// radarr id
-	"radarr": RadarrIdIdentifier,
-	// steam id
-	"steam": SteamIdIdentifier,
-	// isbn
-	"openlibrary": OpenLibraryIdIdentifier,
-	// isbn
-	"googlebooks": GoogleBooksIdIdentifier,
-	"seerr":       SeerrIdIdentifier,
-	"wikipedia":       WikipediaIdIdentifier,
-	"gtdbwii":        GTDBWiiIdIdentify,
-	"gtdbgamecube":        GTDBGameCubeIdIdentify,
-	"gtdbcustom":        GTDBCustomIdIdentify,
-	"gtdbswitch":   GTDBSwitchIdIdentify,
-	"gtdbds":   GTDBDSIdIdentify,
-	"gtdbwiiu":   GTDBWiiUIdIdentify,
-}
+type IdIdentifier func(id string, us settings.SettingsData) (db_types.MetadataEntry, error)
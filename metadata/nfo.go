@@ -1,41 +1,628 @@
 package metadata
 
 import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 
+	"aiolimas/db"
+	"aiolimas/settings"
 	"aiolimas/types"
 )
 
-//returns the path to nfo file, or "" if no path exists
-func NFOExists(entry *db_types.InfoEntry) string {
-	location := entry.Location
+/*
+	NFO files are the xml files that kodi, jellyfin, and emby keep next to media
+	https://kodi.wiki/view/NFO_files
 
-	stat, err := os.Stat(entry.Location)
-	if  err != nil {
+	The id of an nfo result is the path to the nfo file, with location aliases condensed
+
+	nfos that aio writes have a <generator> element, those are only a copy of what a provider gave,
+	so they are not preferred over the provider (see preferNFO)
+*/
+
+// the text of the <generator> element in the nfos that aio writes
+const nfoGenerator = "aiolimas"
+
+// the names an nfo can have inside of a folder, in order of preference
+var folderNFONames = []string{"tvshow.nfo", "movie.nfo", "album.nfo", "season.nfo"}
+
+// the uniqueid types that are stored in MediaDependant as {Type}-{id type}id, eg: Movie-imdbid
+var nfoIdTypes = []string{"imdb", "tmdb", "tvdb", "anilist", "musicbrainz"}
+
+// returns the path to the nfo file for location, or "" if no nfo exists
+// location must already have its location aliases expanded
+func NFOExists(location string) string {
+	stat, err := os.Stat(location)
+	if err != nil {
 		return ""
 	}
 
-	expectedNFO := fmt.Sprintf("%s.nfo", stat.Name())
-
+	candidates := []string{}
 	if stat.IsDir() {
-		entries, err := os.ReadDir(location)
-		if err != nil{
-			return ""
+		for _, name := range folderNFONames {
+			candidates = append(candidates, path.Join(location, name))
 		}
-		for _, entry := range entries {
-			if entry.Name() == expectedNFO {
-				return path.Join(location, entry.Name())
-			}
+		candidates = append(candidates, path.Join(location, stat.Name()+".nfo"))
+	} else {
+		candidates = append(candidates, strings.TrimSuffix(location, path.Ext(location))+".nfo")
+	}
+
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+type nfoRating struct {
+	Name    string `xml:"name,attr"`
+	Max     string `xml:"max,attr"`
+	Default string `xml:"default,attr"`
+	Value   string `xml:"value"`
+}
+
+type nfoUniqueId struct {
+	Type    string `xml:"type,attr"`
+	Default string `xml:"default,attr"`
+	Value   string `xml:",chardata"`
+}
+
+type nfoThumb struct {
+	Aspect string `xml:"aspect,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// the parts of movie, tvshow, episodedetails, and album nfos that we use
+type nfoFile struct {
+	XMLName       xml.Name
+	Title         string        `xml:"title"`
+	OriginalTitle string        `xml:"originaltitle"`
+	Plot          string        `xml:"plot"`
+	Review        string        `xml:"review"` // albums have a review instead of a plot
+	Year          string        `xml:"year"`
+	Premiered     string        `xml:"premiered"`
+	Aired         string        `xml:"aired"`
+	ReleaseDate   string        `xml:"releasedate"`
+	Genres        []string      `xml:"genre"`
+	Countries     []string      `xml:"country"`
+	Rating        string        `xml:"rating"` // older nfos have a single rating out of 10
+	Ratings       []nfoRating   `xml:"ratings>rating"`
+	UniqueIds     []nfoUniqueId `xml:"uniqueid"`
+	Id            string        `xml:"id"`
+	Thumbs        []nfoThumb    `xml:"thumb"`
+	Runtime       string        `xml:"runtime"`
+	Generator     string        `xml:"generator"`
+}
+
+// the root element for each type that nfos can be written for
+func nfoRoot(ty db_types.MediaTypes) (string, bool) {
+	switch ty {
+	case db_types.TY_MOVIE, db_types.TY_MOVIE_SHORT, db_types.TY_DOCUMENTARY:
+		return "movie", true
+	case db_types.TY_SHOW:
+		return "tvshow", true
+	case db_types.TY_ALBUMN, db_types.TY_SOUNDTRACK:
+		return "album", true
+	}
+	return "", false
+}
+
+func CanWriteNFO(ty db_types.MediaTypes) bool {
+	_, ok := nfoRoot(ty)
+	return ok
+}
+
+func nfoType(root string) db_types.MediaTypes {
+	switch root {
+	case "movie":
+		return db_types.TY_MOVIE
+	case "album":
+		return db_types.TY_ALBUMN
+	}
+	// tvshow, season, episodedetails
+	return db_types.TY_SHOW
+}
+
+func (self *nfoFile) rating() (float64, float64) {
+	for _, rating := range self.Ratings {
+		if rating.Default != "true" && len(self.Ratings) > 1 {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(rating.Value), 64)
+		if err != nil {
+			continue
+		}
+		max, err := strconv.ParseFloat(rating.Max, 64)
+		if err != nil || max == 0 {
+			max = 10
+		}
+		return value, max
+	}
+
+	if value, err := strconv.ParseFloat(strings.TrimSpace(self.Rating), 64); err == nil {
+		return value, 10
+	}
+	return 0, 0
+}
+
+func (self *nfoFile) year() int64 {
+	for _, date := range []string{self.Year, self.Premiered, self.Aired, self.ReleaseDate} {
+		date = strings.TrimSpace(date)
+		if len(date) < 4 {
+			continue
+		}
+		if year, err := strconv.ParseInt(date[:4], 10, 64); err == nil {
+			return year
+		}
+	}
+	return 0
+}
+
+func (self *nfoFile) thumbnail() string {
+	thumbs := slices.Clone(self.Thumbs)
+	// posters first
+	slices.SortStableFunc(thumbs, func(a nfoThumb, b nfoThumb) int {
+		if a.Aspect == "poster" && b.Aspect != "poster" {
+			return -1
+		}
+		if b.Aspect == "poster" && a.Aspect != "poster" {
+			return 1
+		}
+		return 0
+	})
+	for _, thumb := range thumbs {
+		url := strings.TrimSpace(thumb.Value)
+		// local images cannot be served as a thumbnail
+		if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+			return url
 		}
-		return ""
 	}
+	return ""
+}
+
+func ParseNFO(nfoPath string) (db_types.MetadataEntry, error) {
+	var out db_types.MetadataEntry
 
-	fullExpectedNFO := path.Join(location, expectedNFO)
-	_, err = os.Stat(fullExpectedNFO)
-	if err != nil{
+	data, err := os.ReadFile(nfoPath)
+	if err != nil {
+		return out, err
+	}
+
+	var nfo nfoFile
+	if err := xml.Unmarshal(data, &nfo); err != nil {
+		return out, fmt.Errorf("could not parse %s: %w", nfoPath, err)
+	}
+
+	ty := nfoType(nfo.XMLName.Local)
+
+	out.Provider = "nfo"
+	out.Title = strings.TrimSpace(nfo.Title)
+	if original := strings.TrimSpace(nfo.OriginalTitle); original != out.Title {
+		out.Native_Title = original
+	}
+	out.Description = strings.TrimSpace(nfo.Plot)
+	if out.Description == "" {
+		out.Description = strings.TrimSpace(nfo.Review)
+	}
+	out.ReleaseYear = nfo.year()
+	out.Rating, out.RatingMax = nfo.rating()
+	out.Thumbnail = nfo.thumbnail()
+
+	if len(nfo.Genres) > 0 {
+		genres, err := json.Marshal(nfo.Genres)
+		if err == nil {
+			out.Genres = string(genres)
+		}
+	}
+	if len(nfo.Countries) > 0 {
+		out.Country = strings.TrimSpace(nfo.Countries[0])
+	}
+
+	mediaDependant := map[string]string{}
+	for _, id := range nfo.UniqueIds {
+		if slices.Contains(nfoIdTypes, id.Type) {
+			mediaDependant[fmt.Sprintf("%s-%sid", ty, id.Type)] = strings.TrimSpace(id.Value)
+		}
+	}
+	// older nfos only have an imdb id
+	imdbKey := fmt.Sprintf("%s-imdbid", ty)
+	if id := strings.TrimSpace(nfo.Id); strings.HasPrefix(id, "tt") && mediaDependant[imdbKey] == "" {
+		mediaDependant[imdbKey] = id
+	}
+	if runtime := strings.TrimSpace(nfo.Runtime); runtime != "" && ty == db_types.TY_MOVIE {
+		mediaDependant["Movie-length"] = runtime
+	}
+	md, err := json.Marshal(mediaDependant)
+	if err != nil {
+		return out, err
+	}
+	out.MediaDependant = string(md)
+
+	return out, nil
+}
+
+// if the nfo at nfoPath was written by aio
+func IsGeneratedNFO(nfoPath string) bool {
+	data, err := os.ReadFile(nfoPath)
+	if err != nil {
+		return false
+	}
+	var nfo nfoFile
+	if err := xml.Unmarshal(data, &nfo); err != nil {
+		return false
+	}
+	return strings.TrimSpace(nfo.Generator) == nfoGenerator
+}
+
+// an nfo that the user (or another media server) curated is preferred over any provider
+// one that aio wrote is only used if there is no provider to go back to
+func preferNFO(info *GetMetadataInfo, us settings.SettingsData) bool {
+	nfoPath := nfoForEntry(info.Entry, us)
+	if nfoPath == "" {
+		return false
+	}
+	if !IsGeneratedNFO(nfoPath) {
+		return true
+	}
+	return info.MetadataEntry == nil || info.MetadataEntry.ProviderID == ""
+}
+
+func nfoForEntry(entry *db_types.InfoEntry, us settings.SettingsData) string {
+	if entry.Location == "" {
 		return ""
 	}
-	return fullExpectedNFO
+	return NFOExists(settings.ExpandPathWithLocationAliases(us.LocationAliases, entry.Location))
+}
+
+func NFOProvider(info *GetMetadataInfo) (db_types.MetadataEntry, error) {
+	us, err := settings.GetUserSettings(info.Uid)
+	if err != nil {
+		return db_types.MetadataEntry{}, err
+	}
+
+	nfoPath := nfoForEntry(info.Entry, us)
+	if nfoPath == "" {
+		return db_types.MetadataEntry{}, fmt.Errorf("there is no nfo file at %s", info.Entry.Location)
+	}
+
+	out, err := ParseNFO(nfoPath)
+	out.ProviderID = settings.CondensePathWithLocationAliases(us.LocationAliases, nfoPath)
+	return out, err
+}
+
+// if path is dir, or inside of it
+func isInside(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// the id is a path, so only nfos that belong to the user's media can be read:
+// ones inside of a library root, a location alias, or the Location of one of the user's entries
+func nfoAllowed(nfoPath string, us settings.SettingsData) (bool, error) {
+	// symlinks could point out of the folders
+	if resolved, err := filepath.EvalSymlinks(nfoPath); err == nil {
+		nfoPath = resolved
+	}
+	nfoPath = filepath.Clean(nfoPath)
+
+	dirs := []string{}
+	for _, root := range us.LibraryRoots {
+		dirs = append(dirs, root.Path)
+	}
+	for _, dir := range us.LocationAliases {
+		dirs = append(dirs, dir)
+	}
+
+	locations, err := db.ListLocations(us.Uid)
+	if err != nil {
+		return false, err
+	}
+	for _, location := range locations {
+		location = settings.ExpandPathWithLocationAliases(us.LocationAliases, location)
+		if stat, err := os.Stat(location); err == nil && !stat.IsDir() {
+			// an entry that is a file only has the nfo next to it
+			location = strings.TrimSuffix(location, path.Ext(location)) + ".nfo"
+		}
+		dirs = append(dirs, location)
+	}
+
+	for _, dir := range dirs {
+		dir = settings.ExpandPathWithLocationAliases(us.LocationAliases, dir)
+		if dir == "" {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = resolved
+		}
+		if isInside(filepath.Clean(dir), nfoPath) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func NFOById(id string, us settings.SettingsData) (db_types.MetadataEntry, error) {
+	if path.Ext(id) != ".nfo" {
+		return db_types.MetadataEntry{}, fmt.Errorf("%s is not an nfo file", id)
+	}
+
+	nfoPath := settings.ExpandPathWithLocationAliases(us.LocationAliases, id)
+	allowed, err := nfoAllowed(nfoPath, us)
+	if err != nil {
+		return db_types.MetadataEntry{}, err
+	}
+	if !allowed {
+		return db_types.MetadataEntry{}, fmt.Errorf("%s is not in a library root, or an entry's location", id)
+	}
+
+	out, err := ParseNFO(nfoPath)
+	out.ProviderID = id
+	return out, err
+}
+
+// a generic xml element, so that the parts of an existing nfo that we do not know about are kept
+type xmlNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Text     string     `xml:",chardata"`
+	Children []xmlNode  `xml:",any"`
+}
+
+func textNode(name string, text string, attrs ...xml.Attr) xmlNode {
+	return xmlNode{XMLName: xml.Name{Local: name}, Text: text, Attrs: attrs}
+}
+
+func attr(name string, value string) xml.Attr {
+	return xml.Attr{Name: xml.Name{Local: name}, Value: value}
+}
+
+// the indentation of the original file would be written as text
+func (self *xmlNode) trimText() {
+	if len(self.Children) > 0 {
+		self.Text = strings.TrimSpace(self.Text)
+	}
+	for i := range self.Children {
+		self.Children[i].trimText()
+	}
+}
+
+// the elements that meta is written as, elements for empty fields are left out
+func metadataNFONodes(ty db_types.MediaTypes, meta db_types.MetadataEntry) []xmlNode {
+	nodes := []xmlNode{}
+	if meta.Title != "" {
+		nodes = append(nodes, textNode("title", meta.Title))
+	}
+	if meta.Native_Title != "" {
+		nodes = append(nodes, textNode("originaltitle", meta.Native_Title))
+	}
+	if meta.Description != "" {
+		if ty == db_types.TY_ALBUMN || ty == db_types.TY_SOUNDTRACK {
+			nodes = append(nodes, textNode("review", meta.Description))
+		} else {
+			nodes = append(nodes, textNode("plot", meta.Description))
+		}
+	}
+	if meta.ReleaseYear != 0 {
+		nodes = append(nodes, textNode("year", strconv.FormatInt(meta.ReleaseYear, 10)))
+	}
+
+	var genres []string
+	json.Unmarshal([]byte(meta.Genres), &genres)
+	for _, genre := range genres {
+		nodes = append(nodes, textNode("genre", genre))
+	}
+
+	if meta.Country != "" {
+		nodes = append(nodes, textNode("country", meta.Country))
+	}
+
+	// a rating from the nfo is already in it, with the name of where it came from
+	if meta.Rating != 0 && meta.Provider != "nfo" {
+		max := meta.RatingMax
+		if max == 0 {
+			max = 10
+		}
+		name := meta.Provider
+		switch name {
+		case "":
+			name = "aio"
+		case "omdb":
+			// the rating omdb gives is the imdb rating
+			name = "imdb"
+		}
+		rating := textNode("rating", "", attr("name", name), attr("max", strconv.FormatFloat(max, 'f', -1, 64)), attr("default", "true"))
+		rating.Children = []xmlNode{textNode("value", strconv.FormatFloat(meta.Rating, 'f', -1, 64))}
+		ratings := textNode("ratings", "")
+		ratings.Children = []xmlNode{rating}
+		nodes = append(nodes, ratings)
+	}
+
+	var mediaDependant map[string]string
+	json.Unmarshal([]byte(meta.MediaDependant), &mediaDependant)
+	for _, idType := range nfoIdTypes {
+		id := mediaDependant[fmt.Sprintf("%s-%sid", ty, idType)]
+		if idType == "anilist" && id == "" && strings.HasPrefix(meta.Provider, "anilist") {
+			id = meta.ProviderID
+		}
+		if id != "" {
+			nodes = append(nodes, textNode("uniqueid", id, attr("type", idType)))
+		}
+	}
+
+	if strings.HasPrefix(meta.Thumbnail, "http://") || strings.HasPrefix(meta.Thumbnail, "https://") {
+		nodes = append(nodes, textNode("thumb", meta.Thumbnail, attr("aspect", "poster")))
+	}
+
+	nodes = append(nodes, textNode("generator", nfoGenerator))
+
+	return nodes
+}
+
+func nodeAttr(node xmlNode, name string) string {
+	for _, a := range node.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// writes meta as an nfo for an entry of type ty
+// if existing is not empty, it is the current nfo, and every element that meta does not set is kept (eg: actors)
+func MarshalNFO(ty db_types.MediaTypes, meta db_types.MetadataEntry, existing []byte) ([]byte, error) {
+	rootName, ok := nfoRoot(ty)
+	if !ok {
+		return nil, fmt.Errorf("nfo files cannot be written for %s entries", ty)
+	}
+
+	root := xmlNode{XMLName: xml.Name{Local: rootName}}
+	if len(existing) > 0 {
+		if err := xml.Unmarshal(existing, &root); err != nil {
+			return nil, fmt.Errorf("could not parse the existing nfo: %w", err)
+		}
+		root.trimText()
+	}
+
+	nodes := metadataNFONodes(ty, meta)
+
+	// nodes take the place of the first existing element with the same name, so that the order of the file is kept
+	// uniqueids are matched by type, and only have their value changed
+	byName := map[string][]xmlNode{}
+	order := []string{}
+	uniqueIds := map[string]string{}
+	for _, node := range nodes {
+		name := node.XMLName.Local
+		if name == "uniqueid" {
+			uniqueIds[nodeAttr(node, "type")] = node.Text
+			continue
+		}
+		if _, ok := byName[name]; !ok {
+			order = append(order, name)
+		}
+		byName[name] = append(byName[name], node)
+	}
+	// the legacy rating is replaced by ratings
+	if _, ok := byName["ratings"]; ok {
+		byName["rating"] = nil
+	}
+
+	children := []xmlNode{}
+	written := map[string]bool{}
+	for _, child := range root.Children {
+		name := child.XMLName.Local
+		if name == "uniqueid" {
+			idType := nodeAttr(child, "type")
+			if id, ok := uniqueIds[idType]; ok {
+				child.Text = id
+				delete(uniqueIds, idType)
+			}
+			children = append(children, child)
+			continue
+		}
+
+		replacement, ok := byName[name]
+		if !ok {
+			children = append(children, child)
+			continue
+		}
+		if !written[name] {
+			children = append(children, replacement...)
+			written[name] = true
+		}
+	}
+	for _, name := range order {
+		if !written[name] {
+			children = append(children, byName[name]...)
+		}
+	}
+	for _, node := range nodes {
+		if node.XMLName.Local == "uniqueid" {
+			if _, ok := uniqueIds[nodeAttr(node, "type")]; ok {
+				children = append(children, node)
+			}
+		}
+	}
+	root.Children = children
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(root); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// where the nfo for location is written, an existing nfo is overwritten
+func nfoWritePath(location string, ty db_types.MediaTypes) (string, error) {
+	if existing := NFOExists(location); existing != "" {
+		return existing, nil
+	}
+
+	stat, err := os.Stat(location)
+	if err != nil {
+		return "", err
+	}
+	if !stat.IsDir() {
+		return strings.TrimSuffix(location, path.Ext(location)) + ".nfo", nil
+	}
+
+	rootName, _ := nfoRoot(ty)
+	return path.Join(location, rootName+".nfo"), nil
+}
+
+// returns the path that the nfo for entry would be written to, and its contents
+func BuildNFO(entry *db_types.InfoEntry, meta db_types.MetadataEntry, us settings.SettingsData) (string, []byte, error) {
+	if entry.Location == "" {
+		return "", nil, errors.New("the entry has no location")
+	}
+
+	location := settings.ExpandPathWithLocationAliases(us.LocationAliases, entry.Location)
+	nfoPath, err := nfoWritePath(location, entry.Type)
+	if err != nil {
+		return "", nil, err
+	}
+
+	existing, err := os.ReadFile(nfoPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", nil, err
+	}
+
+	data, err := MarshalNFO(entry.Type, meta, existing)
+	return nfoPath, data, err
+}
+
+// writes meta to the nfo next to entry's Location, so that other media servers use it
+// returns the path that was written
+func WriteNFO(entry *db_types.InfoEntry, meta db_types.MetadataEntry, us settings.SettingsData) (string, error) {
+	nfoPath, data, err := BuildNFO(entry, meta, us)
+	if err != nil {
+		return "", err
+	}
+
+	tmp := nfoPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return "", err
+	}
+	return nfoPath, os.Rename(tmp, nfoPath)
+}
+
+func init() {
+	RegisterProvider(&BasicProvider{
+		ProviderName: "nfo",
+		Thumbnails:   true,
+		FetchFn:      NFOProvider,
+		ByIdFn:       NFOById,
+	})
 }
@@ -0,0 +1,93 @@
+package metadata
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"aiolimas/settings"
+	db_types "aiolimas/types"
+)
+
+func TestGeneratedNFO(t *testing.T) {
+	dir := t.TempDir()
+
+	written := filepath.Join(dir, "written.nfo")
+	data, err := MarshalNFO(db_types.TY_MOVIE, db_types.MetadataEntry{Title: "Written"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(written, data, 0o644)
+
+	curated := filepath.Join(dir, "curated.nfo")
+	os.WriteFile(curated, []byte(`<movie><title>Curated</title></movie>`), 0o644)
+
+	tests := []struct {
+		name       string
+		nfo        string
+		providerId string
+		want       bool
+	}{
+		{"a curated nfo is preferred", curated, "tt123", true},
+		{"a written nfo is not preferred over a provider", written, "tt123", false},
+		{"a written nfo is used if there is no provider", written, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			movie := filepath.Join(dir, "movie.mkv")
+			os.WriteFile(movie, nil, 0o644)
+			os.Remove(filepath.Join(dir, "movie.nfo"))
+			if err := os.Link(test.nfo, filepath.Join(dir, "movie.nfo")); err != nil {
+				t.Fatal(err)
+			}
+
+			info := GetMetadataInfo{
+				Entry:         &db_types.InfoEntry{Location: movie},
+				MetadataEntry: &db_types.MetadataEntry{ProviderID: test.providerId},
+			}
+			if got := preferNFO(&info, settings.SettingsData{}); got != test.want {
+				t.Errorf("preferNFO() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNFOById(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	outside := filepath.Join(dir, "outside")
+	os.Mkdir(root, 0o755)
+	os.Mkdir(outside, 0o755)
+
+	nfo := []byte(`<movie><title>Movie</title></movie>`)
+	os.WriteFile(filepath.Join(root, "movie.nfo"), nfo, 0o644)
+	os.WriteFile(filepath.Join(outside, "movie.nfo"), nfo, 0o644)
+	os.Symlink(filepath.Join(outside, "movie.nfo"), filepath.Join(root, "link.nfo"))
+
+	us := settings.SettingsData{
+		Uid:             1,
+		LocationAliases: map[string]string{"MEDIA": root},
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		ok   bool
+	}{
+		{"inside an alias", "${MEDIA}/movie.nfo", true},
+		{"outside of every folder", filepath.Join(outside, "movie.nfo"), false},
+		{"escapes with ..", "${MEDIA}/../outside/movie.nfo", false},
+		{"symlink out of the folder", "${MEDIA}/link.nfo", false},
+		{"not an nfo", "${MEDIA}/movie.mkv", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NFOById(test.id, us)
+			if (err == nil) != test.ok {
+				t.Errorf("NFOById(%s) error = %v, want ok = %v", test.id, err, test.ok)
+			}
+		})
+	}
+}
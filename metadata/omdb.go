@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"net/url"
 	"os"
 	"strconv"
@@ -345,6 +344,9 @@ func omdbResultToMetadata(result OMDBResponse) (db_types.MetadataEntry, error) {
 
 		mediaDep["Show-episode-duration"] = duration
 		mediaDep["Show-imdbid"] = result.ImdbID
+		if _, err := strconv.ParseInt(result.TotalSeasons, 10, 64); err == nil {
+			mediaDep["Show-seasons"] = result.TotalSeasons
+		}
 	} else {
 		length := strings.Split(result.Runtime, " ")[0]
 
@@ -436,7 +438,7 @@ func OMDBProvider(info *GetMetadataInfo) (db_types.MetadataEntry, error) {
 		url.QueryEscape(search),
 	)
 
-	res, err := http.Get(url)
+	res, err := providerClient("omdb").Get(url)
 	if err != nil {
 		return out, err
 	}
@@ -478,7 +480,7 @@ func OmdbIdentifier(info IdentifyMetadata) ([]db_types.MetadataEntry, error) {
 		url.QueryEscape(searchTitle),
 	)
 
-	res, err := http.Get(url)
+	res, err := providerClient("omdb").Get(url)
 	if err != nil {
 		return outMeta, err
 	}
@@ -537,7 +539,7 @@ func OmdbIdIdentifier(id string, us settings.SettingsData) (db_types.MetadataEnt
 		url.QueryEscape("tt"+id),
 	)
 
-	res, err := http.Get(url)
+	res, err := providerClient("omdb").Get(url)
 	if err != nil {
 		return out, err
 	}
@@ -557,3 +559,19 @@ func OmdbIdIdentifier(id string, us settings.SettingsData) (db_types.MetadataEnt
 
 	return omdbResultToMetadata(*jData)
 }
+
+func init() {
+	RegisterProvider(&BasicProvider{
+		ProviderName: "omdb",
+		Types:        []db_types.MediaTypes{db_types.TY_SHOW, db_types.TY_MOVIE, db_types.TY_MOVIE_SHORT, db_types.TY_DOCUMENTARY},
+		Settings:     []string{"OMDB_KEY"},
+		Thumbnails:   true,
+		FetchFn:      OMDBProvider,
+		RankFn: func(entry *db_types.InfoEntry) int {
+			return 1
+		},
+		SearchFn:     OmdbIdentifier,
+		// imdb id (without the tt)
+		ByIdFn: OmdbIdIdentifier,
+	})
+}
@@ -0,0 +1,228 @@
+package metadata
+
+import (
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"aiolimas/settings"
+	db_types "aiolimas/types"
+)
+
+type Capability string
+
+const (
+	CAP_FETCH      Capability = "fetch"      // find metadata for an entry, see GetMetadata
+	CAP_SEARCH     Capability = "search"     // list results for a title, see Identify
+	CAP_BY_ID      Capability = "by-id"      // get metadata from the provider's id, see GetMetadataById
+	CAP_LOCATION   Capability = "location"   // find where an entry is on disk, see GetLocation
+	CAP_THUMBNAILS Capability = "thumbnails" // the metadata includes a thumbnail
+)
+
+type Provider interface {
+	Name() string
+
+	// if empty, any type/format is supported
+	MediaTypes() []db_types.MediaTypes
+	Formats() []db_types.Format
+
+	Capabilities() []Capability
+
+	// how well the provider fits an entry that it supports, the highest is used by GetMetadata
+	// 0 means the provider is only used when it is asked for by name
+	Rank(entry *db_types.InfoEntry) int
+
+	// the names of SettingsData fields, or environment variables that must be set to use the provider
+	RequiredSettings() []string
+
+	// the functions for capabilities that the provider does not have return an error
+	Fetch(info *GetMetadataInfo) (db_types.MetadataEntry, error)
+	Search(info IdentifyMetadata) ([]db_types.MetadataEntry, error)
+	ById(id string, us settings.SettingsData) (db_types.MetadataEntry, error)
+	Location(us *settings.SettingsData, providerID string) (string, error)
+}
+
+func HasCapability(p Provider, capability Capability) bool {
+	return slices.Contains(p.Capabilities(), capability)
+}
+
+// if every one of p.RequiredSettings() is set
+func IsConfigured(p Provider, us settings.SettingsData) bool {
+	usValue := reflect.ValueOf(us)
+	for _, name := range p.RequiredSettings() {
+		if field := usValue.FieldByName(name); field.IsValid() {
+			if field.IsZero() {
+				return false
+			}
+			continue
+		}
+
+		if os.Getenv(name) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// if the entry's type and format are ones that p lists
+func Supports(p Provider, entry *db_types.InfoEntry) bool {
+	types := p.MediaTypes()
+	formats := p.Formats()
+	return (len(types) == 0 || slices.Contains(types, entry.Type)) &&
+		(len(formats) == 0 || slices.Contains(formats, entry.Format))
+}
+
+// a Provider made of functions, a nil function means the provider does not have that capability
+type BasicProvider struct {
+	ProviderName string
+	Types        []db_types.MediaTypes
+	FormatList   []db_types.Format
+	Settings     []string
+	Thumbnails   bool
+
+	// if nil, the rank is always 0
+	RankFn func(entry *db_types.InfoEntry) int
+
+	FetchFn    ProviderFunc
+	SearchFn   func(info IdentifyMetadata) ([]db_types.MetadataEntry, error)
+	ByIdFn     IdIdentifier
+	LocationFn LocationFunc
+}
+
+func (self *BasicProvider) Name() string {
+	return self.ProviderName
+}
+
+func (self *BasicProvider) MediaTypes() []db_types.MediaTypes {
+	return self.Types
+}
+
+func (self *BasicProvider) Formats() []db_types.Format {
+	return self.FormatList
+}
+
+func (self *BasicProvider) RequiredSettings() []string {
+	return self.Settings
+}
+
+func (self *BasicProvider) Capabilities() []Capability {
+	out := []Capability{}
+	if self.FetchFn != nil {
+		out = append(out, CAP_FETCH)
+	}
+	if self.SearchFn != nil {
+		out = append(out, CAP_SEARCH)
+	}
+	if self.ByIdFn != nil {
+		out = append(out, CAP_BY_ID)
+	}
+	if self.LocationFn != nil {
+		out = append(out, CAP_LOCATION)
+	}
+	if self.Thumbnails {
+		out = append(out, CAP_THUMBNAILS)
+	}
+	return out
+}
+
+func (self *BasicProvider) Rank(entry *db_types.InfoEntry) int {
+	if self.RankFn == nil {
+		return 0
+	}
+	return self.RankFn(entry)
+}
+
+func (self *BasicProvider) Fetch(info *GetMetadataInfo) (db_types.MetadataEntry, error) {
+	if self.FetchFn == nil {
+		return db_types.MetadataEntry{}, unsupported(self, CAP_FETCH)
+	}
+	return self.FetchFn(info)
+}
+
+func (self *BasicProvider) Search(info IdentifyMetadata) ([]db_types.MetadataEntry, error) {
+	if self.SearchFn == nil {
+		return nil, unsupported(self, CAP_SEARCH)
+	}
+	return self.SearchFn(info)
+}
+
+func (self *BasicProvider) ById(id string, us settings.SettingsData) (db_types.MetadataEntry, error) {
+	if self.ByIdFn == nil {
+		return db_types.MetadataEntry{}, unsupported(self, CAP_BY_ID)
+	}
+	return self.ByIdFn(id, us)
+}
+
+func (self *BasicProvider) Location(us *settings.SettingsData, providerID string) (string, error) {
+	if self.LocationFn == nil {
+		return "", unsupported(self, CAP_LOCATION)
+	}
+	return self.LocationFn(us, providerID)
+}
+
+type UnsupportedError struct {
+	Provider   string
+	Capability Capability
+}
+
+func (self UnsupportedError) Error() string {
+	return self.Provider + " does not support " + string(self.Capability)
+}
+
+func unsupported(p Provider, capability Capability) error {
+	return UnsupportedError{Provider: p.Name(), Capability: capability}
+}
+
+var providers = map[string]Provider{}
+
+// providers register themselves in an init function in their own file
+func RegisterProvider(p Provider) {
+	providers[p.Name()] = p
+}
+
+func GetProvider(name string) (Provider, bool) {
+	p, ok := providers[name]
+	return p, ok
+}
+
+// sorted by name
+// if capability is "", every provider is listed
+func ListProviders(capability Capability) []Provider {
+	out := []Provider{}
+	for _, p := range providers {
+		if capability == "" || HasCapability(p, capability) {
+			out = append(out, p)
+		}
+	}
+
+	slices.SortFunc(out, func(a Provider, b Provider) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return out
+}
+
+// the provider with capability that supports entry and ranks it the highest
+// ties go to the first by name
+func bestProvider(entry *db_types.InfoEntry, capability Capability) (Provider, bool) {
+	var best Provider
+	bestRank := 0
+	for _, p := range ListProviders(capability) {
+		if !Supports(p, entry) {
+			continue
+		}
+		if rank := p.Rank(entry); rank > bestRank {
+			best, bestRank = p, rank
+		}
+	}
+	return best, best != nil
+}
+
+// gets the provider called name, only if it has capability
+func getProviderWith(name string, capability Capability) (Provider, bool) {
+	p, ok := providers[name]
+	if !ok || !HasCapability(p, capability) {
+		return nil, false
+	}
+	return p, true
+}
@@ -0,0 +1,63 @@
+package metadata
+
+import (
+	"testing"
+
+	db_types "aiolimas/types"
+)
+
+func TestBestProvider(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry db_types.InfoEntry
+		want  string
+	}{
+		{"show", db_types.InfoEntry{Type: db_types.TY_SHOW}, "omdb"},
+		{"anime show", db_types.InfoEntry{Type: db_types.TY_SHOW, ArtStyle: db_types.AS_ANIME}, "anilist-show"},
+		{"anime movie", db_types.InfoEntry{Type: db_types.TY_MOVIE, ArtStyle: db_types.AS_ANIME}, "anilist-show"},
+		{"documentary", db_types.InfoEntry{Type: db_types.TY_DOCUMENTARY}, "omdb"},
+		{"manga", db_types.InfoEntry{Type: db_types.TY_MANGA}, "anilist-manga"},
+		{"book", db_types.InfoEntry{Type: db_types.TY_BOOK, Location: "/books/a.epub"}, "googlebooks"},
+		{"steam game", db_types.InfoEntry{Type: db_types.TY_GAME, Format: db_types.F_STEAM}, "steam"},
+		{"wii game", db_types.InfoEntry{Type: db_types.TY_GAME, Format: db_types.F_WII}, ""},
+		{"song", db_types.InfoEntry{Type: db_types.TY_SONG, Location: "/music/a.flac"}, "local"},
+		{"song without a location", db_types.InfoEntry{Type: db_types.TY_SONG}, ""},
+		{"meme", db_types.InfoEntry{Type: db_types.TY_MEME}, "local"},
+		{"board game", db_types.InfoEntry{Type: db_types.TY_BOARDGAME}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ""
+			if p, ok := bestProvider(&test.entry, CAP_FETCH); ok {
+				got = p.Name()
+			}
+			if got != test.want {
+				t.Errorf("got '%s', want '%s'", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDetermineBestLocationProvider(t *testing.T) {
+	tests := []struct {
+		name     string
+		entry    db_types.InfoEntry
+		provider string
+		want     string
+	}{
+		{"from sonarr", db_types.InfoEntry{Type: db_types.TY_SHOW}, "sonarr", "sonarr"},
+		{"from radarr", db_types.InfoEntry{Type: db_types.TY_MOVIE}, "radarr", "radarr"},
+		{"steam game", db_types.InfoEntry{Type: db_types.TY_GAME, Format: db_types.F_STEAM}, "", "steam"},
+		{"from omdb", db_types.InfoEntry{Type: db_types.TY_MOVIE}, "omdb", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			meta := db_types.MetadataEntry{Provider: test.provider}
+			if got := DetermineBestLocationProvider(&test.entry, &meta); got != test.want {
+				t.Errorf("got '%s', want '%s'", got, test.want)
+			}
+		})
+	}
+}
@@ -65,7 +65,7 @@ func RadarrProvider(info *GetMetadataInfo) (db_types.MetadataEntry, error) {
 		return out, errors.New("no search possible")
 	}
 
-	all, err := Lookup(query, fullUrl, key)
+	all, err := Lookup(ARR_Movie, query, fullUrl, key)
 
 	if err != nil {
 		logging.ELog(err)
@@ -110,7 +110,7 @@ func RadarrIdentifier(info IdentifyMetadata) ([]db_types.MetadataEntry, error) {
 
 	query := info.Title
 
-	all, err := Lookup(query, fullUrl, key)
+	all, err := Lookup(ARR_Movie, query, fullUrl, key)
 
 	if err != nil {
 		logging.ELog(err)
@@ -150,7 +150,7 @@ func RadarrIdIdentifier(id string, us settings.SettingsData) (db_types.MetadataE
 
 	fullUrl := url + "api/v3/movie/" + id
 
-	client := http.Client {}
+	client := providerClient("radarr")
 	req, err := http.NewRequest("GET", fullUrl, nil)
 	if err != nil {
 		logging.ELog(err)
@@ -192,7 +192,7 @@ func RadarrIdIdentifier(id string, us settings.SettingsData) (db_types.MetadataE
 
 	mediaDependant := map[string]string {
 		"Movie-length": fmt.Sprintf("%0.2f", data["runtime"].(float64)),
-		"Movie-radarrid": fmt.Sprintf("%d", id),
+		"Movie-radarrid": id,
 	}
 
 	mdMarshal, err := json.Marshal(mediaDependant)
@@ -206,3 +206,17 @@ func RadarrIdIdentifier(id string, us settings.SettingsData) (db_types.MetadataE
 
 	return out, nil
 }
+
+func init() {
+	RegisterProvider(&BasicProvider{
+		ProviderName: "radarr",
+		Types:        []db_types.MediaTypes{db_types.TY_MOVIE, db_types.TY_MOVIE_SHORT},
+		Settings:     []string{"RadarrURL", "RadarrKey"},
+		Thumbnails:   true,
+		FetchFn:      RadarrProvider,
+		SearchFn:     RadarrIdentifier,
+		// radarr id
+		ByIdFn:     RadarrIdIdentifier,
+		LocationFn: RadarrGetLocation,
+	})
+}
@@ -209,7 +209,7 @@ func SeerrIdentifier(info IdentifyMetadata) ([]db_types.MetadataEntry, error) {
 		url.PathEscape(info.Title),
 	)
 
-	client := http.Client{}
+	client := providerClient("seerr")
 	req, _ := http.NewRequest("GET", url, nil)
 	req.Header.Set("X-Api-Key", key)
 	res, err := client.Do(req)
@@ -253,7 +253,7 @@ func SeerrIdIdentifier(id string, us settings.SettingsData) (db_types.MetadataEn
 		return outMeta, errors.New("No seerr key configured")
 	}
 
-	client := http.Client{}
+	client := providerClient("seerr")
 	url := fmt.Sprintf(
 		"%s/api/v1/movie/%s",
 		base,
@@ -306,3 +306,15 @@ func SeerrProvider(info *GetMetadataInfo) (db_types.MetadataEntry, error) {
 	}
 	return SeerrIdIdentifier(entries[0].ProviderID, us)
 }
+
+func init() {
+	RegisterProvider(&BasicProvider{
+		ProviderName: "seerr",
+		Types:        []db_types.MediaTypes{db_types.TY_SHOW, db_types.TY_MOVIE},
+		Settings:     []string{"SEERR_URL", "SEERR_KEY"},
+		Thumbnails:   true,
+		FetchFn:      SeerrProvider,
+		SearchFn:     SeerrIdentifier,
+		ByIdFn:       SeerrIdIdentifier,
+	})
+}
@@ -65,7 +65,7 @@ func SonarrProvider(info *GetMetadataInfo) (db_types.MetadataEntry, error) {
 		return out, errors.New("no search possible")
 	}
 
-	all, err := Lookup(query, fullUrl, key)
+	all, err := Lookup(ARR_Series, query, fullUrl, key)
 
 	if err != nil {
 		logging.ELog(err)
@@ -112,7 +112,7 @@ func SonarrIdentifier(info IdentifyMetadata) ([]db_types.MetadataEntry, error) {
 
 	query := info.Title
 
-	all, err := Lookup(query, fullUrl, key)
+	all, err := Lookup(ARR_Series, query, fullUrl, key)
 
 	if err != nil {
 		logging.ELog(err)
@@ -140,43 +140,53 @@ func SonarrIdentifier(info IdentifyMetadata) ([]db_types.MetadataEntry, error) {
 	return outMeta, nil
 }
 
-func SonarrIdIdentifier(id string, us settings.SettingsData) (db_types.MetadataEntry, error) {
-	out := db_types.MetadataEntry{}
-
-	key := us.SonarrKey
-	url := us.SonarrURL
-
-	if url == "" || key == ""{
-		return out, errors.New("sonarr is not setup")
-	}
-
+func sonarrSeries(id string, url string, key string) (map[string]interface{}, error) {
 	fullUrl := url + "api/v3/series/" + id
 
-	client := http.Client {}
+	client := providerClient("sonarr")
 	req, err := http.NewRequest("GET", fullUrl, nil)
 	if err != nil {
 		logging.ELog(err)
-		return out, err
+		return nil, err
 	}
 
 	req.Header.Set("X-Api-Key", key)
 	res, err := client.Do(req)
 	if err != nil {
 		logging.ELog(err)
-		return out, err
+		return nil, err
 	}
+	defer res.Body.Close()
 
 	var data map[string]interface{}
 
 	text, err := io.ReadAll(res.Body)
 	if err != nil {
 		logging.ELog(err)
-		return out, err
+		return nil, err
 	}
 
 	err = json.Unmarshal(text, &data)
 	if err != nil {
 		logging.ELog(err)
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func SonarrIdIdentifier(id string, us settings.SettingsData) (db_types.MetadataEntry, error) {
+	out := db_types.MetadataEntry{}
+
+	key := us.SonarrKey
+	url := us.SonarrURL
+
+	if url == "" || key == ""{
+		return out, errors.New("sonarr is not setup")
+	}
+
+	data, err := sonarrSeries(id, url, key)
+	if err != nil {
 		return out, err
 	}
 
@@ -202,6 +212,13 @@ func SonarrIdIdentifier(id string, us settings.SettingsData) (db_types.MetadataE
 		specialsCount = season0Stats["totalEpisodeCount"].(float64)
 	}
 
+	seasons := 0
+	for _, season := range seasonsArray {
+		if season.(map[string]interface{})["seasonNumber"].(float64) != 0 {
+			seasons++
+		}
+	}
+
 	stats := data["statistics"].(map[string]interface{})
 	totalEpisodes := stats["totalEpisodeCount"].(float64) - specialsCount
 	airingStatus := data["status"].(string)
@@ -212,6 +229,7 @@ func SonarrIdIdentifier(id string, us settings.SettingsData) (db_types.MetadataE
 		"Show-episode-duration": fmt.Sprintf("%0.2f", episodeDuration),
 		"Show-length": fmt.Sprintf("%0.2f", episodeDuration * totalEpisodes),
 		"Show-episodes": fmt.Sprintf("%0.2f", totalEpisodes),
+		"Show-seasons": fmt.Sprintf("%d", seasons),
 		"Show-sonarrid": id,
 	}
 
@@ -226,3 +244,17 @@ func SonarrIdIdentifier(id string, us settings.SettingsData) (db_types.MetadataE
 
 	return out, nil
 }
+
+func init() {
+	RegisterProvider(&BasicProvider{
+		ProviderName: "sonarr",
+		Types:        []db_types.MediaTypes{db_types.TY_SHOW},
+		Settings:     []string{"SonarrURL", "SonarrKey"},
+		Thumbnails:   true,
+		FetchFn:      SonarrProvider,
+		SearchFn:     SonarrIdentifier,
+		// sonarr id
+		ByIdFn:     SonarrIdIdentifier,
+		LocationFn: SonarrGetLocation,
+	})
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
@@ -69,7 +68,7 @@ func getSteamSearchTree(search string) (*html.Node, error){
 
 	fullUrl := fmt.Sprintf(baseUrl, url.QueryEscape(search))
 
-	resp, err := http.Get(fullUrl)
+	resp, err := providerClient("steam").Get(fullUrl)
 	if err != nil {
 		return nil, err
 	}
@@ -185,7 +184,7 @@ func SteamIdIdentifier(id string, us settings.SettingsData) (db_types.MetadataEn
 
 	fullUrl := fmt.Sprintf("https://store.steampowered.com/api/appdetails?appids=%s", url.QueryEscape(id))
 
-	res, err := http.Get(fullUrl)
+	res, err := providerClient("steam").Get(fullUrl)
 	if err != nil {
 		return out, err
 	}
@@ -237,7 +236,7 @@ func SteamIdIdentifier(id string, us settings.SettingsData) (db_types.MetadataEn
 	}
 
 	reviewsUrl := fmt.Sprintf("https://store.steampowered.com/appreviews/%s?json=1", url.QueryEscape(id))
-	res, err = http.Get(reviewsUrl)
+	res, err = providerClient("steam").Get(reviewsUrl)
 	if err != nil {
 		return out, err
 	}
@@ -263,3 +262,20 @@ func SteamLocationFinder(_ *settings.SettingsData, providerID string) (string, e
 
 	return "", errors.New("please set the metadata ProviderID before setting the location")
 }
+
+func init() {
+	RegisterProvider(&BasicProvider{
+		ProviderName: "steam",
+		Types:        []db_types.MediaTypes{db_types.TY_GAME},
+		FormatList:   []db_types.Format{db_types.F_STEAM},
+		Thumbnails:   true,
+		FetchFn:      SteamProvider,
+		RankFn: func(entry *db_types.InfoEntry) int {
+			return 1
+		},
+		SearchFn:     SteamIdentifier,
+		// steam id
+		ByIdFn:     SteamIdIdentifier,
+		LocationFn: SteamLocationFinder,
+	})
+}
@@ -0,0 +1,298 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+var videoExtensions = []string{".mp4", ".m4v", ".mov", ".mkv", ".webm"}
+
+func isVideoFile(name string) bool {
+	return slices.Contains(videoExtensions, strings.ToLower(filepath.Ext(name)))
+}
+
+// MP4 {{{
+
+// the boxes in a box (or a file), each call to next reads one box header
+type mp4Boxes struct {
+	r   io.ReadSeeker
+	end int64
+}
+
+// returns the box type, and its body size
+// the reader is left at the start of the body
+func (self *mp4Boxes) next() (string, int64, error) {
+	pos, err := self.r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", 0, err
+	}
+	if pos+8 > self.end {
+		return "", 0, io.EOF
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(self.r, header); err != nil {
+		return "", 0, err
+	}
+	size := int64(binary.BigEndian.Uint32(header))
+	headerSize := int64(8)
+	switch size {
+	case 0:
+		// the box goes to the end of the file
+		size = self.end - pos
+	case 1:
+		large := make([]byte, 8)
+		if _, err := io.ReadFull(self.r, large); err != nil {
+			return "", 0, err
+		}
+		size = int64(binary.BigEndian.Uint64(large))
+		headerSize = 16
+	}
+	if size < headerSize || pos+size > self.end {
+		return "", 0, fmt.Errorf("invalid size for the %q box", header[4:])
+	}
+	return string(header[4:]), size - headerSize, nil
+}
+
+// reads the boxes until one of type name, the reader is left at the start of its body
+func (self *mp4Boxes) find(name string) (int64, error) {
+	for {
+		ty, size, err := self.next()
+		if err != nil {
+			return 0, err
+		}
+		if ty == name {
+			return size, nil
+		}
+		if _, err := self.r.Seek(size, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// the duration in the movie header, moov > mvhd
+func mp4Duration(file io.ReadSeeker, fileSize int64) (time.Duration, error) {
+	top := mp4Boxes{r: file, end: fileSize}
+	moovSize, err := top.find("moov")
+	if err != nil {
+		return 0, err
+	}
+	moovStart, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	moov := mp4Boxes{r: file, end: moovStart + moovSize}
+	mvhdSize, err := moov.find("mvhd")
+	if err != nil {
+		return 0, err
+	}
+	if mvhdSize < 20 {
+		return 0, errors.New("mvhd is too short")
+	}
+
+	mvhd := make([]byte, min(mvhdSize, 32))
+	if _, err := io.ReadFull(file, mvhd); err != nil {
+		return 0, err
+	}
+
+	// version 1 uses 64 bit times
+	var timescale, duration uint64
+	if mvhd[0] == 1 {
+		if len(mvhd) < 32 {
+			return 0, errors.New("mvhd is too short")
+		}
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[20:24]))
+		duration = binary.BigEndian.Uint64(mvhd[24:32])
+	} else {
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+	}
+
+	if timescale == 0 {
+		return 0, errors.New("mvhd has a timescale of 0")
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second)), nil
+}
+
+// }}}
+
+// Matroska (mkv, webm) {{{
+
+const (
+	ebmlHeaderId    = 0x1a45dfa3
+	mkvSegmentId    = 0x18538067
+	mkvInfoId       = 0x1549a966
+	mkvClusterId    = 0x1f43b675
+	mkvTimescaleId  = 0x2ad7b1
+	mkvDurationId   = 0x4489
+	ebmlUnknownSize = -1
+)
+
+// reads an ebml variable size integer
+// ids keep their length marker, sizes do not
+func readVint(r io.Reader, keepMarker bool) (int64, error) {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, err
+	}
+
+	length := 1
+	for mask := byte(0x80); mask != 0 && b[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, errors.New("invalid ebml integer")
+	}
+
+	value := uint64(b[0])
+	if !keepMarker {
+		value &= 0xff >> length
+	}
+	allOnes := value == 0xff>>length
+
+	rest := make([]byte, length-1)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return 0, err
+	}
+	for _, c := range rest {
+		value = value<<8 | uint64(c)
+		allOnes = allOnes && c == 0xff
+	}
+
+	if !keepMarker && allOnes {
+		return ebmlUnknownSize, nil
+	}
+	return int64(value), nil
+}
+
+func readEbmlHeader(r io.Reader) (int64, int64, error) {
+	id, err := readVint(r, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, err := readVint(r, false)
+	return id, size, err
+}
+
+// the duration in the segment info, Segment > Info > Duration * TimestampScale
+func mkvDuration(file io.ReadSeeker) (time.Duration, error) {
+	for {
+		id, size, err := readEbmlHeader(file)
+		if err != nil {
+			return 0, err
+		}
+
+		switch id {
+		case mkvSegmentId:
+			// read its children
+			continue
+		case mkvClusterId:
+			// the info always comes before the media
+			return 0, errors.New("no info before the first cluster")
+		}
+
+		if size == ebmlUnknownSize {
+			return 0, fmt.Errorf("element %x has an unknown size", id)
+		}
+		if id == mkvInfoId {
+			// the info only holds a few small elements
+			if size > 1<<16 {
+				return 0, errors.New("the info is too large")
+			}
+			info := make([]byte, size)
+			if _, err := io.ReadFull(file, info); err != nil {
+				return 0, err
+			}
+			return mkvInfoDuration(bytes.NewReader(info))
+		}
+		if _, err := file.Seek(size, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func mkvInfoDuration(info *bytes.Reader) (time.Duration, error) {
+	// nanoseconds per unit of Duration
+	timescale := uint64(1_000_000)
+	duration := -1.0
+
+	for info.Len() > 0 {
+		id, size, err := readEbmlHeader(info)
+		if err != nil {
+			return 0, err
+		}
+		if size < 0 || size > int64(info.Len()) {
+			return 0, fmt.Errorf("element %x has an invalid size", id)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(info, data); err != nil {
+			return 0, err
+		}
+
+		switch {
+		case id == mkvTimescaleId && size <= 8:
+			timescale = 0
+			for _, b := range data {
+				timescale = timescale<<8 | uint64(b)
+			}
+		case id == mkvDurationId && size == 4:
+			duration = float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+		case id == mkvDurationId && size == 8:
+			duration = math.Float64frombits(binary.BigEndian.Uint64(data))
+		}
+	}
+
+	if duration < 0 {
+		return 0, errors.New("the info has no duration")
+	}
+	return time.Duration(duration * float64(timescale)), nil
+}
+
+// }}}
+
+// the duration of a video file, 0 if it is not a supported video file or the duration could not be read
+func VideoDuration(path string) time.Duration {
+	if !isVideoFile(path) {
+		return 0
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return 0
+	}
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return 0
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0
+	}
+
+	var d time.Duration
+	if binary.BigEndian.Uint32(magic) == ebmlHeaderId {
+		d, err = mkvDuration(file)
+	} else {
+		d, err = mp4Duration(file, stat.Size())
+	}
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,78 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func mp4Box(ty string, body ...[]byte) []byte {
+	data := bytes.Join(body, nil)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(data)+8)), append([]byte(ty), data...)...)
+}
+
+// a version 0 movie header
+func mvhd(timescale uint32, duration uint32) []byte {
+	body := make([]byte, 100)
+	binary.BigEndian.PutUint32(body[12:], timescale)
+	binary.BigEndian.PutUint32(body[16:], duration)
+	return mp4Box("mvhd", body)
+}
+
+// an element with a 1 byte size, which is enough for the tests
+func ebml(id uint32, body ...[]byte) []byte {
+	data := bytes.Join(body, nil)
+	idBytes := binary.BigEndian.AppendUint32(nil, id)
+	for len(idBytes) > 1 && idBytes[0] == 0 {
+		idBytes = idBytes[1:]
+	}
+	return append(append(idBytes, 0x80|byte(len(data))), data...)
+}
+
+func float64Bytes(f float64) []byte {
+	return binary.BigEndian.AppendUint64(nil, math.Float64bits(f))
+}
+
+func TestVideoDuration(t *testing.T) {
+	ftyp := mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41"))
+	ebmlHeader := ebml(ebmlHeaderId, ebml(0x4282, []byte("matroska")))
+
+	// 2 hours and 50 minutes, in the default timescale of 1ms
+	heat := float64((2*time.Hour + 50*time.Minute) / time.Millisecond)
+
+	tests := []struct {
+		name string
+		file string
+		data []byte
+		want time.Duration
+	}{
+		{"mp4", "a.mp4", bytes.Join([][]byte{ftyp, mp4Box("moov", mvhd(1000, 90_500))}, nil), 90500 * time.Millisecond},
+		{"mp4 with the moov after the media", "a.m4v", bytes.Join([][]byte{ftyp, mp4Box("mdat", make([]byte, 64)), mp4Box("moov", mp4Box("free"), mvhd(600, 1200))}, nil), 2 * time.Second},
+		{"mp4 without a moov", "a.mp4", ftyp, 0},
+		{"mp4 with a timescale of 0", "a.mp4", bytes.Join([][]byte{ftyp, mp4Box("moov", mvhd(0, 1200))}, nil), 0},
+		{"mp4 box larger than the file", "a.mp4", append(ftyp, 0, 0, 0xff, 0xff, 'm', 'o', 'o', 'v'), 0},
+		{"mkv", "a.mkv", bytes.Join([][]byte{ebmlHeader, ebml(mkvSegmentId, ebml(mkvInfoId, ebml(mkvDurationId, float64Bytes(heat))))}, nil), 2*time.Hour + 50*time.Minute},
+		{"webm with a timescale", "a.webm", bytes.Join([][]byte{ebmlHeader, ebml(mkvSegmentId, ebml(0x114d9b74, make([]byte, 8)), ebml(mkvInfoId, ebml(mkvTimescaleId, []byte{0x3b, 0x9a, 0xca, 0x00}), ebml(mkvDurationId, float64Bytes(1500))))}, nil), 1500 * time.Second},
+		{"mkv without an info", "a.mkv", bytes.Join([][]byte{ebmlHeader, ebml(mkvSegmentId, ebml(mkvClusterId, make([]byte, 8)))}, nil), 0},
+		{"mkv info without a duration", "a.mkv", bytes.Join([][]byte{ebmlHeader, ebml(mkvSegmentId, ebml(mkvInfoId, ebml(mkvTimescaleId, []byte{0x01})))}, nil), 0},
+		{"not a video", "a.avi", bytes.Join([][]byte{ftyp, mp4Box("moov", mvhd(1000, 90_500))}, nil), 0},
+		{"empty", "a.mp4", nil, 0},
+	}
+
+	dir := t.TempDir()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, test.file)
+			if err := os.WriteFile(path, test.data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if got := VideoDuration(path); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -71,7 +71,7 @@ type WikiSummary struct {
 func WikipediaIdentifier(info IdentifyMetadata) ([]db_types.MetadataEntry, error) {
 	out := []db_types.MetadataEntry{}
 
-	client := http.Client{}
+	client := providerClient("wikipedia")
 
 	req, err := http.NewRequest("GET", "https://en.wikipedia.org/w/api.php", nil)
 	if err != nil {
@@ -119,7 +119,7 @@ func WikipediaIdentifier(info IdentifyMetadata) ([]db_types.MetadataEntry, error
 func WikipediaIdIdentifier(id string, us settings.SettingsData) (db_types.MetadataEntry, error) {
 	out := db_types.MetadataEntry{}
 
-	client := http.Client{}
+	client := providerClient("wikipedia")
 
 
 	eid := url.PathEscape(id)
@@ -256,3 +256,12 @@ next:
 
 	return out, nil
 }
+
+func init() {
+	RegisterProvider(&BasicProvider{
+		ProviderName: "wikipedia",
+		Thumbnails:   true,
+		SearchFn:     WikipediaIdentifier,
+		ByIdFn:       WikipediaIdIdentifier,
+	})
+}
@@ -0,0 +1,290 @@
+package playback
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Format string
+
+const (
+	F_GENERIC  Format = "generic"
+	F_MPV      Format = "mpv"
+	F_JELLYFIN Format = "jellyfin"
+	F_PLEX     Format = "plex"
+)
+
+func ListFormats() []Format {
+	return []Format{F_GENERIC, F_MPV, F_JELLYFIN, F_PLEX}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// generic {{{
+
+type genericPayload struct {
+	// started, position, or stopped
+	Event     string
+	Path      string
+	Title     string
+	Ids       map[string]string
+	Position  *float64
+	Duration  *float64
+	Completed bool
+}
+
+func parseGeneric(body []byte) (Event, error) {
+	var payload genericPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return Event{}, err
+	}
+
+	event := Event{
+		Path:      payload.Path,
+		Title:     payload.Title,
+		Ids:       payload.Ids,
+		Position:  -1,
+		Duration:  -1,
+		Completed: payload.Completed,
+	}
+	if payload.Position != nil {
+		event.Position = seconds(*payload.Position)
+	}
+	if payload.Duration != nil {
+		event.Duration = seconds(*payload.Duration)
+	}
+
+	switch strings.ToLower(payload.Event) {
+	case "started", "start":
+		event.Action = A_START
+	case "position", "progress":
+		event.Action = A_PROGRESS
+	case "stopped", "stop":
+		event.Action = A_STOP
+	default:
+		return event, fmt.Errorf("Unknown event: '%s', expected started, position, or stopped", payload.Event)
+	}
+	return event, nil
+}
+
+// }}}
+
+// mpv {{{
+
+// sent by a script, the names are the names of mpv's events and properties
+type mpvPayload struct {
+	Event      string
+	Path       string   `json:"path"`
+	MediaTitle string   `json:"media-title"`
+	TimePos    *float64 `json:"time-pos"`
+	Duration   *float64 `json:"duration"`
+	// the reason of end-file, eof means the file was played to the end
+	Reason string `json:"reason"`
+}
+
+func parseMPV(body []byte) (Event, error) {
+	var payload mpvPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return Event{}, err
+	}
+
+	event := Event{
+		Path:     payload.Path,
+		Title:    payload.MediaTitle,
+		Position: -1,
+		Duration: -1,
+	}
+	if payload.TimePos != nil {
+		event.Position = seconds(*payload.TimePos)
+	}
+	if payload.Duration != nil {
+		event.Duration = seconds(*payload.Duration)
+	}
+
+	switch payload.Event {
+	case "start-file", "file-loaded":
+		event.Action = A_START
+	case "playback-progress", "pause", "unpause", "seek":
+		event.Action = A_PROGRESS
+	case "end-file":
+		event.Action = A_STOP
+		event.Completed = payload.Reason == "eof"
+	default:
+		return event, fmt.Errorf("Unknown mpv event: '%s'", payload.Event)
+	}
+
+	// mpv paths can be file:// urls
+	if u, err := url.Parse(event.Path); err == nil && u.Scheme == "file" {
+		event.Path = u.Path
+	}
+	return event, nil
+}
+
+// }}}
+
+// jellyfin {{{
+
+// the default template of jellyfin-plugin-webhook
+// ticks are 100ns
+type jellyfinPayload struct {
+	NotificationType      string
+	ItemId                string
+	Name                  string
+	Path                  string
+	PlaybackPositionTicks int64
+	RunTimeTicks          int64
+	PlayedToCompletion    bool
+	Provider_imdb         string
+	Provider_tmdb         string
+	Provider_tvdb         string
+}
+
+func parseJellyfin(body []byte) (Event, error) {
+	var payload jellyfinPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return Event{}, err
+	}
+
+	event := Event{
+		Path:      payload.Path,
+		Title:     payload.Name,
+		Position:  time.Duration(payload.PlaybackPositionTicks * 100),
+		Duration:  time.Duration(payload.RunTimeTicks * 100),
+		Completed: payload.PlayedToCompletion,
+		Ids: map[string]string{
+			"jellyfin": payload.ItemId,
+			"imdb":     payload.Provider_imdb,
+			"tmdb":     payload.Provider_tmdb,
+			"tvdb":     payload.Provider_tvdb,
+		},
+	}
+	if payload.RunTimeTicks == 0 {
+		event.Duration = -1
+	}
+
+	switch payload.NotificationType {
+	case "PlaybackStart":
+		event.Action = A_START
+	case "PlaybackProgress":
+		event.Action = A_PROGRESS
+	case "PlaybackStop":
+		event.Action = A_STOP
+	default:
+		return event, fmt.Errorf("Unknown jellyfin notification: '%s'", payload.NotificationType)
+	}
+	return event, nil
+}
+
+// }}}
+
+// plex {{{
+
+// plex sends the payload as the "payload" field of a multipart form
+// times are in milliseconds
+type plexPayload struct {
+	Event    string `json:"event"`
+	Metadata struct {
+		RatingKey  string `json:"ratingKey"`
+		Title      string `json:"title"`
+		ViewOffset int64  `json:"viewOffset"`
+		Duration   int64  `json:"duration"`
+		// the legacy agents put one id here, eg: com.plexapp.agents.imdb://tt0113277?lang=en
+		Guid string `json:"guid"`
+		// the new agents list every id, eg: [{"id": "imdb://tt0113277"}, {"id": "tmdb://949"}]
+		Guids []struct {
+			Id string `json:"id"`
+		} `json:"Guid"`
+	} `json:"Metadata"`
+}
+
+// "imdb://tt0113277" -> "imdb", "tt0113277"
+func plexGuid(guid string) (string, string, bool) {
+	provider, id, ok := strings.Cut(guid, "://")
+	if !ok {
+		return "", "", false
+	}
+	provider = provider[strings.LastIndex(provider, ".")+1:]
+	id, _, _ = strings.Cut(id, "?")
+	switch provider {
+	case "themoviedb":
+		provider = "tmdb"
+	case "thetvdb":
+		provider = "tvdb"
+	}
+	return provider, id, true
+}
+
+func parsePlex(body []byte) (Event, error) {
+	var payload plexPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return Event{}, err
+	}
+
+	meta := payload.Metadata
+	event := Event{
+		Title:    meta.Title,
+		Position: time.Duration(meta.ViewOffset) * time.Millisecond,
+		Duration: time.Duration(meta.Duration) * time.Millisecond,
+		Ids: map[string]string{
+			"plex": meta.RatingKey,
+		},
+	}
+	if meta.Duration == 0 {
+		event.Duration = -1
+	}
+	guids := []string{meta.Guid}
+	for _, guid := range meta.Guids {
+		guids = append(guids, guid.Id)
+	}
+	for _, guid := range guids {
+		// the new agents' own guid (plex://movie/...) would replace the ratingKey
+		if provider, id, ok := plexGuid(guid); ok && provider != "plex" {
+			event.Ids[provider] = id
+		}
+	}
+
+	switch payload.Event {
+	case "media.play", "media.resume":
+		event.Action = A_START
+	case "media.pause":
+		event.Action = A_PROGRESS
+	case "media.stop":
+		event.Action = A_STOP
+	case "media.scrobble":
+		// plex scrobbles when 90% of the file has been played
+		event.Action = A_STOP
+		event.Completed = true
+	default:
+		return event, fmt.Errorf("Unknown plex event: '%s'", payload.Event)
+	}
+	return event, nil
+}
+
+// }}}
+
+func Parse(format Format, body []byte) (Event, error) {
+	var event Event
+	var err error
+	switch format {
+	case F_MPV:
+		event, err = parseMPV(body)
+	case F_JELLYFIN:
+		event, err = parseJellyfin(body)
+	case F_PLEX:
+		event, err = parsePlex(body)
+	default:
+		event, err = parseGeneric(body)
+	}
+
+	for k, v := range event.Ids {
+		if v == "" {
+			delete(event.Ids, k)
+		}
+	}
+	return event, err
+}
@@ -0,0 +1,100 @@
+package playback
+
+import (
+	"maps"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseFixtures(t *testing.T) {
+	const path = "/media/movies/Heat (1995)/Heat (1995).mkv"
+	length := 10260 * time.Second
+
+	tests := []struct {
+		format  Format
+		fixture string
+		want    Event
+	}{
+		{F_GENERIC, "generic/started.json", Event{
+			Action: A_START, Path: path, Title: "Heat", Position: 0, Duration: length,
+			Ids: map[string]string{"imdb": "tt0113277"},
+		}},
+		{F_GENERIC, "generic/stopped.json", Event{
+			Action: A_STOP, Path: path, Title: "Heat", Position: 10140 * time.Second, Duration: length,
+			Ids: map[string]string{"imdb": "tt0113277"},
+		}},
+		{F_MPV, "mpv/file-loaded.json", Event{
+			Action: A_START, Path: path, Title: "Heat", Position: 0, Duration: length + 500*time.Millisecond,
+		}},
+		{F_MPV, "mpv/end-file.json", Event{
+			Action: A_STOP, Path: path, Title: "Heat", Position: -1, Duration: -1, Completed: true,
+		}},
+		{F_JELLYFIN, "jellyfin/playback-start.json", Event{
+			Action: A_START, Path: path, Title: "Heat", Position: 0, Duration: length,
+			Ids: map[string]string{"jellyfin": "8a3c6f1e2b7d4e9f9a0b1c2d3e4f5a6b", "imdb": "tt0113277", "tmdb": "949"},
+		}},
+		{F_JELLYFIN, "jellyfin/playback-stop.json", Event{
+			Action: A_STOP, Path: path, Title: "Heat", Position: 10140 * time.Second, Duration: length, Completed: true,
+			Ids: map[string]string{"jellyfin": "8a3c6f1e2b7d4e9f9a0b1c2d3e4f5a6b", "imdb": "tt0113277", "tmdb": "949"},
+		}},
+		{F_PLEX, "plex/media-play.json", Event{
+			Action: A_START, Title: "Heat", Position: 0, Duration: length,
+			Ids: map[string]string{"plex": "1936", "imdb": "tt0113277", "tmdb": "949", "tvdb": "1038"},
+		}},
+		{F_PLEX, "plex/media-scrobble.json", Event{
+			Action: A_STOP, Title: "Heat", Position: 9240 * time.Second, Duration: length, Completed: true,
+			Ids: map[string]string{"plex": "1936", "imdb": "tt0113277"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			body, err := os.ReadFile("../docs/hooks/player/" + test.fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Parse(test.format, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.want.Ids == nil {
+				test.want.Ids = map[string]string{}
+			}
+			if got.Ids == nil {
+				got.Ids = map[string]string{}
+			}
+			if !maps.Equal(got.Ids, test.want.Ids) {
+				t.Errorf("ids are %v, want %v", got.Ids, test.want.Ids)
+			}
+			got.Ids, test.want.Ids = nil, nil
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseUnknownEvents(t *testing.T) {
+	tests := []struct {
+		format Format
+		body   string
+	}{
+		{F_GENERIC, `{"event": "rewound"}`},
+		{F_MPV, `{"event": "shutdown"}`},
+		{F_JELLYFIN, `{"NotificationType": "ItemAdded"}`},
+		{F_PLEX, `{"event": "library.new"}`},
+		{F_GENERIC, `not json`},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.format)+" "+test.body, func(t *testing.T) {
+			if _, err := Parse(test.format, []byte(test.body)); err == nil {
+				t.Error("parsed an unknown event")
+			}
+		})
+	}
+}
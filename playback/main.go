@@ -0,0 +1,317 @@
+package playback
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"aiolimas/db"
+	"aiolimas/logging"
+	"aiolimas/settings"
+	"aiolimas/stream"
+	db_types "aiolimas/types"
+)
+
+/*
+	Local players report what they play, and these reports drive the engagement of the entry being played:
+
+	start:    the entry is begun, or resumed if it was paused
+	progress: CurrentPosition, and Minutes are updated
+	stop:     like progress, and if the file was played to the end the entry is finished
+
+	Events are matched with entries by the path of the file (an entry's Location, or a file inside of it),
+	or by the ids of the file, see Resolve
+*/
+
+type Action string
+
+const (
+	A_START    Action = "start"
+	A_PROGRESS Action = "progress"
+	A_STOP     Action = "stop"
+)
+
+type Event struct {
+	Action Action
+	// the file being played, "" if the player only knows its ids
+	Path string
+	// provider -> id, eg: {"imdb": "tt0113277", "jellyfin": "..."}
+	Ids   map[string]string
+	Title string
+	// -1 if unknown
+	Position time.Duration
+	Duration time.Duration
+	// the player says that the file was played to the end
+	Completed bool
+}
+
+var ErrNoEntry = errors.New("no entry matches the event")
+
+// what happened to the entry
+type Result struct {
+	ItemId int64
+	Title  string
+	// began, resumed, finished
+	Actions         []string
+	Status          db_types.Status
+	CurrentPosition string
+	Minutes         int64
+}
+
+// what was last heard about a file that is playing, used to count the minutes that are watched
+type session struct {
+	File     string
+	Position time.Duration
+	At       time.Time
+	// time that has been watched, but not yet added to Minutes
+	Unsaved time.Duration
+}
+
+var sessions = map[string]*session{}
+var sessionLock sync.Mutex
+
+func sessionKey(uid int64, itemId int64) string {
+	return fmt.Sprintf("%d-%d", uid, itemId)
+}
+
+func expand(us settings.SettingsData, location string) string {
+	return filepath.Clean(settings.ExpandPathWithLocationAliases(us.LocationAliases, location))
+}
+
+// the entry with the most specific Location that contains path
+// returns the entry, and the path of the file relative to its Location ("" if path is the Location)
+func resolvePath(entries []db_types.InfoEntry, us settings.SettingsData, path string) (db_types.InfoEntry, string, bool) {
+	path = filepath.Clean(path)
+
+	var best db_types.InfoEntry
+	bestLocation := ""
+	for _, entry := range entries {
+		if entry.Location == "" {
+			continue
+		}
+		location := expand(us, entry.Location)
+		if path != location && !strings.HasPrefix(path, location+string(filepath.Separator)) {
+			continue
+		}
+		if len(location) > len(bestLocation) {
+			best = entry
+			bestLocation = location
+		}
+	}
+
+	if bestLocation == "" {
+		return best, "", false
+	}
+	rel, _ := filepath.Rel(bestLocation, path)
+	if rel == "." {
+		rel = ""
+	}
+	return best, rel, true
+}
+
+// whether meta has one of ids, either as the "{provider}-id" Datapoint, or the "{Type}-{provider}id" MediaDependant
+func hasId(meta db_types.MetadataEntry, ty db_types.MediaTypes, ids map[string]string) bool {
+	datapoints := map[string]string{}
+	json.Unmarshal([]byte(meta.Datapoints), &datapoints)
+	mediaDependant := map[string]string{}
+	json.Unmarshal([]byte(meta.MediaDependant), &mediaDependant)
+
+	for provider, id := range ids {
+		if datapoints[provider+"-id"] == id || mediaDependant[fmt.Sprintf("%s-%sid", ty, provider)] == id {
+			return true
+		}
+	}
+	return false
+}
+
+// finds the entry that event is about, by path first, then by ids
+func Resolve(ctx db.RequestContext, us settings.SettingsData, event Event) (db_types.InfoEntry, string, error) {
+	entries, err := db.ListEntries(ctx, "entryInfo.itemId")
+	if err != nil {
+		return db_types.InfoEntry{}, "", err
+	}
+
+	if event.Path != "" {
+		if entry, rel, ok := resolvePath(entries, us, event.Path); ok {
+			return entry, rel, nil
+		}
+	}
+
+	if len(event.Ids) > 0 {
+		metas, err := db.ListMetadata(ctx)
+		if err != nil {
+			return db_types.InfoEntry{}, "", err
+		}
+		types := map[int64]db_types.MediaTypes{}
+		for _, entry := range entries {
+			types[entry.ItemId] = entry.Type
+		}
+		for _, meta := range metas {
+			ty, ok := types[meta.ItemId]
+			if ok && hasId(meta, ty, event.Ids) {
+				entry, err := db.GetInfoEntryById(ctx, meta.ItemId)
+				return entry, "", err
+			}
+		}
+	}
+
+	return db_types.InfoEntry{}, "", ErrNoEntry
+}
+
+// 1:02:03
+func clock(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+}
+
+// the last file of a folder entry, finishing it finishes the entry
+// files closer to location win, so that eg: a movie's Extras folder does not count
+func lastFile(location string) string {
+	last := ""
+	lastDepth := 0
+	filepath.WalkDir(location, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if path != location && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !stream.IsStreamable(path) {
+			return nil
+		}
+		depth := strings.Count(path, string(filepath.Separator))
+		if last == "" || depth <= lastDepth {
+			last = path
+			lastDepth = depth
+		}
+		return nil
+	})
+	return last
+}
+
+func isCompleted(event Event, us settings.SettingsData) bool {
+	if event.Completed {
+		return true
+	}
+	if event.Position < 0 || event.Duration <= 0 {
+		return false
+	}
+	percent := us.PlaybackFinishPercent
+	if percent <= 0 {
+		percent = 90
+	}
+	return float64(event.Position) >= float64(event.Duration)*percent/100
+}
+
+// adds the time that was watched since the last event to user.Minutes
+// a jump that is larger than the time between events is a seek, and is not counted
+func trackMinutes(uid int64, user *db_types.UserViewingEntry, file string, event Event) {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	key := sessionKey(uid, user.ItemId)
+	now := time.Now()
+	cur, ok := sessions[key]
+	if ok && cur.File == file && event.Position >= 0 && cur.Position >= 0 {
+		watched := event.Position - cur.Position
+		// allows for players that are sped up, and events that arrive late
+		if watched > 0 && watched <= now.Sub(cur.At)*2+30*time.Second {
+			cur.Unsaved += watched
+		}
+	}
+	if !ok || cur.File != file {
+		cur = &session{File: file}
+	}
+	cur.Position = event.Position
+	cur.At = now
+
+	minutes := int64(cur.Unsaved / time.Minute)
+	user.Minutes += minutes
+	cur.Unsaved -= time.Duration(minutes) * time.Minute
+
+	if event.Action == A_STOP {
+		delete(sessions, key)
+	} else {
+		sessions[key] = cur
+	}
+}
+
+func Apply(uid int64, us settings.SettingsData, entry db_types.InfoEntry, file string, event Event) (Result, error) {
+	ctx := db.RequestContext{UID: uid, Auth: uid}
+	timezone := us.DefaultTimeZone
+
+	result := Result{
+		ItemId:  entry.ItemId,
+		Title:   entry.En_Title,
+		Actions: []string{},
+	}
+
+	user, err := db.GetUserEntry(ctx, entry.ItemId)
+	if err != nil {
+		return result, err
+	}
+
+	completed := event.Action == A_STOP && isCompleted(event, us)
+	// a folder is only finished by its last file
+	if completed && file != "" {
+		completed = lastFile(expand(us, entry.Location)) == filepath.Join(expand(us, entry.Location), file)
+	}
+
+	begin := event.Action != A_STOP || (completed && user.Status != db_types.S_FINISHED)
+	if begin && !user.IsViewing() {
+		if user.CanResume() {
+			err = db.Resume(uid, timezone, &user)
+			result.Actions = append(result.Actions, "resumed")
+		} else if user.CanBegin() {
+			err = db.Begin(uid, timezone, &user)
+			result.Actions = append(result.Actions, "began")
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	trackMinutes(uid, &user, file, event)
+
+	if event.Position >= 0 {
+		user.CurrentPosition = clock(event.Position)
+		if file != "" {
+			user.CurrentPosition = fmt.Sprintf("%s %s", filepath.ToSlash(file), user.CurrentPosition)
+		}
+	}
+
+	finished := completed && user.CanFinish()
+	if finished {
+		if err := db.Finish(uid, timezone, &user); err != nil {
+			return result, err
+		}
+		result.Actions = append(result.Actions, "finished")
+		// the next viewing starts from the beginning
+		if file == "" {
+			user.CurrentPosition = ""
+		}
+	}
+
+	if err := db.UpdateUserViewingEntry(uid, &user); err != nil {
+		return result, err
+	}
+
+	if finished && us.AutoFinishParents {
+		if _, err := db.FinishCompletedAncestors(uid, timezone, user.ItemId); err != nil {
+			logging.ELog(err)
+		}
+	}
+
+	result.Status = user.Status
+	result.CurrentPosition = user.CurrentPosition
+	result.Minutes = user.Minutes
+	return result, nil
+}
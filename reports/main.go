@@ -0,0 +1,316 @@
+package reports
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"aiolimas/db"
+	"aiolimas/logging"
+	db_types "aiolimas/types"
+)
+
+// the amount of items to put in the Longest and HighestRated lists
+const TOP_COUNT = 10
+
+type ReportItem struct {
+	ItemId     int64
+	Title      string
+	Type       db_types.MediaTypes
+	Format     db_types.Format
+	Thumbnail  string
+	UserRating float64
+	Minutes    int64
+}
+
+type GenreCount struct {
+	Genre string
+	Count int64
+}
+
+type Streak struct {
+	Days  int64
+	Start string // YYYY-MM-DD
+	End   string // YYYY-MM-DD
+}
+
+type Report struct {
+	Uid   int64
+	Start int64 // unix ms
+	End   int64 // unix ms
+
+	Started  []ReportItem
+	Finished []ReportItem
+	Dropped  []ReportItem
+
+	Longest      []ReportItem
+	HighestRated []ReportItem
+
+	// format name -> currency -> total spent
+	SpentPerFormat map[string]map[string]float64
+
+	Genres []GenreCount
+
+	LongestStreak Streak
+
+	// thumbnails of every finished item, in order of finishing
+	Collage []string
+}
+
+// events that count towards a streak
+var streakEvents = []string{
+	"Started", "Finished", "Resuming", "Paused", "Dropped", "Waiting",
+}
+
+// events that only have an After timestamp are placed at that timestamp
+func eventTime(ev *db_types.UserViewingEvent) int64 {
+	if ev.Timestamp != 0 {
+		return ev.Timestamp
+	}
+	return ev.After
+}
+
+func eventDay(ev *db_types.UserViewingEvent) string {
+	t := time.UnixMilli(eventTime(ev))
+	if loc, err := time.LoadLocation(ev.TimeZone); err == nil && ev.TimeZone != "" {
+		t = t.In(loc)
+	} else {
+		t = t.UTC()
+	}
+	return t.Format(time.DateOnly)
+}
+
+func YearRange(year int) (int64, int64) {
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(year+1, time.January, 1, 0, 0, 0, 0, time.UTC)
+	return start.UnixMilli(), end.UnixMilli() - 1
+}
+
+func longestStreak(days []string) Streak {
+	out := Streak{}
+	if len(days) == 0 {
+		return out
+	}
+
+	slices.Sort(days)
+	days = slices.Compact(days)
+
+	cur := Streak{Days: 1, Start: days[0], End: days[0]}
+	out = cur
+
+	for _, day := range days[1:] {
+		prev, _ := time.Parse(time.DateOnly, cur.End)
+		d, _ := time.Parse(time.DateOnly, day)
+
+		if d.Sub(prev) == 24*time.Hour {
+			cur.Days += 1
+			cur.End = day
+		} else {
+			cur = Streak{Days: 1, Start: day, End: day}
+		}
+
+		if cur.Days > out.Days {
+			out = cur
+		}
+	}
+
+	return out
+}
+
+// start and end are unix ms timestamps, both inclusive
+func BuildReport(ctx db.RequestContext, start int64, end int64) (Report, error) {
+	out := Report{
+		Uid:            ctx.UID,
+		Start:          start,
+		End:            end,
+		Started:        []ReportItem{},
+		Finished:       []ReportItem{},
+		Dropped:        []ReportItem{},
+		Longest:        []ReportItem{},
+		HighestRated:   []ReportItem{},
+		SpentPerFormat: map[string]map[string]float64{},
+		Genres:         []GenreCount{},
+		Collage:        []string{},
+	}
+
+	infos, err := db.ListEntries(ctx, "entryInfo.itemid")
+	if err != nil {
+		return out, err
+	}
+
+	metas, err := db.ListMetadata(ctx)
+	if err != nil {
+		return out, err
+	}
+
+	users, err := db.AllUserEntries(ctx)
+	if err != nil {
+		return out, err
+	}
+
+	events, err := db.GetEvents(ctx, -1)
+	if err != nil {
+		return out, err
+	}
+
+	transactions, err := db.ListTransactions(ctx, 0)
+	if err != nil {
+		return out, err
+	}
+
+	infoMap := map[int64]db_types.InfoEntry{}
+	for _, info := range infos {
+		infoMap[info.ItemId] = info
+	}
+
+	metaMap := map[int64]db_types.MetadataEntry{}
+	for _, meta := range metas {
+		metaMap[meta.ItemId] = meta
+	}
+
+	userMap := map[int64]db_types.UserViewingEntry{}
+	for _, user := range users {
+		userMap[user.ItemId] = user
+	}
+
+	mkItem := func(id int64) ReportItem {
+		info := infoMap[id]
+		meta := metaMap[id]
+		user := userMap[id]
+
+		title := info.En_Title
+		if title == "" {
+			title = meta.Title
+		}
+
+		return ReportItem{
+			ItemId:     id,
+			Title:      title,
+			Type:       info.Type,
+			Format:     info.Format,
+			Thumbnail:  meta.Thumbnail,
+			UserRating: user.UserRating,
+			Minutes:    user.Minutes,
+		}
+	}
+
+	// an entry can be started, finished, or dropped more than once in the period, it is listed once
+	addOnce := func(list *[]ReportItem, id int64) {
+		if !slices.ContainsFunc(*list, func(item ReportItem) bool { return item.ItemId == id }) {
+			*list = append(*list, mkItem(id))
+		}
+	}
+
+	// eventId -> timestamp, needed for transactions
+	eventTimes := map[int64]int64{}
+
+	finished := []int64{}
+	streakDays := []string{}
+
+	for _, ev := range events {
+		t := eventTime(&ev)
+		eventTimes[ev.EventId] = t
+
+		if t < start || t > end {
+			continue
+		}
+
+		if _, ok := infoMap[ev.ItemId]; !ok {
+			continue
+		}
+
+		switch ev.Event {
+		case "Started":
+			addOnce(&out.Started, ev.ItemId)
+		case "Finished":
+			addOnce(&out.Finished, ev.ItemId)
+			if !slices.Contains(finished, ev.ItemId) {
+				finished = append(finished, ev.ItemId)
+			}
+		case "Dropped":
+			addOnce(&out.Dropped, ev.ItemId)
+		}
+
+		if slices.Contains(streakEvents, ev.Event) {
+			streakDays = append(streakDays, eventDay(&ev))
+		}
+	}
+
+	out.LongestStreak = longestStreak(streakDays)
+
+	genreCounts := map[string]int64{}
+
+	for _, id := range finished {
+		item := mkItem(id)
+		out.Longest = append(out.Longest, item)
+		out.HighestRated = append(out.HighestRated, item)
+
+		if item.Thumbnail != "" {
+			out.Collage = append(out.Collage, item.Thumbnail)
+		}
+
+		genres := metaMap[id].Genres
+		if genres == "" {
+			continue
+		}
+
+		var genreList []string
+		if err := json.Unmarshal([]byte(genres), &genreList); err != nil {
+			logging.ELog(err)
+			continue
+		}
+
+		for _, genre := range genreList {
+			genreCounts[genre] += 1
+		}
+	}
+
+	slices.SortStableFunc(out.Longest, func(a ReportItem, b ReportItem) int {
+		return int(b.Minutes - a.Minutes)
+	})
+	out.Longest = out.Longest[:min(TOP_COUNT, len(out.Longest))]
+
+	slices.SortStableFunc(out.HighestRated, func(a ReportItem, b ReportItem) int {
+		if a.UserRating > b.UserRating {
+			return -1
+		} else if a.UserRating < b.UserRating {
+			return 1
+		}
+		return 0
+	})
+	out.HighestRated = out.HighestRated[:min(TOP_COUNT, len(out.HighestRated))]
+
+	for genre, count := range genreCounts {
+		out.Genres = append(out.Genres, GenreCount{Genre: genre, Count: count})
+	}
+	slices.SortFunc(out.Genres, func(a GenreCount, b GenreCount) int {
+		if a.Count != b.Count {
+			return int(b.Count - a.Count)
+		}
+		if a.Genre < b.Genre {
+			return -1
+		}
+		return 1
+	})
+
+	formatNames := db_types.ListFormats()
+	for _, t := range transactions {
+		info, ok := infoMap[t.ItemId]
+		if !ok || t.Price <= 0 || !t.Kind.MovesMoney() {
+			continue
+		}
+
+		ts, ok := eventTimes[t.EventId]
+		if !ok || ts < start || ts > end {
+			continue
+		}
+
+		name := formatNames[info.Format]
+		if _, ok := out.SpentPerFormat[name]; !ok {
+			out.SpentPerFormat[name] = map[string]float64{}
+		}
+		out.SpentPerFormat[name][t.Currency] += t.Price
+	}
+
+	return out, nil
+}
@@ -0,0 +1,77 @@
+package reports
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"aiolimas/db"
+	db_types "aiolimas/types"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "aio-reports-test")
+	if err != nil {
+		panic(err.Error())
+	}
+	os.Setenv("AIO_DIR", dir)
+
+	// the schema is read relative to the root of the repo
+	if err := os.Chdir(".."); err != nil {
+		panic(err.Error())
+	}
+	if err := db.InitDb(); err != nil {
+		panic(err.Error())
+	}
+
+	code := m.Run()
+	db.DB.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestBuildReportListsEntriesOnce(t *testing.T) {
+	const uid = 26
+	start, end := YearRange(2024)
+
+	info := db_types.InfoEntry{En_Title: "rewatched", Type: db_types.TY_MOVIE}
+	var meta db_types.MetadataEntry
+	var user db_types.UserViewingEntry
+	if err := db.AddEntry(uid, "UTC", &info, &meta, &user); err != nil {
+		t.Fatal(err)
+	}
+
+	day := func(month time.Month, d int) int64 {
+		return time.Date(2024, month, d, 12, 0, 0, 0, time.UTC).UnixMilli()
+	}
+	events := []struct {
+		name string
+		at   int64
+	}{
+		{"Started", day(time.January, 1)},
+		{"Dropped", day(time.January, 2)},
+		{"Started", day(time.March, 1)},
+		{"Dropped", day(time.March, 2)},
+		{"Started", day(time.June, 1)},
+		{"Finished", day(time.June, 2)},
+		{"Finished", day(time.July, 2)},
+	}
+	for _, ev := range events {
+		_, err := db.RegisterUserEvent(uid, db_types.UserViewingEvent{ItemId: info.ItemId, Event: ev.name, Timestamp: ev.at, TimeZone: "UTC"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := BuildReport(db.RequestContext{UID: uid, Auth: uid}, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lists := map[string][]ReportItem{"Started": report.Started, "Dropped": report.Dropped, "Finished": report.Finished}
+	for name, list := range lists {
+		if len(list) != 1 || list[0].ItemId != info.ItemId {
+			t.Errorf("%s is %+v, want only %d", name, list, info.ItemId)
+		}
+	}
+}
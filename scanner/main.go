@@ -0,0 +1,621 @@
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"aiolimas/db"
+	"aiolimas/metadata"
+	"aiolimas/settings"
+	db_types "aiolimas/types"
+)
+
+/*
+	The scanner walks the user's LibraryRoots and groups the files in them into items:
+
+	Movie:       root/Title (Year)/file.mkv, or root/Title (Year).mkv
+	Show:        root/Title/Season 1/..., seasons become children
+	Albumn:      root/Album/*.flac, or root/Artist/Album/*.flac
+	Manga, Book: root/Series/Series v01.cbz, volumes become children
+
+	Items are matched with entries by Location,
+	and entries whose Location disappeared are matched with new items by folder name or title to detect moves
+*/
+
+type fileKind int
+
+const (
+	k_unknown fileKind = iota
+	k_video
+	k_audio
+	k_comic
+	k_book
+	// files that go with media, eg: subtitles, covers
+	k_sidecar
+)
+
+var extensions = map[string]fileKind{
+	".mkv": k_video, ".mp4": k_video, ".avi": k_video, ".webm": k_video, ".m4v": k_video,
+	".mov": k_video, ".wmv": k_video, ".ts": k_video, ".flv": k_video, ".mpg": k_video,
+	".mpeg": k_video, ".ogv": k_video,
+
+	".mp3": k_audio, ".flac": k_audio, ".ogg": k_audio, ".opus": k_audio, ".m4a": k_audio,
+	".wav": k_audio, ".aac": k_audio, ".wma": k_audio, ".alac": k_audio, ".ape": k_audio,
+
+	".cbz": k_comic, ".cbr": k_comic, ".cb7": k_comic, ".cbt": k_comic,
+
+	".epub": k_book, ".pdf": k_book, ".mobi": k_book, ".azw3": k_book, ".djvu": k_book,
+
+	".nfo": k_sidecar, ".srt": k_sidecar, ".ass": k_sidecar, ".ssa": k_sidecar, ".sub": k_sidecar,
+	".idx": k_sidecar, ".vtt": k_sidecar, ".jpg": k_sidecar, ".jpeg": k_sidecar, ".png": k_sidecar,
+	".webp": k_sidecar, ".txt": k_sidecar, ".cue": k_sidecar, ".log": k_sidecar, ".m3u": k_sidecar,
+}
+
+func kindOf(name string) fileKind {
+	return extensions[strings.ToLower(filepath.Ext(name))]
+}
+
+var (
+	yearRe      = regexp.MustCompile(`(?:^|[^\d])((?:19|20)\d{2})(?:[^\d]|$)`)
+	tagRe       = regexp.MustCompile(`\[[^\]]*\]|\([^)]*\)|\{[^}]*\}`)
+	junkRe      = regexp.MustCompile(`(?i)\b(?:\d{3,4}p|[xh]\.?26[45]|hevc|bluray|blu-ray|web-?dl|webrip|hdtv|dvdrip|remux)\b.*$`)
+	seasonDirRe = regexp.MustCompile(`(?i)^(?:season|series|s)[ ._-]*(\d{1,3})$`)
+	volumeRe    = regexp.MustCompile(`(?i)(?:^|[^a-z])(?:volume|vol|v)[ ._-]*(\d{1,3})(?:[^\d]|$)`)
+	discDirRe   = regexp.MustCompile(`(?i)^(?:cd|dis[ck])[ ._-]*\d+$`)
+	episodeRe   = regexp.MustCompile(`(?i)(?:^|[^a-z\d])s\d{1,3}[ ._-]*e\d{1,4}(?:[^\d]|$)`)
+)
+
+// gets the title and year from a file or folder name (without the extension)
+// eg: "The.Matrix.1999.1080p.BluRay" -> "The Matrix", 1999
+// eg: "[Group] Some Show (2010) [1080p]" -> "Some Show", 2010
+func ParseName(name string) (string, int64) {
+	spaced := name
+	if !strings.Contains(name, " ") {
+		spaced = strings.NewReplacer(".", " ", "_", " ").Replace(name)
+	}
+	spaced = junkRe.ReplaceAllString(spaced, "")
+
+	var year int64
+	// the last year is used so that titles with years in them keep them, eg: Blade Runner 2049 (2017)
+	matches := yearRe.FindAllStringSubmatchIndex(spaced, -1)
+	for i := len(matches) - 1; i >= 0; i-- {
+		before := spaced[:matches[i][2]]
+		if strings.TrimSpace(tagRe.ReplaceAllString(before, "")) == "" {
+			continue
+		}
+		year, _ = strconv.ParseInt(spaced[matches[i][2]:matches[i][3]], 10, 64)
+		spaced = before
+		break
+	}
+
+	title := strings.Join(strings.Fields(tagRe.ReplaceAllString(spaced, " ")), " ")
+	title = strings.Trim(title, " -([")
+	if title == "" {
+		return name, year
+	}
+	return title, year
+}
+
+func stripExt(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// a group of files that becomes one entry
+type Item struct {
+	Path  string
+	Title string
+	Year  int64
+	Type  db_types.MediaTypes
+	// the season or volume number of a child, 0 for items at the top of a root
+	Number   int64
+	Children []Item
+}
+
+type RootScan struct {
+	Items []Item
+	// files that could not be grouped into an item
+	Unmatched []string
+}
+
+func readDir(dir string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(entries, func(e os.DirEntry) bool {
+		return isHidden(e.Name())
+	}), nil
+}
+
+// counts the media files directly in dir, and says if dir has season folders or episode files
+func countDir(dir string) (map[fileKind]int, bool) {
+	counts := map[fileKind]int{}
+	episodic := false
+
+	entries, err := readDir(dir)
+	if err != nil {
+		return counts, false
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if seasonDirRe.MatchString(entry.Name()) {
+				episodic = true
+			}
+			continue
+		}
+		kind := kindOf(entry.Name())
+		counts[kind]++
+		if kind == k_video && episodeRe.MatchString(entry.Name()) {
+			episodic = true
+		}
+	}
+	return counts, episodic
+}
+
+// guesses the type of an item at the top of a root that has no Type
+// returns "" if it does not look like media
+func guessType(path string, isDir bool) db_types.MediaTypes {
+	if !isDir {
+		switch kindOf(path) {
+		case k_video:
+			return db_types.TY_MOVIE
+		case k_audio:
+			return db_types.TY_SONG
+		case k_comic:
+			return db_types.TY_MANGA
+		case k_book:
+			return db_types.TY_BOOK
+		}
+		return ""
+	}
+
+	counts, episodic := countDir(path)
+	if episodic {
+		return db_types.TY_SHOW
+	}
+
+	switch {
+	case counts[k_video] == 1:
+		return db_types.TY_MOVIE
+	case counts[k_video] > 1:
+		return db_types.TY_SHOW
+	case counts[k_audio] > 0:
+		return db_types.TY_ALBUMN
+	case counts[k_comic] > 0:
+		return db_types.TY_MANGA
+	case counts[k_book] > 0:
+		return db_types.TY_BOOK
+	}
+
+	// eg: Artist/Album/*.flac, or a show whose seasons are not named Season N
+	entries, err := readDir(path)
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		counts, _ := countDir(filepath.Join(path, entry.Name()))
+		if counts[k_audio] > 0 {
+			return db_types.TY_ALBUMN
+		}
+		if counts[k_video] > 0 {
+			return db_types.TY_SHOW
+		}
+	}
+	return ""
+}
+
+func mkItem(path string, isDir bool, ty db_types.MediaTypes) Item {
+	name := filepath.Base(path)
+	if !isDir {
+		name = stripExt(name)
+	}
+	title, year := ParseName(name)
+	return Item{
+		Path:  path,
+		Title: title,
+		Year:  year,
+		Type:  ty,
+	}
+}
+
+func scanShow(path string, ty db_types.MediaTypes) Item {
+	show := mkItem(path, true, ty)
+
+	entries, err := readDir(path)
+	if err != nil {
+		return show
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		// folders that are not seasons (eg: Specials, Extras) are part of the show itself
+		m := seasonDirRe.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+		n, _ := strconv.ParseInt(m[1], 10, 64)
+		if n == 0 {
+			continue
+		}
+		show.Children = append(show.Children, Item{
+			Path:   filepath.Join(path, entry.Name()),
+			Title:  fmt.Sprintf("Season %d", n),
+			Type:   ty,
+			Number: n,
+		})
+	}
+	slices.SortFunc(show.Children, func(a Item, b Item) int {
+		return int(a.Number - b.Number)
+	})
+	return show
+}
+
+func scanSeries(path string, ty db_types.MediaTypes) Item {
+	series := mkItem(path, true, ty)
+
+	entries, err := readDir(path)
+	if err != nil {
+		return series
+	}
+	seen := map[int64]bool{}
+	for _, entry := range entries {
+		if !entry.IsDir() && kindOf(entry.Name()) != k_comic && kindOf(entry.Name()) != k_book {
+			continue
+		}
+
+		name := entry.Name()
+		if !entry.IsDir() {
+			name = stripExt(name)
+		}
+		m := volumeRe.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		n, _ := strconv.ParseInt(m[1], 10, 64)
+		if n == 0 || seen[n] {
+			continue
+		}
+		seen[n] = true
+
+		series.Children = append(series.Children, Item{
+			Path:   filepath.Join(path, entry.Name()),
+			Title:  fmt.Sprintf("Volume %d", n),
+			Type:   ty,
+			Number: n,
+		})
+	}
+	slices.SortFunc(series.Children, func(a Item, b Item) int {
+		return int(a.Number - b.Number)
+	})
+	return series
+}
+
+// an album folder has audio in it, or only has disc folders (CD1, Disc 2) with audio in them
+// otherwise it is an artist folder, and each of its folders with audio is an album
+func scanAlbums(path string, ty db_types.MediaTypes) ([]Item, []string) {
+	counts, _ := countDir(path)
+	if counts[k_audio] > 0 {
+		return []Item{mkItem(path, true, ty)}, nil
+	}
+
+	entries, err := readDir(path)
+	if err != nil {
+		return nil, []string{path}
+	}
+
+	albums := []Item{}
+	discs := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		sub := filepath.Join(path, entry.Name())
+		counts, _ := countDir(sub)
+		if counts[k_audio] == 0 {
+			continue
+		}
+		if discDirRe.MatchString(entry.Name()) {
+			discs++
+		}
+		albums = append(albums, mkItem(sub, true, ty))
+	}
+
+	if len(albums) == 0 {
+		return nil, []string{path}
+	}
+	if discs == len(albums) {
+		return []Item{mkItem(path, true, ty)}, nil
+	}
+	return albums, nil
+}
+
+// groups everything at the top of root into items of type ty,
+// if ty is "", the type of each item is guessed
+func ScanRoot(root string, ty db_types.MediaTypes) (RootScan, error) {
+	scan := RootScan{
+		Items:     []Item{},
+		Unmatched: []string{},
+	}
+
+	entries, err := readDir(root)
+	if err != nil {
+		return scan, err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(root, entry.Name())
+		isDir := entry.IsDir()
+
+		if !isDir {
+			switch kindOf(entry.Name()) {
+			case k_sidecar:
+				continue
+			case k_unknown:
+				scan.Unmatched = append(scan.Unmatched, path)
+				continue
+			}
+		}
+
+		itemType := ty
+		if itemType == "" {
+			itemType = guessType(path, isDir)
+		}
+
+		switch itemType {
+		case "":
+			scan.Unmatched = append(scan.Unmatched, path)
+
+		case db_types.TY_SHOW:
+			// a loose file in a show root is most likely an episode that was not put in its show's folder
+			if !isDir {
+				scan.Unmatched = append(scan.Unmatched, path)
+				continue
+			}
+			scan.Items = append(scan.Items, scanShow(path, itemType))
+
+		case db_types.TY_ALBUMN:
+			if !isDir {
+				scan.Unmatched = append(scan.Unmatched, path)
+				continue
+			}
+			albums, unmatched := scanAlbums(path, itemType)
+			scan.Items = append(scan.Items, albums...)
+			scan.Unmatched = append(scan.Unmatched, unmatched...)
+
+		case db_types.TY_MANGA, db_types.TY_BOOK:
+			if !isDir {
+				scan.Items = append(scan.Items, mkItem(path, false, itemType))
+				continue
+			}
+			scan.Items = append(scan.Items, scanSeries(path, itemType))
+
+		default:
+			scan.Items = append(scan.Items, mkItem(path, isDir, itemType))
+		}
+	}
+
+	return scan, nil
+}
+
+type Move struct {
+	ItemId int64
+	From   string
+	To     string
+}
+
+type Report struct {
+	// entries that were created, ItemId is 0 if the scan was not applied
+	Created []db_types.InfoEntry
+	// entries whose Location is still where the scan found it
+	Matched []int64
+	// entries whose files were found somewhere else in a root
+	Moved []Move
+	// entries in a root whose Location no longer exists, these are not deleted
+	Missing []db_types.InfoEntry
+	// files that could not be grouped into an entry
+	Unmatched []string
+	// if entries were created and moved entries were updated
+	Applied bool
+}
+
+func isUnder(path string, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+func sameItem(entry db_types.InfoEntry, oldPath string, item Item) bool {
+	if entry.Type != item.Type {
+		return false
+	}
+	return filepath.Base(oldPath) == filepath.Base(item.Path) ||
+		strings.EqualFold(entry.En_Title, item.Title)
+}
+
+// scans roots and matches what is found with uid's entries
+// if apply is false, nothing is written, and the report says what would happen
+func Scan(uid int64, us settings.SettingsData, roots []settings.LibraryRoot, apply bool) (Report, error) {
+	report := Report{
+		Created:   []db_types.InfoEntry{},
+		Matched:   []int64{},
+		Moved:     []Move{},
+		Missing:   []db_types.InfoEntry{},
+		Unmatched: []string{},
+		Applied:   apply,
+	}
+
+	expand := func(location string) string {
+		return filepath.Clean(settings.ExpandPathWithLocationAliases(us.LocationAliases, location))
+	}
+
+	ctx := db.RequestContext{UID: uid, Auth: uid}
+	entries, err := db.ListEntries(ctx, "entryInfo.itemId")
+	if err != nil {
+		return report, err
+	}
+
+	byPath := map[string]db_types.InfoEntry{}
+	for _, entry := range entries {
+		if entry.Location == "" {
+			continue
+		}
+		path := expand(entry.Location)
+		if _, ok := byPath[path]; !ok {
+			byPath[path] = entry
+		}
+	}
+
+	rootPaths := []string{}
+	items := []Item{}
+	// the root that each item came from
+	itemRoots := []settings.LibraryRoot{}
+	for _, root := range roots {
+		rootPath := expand(root.Path)
+		// a root that cannot be read (eg: an unmounted drive) is an error,
+		// otherwise every entry in it would be reported missing
+		scan, err := ScanRoot(rootPath, db_types.MediaTypes(root.Type))
+		if err != nil {
+			return report, fmt.Errorf("could not scan %s: %w", root.Path, err)
+		}
+		rootPaths = append(rootPaths, rootPath)
+		report.Unmatched = append(report.Unmatched, scan.Unmatched...)
+
+		for _, item := range scan.Items {
+			items = append(items, item)
+			itemRoots = append(itemRoots, root)
+		}
+	}
+
+	// entries in a root whose files are gone, these are either moved or deleted
+	missing := map[string]db_types.InfoEntry{}
+	for path, entry := range byPath {
+		if !slices.ContainsFunc(rootPaths, func(root string) bool { return isUnder(path, root) }) {
+			continue
+		}
+		if _, err := os.Lstat(path); err != nil {
+			missing[path] = entry
+		}
+	}
+
+	moveEntry := func(entry db_types.InfoEntry, from string, to string) error {
+		delete(missing, from)
+		delete(byPath, from)
+
+		location := settings.CondensePathWithLocationAliases(us.LocationAliases, to)
+		report.Moved = append(report.Moved, Move{ItemId: entry.ItemId, From: entry.Location, To: location})
+		entry.Location = location
+		byPath[to] = entry
+		if apply {
+			return db.UpdateInfoEntry(uid, &entry)
+		}
+		return nil
+	}
+
+	for _, item := range items {
+		if _, ok := byPath[item.Path]; ok {
+			continue
+		}
+
+		oldPaths := slices.Sorted(maps.Keys(missing))
+		for _, oldPath := range oldPaths {
+			entry, ok := missing[oldPath]
+			if !ok || !sameItem(entry, oldPath, item) {
+				continue
+			}
+
+			if err := moveEntry(entry, oldPath, item.Path); err != nil {
+				return report, err
+			}
+			// everything that was in the old folder (eg: seasons) moved with it
+			for _, childPath := range oldPaths {
+				child, ok := missing[childPath]
+				if !ok || !isUnder(childPath, oldPath) {
+					continue
+				}
+				newPath := item.Path + strings.TrimPrefix(childPath, oldPath)
+				if err := moveEntry(child, childPath, newPath); err != nil {
+					return report, err
+				}
+			}
+			break
+		}
+	}
+
+	for _, entry := range missing {
+		report.Missing = append(report.Missing, entry)
+	}
+	slices.SortFunc(report.Missing, func(a db_types.InfoEntry, b db_types.InfoEntry) int {
+		return int(a.ItemId - b.ItemId)
+	})
+
+	var add func(item Item, parent db_types.InfoEntry, root settings.LibraryRoot) error
+	add = func(item Item, parent db_types.InfoEntry, root settings.LibraryRoot) error {
+		entry, ok := byPath[item.Path]
+		if ok {
+			report.Matched = append(report.Matched, entry.ItemId)
+		} else {
+			entry = db_types.InfoEntry{
+				En_Title: item.Title,
+				Format:   db_types.F_DIGITAL,
+				Location: settings.CondensePathWithLocationAliases(us.LocationAliases, item.Path),
+				Type:     item.Type,
+				ArtStyle: db_types.ArtStyle(root.ArtStyle),
+				Library:  root.Library,
+			}
+			meta := db_types.MetadataEntry{
+				Title:       item.Title,
+				ReleaseYear: item.Year,
+			}
+
+			if item.Number != 0 {
+				entry.En_Title = fmt.Sprintf("%s %s", parent.En_Title, item.Title)
+				meta.Title = entry.En_Title
+				md, _ := json.Marshal(map[string]string{
+					metadata.DefaultExpandKind(item.Type).NumberKey(item.Type): fmt.Sprintf("%d", item.Number),
+				})
+				meta.MediaDependant = string(md)
+			}
+
+			if apply {
+				user := db_types.UserViewingEntry{}
+				if err := db.AddEntry(uid, us.DefaultTimeZone, &entry, &meta, &user); err != nil {
+					return fmt.Errorf("could not add %s: %w", item.Path, err)
+				}
+				if item.Number != 0 && parent.ItemId != 0 {
+					if err := db.AddRelation(uid, entry.ItemId, db_types.R_Child, parent.ItemId); err != nil {
+						return fmt.Errorf("could not make %s a child: %w", item.Path, err)
+					}
+				}
+			}
+			report.Created = append(report.Created, entry)
+		}
+
+		for _, child := range item.Children {
+			if err := add(child, entry, root); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i, item := range items {
+		if err := add(item, db_types.InfoEntry{}, itemRoots[i]); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
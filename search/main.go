@@ -486,6 +486,45 @@ func (self MacroNode) ToString() (string, error) {
 			return fmt.Sprintf("mediaDependant != '' AND CAST(jsoN_extract(mediaDependant, '$.%s') as decimal)", name), nil
 		},
 
+		// rel:<kind> entries that have a <kind> relation
+		// rel:<kind>:<id> entries that are <kind> <id>
+		"rel": func(macro string) (string, error) {
+			name, other, hasOther := strings.Cut(macro[4:], ":")
+			kind, reversed, ok := db_types.LookupRelationKind(name)
+			if !ok {
+				return "", errors.New("invalid relation kind " + name)
+			}
+
+			self, target := "left", "right"
+			if reversed {
+				self, target = target, self
+			}
+
+			cond := fmt.Sprintf("relations.%s = entryInfo.itemId", self)
+			if kind.Symmetric {
+				cond = "(relations.left = entryInfo.itemId OR relations.right = entryInfo.itemId)"
+			}
+
+			if hasOther {
+				id, err := strconv.ParseInt(other, 10, 64)
+				if err != nil {
+					return "", errors.New("invalid id " + other)
+				}
+				if kind.Symmetric {
+					cond = fmt.Sprintf("((relations.left = entryInfo.itemId AND relations.right = %d) OR (relations.right = entryInfo.itemId AND relations.left = %d))", id, id)
+				} else {
+					cond += fmt.Sprintf(" AND relations.%s = %d", target, id)
+				}
+			}
+
+			return fmt.Sprintf("EXISTS (SELECT * FROM relations WHERE relations.relation = %d AND %s)", kind.Id, cond), nil
+		},
+
+		"shelf": func(macro string) (string, error) {
+			storage := macro[6:]
+			return fmt.Sprintf("EXISTS (SELECT * FROM inventory WHERE inventory.itemId = entryInfo.itemId AND inventory.storage LIKE '%%%s%%')", storage), nil
+		},
+
 		"g": func(macro string) (string, error) {
 			genre := macro[2:]
 			return fmt.Sprintf("EXISTS (SELECT * FROM json_each(json_extract(genres, '$')) WHERE genres != '' AND json_each.value LIKE '%s')", genre), nil
@@ -505,6 +544,8 @@ func (self MacroNode) ToString() (string, error) {
 		"ep":      "CAST(json_extract(mediaDependant, format('$.%s-episodes', type)) as DECIMAL)",
 		"len":     "CAST(json_extract(mediaDependant, format('$.%s-length', type)) as DECIMAL)",
 		"epd":     "CAST(json_extract(mediaDependant, format('$.%s-episode-duration', type)) as DECIMAL)",
+		"loaned":  "EXISTS (SELECT * FROM loans WHERE loans.itemId = entryInfo.itemId AND loans.returned = 0)",
+		"overdue": "EXISTS (SELECT * FROM loans WHERE loans.itemId = entryInfo.itemId AND loans.returned = 0 AND loans.due != 0 AND loans.due < CAST(strftime('%s', 'now') AS INTEGER) * 1000)",
 	}
 
 	for _, item := range mediaTypes {
@@ -9,6 +9,9 @@ import (
 )
 
 type SettingsData struct {
+	// the user the settings are for, it is not stored
+	Uid int64 `json:"-"`
+
 	SonarrURL string
 	SonarrKey string
 	RadarrURL string
@@ -16,9 +19,94 @@ type SettingsData struct {
 
 	WriteIdFile bool
 
+	// write an nfo file next to an entry's Location when its metadata is fetched or refreshed
+	WriteNFO bool
+
 	LocationAliases map[string]string
 
 	DefaultTimeZone string
+
+	// ISO 4217 code that ledger totals are converted to
+	PreferredCurrency string
+
+	// when every descendant of an entry is finished, finish the entry too
+	AutoFinishParents bool
+
+	MetadataRefresh MetadataRefreshSettings
+
+	// media type -> metadata field -> providers to take that field from, in order
+	// the "*" type applies to every type
+	// eg: {"Show": {"Description": ["wikipedia"], "Rating": ["anilist", "omdb"]}}
+	MetadataPriority map[string]map[string][]string
+
+	// folders that the library scanner creates entries from
+	LibraryRoots []LibraryRoot
+
+	// how much of a file, in percent, a player has to play for the entry to be finished, defaults to 90
+	PlaybackFinishPercent float64
+
+	// lets webhooks (eg: /hook-radarr) authorize with ?uid=...&secret=... instead of a password
+	// "" disables this
+	WebhookSecret string
+
+	// radarr/sonarr tag -> what it does to the entries that are created from items with that tag
+	// these are added to, or replace the default rules for no-add, planned, anime, and live-action
+	ArrTagRules map[string]ArrTagRule
+
+	// when a movie or series is deleted from radarr/sonarr, delete its entries
+	// otherwise they are kept, but are no longer linked to radarr/sonarr
+	ArrDeleteEntries bool
+}
+
+type ArrTagRule struct {
+	// do not create an entry
+	Skip bool
+
+	// the status of the entry, eg: Planned
+	Status string
+
+	// added to the ArtStyle of the entry
+	ArtStyle uint64
+
+	// the name of the format of the entry, eg: 4KBLURAY, see /type/format
+	Format string
+
+	// aio tags that are added to the entry
+	Tags []string
+
+	Library int64
+}
+
+type LibraryRoot struct {
+	// may use LocationAliases, eg: ${ANIME}
+	Path string
+
+	// the type of every item in the root, eg: Movie, Show, Albumn, Manga, Book
+	// "" guesses the type of each item from the files in it
+	Type string
+
+	// ArtStyle and Library are given to every entry that is created from this root
+	ArtStyle uint64
+	Library  int64
+}
+
+// which entries have their metadata refreshed in the background
+// durations are go durations, eg: 24h, 168h
+type MetadataRefreshSettings struct {
+	Enabled bool
+
+	// entries with one of these statuses are refreshed, eg: ["Viewing", "Waiting", "Planned"]
+	Statuses []string
+
+	// entries that have not been refreshed in this long are refreshed, whatever their status
+	// "" means only Statuses are used
+	MaxAge string
+
+	// the minimum time between refreshes of the same entry, defaults to 24h
+	MinInterval string
+
+	// the most entries that are refreshed at a time, defaults to 25
+	Limit int64
 }
 
 func GetUserSettings(uid int64) (SettingsData, error) {
@@ -26,18 +114,70 @@ func GetUserSettings(uid int64) (SettingsData, error) {
 
 	file, err := os.Open(settingsFile)
 	if err != nil {
-		return SettingsData{}, nil
+		return SettingsData{Uid: uid}, nil
 	}
 	text, err := io.ReadAll(file)
 	if err != nil {
-		return SettingsData{}, err
+		return SettingsData{Uid: uid}, err
 	}
 
 	var settings SettingsData
 
 	err = json.Unmarshal(text, &settings)
 	if err != nil {
-		return SettingsData{}, err
+		return SettingsData{Uid: uid}, err
+	}
+
+	settings.Uid = uid
+	return settings, nil
+}
+
+// server wide settings, stored in $AIO_CONFIG_FILE
+type ServerSettings struct {
+	MetadataCache MetadataCacheSettings
+
+	// the url that other programs reach the server at, eg: https://aio.example.com
+	// used for the urls in playlists, when empty the Host of the request is used
+	PublicURL string
+
+	// read the scheme and host from X-Forwarded-Proto and X-Forwarded-Host
+	// only set this when the server is behind a proxy that sets them
+	TrustForwardedHeaders bool
+}
+
+// durations are go durations, eg: 12h, 1h30m, 500ms
+type MetadataCacheSettings struct {
+	// where responses are stored, defaults to $AIO_DIR/metadata-cache
+	Dir string
+
+	// how long responses are kept, "0" disables the cache
+	DefaultTTL string
+	// provider name -> ttl, overrides DefaultTTL
+	TTL map[string]string
+
+	// host -> the minimum time between requests to that host
+	RateLimit map[string]string
+
+	// only serve cached responses, requests that are not cached fail
+	Offline bool
+}
+
+func GetServerSettings() (ServerSettings, error) {
+	file, err := os.Open(os.Getenv("AIO_CONFIG_FILE"))
+	if err != nil {
+		return ServerSettings{}, nil
+	}
+	defer file.Close()
+
+	text, err := io.ReadAll(file)
+	if err != nil {
+		return ServerSettings{}, err
+	}
+
+	var settings ServerSettings
+	err = json.Unmarshal(text, &settings)
+	if err != nil {
+		return ServerSettings{}, err
 	}
 
 	return settings, nil
@@ -0,0 +1,427 @@
+package stream
+
+import (
+	"cmp"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"aiolimas/db"
+	"aiolimas/metadata"
+	"aiolimas/settings"
+	db_types "aiolimas/types"
+)
+
+type Format string
+
+const (
+	F_M3U8 Format = "m3u8"
+	F_XSPF Format = "xspf"
+)
+
+func ListFormats() []Format {
+	return []Format{F_M3U8, F_XSPF}
+}
+
+func (self Format) ContentType() string {
+	switch self {
+	case F_XSPF:
+		return "application/xspf+xml"
+	}
+	return "application/vnd.apple.mpegurl"
+}
+
+var ErrOutsideLocation = errors.New("the subfile is outside of the entry's location")
+
+// mime.TypeByExtension depends on the mime.types files of the system, and most do not know about these
+var mediaTypes = map[string]string{
+	".mkv":  "video/x-matroska",
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".webm": "video/webm",
+	".avi":  "video/x-msvideo",
+	".mov":  "video/quicktime",
+	".ts":   "video/mp2t",
+	".ogv":  "video/ogg",
+	".mp3":  "audio/mpeg",
+	".flac": "audio/flac",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/ogg; codecs=opus",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".wav":  "audio/wav",
+	".epub": "application/epub+zip",
+	".cbz":  "application/vnd.comicbook+zip",
+	".cbr":  "application/vnd.comicbook-rar",
+	".pdf":  "application/pdf",
+	".srt":  "application/x-subrip",
+	".vtt":  "text/vtt",
+	".ass":  "text/x-ssa",
+	".nfo":  "text/xml",
+}
+
+// the content type of the file at path, based on its extension
+func ContentType(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ty, ok := mediaTypes[ext]; ok {
+		return ty
+	}
+	if ty := mime.TypeByExtension(ext); ty != "" {
+		return ty
+	}
+	return "application/octet-stream"
+}
+
+// whether the file at path can go in a playlist
+func IsStreamable(path string) bool {
+	ty := ContentType(path)
+	return strings.HasPrefix(ty, "audio/") || strings.HasPrefix(ty, "video/")
+}
+
+// joins subfile onto location (which must already be expanded),
+// returns ErrOutsideLocation if the result is not location, or inside of it
+func Resolve(location string, subfile string) (string, error) {
+	location = filepath.Clean(location)
+	if subfile == "" {
+		return location, nil
+	}
+
+	full := filepath.Join(location, filepath.FromSlash(subfile))
+	rel, err := filepath.Rel(location, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrOutsideLocation
+	}
+	return full, nil
+}
+
+type Track struct {
+	URL   string
+	Title string
+	// -1 if it is unknown
+	Duration time.Duration
+}
+
+// builds the url that streams subfile of the entry with id
+// base is the scheme and host, eg: http://localhost:8080
+// the url is signed for uid, so that players can use it without the user's password, see VerifyToken
+func StreamURL(base string, uid int64, id int64, subfile string) (string, error) {
+	k, err := signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	subfile = filepath.ToSlash(subfile)
+	expires := time.Now().Add(TokenLifetime).Unix()
+
+	query := url.Values{}
+	query.Set("uid", strconv.FormatInt(uid, 10))
+	query.Set("id", strconv.FormatInt(id, 10))
+	if subfile != "" {
+		query.Set("subfile", subfile)
+	}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("token", sign(k, uid, id, subfile, expires))
+	return fmt.Sprintf("%s/api/v1/entry/stream?%s", base, query.Encode()), nil
+}
+
+func entryTitle(entry db_types.InfoEntry) string {
+	if entry.En_Title != "" {
+		return entry.En_Title
+	}
+	return entry.Native_Title
+}
+
+// the length stored by metadata providers, in minutes
+func metadataLength(meta db_types.MetadataEntry, ty db_types.MediaTypes) time.Duration {
+	var md map[string]string
+	if err := json.Unmarshal([]byte(meta.MediaDependant), &md); err != nil {
+		return -1
+	}
+	minutes, err := strconv.ParseFloat(md[fmt.Sprintf("%s-length", ty)], 64)
+	if err != nil || minutes <= 0 {
+		return -1
+	}
+	return time.Duration(minutes * float64(time.Minute))
+}
+
+func fileDuration(path string) time.Duration {
+	if d := metadata.AudioDuration(path); d > 0 {
+		return d
+	}
+	if d := metadata.VideoDuration(path); d > 0 {
+		return d
+	}
+	return -1
+}
+
+// the number the child has in its parent (season, volume, etc), 0 if it has none
+func childNumber(ctx db.RequestContext, entry db_types.InfoEntry) int64 {
+	meta, err := db.GetMetadataEntryById(ctx, entry.ItemId)
+	if err != nil {
+		return 0
+	}
+	for _, kind := range metadata.ListExpandKinds() {
+		if n := metadata.ExpansionNumber(kind, entry.Type, meta); n != 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// the R_Child children of id, numbered children come first in order, the rest are sorted by title
+func children(ctx db.RequestContext, id int64) ([]db_types.InfoEntry, error) {
+	items, err := db.GetRelation(ctx, id, db_types.R_Child, false)
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := map[int64]int64{}
+	for _, item := range items {
+		numbers[item.ItemId] = childNumber(ctx, item)
+	}
+
+	slices.SortFunc(items, func(a db_types.InfoEntry, b db_types.InfoEntry) int {
+		na, nb := numbers[a.ItemId], numbers[b.ItemId]
+		if na != nb {
+			if na == 0 {
+				return 1
+			} else if nb == 0 {
+				return -1
+			}
+			return cmp.Compare(na, nb)
+		}
+		if c := strings.Compare(entryTitle(a), entryTitle(b)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ItemId, b.ItemId)
+	})
+	return items, nil
+}
+
+// the entry followed by all of its R_Child descendants, depth first
+func tree(ctx db.RequestContext, entry db_types.InfoEntry, seen map[int64]bool) ([]db_types.InfoEntry, error) {
+	if seen[entry.ItemId] {
+		return nil, nil
+	}
+	seen[entry.ItemId] = true
+
+	out := []db_types.InfoEntry{entry}
+	items, err := children(ctx, entry.ItemId)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		sub, err := tree(ctx, item, seen)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sub...)
+	}
+	return out, nil
+}
+
+// the tracks in dir, skip contains the locations of other entries, which get their own tracks
+func dirTracks(base string, entry db_types.InfoEntry, location string, dir string, skip map[string]bool) ([]Track, error) {
+	out := []Track{}
+	title := entryTitle(entry)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") || skip[path] {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !IsStreamable(path) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(location, path)
+		if err != nil {
+			return err
+		}
+		streamURL, err := StreamURL(base, entry.Uid, entry.ItemId, rel)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(d.Name(), filepath.Ext(d.Name()))
+		out = append(out, Track{
+			URL:      streamURL,
+			Title:    fmt.Sprintf("%s - %s", title, name),
+			Duration: fileDuration(path),
+		})
+		return nil
+	})
+	return out, err
+}
+
+// the tracks of one entry, if subfile is a folder, only the tracks in it are included
+func entryTracks(ctx db.RequestContext, us settings.SettingsData, base string, entry db_types.InfoEntry, subfile string, skip map[string]bool) ([]Track, error) {
+	if entry.Location == "" {
+		return nil, nil
+	}
+
+	location := filepath.Clean(settings.ExpandPathWithLocationAliases(us.LocationAliases, entry.Location))
+	path, err := Resolve(location, subfile)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// entries that are not on disk are left out instead of failing the whole playlist
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if stat.IsDir() {
+		return dirTracks(base, entry, location, path, skip)
+	}
+
+	if !IsStreamable(path) {
+		return nil, nil
+	}
+
+	duration := fileDuration(path)
+	if duration < 0 && subfile == "" {
+		if meta, err := db.GetMetadataEntryById(ctx, entry.ItemId); err == nil {
+			duration = metadataLength(meta, entry.Type)
+		}
+	}
+	streamURL, err := StreamURL(base, entry.Uid, entry.ItemId, subfile)
+	if err != nil {
+		return nil, err
+	}
+	return []Track{{
+		URL:      streamURL,
+		Title:    entryTitle(entry),
+		Duration: duration,
+	}}, nil
+}
+
+// the tracks of entry and, if withChildren is true, all of its R_Child descendants in order
+// base is the scheme and host that the track urls use
+func Tracks(ctx db.RequestContext, us settings.SettingsData, base string, entry db_types.InfoEntry, subfile string, withChildren bool) ([]Track, error) {
+	entries := []db_types.InfoEntry{entry}
+	if withChildren {
+		var err error
+		entries, err = tree(ctx, entry, map[int64]bool{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// a show's folder usually contains the folders of its seasons,
+	// those files belong to the season entries, not the show
+	skip := map[string]bool{}
+	for _, item := range entries[1:] {
+		if item.Location != "" {
+			skip[filepath.Clean(settings.ExpandPathWithLocationAliases(us.LocationAliases, item.Location))] = true
+		}
+	}
+
+	out := []Track{}
+	for i, item := range entries {
+		sub := ""
+		if i == 0 {
+			sub = subfile
+		}
+		tracks, err := entryTracks(ctx, us, base, item, sub, skip)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, tracks...)
+	}
+	return out, nil
+}
+
+func seconds(d time.Duration) int64 {
+	if d < 0 {
+		return -1
+	}
+	return int64(d.Round(time.Second) / time.Second)
+}
+
+func writeM3U8(w io.Writer, title string, tracks []Track) error {
+	text := "#EXTM3U\n"
+	if title != "" {
+		text += fmt.Sprintf("#PLAYLIST:%s\n", title)
+	}
+	for _, track := range tracks {
+		// a newline in the title would end the #EXTINF line early
+		name := strings.ReplaceAll(track.Title, "\n", " ")
+		text += fmt.Sprintf("#EXTINF:%d,%s\n%s\n", seconds(track.Duration), name, track.URL)
+	}
+	_, err := io.WriteString(w, text)
+	return err
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title"`
+	Duration int64  `xml:"duration,omitempty"`
+}
+
+// https://xspf.org/spec
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+func writeXSPF(w io.Writer, title string, tracks []Track) error {
+	playlist := xspfPlaylist{
+		Version: "1",
+		Title:   title,
+		Tracks:  []xspfTrack{},
+	}
+	for _, track := range tracks {
+		t := xspfTrack{
+			Location: track.URL,
+			Title:    track.Title,
+		}
+		// xspf durations are in milliseconds
+		if track.Duration > 0 {
+			t.Duration = track.Duration.Milliseconds()
+		}
+		playlist.Tracks = append(playlist.Tracks, t)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.Encode(playlist); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func WritePlaylist(w io.Writer, format Format, title string, tracks []Track) error {
+	switch format {
+	case F_XSPF:
+		return writeXSPF(w, title, tracks)
+	}
+	return writeM3U8(w, title, tracks)
+}
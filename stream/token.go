@@ -0,0 +1,71 @@
+package stream
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// how long the urls in a playlist can be used, players are given the playlist, not the user's password
+const TokenLifetime = 24 * time.Hour
+
+var (
+	keyMu   sync.Mutex
+	keyPath string
+	key     []byte
+)
+
+// the key that signs stream urls, it is made the first time it is needed and kept in $AIO_DIR/stream-key
+func signingKey() ([]byte, error) {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+
+	path := filepath.Join(os.Getenv("AIO_DIR"), "stream-key")
+	if key != nil && keyPath == path {
+		return key, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		data = make([]byte, 32)
+		if _, err := rand.Read(data); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else if len(data) < 32 {
+		return nil, fmt.Errorf("%s is too short", path)
+	}
+
+	keyPath, key = path, data
+	return key, nil
+}
+
+func sign(k []byte, uid int64, id int64, subfile string, expires int64) string {
+	mac := hmac.New(sha256.New, k)
+	fmt.Fprintf(mac, "%d\n%d\n%s\n%d", uid, id, subfile, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// whether token is the signature of a StreamURL for uid, id and subfile that has not expired
+func VerifyToken(uid int64, id int64, subfile string, expires int64, token string) bool {
+	if time.Now().Unix() > expires {
+		return false
+	}
+	k, err := signingKey()
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(sign(k, uid, id, subfile, expires)))
+}
@@ -0,0 +1,36 @@
+package stream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyToken(t *testing.T) {
+	t.Setenv("AIO_DIR", t.TempDir())
+	k, err := signingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Hour).Unix()
+	if !VerifyToken(1, 2, "a/b.mp3", future, sign(k, 1, 2, "a/b.mp3", future)) {
+		t.Error("a valid token was rejected")
+	}
+	if VerifyToken(1, 2, "a/b.mp3", past, sign(k, 1, 2, "a/b.mp3", past)) {
+		t.Error("an expired token was accepted")
+	}
+	if VerifyToken(1, 2, "a/c.mp3", future, sign(k, 1, 2, "a/b.mp3", future)) {
+		t.Error("a token for another file was accepted")
+	}
+
+	// the key is kept, so urls stay valid when the server restarts
+	key = nil
+	again, err := signingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(k) {
+		t.Error("the key changed after it was read again")
+	}
+}
@@ -0,0 +1,107 @@
+package db_types
+
+import (
+	"database/sql"
+	"encoding/json"
+	"slices"
+)
+
+type Condition string
+
+const (
+	C_UNKNOWN   Condition = ""
+	C_NEW       Condition = "New"
+	C_LIKE_NEW  Condition = "Like new"
+	C_VERY_GOOD Condition = "Very good"
+	C_GOOD      Condition = "Good"
+	C_FAIR      Condition = "Fair"
+	C_POOR      Condition = "Poor"
+)
+
+func ListConditions() []Condition {
+	return []Condition{
+		C_UNKNOWN, C_NEW, C_LIKE_NEW, C_VERY_GOOD, C_GOOD, C_FAIR, C_POOR,
+	}
+}
+
+func IsValidCondition(condition string) bool {
+	return slices.Contains(ListConditions(), Condition(condition))
+}
+
+// information about the physical copy of an item
+type InventoryEntry struct {
+	Uid          int64
+	ItemId       int64
+	Condition    Condition
+	Edition      string
+	Storage      string // where the copy is kept, eg: a shelf, or box
+	Barcode      string // barcode/UPC/ISBN
+	PurchaseDate int64  // unix ms, 0 if unknown
+}
+
+func (self InventoryEntry) Id() int64 {
+	return self.ItemId
+}
+
+func (self InventoryEntry) ReadEntryCopy(rows *sql.Rows) (TableRepresentation, error) {
+	return self, self.ReadEntry(rows)
+}
+
+func (self *InventoryEntry) ReadEntry(rows *sql.Rows) error {
+	return rows.Scan(
+		&self.Uid,
+		&self.ItemId,
+		&self.Condition,
+		&self.Edition,
+		&self.Storage,
+		&self.Barcode,
+		&self.PurchaseDate,
+	)
+}
+
+func (self InventoryEntry) ToJson() ([]byte, error) {
+	return json.Marshal(self)
+}
+
+// a record of an item being lent to someone
+// each copy of an item (see R_Copy) is its own entry, so ItemId is the copy that was lent
+type Loan struct {
+	Uid      int64
+	ItemId   int64
+	Borrower string
+	Lent     int64 // unix ms
+	Due      int64 // unix ms, 0 if there is no due date
+	Returned int64 // unix ms, 0 if it has not been returned
+	Notes    string
+	LoanId   int64
+}
+
+func (self Loan) Id() int64 {
+	return self.LoanId
+}
+
+func (self Loan) ReadEntryCopy(rows *sql.Rows) (TableRepresentation, error) {
+	return self, self.ReadEntry(rows)
+}
+
+func (self *Loan) ReadEntry(rows *sql.Rows) error {
+	return rows.Scan(
+		&self.LoanId,
+		&self.Uid,
+		&self.ItemId,
+		&self.Borrower,
+		&self.Lent,
+		&self.Due,
+		&self.Returned,
+		&self.Notes,
+	)
+}
+
+func (self Loan) ToJson() ([]byte, error) {
+	return json.Marshal(self)
+}
+
+// now is unix ms
+func (self Loan) IsOverdue(now int64) bool {
+	return self.Returned == 0 && self.Due != 0 && self.Due < now
+}
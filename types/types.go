@@ -3,6 +3,7 @@ package db_types
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"reflect"
 	"slices"
 	"strings"
@@ -29,23 +30,136 @@ func (self *EntrySettings) ReadEntry(rows *sql.Rows) error {
 
 type Transaction string
 const (
-	TRANSACTION_BUY = "Purchased"
-	TRANSACTION_SELL = "Sold"
+	TRANSACTION_BUY           Transaction = "Purchased"
+	TRANSACTION_SELL          Transaction = "Sold"
+	TRANSACTION_RENT          Transaction = "Rented"
+	TRANSACTION_SUBSCRIPTION  Transaction = "Subscription"
+	TRANSACTION_GIFT_RECEIVED Transaction = "Gift received"
+	TRANSACTION_GIFT_GIVEN    Transaction = "Gift given"
+	TRANSACTION_REFUND        Transaction = "Refunded"
+	TRANSACTION_TRADE         Transaction = "Traded"
 )
 
+func ListTransactionKinds() []Transaction {
+	return []Transaction{
+		TRANSACTION_BUY, TRANSACTION_SELL, TRANSACTION_RENT,
+		TRANSACTION_SUBSCRIPTION, TRANSACTION_GIFT_RECEIVED,
+		TRANSACTION_GIFT_GIVEN, TRANSACTION_REFUND, TRANSACTION_TRADE,
+	}
+}
+
+func IsValidTransactionKind(kind string) bool {
+	return slices.Contains(ListTransactionKinds(), Transaction(kind))
+}
+
+// gifts record the value of an item, no money is actually spent or earned
+func (self Transaction) MovesMoney() bool {
+	return self != TRANSACTION_GIFT_RECEIVED && self != TRANSACTION_GIFT_GIVEN
+}
+
+// amounts are positive when money is spent, and negative when it is earned
+// this makes sure the sign of amount matches the kind of transaction,
+// trades can go either way so they are left alone
+func (self Transaction) NormalizeAmount(amount int64) int64 {
+	if self == TRANSACTION_TRADE {
+		return amount
+	}
+
+	if amount < 0 {
+		amount = -amount
+	}
+
+	if self == TRANSACTION_SELL || self == TRANSACTION_REFUND {
+		return -amount
+	}
+	return amount
+}
+
 type Relations struct {
 	Children []int64
 	Requires []int64
 	Copies   []int64
+
+	// relation name -> ids, from this entry's point of view
+	// eg: if this entry is a sequel of 3, Related["sequel-of"] contains 3
+	Related map[string][]int64
 }
 
+// a relation is stored as (left, relation, right)
+// and is read as "left is <relation> right", eg: left is a child of right
 type Relation uint
 const (
 	R_Child Relation = 1
 	R_Requires Relation = 2
 	R_Copy Relation = 3
+	R_Sequel Relation = 4
+	R_Adaptation Relation = 5
+	R_SpinOff Relation = 6
+	R_Remake Relation = 7
+	R_SameFranchise Relation = 8
+	R_Soundtrack Relation = 9
 )
 
+type RelationKind struct {
+	Id Relation
+	// what left is to right
+	Name string
+	// what right is to left, same as Name if Symmetric
+	InverseName string
+	Symmetric bool
+	// if left is counted as a descendant of right in GetDescendants
+	Descendant bool
+}
+
+var relationKinds = []RelationKind{
+	{Id: R_Child, Name: "child-of", InverseName: "parent-of", Descendant: true},
+	{Id: R_Requires, Name: "requires", InverseName: "required-by"},
+	{Id: R_Copy, Name: "copy-of", InverseName: "copy-of", Symmetric: true},
+	{Id: R_Sequel, Name: "sequel-of", InverseName: "prequel-of"},
+	{Id: R_Adaptation, Name: "adaptation-of", InverseName: "adapted-into"},
+	{Id: R_SpinOff, Name: "spin-off-of", InverseName: "has-spin-off"},
+	{Id: R_Remake, Name: "remake-of", InverseName: "remade-as"},
+	{Id: R_SameFranchise, Name: "same-franchise", InverseName: "same-franchise", Symmetric: true},
+	{Id: R_Soundtrack, Name: "soundtrack-of", InverseName: "has-soundtrack"},
+}
+
+func ListRelationKinds() []RelationKind {
+	return relationKinds
+}
+
+func GetRelationKind(id Relation) (RelationKind, bool) {
+	for _, kind := range relationKinds {
+		if kind.Id == id {
+			return kind, true
+		}
+	}
+	return RelationKind{}, false
+}
+
+// finds a relation kind by either its Name or InverseName
+// if reversed is true, name is the InverseName and left and right should be swapped
+func LookupRelationKind(name string) (kind RelationKind, reversed bool, ok bool) {
+	for _, kind := range relationKinds {
+		if kind.Name == name {
+			return kind, false, true
+		}
+		if kind.InverseName == name {
+			return kind, true, true
+		}
+	}
+	return RelationKind{}, false, false
+}
+
+func ListDescendantRelations() []Relation {
+	out := []Relation{}
+	for _, kind := range relationKinds {
+		if kind.Descendant {
+			out = append(out, kind.Id)
+		}
+	}
+	return out
+}
+
 type ArtStyle uint
 
 const (
@@ -284,9 +398,19 @@ type TransactionEntry struct {
 	Uid int64
 	ItemId int64
 	EventId int64
-	Price float64
 	Currency string
+	Amount int64 // in the minor unit of Currency, see MajorToMinor
+	Kind Transaction
+	Vendor string
+	OrderId string
+	ReceiptPath string
+	Notes string
+	// the subscription that generated this transaction, 0 if none
+	Subscription int64
 	TransactionId int64
+
+	// RUNTIME VALUES (not stored in database), see self.ReadEntry
+	Price float64 `runtime:"true"` // Amount in the major unit of Currency
 }
 
 func (self TransactionEntry) Id() int64 {
@@ -298,20 +422,153 @@ func (self TransactionEntry) ReadEntryCopy(rows *sql.Rows) (TableRepresentation,
 }
 
 func (self *TransactionEntry) ReadEntry(rows *sql.Rows) error {
-	return rows.Scan(
+	err := rows.Scan(
 		&self.TransactionId,
 		&self.Uid,
 		&self.ItemId,
 		&self.EventId,
-		&self.Price,
 		&self.Currency,
+		&self.Amount,
+		&self.Kind,
+		&self.Vendor,
+		&self.OrderId,
+		&self.ReceiptPath,
+		&self.Notes,
+		&self.Subscription,
 	)
+	if err != nil {
+		return err
+	}
+
+	self.Price = MinorToMajor(self.Amount, self.Currency)
+
+	return nil
 }
 
 func (self TransactionEntry) ToJson() ([]byte, error) {
 	return json.Marshal(self)
 }
 
+type SubscriptionPeriod string
+const (
+	SP_DAY   SubscriptionPeriod = "day"
+	SP_WEEK  SubscriptionPeriod = "week"
+	SP_MONTH SubscriptionPeriod = "month"
+	SP_YEAR  SubscriptionPeriod = "year"
+)
+
+func IsValidSubscriptionPeriod(period string) bool {
+	return slices.Contains([]SubscriptionPeriod{SP_DAY, SP_WEEK, SP_MONTH, SP_YEAR}, SubscriptionPeriod(period))
+}
+
+// adds 1 period to t (unix ms)
+func (self SubscriptionPeriod) Next(t int64) int64 {
+	return self.Nth(t, 1)
+}
+
+// the time (unix ms) n periods after start
+// months and years are counted from start, and clamped to the end of shorter months,
+// so a subscription that starts on Jan 31 is charged on Feb 28, then Mar 31
+func (self SubscriptionPeriod) Nth(start int64, n int64) int64 {
+	tm := time.UnixMilli(start)
+	switch self {
+	case SP_DAY:
+		return tm.AddDate(0, 0, int(n)).UnixMilli()
+	case SP_WEEK:
+		return tm.AddDate(0, 0, 7*int(n)).UnixMilli()
+	case SP_YEAR:
+		return addMonths(tm, 12*int(n)).UnixMilli()
+	}
+	return addMonths(tm, int(n)).UnixMilli()
+}
+
+// time.AddDate normalizes Jan 31 + 1 month to Mar 3, this clamps it to Feb 28 instead
+func addMonths(tm time.Time, months int) time.Time {
+	year, month, day := tm.Date()
+	first := time.Date(year, month+time.Month(months), 1, tm.Hour(), tm.Minute(), tm.Second(), tm.Nanosecond(), tm.Location())
+	lastDay := first.AddDate(0, 1, -1).Day()
+	return time.Date(first.Year(), first.Month(), min(day, lastDay), tm.Hour(), tm.Minute(), tm.Second(), tm.Nanosecond(), tm.Location())
+}
+
+// a recurring charge, each period a Subscription transaction is created for ItemId
+// ItemId is usually the Library entry for the service (eg: a streaming service)
+type Subscription struct {
+	Uid int64
+	ItemId int64
+	Currency string
+	Amount int64 // in the minor unit of Currency
+	Period SubscriptionPeriod
+	Start int64 // unix ms of the first charge
+	Until int64 // unix ms, 0 if the subscription is ongoing
+	LastCharged int64 // unix ms, 0 if it was never charged
+	Vendor string
+	Notes string
+	SubscriptionId int64
+}
+
+func (self Subscription) Id() int64 {
+	return self.SubscriptionId
+}
+
+func (self Subscription) ReadEntryCopy(rows *sql.Rows) (TableRepresentation, error) {
+	return self, self.ReadEntry(rows)
+}
+
+func (self *Subscription) ReadEntry(rows *sql.Rows) error {
+	return rows.Scan(
+		&self.SubscriptionId,
+		&self.Uid,
+		&self.ItemId,
+		&self.Currency,
+		&self.Amount,
+		&self.Period,
+		&self.Start,
+		&self.Until,
+		&self.LastCharged,
+		&self.Vendor,
+		&self.Notes,
+	)
+}
+
+func (self Subscription) ToJson() ([]byte, error) {
+	return json.Marshal(self)
+}
+
+// a background refresh of an entry's metadata
+type MetadataRefresh struct {
+	Uid       int64
+	ItemId    int64
+	Timestamp int64 // unix ms
+	Provider  string
+	Changes   string // JSON {field: {Old, New}} as a string
+	Error     string // empty if the refresh succeeded
+	RefreshId int64
+}
+
+func (self MetadataRefresh) Id() int64 {
+	return self.RefreshId
+}
+
+func (self MetadataRefresh) ReadEntryCopy(rows *sql.Rows) (TableRepresentation, error) {
+	return self, self.ReadEntry(rows)
+}
+
+func (self *MetadataRefresh) ReadEntry(rows *sql.Rows) error {
+	return rows.Scan(
+		&self.RefreshId,
+		&self.Uid,
+		&self.ItemId,
+		&self.Timestamp,
+		&self.Provider,
+		&self.Changes,
+		&self.Error,
+	)
+}
+
+func (self MetadataRefresh) ToJson() ([]byte, error) {
+	return json.Marshal(self)
+}
+
 // names here MUST match names in the metadta sqlite table
 type MetadataEntry struct {
 	Uid    int64
@@ -331,6 +588,7 @@ type MetadataEntry struct {
 	ProviderID     string // the id that the provider used
 	Genres         string
 	Country string
+	Locks          string // JSON [string] as a string, the fields that fetching metadata will not change
 }
 
 func (self MetadataEntry) Id() int64 {
@@ -358,6 +616,7 @@ func (self *MetadataEntry) ReadEntry(rows *sql.Rows) error {
 		&self.ProviderID,
 		&self.Genres,
 		&self.Country,
+		&self.Locks,
 	)
 }
 
@@ -386,6 +645,9 @@ type InfoEntry struct {
 
 	// RUNTIME VALUES (not stored in database), see self.ReadEntry
 	Tags []string `runtime:"true"`
+
+	// only set for entries with descendants, when requested
+	Rollup *Rollup `runtime:"true" json:",omitempty"`
 }
 
 func (self *InfoEntry) IsAnime() bool {
@@ -503,6 +765,9 @@ type UserViewingEntry struct {
 	CurrentPosition string
 	Extra           string
 	Minutes         int64
+
+	// the status changes (see db.Begin, db.Finish, etc) whose webhooks are sent once the entry is saved
+	StatusEvents []WebhookEvent `runtime:"true" json:"-"`
 }
 
 func (self UserViewingEntry) Id() int64 {
@@ -569,8 +834,130 @@ type EntryTree struct {
 	UserInfo  UserViewingEntry
 	Children  []string
 	Copies    []string
+	Rollup    *Rollup `json:",omitempty"`
 }
 
 func (self EntryTree) ToJson() ([]byte, error) {
 	return json.Marshal(self)
 }
+
+// aggregates over every descendant of an entry, see db.GetRollups
+// the entry itself is not included
+type Rollup struct {
+	ItemId          int64
+	Descendants     int64
+	Minutes         int64
+	AverageRating   float64 // unrated descendants are not counted
+	PercentFinished float64
+	StatusCounts    map[Status]int64
+	Spent           map[string]int64 // currency -> amount in minor units, see TransactionEntry.Amount
+	FirstEvent      int64            // unix ms, 0 if there are no events
+	LastEvent       int64            // unix ms, 0 if there are no events
+}
+
+func (self Rollup) Id() int64 {
+	return self.ItemId
+}
+
+func (self Rollup) ReadEntryCopy(rows *sql.Rows) (TableRepresentation, error) {
+	return self, self.ReadEntry(rows)
+}
+
+func (self *Rollup) ReadEntry(rows *sql.Rows) error {
+	var statusCounts, spent string
+	err := rows.Scan(
+		&self.ItemId,
+		&self.Descendants,
+		&self.Minutes,
+		&self.AverageRating,
+		&self.PercentFinished,
+		&statusCounts,
+		&spent,
+		&self.FirstEvent,
+		&self.LastEvent,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(statusCounts), &self.StatusCounts); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(spent), &self.Spent)
+}
+
+func (self Rollup) ToJson() ([]byte, error) {
+	return json.Marshal(self)
+}
+
+// every descendant is Finished
+func (self Rollup) AllFinished() bool {
+	return self.Descendants > 0 && self.StatusCounts[S_FINISHED] == self.Descendants
+}
+
+func ListChangeKinds() []string {
+	return []string{
+		"info", "metadata", "user", "event", "relation", "transaction",
+		"subscription", "inventory", "loan", "exchangeRate",
+	}
+}
+
+// a row of a library table that was inserted, updated, or deleted, see /events/stream
+type Change struct {
+	Seq       int64 // increases with every change, across every user
+	Uid       int64
+	Kind      string // see ListChangeKinds
+	Op        string // insert, update, or delete
+	ItemId    int64  // the entry the row belongs to, the left entry for relations
+	RowId     int64  // the rowid of the row in its table, eg: the EventId of an event
+	Timestamp int64  // unix ms
+	// identifies relations ("left,relation,right"), and exchange rates ("FROM,TO"), which have no entry or stable rowid
+	Key string
+}
+
+func (self Change) Id() int64 {
+	return self.Seq
+}
+
+// the column of the changes table that identifies rows of kind, see Change.Identity
+func ChangeIdentityColumn(kind string) string {
+	switch kind {
+	case "info", "metadata", "user", "inventory":
+		return "itemId"
+	case "relation", "exchangeRate":
+		return "key"
+	}
+	return "rowId"
+}
+
+// every change to the same row has the same Identity
+func (self Change) Identity() string {
+	switch ChangeIdentityColumn(self.Kind) {
+	case "itemId":
+		return fmt.Sprintf("%d", self.ItemId)
+	case "key":
+		return self.Key
+	}
+	return fmt.Sprintf("%d", self.RowId)
+}
+
+func (self Change) ReadEntryCopy(rows *sql.Rows) (TableRepresentation, error) {
+	return self, self.ReadEntry(rows)
+}
+
+func (self *Change) ReadEntry(rows *sql.Rows) error {
+	return rows.Scan(
+		&self.Seq,
+		&self.Uid,
+		&self.Kind,
+		&self.Op,
+		&self.ItemId,
+		&self.RowId,
+		&self.Timestamp,
+		&self.Key,
+	)
+}
+
+func (self Change) ToJson() ([]byte, error) {
+	return json.Marshal(self)
+}
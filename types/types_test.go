@@ -0,0 +1,40 @@
+package db_types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscriptionPeriodNth(t *testing.T) {
+	date := func(year int, month time.Month, day int) int64 {
+		return time.Date(year, month, day, 12, 0, 0, 0, time.UTC).UnixMilli()
+	}
+
+	tests := []struct {
+		name   string
+		period SubscriptionPeriod
+		start  int64
+		n      int64
+		want   int64
+	}{
+		{"day", SP_DAY, date(2024, time.January, 31), 1, date(2024, time.February, 1)},
+		{"week", SP_WEEK, date(2024, time.January, 31), 2, date(2024, time.February, 14)},
+		{"month clamped to a leap february", SP_MONTH, date(2024, time.January, 31), 1, date(2024, time.February, 29)},
+		{"month clamped to february", SP_MONTH, date(2023, time.January, 31), 1, date(2023, time.February, 28)},
+		{"months after a short month do not drift", SP_MONTH, date(2024, time.January, 31), 2, date(2024, time.March, 31)},
+		{"month clamped to april", SP_MONTH, date(2024, time.January, 31), 3, date(2024, time.April, 30)},
+		{"months across a year", SP_MONTH, date(2024, time.November, 30), 3, date(2025, time.February, 28)},
+		{"year from a leap day", SP_YEAR, date(2024, time.February, 29), 1, date(2025, time.February, 28)},
+		{"years back to a leap day", SP_YEAR, date(2024, time.February, 29), 4, date(2028, time.February, 29)},
+		{"zeroth period is the start", SP_MONTH, date(2024, time.January, 31), 0, date(2024, time.January, 31)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.period.Nth(test.start, test.n)
+			if got != test.want {
+				t.Errorf("got %s, want %s", time.UnixMilli(got).UTC(), time.UnixMilli(test.want).UTC())
+			}
+		})
+	}
+}
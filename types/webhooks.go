@@ -0,0 +1,185 @@
+package db_types
+
+import (
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+type WebhookEvent string
+
+const (
+	WE_PING                WebhookEvent = "ping"
+	WE_ENTRY_ADDED         WebhookEvent = "entry.added"
+	WE_ENTRY_DELETED       WebhookEvent = "entry.deleted"
+	WE_STATUS_STARTED      WebhookEvent = "status.started"
+	WE_STATUS_FINISHED     WebhookEvent = "status.finished"
+	WE_STATUS_DROPPED      WebhookEvent = "status.dropped"
+	WE_STATUS_PAUSED       WebhookEvent = "status.paused"
+	WE_STATUS_RESUMED      WebhookEvent = "status.resumed"
+	WE_STATUS_PLANNED      WebhookEvent = "status.planned"
+	WE_STATUS_WAITING      WebhookEvent = "status.waiting"
+	WE_RATING_CHANGED      WebhookEvent = "rating.changed"
+	WE_TRANSACTION_CREATED WebhookEvent = "transaction.created"
+	WE_TRANSACTION_DELETED WebhookEvent = "transaction.deleted"
+	WE_METADATA_REFRESHED  WebhookEvent = "metadata.refreshed"
+)
+
+func ListWebhookEvents() []WebhookEvent {
+	return []WebhookEvent{
+		WE_PING,
+		WE_ENTRY_ADDED, WE_ENTRY_DELETED,
+		WE_STATUS_STARTED, WE_STATUS_FINISHED, WE_STATUS_DROPPED, WE_STATUS_PAUSED,
+		WE_STATUS_RESUMED, WE_STATUS_PLANNED, WE_STATUS_WAITING,
+		WE_RATING_CHANGED,
+		WE_TRANSACTION_CREATED, WE_TRANSACTION_DELETED,
+		WE_METADATA_REFRESHED,
+	}
+}
+
+// filter is an event, "*", or a group of events, eg: "status.*"
+func IsValidWebhookFilter(filter string) bool {
+	if filter == "*" {
+		return true
+	}
+	if group, ok := strings.CutSuffix(filter, ".*"); ok {
+		return slices.ContainsFunc(ListWebhookEvents(), func(e WebhookEvent) bool {
+			return strings.HasPrefix(string(e), group+".")
+		})
+	}
+	return slices.Contains(ListWebhookEvents(), WebhookEvent(filter))
+}
+
+func (self WebhookEvent) Matches(filter string) bool {
+	if filter == "*" || filter == string(self) {
+		return true
+	}
+	group, ok := strings.CutSuffix(filter, ".*")
+	return ok && strings.HasPrefix(string(self), group+".")
+}
+
+// an outgoing webhook, it is sent the events in Events as signed json, see webhooks.Sign
+type Webhook struct {
+	Uid       int64
+	Url       string
+	Secret    string // the key of the HMAC-SHA256 signature of each payload
+	Events    string // JSON [string] as a string, see IsValidWebhookFilter, an empty list sends every event
+	Enabled   bool
+	Created   int64 // unix ms
+	WebhookId int64
+}
+
+func (self Webhook) Id() int64 {
+	return self.WebhookId
+}
+
+func (self Webhook) ReadEntryCopy(rows *sql.Rows) (TableRepresentation, error) {
+	return self, self.ReadEntry(rows)
+}
+
+func (self *Webhook) ReadEntry(rows *sql.Rows) error {
+	return rows.Scan(
+		&self.WebhookId,
+		&self.Uid,
+		&self.Url,
+		&self.Secret,
+		&self.Events,
+		&self.Enabled,
+		&self.Created,
+	)
+}
+
+func (self Webhook) ToJson() ([]byte, error) {
+	return json.Marshal(self)
+}
+
+func (self Webhook) Wants(event WebhookEvent) bool {
+	// pings are only sent on request, and should always go through
+	if event == WE_PING {
+		return true
+	}
+	filters := []string{}
+	json.Unmarshal([]byte(self.Events), &filters)
+	if len(filters) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(filters, event.Matches)
+}
+
+type DeliveryStatus string
+
+const (
+	DS_PENDING   DeliveryStatus = "pending"
+	DS_DELIVERED DeliveryStatus = "delivered"
+	// every attempt failed, it will not be retried unless it is redelivered
+	DS_FAILED DeliveryStatus = "failed"
+)
+
+func ListDeliveryStatuses() []DeliveryStatus {
+	return []DeliveryStatus{DS_PENDING, DS_DELIVERED, DS_FAILED}
+}
+
+func IsValidDeliveryStatus(status string) bool {
+	return slices.Contains(ListDeliveryStatuses(), DeliveryStatus(status))
+}
+
+// an event that is queued for, or was sent to a webhook
+type WebhookDelivery struct {
+	Uid          int64
+	WebhookId    int64
+	Event        WebhookEvent
+	ItemId       int64  // 0 if the event is not about an entry
+	Payload      string // the json that is sent
+	Status       DeliveryStatus
+	Attempts     int64
+	NextAttempt  int64 // unix ms
+	ResponseCode int64 // of the last attempt, 0 if there was no response
+	LastError    string
+	Created      int64 // unix ms
+	Delivered    int64 // unix ms, 0 if it has not been delivered
+	DeliveryId   int64
+}
+
+func (self WebhookDelivery) Id() int64 {
+	return self.DeliveryId
+}
+
+func (self WebhookDelivery) ReadEntryCopy(rows *sql.Rows) (TableRepresentation, error) {
+	return self, self.ReadEntry(rows)
+}
+
+func (self *WebhookDelivery) ReadEntry(rows *sql.Rows) error {
+	return rows.Scan(
+		&self.DeliveryId,
+		&self.Uid,
+		&self.WebhookId,
+		&self.Event,
+		&self.ItemId,
+		&self.Payload,
+		&self.Status,
+		&self.Attempts,
+		&self.NextAttempt,
+		&self.ResponseCode,
+		&self.LastError,
+		&self.Created,
+		&self.Delivered,
+	)
+}
+
+func (self WebhookDelivery) ToJson() ([]byte, error) {
+	return json.Marshal(self)
+}
+
+// the json that is sent to webhooks
+type WebhookPayload struct {
+	Event     WebhookEvent
+	Timestamp int64 // unix ms
+	Uid       int64
+	ItemId    int64 // 0 if the event is not about an entry
+	Title     string
+	// a sentence about what happened, eg: "Finished Toy Story (rated 85)"
+	Summary string
+	// depends on Event, eg: the InfoEntry that was added, or the TransactionEntry that was created
+	Data any
+}
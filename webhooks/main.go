@@ -0,0 +1,153 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"aiolimas/db"
+	"aiolimas/logging"
+	db_types "aiolimas/types"
+)
+
+/*
+	Events are queued as WebhookDeliveries by the db package when they happen, and sent from here
+
+	each delivery is POSTed to the webhook's Url with the headers:
+	X-AIO-Event:     the event, eg: status.finished
+	X-AIO-Delivery:  the id of the delivery, it is the same for every attempt
+	X-AIO-Signature: sha256=<hex HMAC-SHA256 of the body, keyed with the webhook's Secret>
+
+	a 2xx response delivers it, otherwise it is attempted again after a backoff, see Backoff
+*/
+
+// after this many attempts a delivery is failed
+const MaxAttempts = 10
+
+const timeout = 10 * time.Second
+
+// a delivery is claimed for this long while it is sent, if aio stops before it is sent, it is attempted again after this
+const claimFor = 3 * timeout
+
+// the delivery is being sent by something else
+var ErrClaimed = errors.New("the delivery is already being sent")
+
+var client = http.Client{Timeout: timeout}
+
+// the time to wait after the attempt'th attempt failed
+// 30s, 1m, 2m, ... up to 6h
+func Backoff(attempt int64) time.Duration {
+	wait := 30 * time.Second
+	for i := int64(1); i < attempt && wait < 6*time.Hour; i++ {
+		wait *= 2
+	}
+	return min(wait, 6*time.Hour)
+}
+
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// returns the status code of the response, and an error if the delivery was not accepted
+func send(hook db_types.Webhook, delivery db_types.WebhookDelivery) (int64, error) {
+	body := []byte(delivery.Payload)
+
+	req, err := http.NewRequest("POST", hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "AIO-LIMAS")
+	req.Header.Set("X-AIO-Event", string(delivery.Event))
+	req.Header.Set("X-AIO-Delivery", fmt.Sprintf("%d", delivery.DeliveryId))
+	req.Header.Set("X-AIO-Signature", Sign(hook.Secret, body))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		text, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return int64(res.StatusCode), fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(text))
+	}
+	return int64(res.StatusCode), nil
+}
+
+// attempts delivery once, and records the result
+// returns ErrClaimed if it is already being attempted
+func Deliver(hook db_types.Webhook, delivery *db_types.WebhookDelivery) error {
+	claimed, err := db.ClaimWebhookDelivery(delivery, time.Now().Add(claimFor).UnixMilli())
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrClaimed
+	}
+
+	code, err := send(hook, *delivery)
+	now := time.Now()
+	delivery.Attempts += 1
+	delivery.ResponseCode = code
+	if err == nil {
+		delivery.Status = db_types.DS_DELIVERED
+		delivery.Delivered = now.UnixMilli()
+		delivery.LastError = ""
+	} else {
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= MaxAttempts {
+			delivery.Status = db_types.DS_FAILED
+		} else {
+			delivery.NextAttempt = now.Add(Backoff(delivery.Attempts)).UnixMilli()
+		}
+	}
+
+	if uerr := db.UpdateWebhookDelivery(delivery.Uid, delivery); uerr != nil {
+		return uerr
+	}
+	return err
+}
+
+// attempts every delivery that is due
+func DeliverDue() {
+	deliveries, err := db.DueWebhookDeliveries(time.Now().UnixMilli(), 100)
+	if err != nil {
+		logging.ELog(err)
+		return
+	}
+
+	hooks := map[int64]db_types.Webhook{}
+	for _, delivery := range deliveries {
+		hook, ok := hooks[delivery.WebhookId]
+		if !ok {
+			hook, err = db.GetWebhook(delivery.Uid, delivery.WebhookId)
+			if err != nil {
+				logging.ELog(err)
+				continue
+			}
+			hooks[hook.WebhookId] = hook
+		}
+
+		if err := Deliver(hook, &delivery); err != nil && err != ErrClaimed {
+			logging.Warn("could not deliver %s to webhook %d: %s", delivery.Event, hook.WebhookId, err.Error())
+		}
+	}
+}
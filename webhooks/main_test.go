@@ -0,0 +1,148 @@
+package webhooks
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"aiolimas/db"
+	db_types "aiolimas/types"
+)
+
+// deliveries are recorded in the database, so the tests share one fresh database
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "aio-webhooks-test")
+	if err != nil {
+		panic(err.Error())
+	}
+	os.Setenv("AIO_DIR", dir)
+
+	// the schema is read relative to the root of the repo
+	if err := os.Chdir(".."); err != nil {
+		panic(err.Error())
+	}
+	if err := db.InitDb(); err != nil {
+		panic(err.Error())
+	}
+
+	code := m.Run()
+	db.DB.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// a webhook of uid that sends to a server which responds with status, and counts what it was sent
+func testWebhook(t *testing.T, uid int64, status int) (db_types.Webhook, *atomic.Int64) {
+	t.Helper()
+	received := &atomic.Int64{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		if r.Header.Get("X-AIO-Signature") == "" {
+			t.Error("the delivery is not signed")
+		}
+		// slow enough that concurrent attempts overlap
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	hook := db_types.Webhook{Url: server.URL, Secret: "secret", Enabled: true}
+	if err := db.CreateWebhook(uid, &hook); err != nil {
+		t.Fatal(err)
+	}
+	return hook, received
+}
+
+func queuePing(t *testing.T, uid int64, hook db_types.Webhook) db_types.WebhookDelivery {
+	t.Helper()
+	delivery, err := db.QueueWebhookDelivery(uid, hook, db_types.WebhookPayload{Event: db_types.WE_PING, Uid: uid, Summary: "Ping"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return delivery
+}
+
+func TestDeliver(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		attempts   int64
+		wantStatus db_types.DeliveryStatus
+		wantErr    bool
+	}{
+		{"accepted", 204, 0, db_types.DS_DELIVERED, false},
+		{"rejected is retried", 500, 0, db_types.DS_PENDING, true},
+		{"the last attempt fails it", 500, MaxAttempts - 1, db_types.DS_FAILED, true},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			uid := int64(1 + i)
+			hook, received := testWebhook(t, uid, test.status)
+			delivery := queuePing(t, uid, hook)
+			if test.attempts > 0 {
+				delivery.Attempts = test.attempts
+				if err := db.UpdateWebhookDelivery(uid, &delivery); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			before := time.Now().UnixMilli()
+			err := Deliver(hook, &delivery)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v", err)
+			}
+			if received.Load() != 1 {
+				t.Errorf("sent %d times", received.Load())
+			}
+
+			saved, err := db.GetWebhookDelivery(uid, delivery.DeliveryId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.Status != test.wantStatus || saved.Attempts != test.attempts+1 || saved.ResponseCode != int64(test.status) {
+				t.Errorf("wrong delivery: %+v", saved)
+			}
+			if saved.Status == db_types.DS_PENDING && saved.NextAttempt < before+Backoff(saved.Attempts).Milliseconds() {
+				t.Errorf("the next attempt is too soon: %d", saved.NextAttempt-before)
+			}
+		})
+	}
+}
+
+func TestDeliverOnce(t *testing.T) {
+	const uid = 10
+	hook, received := testWebhook(t, uid, 200)
+	delivery := queuePing(t, uid, hook)
+
+	// eg: a ping, and DeliverDue picking up the same pending row
+	var wg sync.WaitGroup
+	claimed := atomic.Int64{}
+	for range 4 {
+		wg.Add(1)
+		go func(delivery db_types.WebhookDelivery) {
+			defer wg.Done()
+			err := Deliver(hook, &delivery)
+			if err == nil {
+				claimed.Add(1)
+			} else if err != ErrClaimed {
+				t.Error(err)
+			}
+		}(delivery)
+	}
+	wg.Wait()
+
+	if received.Load() != 1 || claimed.Load() != 1 {
+		t.Errorf("sent %d times, by %d attempts", received.Load(), claimed.Load())
+	}
+
+	// it is no longer due
+	DeliverDue()
+	if received.Load() != 1 {
+		t.Errorf("sent again by DeliverDue")
+	}
+}
@@ -8,9 +8,11 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"aiolimas/accounts"
 	"aiolimas/db"
+	"aiolimas/reports"
 	db_types "aiolimas/types"
 	"aiolimas/util"
 	"aiolimas/logging"
@@ -156,6 +158,61 @@ func handleById(w http.ResponseWriter, req *http.Request, id string, uid int64)
 	w.Write([]byte(text))
 }
 
+func handleReportPath(w http.ResponseWriter, req *http.Request, year string, uid int64) {
+	y := time.Now().Year()
+	if year != "" {
+		i, err := strconv.ParseInt(year, 10, 64)
+		if err != nil {
+			util.WError(w, 400, "Year is not a valid year")
+			return
+		}
+		y = int(i)
+	}
+
+	start, end := reports.YearRange(y)
+
+	pp := req.URL.Query()
+	if s, err := strconv.ParseInt(pp.Get("start"), 10, 64); err == nil {
+		start = s
+	}
+	if e, err := strconv.ParseInt(pp.Get("end"), 10, 64); err == nil {
+		end = e
+	}
+
+	report, err := reports.BuildReport(db.RequestContext{
+		UID: uid,
+		Auth: 0,
+	}, start, end)
+	if err != nil {
+		util.WError(w, 500, "Could not build report: %s", err.Error())
+		return
+	}
+
+	fnMap := template.FuncMap{
+		"Uid": func() int64 { return uid },
+		"FmtTime": func(ms int64) string {
+			return time.UnixMilli(ms).UTC().Format(time.DateOnly)
+		},
+	}
+
+	tmpl, err := template.New("base").Funcs(fnMap).ParseFiles(
+		"./webservice/dynamic/templates/report.html",
+	)
+	if err != nil {
+		util.WError(w, 500, "Could not render report")
+		logging.ELog(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(200)
+
+	err = tmpl.ExecuteTemplate(w, "report", report)
+	if err != nil {
+		logging.ELog(err)
+	}
+}
+
 func handleUsersPath(w http.ResponseWriter, req *http.Request) {
 	aioPath := os.Getenv("AIO_DIR")
 	users, err := accounts.ListUsers(aioPath)
@@ -197,6 +254,13 @@ func HtmlEndpoint(w http.ResponseWriter, req *http.Request) {
 		handleSearchPath(w, req, id)
 	case "users":
 		handleUsersPath(w, req)
+	case "report":
+		uid := getuid()
+		year := ""
+		if len(pathArguments) >= 3 {
+			year = pathArguments[2]
+		}
+		handleReportPath(w, req, year, uid)
 	case "by-id":
 		uid := getuid()
 		if len(pathArguments) < 3 || pathArguments[2] == "" {